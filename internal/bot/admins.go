@@ -0,0 +1,85 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// adminCache remembers each group's administrators for the group_admins
+// auth mode so authorization doesn't call the Telegram API per message.
+type adminCache struct {
+	ttl   time.Duration
+	fetch func(ctx context.Context, chatID int64) ([]int64, error)
+	now   func() time.Time
+
+	mu    sync.Mutex
+	chats map[int64]adminEntry
+}
+
+type adminEntry struct {
+	ids     map[int64]bool
+	fetched time.Time
+}
+
+func newAdminCache(ttl time.Duration, fetch func(ctx context.Context, chatID int64) ([]int64, error)) *adminCache {
+	return &adminCache{
+		ttl:   ttl,
+		fetch: fetch,
+		now:   time.Now,
+		chats: make(map[int64]adminEntry),
+	}
+}
+
+// isAdmin reports whether userID administers chatID, refreshing the chat's
+// admin list once its cached copy is older than the TTL.
+func (c *adminCache) isAdmin(ctx context.Context, chatID, userID int64) (bool, error) {
+	c.mu.Lock()
+	entry, ok := c.chats[chatID]
+	c.mu.Unlock()
+	if ok && c.now().Sub(entry.fetched) < c.ttl {
+		return entry.ids[userID], nil
+	}
+
+	ids, err := c.fetch(ctx, chatID)
+	if err != nil {
+		return false, fmt.Errorf("fetch administrators: %w", err)
+	}
+	entry = adminEntry{ids: make(map[int64]bool, len(ids)), fetched: c.now()}
+	for _, id := range ids {
+		entry.ids[id] = true
+	}
+
+	c.mu.Lock()
+	c.chats[chatID] = entry
+	c.mu.Unlock()
+	return entry.ids[userID], nil
+}
+
+// fetchAdmins lists the user IDs of a chat's owner and administrators.
+func fetchAdmins(tg *bot.Bot) func(ctx context.Context, chatID int64) ([]int64, error) {
+	return func(ctx context.Context, chatID int64) ([]int64, error) {
+		members, err := tg.GetChatAdministrators(ctx, &bot.GetChatAdministratorsParams{ChatID: chatID})
+		if err != nil {
+			return nil, err
+		}
+		var ids []int64
+		for _, m := range members {
+			switch m.Type {
+			case models.ChatMemberTypeOwner:
+				if m.Owner != nil && m.Owner.User != nil {
+					ids = append(ids, m.Owner.User.ID)
+				}
+			case models.ChatMemberTypeAdministrator:
+				if m.Administrator != nil {
+					ids = append(ids, m.Administrator.User.ID)
+				}
+			}
+		}
+		return ids, nil
+	}
+}
@@ -0,0 +1,49 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAdminCache_CachesUntilTTL(t *testing.T) {
+	fetches := 0
+	admins := []int64{1, 2}
+	c := newAdminCache(time.Minute, func(_ context.Context, chatID int64) ([]int64, error) {
+		fetches++
+		return admins, nil
+	})
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	if ok, _ := c.isAdmin(ctx, -100, 1); !ok {
+		t.Error("expected user 1 to be an admin")
+	}
+	if ok, _ := c.isAdmin(ctx, -100, 3); ok {
+		t.Error("expected user 3 not to be an admin")
+	}
+	if fetches != 1 {
+		t.Fatalf("expected one fetch within TTL, got %d", fetches)
+	}
+
+	// After the TTL the list is refreshed and picks up the new admin.
+	admins = []int64{3}
+	now = now.Add(time.Minute)
+	if ok, _ := c.isAdmin(ctx, -100, 3); !ok {
+		t.Error("expected refreshed list to include user 3")
+	}
+	if fetches != 2 {
+		t.Errorf("expected a refetch after TTL, got %d fetches", fetches)
+	}
+}
+
+func TestAdminCache_FetchErrorDenies(t *testing.T) {
+	c := newAdminCache(time.Minute, func(context.Context, int64) ([]int64, error) {
+		return nil, errors.New("forbidden")
+	})
+	if ok, err := c.isAdmin(context.Background(), -100, 1); ok || err == nil {
+		t.Errorf("isAdmin = %v, %v; want false and an error", ok, err)
+	}
+}
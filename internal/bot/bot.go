@@ -2,9 +2,16 @@ package bot
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"math/rand/v2"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 	"unicode/utf8"
 
@@ -13,23 +20,163 @@ import (
 
 	"github.com/zette-dev/natron/internal/config"
 	"github.com/zette-dev/natron/internal/executor"
+	"github.com/zette-dev/natron/internal/reqid"
 	"github.com/zette-dev/natron/internal/session"
+	"github.com/zette-dev/natron/internal/transcript"
 )
 
-const maxMessageLen = 4096
+const (
+	maxMessageLen     = 4096
+	reconnectMinDelay = time.Second
+	maxCodeMessages   = 10 // Code blocks sent separately per response
+	defaultLogLines   = 20 // /log with no argument
+	maxLogLines       = 100
+	maxLogLineLen     = 500 // Longer activity lines are cut in /log
+)
+
+// Streaming edit pacing. Each edit interval is the configured base scaled by
+// how much text arrived since the previous edit, plus a little random jitter
+// so concurrent chats don't all hit the Telegram API on the same tick.
+const (
+	editJitter    = 0.1 // ±10% spread
+	burstRunes    = 400 // growth at or above this edits sooner
+	trickleRunes  = 40  // growth below this edits later
+	burstFactor   = 0.5
+	trickleFactor = 1.5
+)
 
 // SessionProvider is the interface the bot uses to interact with sessions.
 type SessionProvider interface {
 	// Send routes a message to the appropriate session and returns streamed events.
-	// username is the Telegram @username without the @ prefix (empty for DMs or
-	// private groups without a username). title is the group/channel display name.
-	Send(ctx context.Context, chatID int64, username, title, message string) (<-chan executor.Event, error)
+	// The origin identifies the chat and sender the message came from.
+	Send(ctx context.Context, origin session.Origin, message string) (<-chan executor.Event, error)
+
+	// Reset stops the origin's active session so the next message starts fresh.
+	Reset(origin session.Origin)
+
+	// ResetInWorkspace resets the origin's session and switches its chat
+	// to the named workspace under the base path.
+	ResetInWorkspace(origin session.Origin, name string) error
+
+	// Interrupt cancels the origin's in-flight turn, reporting false when
+	// there is no session to cancel.
+	Interrupt(ctx context.Context, origin session.Origin) (bool, error)
+
+	// Status returns the current state of the origin's session.
+	Status(origin session.Origin) session.StatusInfo
+
+	// WorkDir returns the workspace directory the origin resolves to,
+	// whether or not a session exists.
+	WorkDir(origin session.Origin) string
+
+	// SetReadOnly toggles tool-less mode for the origin's chat, resetting
+	// its session if the mode changed.
+	SetReadOnly(origin session.Origin, on bool)
+
+	// ReadOnly reports whether tool-less mode is on for the origin's chat.
+	ReadOnly(origin session.Origin) bool
+
+	// SetModel switches the origin's chat to model, resetting its session
+	// if it changed. It returns session.ErrUnknownModel for models not in
+	// claude.allowed_models.
+	SetModel(origin session.Origin, model string) error
+
+	// Model returns the model the origin's chat uses; empty when the
+	// backend picks.
+	Model(origin session.Origin) string
+
+	// Models lists the models /model accepts; empty means any.
+	Models() []string
+
+	// SetPersona sets the origin's chat's addition to the identity doc,
+	// resetting its session if it changed; empty clears it. It returns
+	// session.ErrPersonaTooLong past claude.max_identity_chars.
+	SetPersona(origin session.Origin, persona string) error
+
+	// Persona returns the origin's chat's persona, or "" if none.
+	Persona(origin session.Origin) string
+
+	// QuickActions lists the follow-ups offered with responses in the
+	// origin's workspace.
+	QuickActions(origin session.Origin) []string
+
+	// SetIdleTimeout overrides the inactivity timeout for the origin's
+	// chat; 0 disables expiry.
+	SetIdleTimeout(origin session.Origin, timeout time.Duration)
+
+	// IdleTimeout returns the inactivity timeout in effect for the
+	// origin's chat; 0 means sessions never expire.
+	IdleTimeout(origin session.Origin) time.Duration
+
+	// Overrides returns the per-chat settings set for the origin's chat.
+	Overrides(origin session.Origin) session.Overrides
+
+	// ClearOverrides drops the origin's chat's per-chat settings and
+	// resets its session.
+	ClearOverrides(origin session.Origin)
+
+	// Keep pauses or resumes expiry of the origin's running session,
+	// returning how long a pause lasts at most.
+	Keep(origin session.Origin, on bool) (time.Duration, error)
+
+	// Workspaces lists the available workspaces, marking the origin's.
+	Workspaces(origin session.Origin) []session.WorkspaceInfo
+
+	// Remember appends a note to the shared memory loaded by new sessions.
+	Remember(note string) error
+
+	// Ask answers a question with a one-off turn outside any session,
+	// using model when set. ctx bounds the turn.
+	Ask(ctx context.Context, model, question string) (string, error)
+
+	// Quick answers message with a one-off turn on model outside the
+	// origin's session, for session.lanes commands.
+	Quick(ctx context.Context, origin session.Origin, model, message string) (string, error)
+
+	// CompactMemory has the agent rewrite the shared memory more concisely,
+	// returning its size in bytes before and after.
+	CompactMemory(ctx context.Context) (before, after int, err error)
 
-	// Reset stops the active session for chatID so the next message starts fresh.
-	Reset(chatID int64)
+	// Prompt reads a named prompt template from the origin's workspace.
+	Prompt(origin session.Origin, name string) (string, error)
 
-	// Status returns the current session state for chatID.
-	Status(chatID int64) session.StatusInfo
+	// ReloadIdentity re-sends the current identity document into the
+	// origin's running session as a message and streams the reply.
+	ReloadIdentity(ctx context.Context, origin session.Origin) (<-chan executor.Event, error)
+
+	// LastResponse returns the raw markdown of the origin's latest response
+	// and whether it was truncated when retained.
+	LastResponse(origin session.Origin) (text string, truncated bool)
+
+	// InterruptAll stops every running turn, returning how many there
+	// were.
+	InterruptAll() int
+
+	// Capabilities reports what the origin's backend supports.
+	Capabilities(origin session.Origin) executor.ExecutorCapabilities
+
+	// OnIdleNudge registers fn to be told when an idle session is about to
+	// be reaped.
+	OnIdleNudge(fn func(origin session.Origin, left time.Duration))
+
+	// OnReap registers fn to be told when a session is stopped for
+	// inactivity.
+	OnReap(fn func(origin session.Origin))
+
+	// TurnsFull reports whether a new turn from origin would wait for one
+	// running in another chat to finish.
+	TurnsFull(origin session.Origin) bool
+
+	// Activity returns up to n recent stderr and tool lines from the
+	// origin's session; ok is false when none are available.
+	Activity(origin session.Origin, n int) (lines []string, ok bool)
+
+	// LastTurnRaw returns the raw executor output of the origin's last
+	// turn, redacted; ok is false when none is available.
+	LastTurnRaw(origin session.Origin) (lines []string, ok bool)
+
+	// Usage totals the origin's chat's recorded spend today and this week.
+	Usage(ctx context.Context, origin session.Origin) (session.UsageReport, error)
 }
 
 // Bot wraps the Telegram bot and routes messages to sessions.
@@ -39,124 +186,1255 @@ type Bot struct {
 	cfg      config.TelegramConfig
 	editIvl  time.Duration
 	allowed  map[int64]bool
+	blocked  map[int64]bool // Dropped before any allow rule is checked
+	adminIDs map[int64]bool // With the owner, may run admin commands
+	observer map[int64]bool // Chats that only receive mirrored responses
+	debounce *debouncer     // nil when batching is disabled
+	history  *chatHistory   // nil unless memory.telegram_history_messages is set
+	admins   *adminCache    // nil unless auth_mode is group_admins
+	adminGrp map[int64]bool // Groups whose admins are authorized in group_admins mode
+	conn     connState      // Telegram long-poll connectivity
+	sched    *sendScheduler // nil unless telegram.send_rate is set
+	inline   *inlineAnswers // nil unless telegram.inline.enabled is set
+	answers  *answers       // nil unless session.rerun_on_edit is set
+	toolOut  *toolOutputs   // nil unless session.tool_output_limit is set
+	paused   atomic.Bool    // Set by /pause_all; no turns start while true
+	uploads  config.UploadsConfig
+
+	statusMsg bool // Show a progress message beside long responses
+	pinStatus bool
+	splitCode bool // Send code blocks as separate messages
+	fmtStream bool // MarkdownV2 in intermediate edits too
+	minFirst  int  // Runes buffered before the first streamed send
+	maxEdits  int  // Intermediate edits per turn; 0 is unlimited
+	maxInput  int  // Longest accepted message in runes; 0 is unlimited
+	reactions bool // Show turn progress as reactions on the user's message
+
+	emptyReply string            // Sent when a turn finishes without text
+	preambles  []*regexp.Regexp  // Trimmed from the start of final responses
+	locale     string            // Catalog for the bot's own messages
+	lanes      map[string]string // session.lanes commands and their models
 }
 
 // New creates a Telegram bot wired to the given session provider.
-func New(cfg config.TelegramConfig, editInterval time.Duration, sessions SessionProvider) (*Bot, error) {
+func New(cfg config.TelegramConfig, sessCfg config.SessionConfig, memCfg config.MemoryConfig, uploadsCfg config.UploadsConfig, sessions SessionProvider) (*Bot, error) {
+	if cfg.Locale != "" && !knownLocale(cfg.Locale) {
+		return nil, fmt.Errorf("unknown telegram.locale %q", cfg.Locale)
+	}
+
 	allowed := make(map[int64]bool, len(cfg.AllowedUserIDs))
 	for _, id := range cfg.AllowedUserIDs {
 		allowed[id] = true
 	}
 
-	b := &Bot{
-		sessions: sessions,
-		cfg:      cfg,
-		editIvl:  editInterval,
-		allowed:  allowed,
+	blocked := make(map[int64]bool, len(cfg.BlockedUserIDs))
+	for _, id := range cfg.BlockedUserIDs {
+		blocked[id] = true
+	}
+
+	adminIDs := make(map[int64]bool, len(cfg.AdminUserIDs))
+	for _, id := range cfg.AdminUserIDs {
+		adminIDs[id] = true
+	}
+
+	observer := make(map[int64]bool)
+	for _, ids := range cfg.Observers {
+		for _, id := range ids {
+			observer[id] = true
+		}
+	}
+
+	b := &Bot{
+		sessions: sessions,
+		cfg:      cfg,
+		editIvl:  sessCfg.EditInterval,
+		allowed:  allowed,
+		blocked:  blocked,
+		adminIDs: adminIDs,
+		observer: observer,
+		uploads:  uploadsCfg,
+
+		statusMsg: sessCfg.StatusMessage,
+		pinStatus: sessCfg.PinStatus,
+		splitCode: sessCfg.SplitCodeBlocks,
+		fmtStream: sessCfg.FormatStreaming,
+		minFirst:  sessCfg.MinFirstChars,
+		maxEdits:  sessCfg.MaxEditsPerTurn,
+		maxInput:  sessCfg.MaxInputChars,
+		reactions: sessCfg.ProgressReactions,
+
+		emptyReply: sessCfg.EmptyResponse,
+		lanes:      sessCfg.Lanes,
+		preambles:  sessCfg.PreamblePatterns,
+		locale:     cfg.Locale,
+	}
+
+	if cfg.SendRate > 0 {
+		b.sched = newSendScheduler(cfg.SendRate)
+	}
+
+	middlewares := []bot.Middleware{b.authMiddleware, b.pauseGate, b.commandAccess}
+	if memCfg.TelegramHistoryMessages > 0 {
+		b.history = newChatHistory(memCfg.TelegramHistoryMessages)
+		// Record after auth, so only what authorized users said reaches a
+		// session's prompt.
+		middlewares = slices.Insert(middlewares, 1, b.recordHistory)
+	}
+	if sessCfg.Debounce > 0 {
+		b.debounce = newDebouncer(sessCfg.Debounce, func(ctx context.Context, msg *models.Message, text string) {
+			b.runTurn(ctx, b.bot, msg, text)
+		})
+		middlewares = append(middlewares, b.flushOnCommand)
+	}
+
+	opts := []bot.Option{
+		bot.WithMiddlewares(middlewares...),
+		bot.WithMessageTextHandler("/new", bot.MatchTypePrefix, b.handleNew),
+		bot.WithMessageTextHandler("/status", bot.MatchTypePrefix, b.handleStatus),
+		bot.WithMessageTextHandler("/whoami", bot.MatchTypePrefix, b.handleWhoami),
+		bot.WithMessageTextHandler("/usage", bot.MatchTypePrefix, b.handleUsage),
+		bot.WithMessageTextHandler("/readonly", bot.MatchTypePrefix, b.handleReadOnly),
+		bot.WithMessageTextHandler("/model", bot.MatchTypePrefix, b.handleModel),
+		bot.WithMessageTextHandler("/persona", bot.MatchTypePrefix, b.handlePersona),
+		bot.WithMessageTextHandler("/workspaces", bot.MatchTypePrefix, b.handleWorkspaces),
+		bot.WithMessageTextHandler("/raw", bot.MatchTypePrefix, b.handleRaw),
+		bot.WithMessageTextHandler("/cancel", bot.MatchTypePrefix, b.handleCancel),
+		bot.WithMessageTextHandler("/timeout", bot.MatchTypePrefix, b.handleTimeout),
+		bot.WithMessageTextHandler("/keep", bot.MatchTypePrefix, b.handleKeep),
+		bot.WithMessageTextHandler("/settings", bot.MatchTypePrefix, b.handleSettings),
+		bot.WithMessageTextHandler("/remember", bot.MatchTypePrefix, b.handleRemember),
+		bot.WithMessageTextHandler("/compact_memory", bot.MatchTypePrefix, b.handleCompactMemory),
+		bot.WithMessageTextHandler("/compact-memory", bot.MatchTypePrefix, b.handleCompactMemory),
+		bot.WithMessageTextHandler("/log", bot.MatchTypePrefix, b.handleLog),
+		bot.WithMessageTextHandler("/debug_last", bot.MatchTypePrefix, b.handleDebugLast),
+		bot.WithMessageTextHandler("/debug-last", bot.MatchTypePrefix, b.handleDebugLast),
+		bot.WithMessageTextHandler("/run", bot.MatchTypePrefix, b.handleRun),
+		bot.WithMessageTextHandler("/reload_identity", bot.MatchTypePrefix, b.handleReloadIdentity),
+		bot.WithMessageTextHandler("/reload-identity", bot.MatchTypePrefix, b.handleReloadIdentity),
+		bot.WithMessageTextHandler("/pause_all", bot.MatchTypePrefix, b.handlePauseAll),
+		bot.WithMessageTextHandler("/pause-all", bot.MatchTypePrefix, b.handlePauseAll),
+		bot.WithMessageTextHandler("/resume_all", bot.MatchTypePrefix, b.handleResumeAll),
+		bot.WithMessageTextHandler("/resume-all", bot.MatchTypePrefix, b.handleResumeAll),
+		bot.WithDefaultHandler(b.handleMessage),
+	}
+	for _, name := range slices.Sorted(maps.Keys(sessCfg.Lanes)) {
+		opts = append(opts, bot.WithMessageTextHandler("/"+name, bot.MatchTypePrefix, b.handleLane(name, sessCfg.Lanes[name])))
+	}
+
+	if cfg.APIBaseURL != "" {
+		opts = append(opts, bot.WithServerURL(strings.TrimSuffix(cfg.APIBaseURL, "/")))
+	}
+
+	tgBot, err := bot.New(cfg.BotToken, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("create telegram bot: %w", err)
+	}
+
+	b.bot = tgBot
+	if cfg.Inline.Enabled {
+		b.inline = newInlineAnswers(cfg.Inline.CacheTTL)
+		tgBot.RegisterHandlerMatchFunc(isInlineQuery, b.handleInlineQuery)
+	}
+	if sessCfg.RerunOnEdit {
+		b.answers = newAnswers()
+		tgBot.RegisterHandlerMatchFunc(isEditedMessage, b.handleEditedMessage)
+	}
+	if sessCfg.ToolOutputLimit > 0 {
+		b.toolOut = newToolOutputs(sessCfg.ToolOutputLimit)
+		tgBot.RegisterHandlerMatchFunc(isToolOutputButton, b.handleToolOutput)
+	}
+	if cfg.AuthMode == config.AuthGroupAdmins {
+		b.adminGrp = make(map[int64]bool, len(cfg.AdminGroupIDs))
+		for _, id := range cfg.AdminGroupIDs {
+			b.adminGrp[id] = true
+		}
+		b.admins = newAdminCache(cfg.AdminCacheTTL, fetchAdmins(tgBot))
+	}
+	if sessCfg.IdleNudge > 0 {
+		sessions.OnIdleNudge(func(origin session.Origin, left time.Duration) {
+			b.nudge(context.Background(), tgBot, origin, left)
+		})
+	}
+	if sessCfg.ReapNotice {
+		sessions.OnReap(func(origin session.Origin) {
+			b.reapNotice(context.Background(), tgBot, origin)
+		})
+	}
+	return b, nil
+}
+
+// Start begins long polling. Blocks until ctx is cancelled, restarting the
+// poll with backoff if it stops early, then marks the bot as draining.
+// Each attempt probes Telegram with getMe first, so Connectivity reflects
+// whether it was actually reached; errors the library retries inside a
+// running poll aren't seen here.
+func (b *Bot) Start(ctx context.Context) {
+	b.setCommands(ctx)
+	slog.Info("telegram bot starting long poll")
+	supervise(ctx, &b.conn, func(ctx context.Context, connected func()) error {
+		if _, err := b.bot.GetMe(ctx); err != nil {
+			return fmt.Errorf("reach telegram: %w", err)
+		}
+		connected()
+		b.bot.Start(ctx)
+		return errors.New("long poll stopped")
+	}, reconnectMinDelay, b.cfg.ReconnectMaxBackoff)
+	b.Drain()
+}
+
+// supervise runs start until ctx is cancelled, restarting it whenever it
+// returns early. start calls connected once it has reached Telegram, and
+// conn records each connect and failure. The delay between attempts doubles
+// up to maxDelay and resets once a run outlasts maxDelay, so a healthy poll
+// that drops once reconnects quickly.
+func supervise(ctx context.Context, conn *connState, start func(ctx context.Context, connected func()) error, minDelay, maxDelay time.Duration) {
+	delay := minDelay
+	for attempt := 1; ; attempt++ {
+		began := time.Now()
+		err := start(ctx, conn.up)
+		if ctx.Err() != nil {
+			return
+		}
+		conn.down(err)
+
+		if time.Since(began) > maxDelay {
+			delay = minDelay
+		}
+		slog.Warn("telegram long poll exited, reconnecting", "attempt", attempt, "delay", delay, "error", err)
+
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+		delay = min(delay*2, maxDelay)
+	}
+}
+
+// authMiddleware silently drops messages from unauthorized users.
+func (b *Bot) authMiddleware(next bot.HandlerFunc) bot.HandlerFunc {
+	return func(ctx context.Context, tg *bot.Bot, update *models.Update) {
+		if q := update.InlineQuery; q != nil {
+			// Inline queries carry no chat, so only the allowlist applies.
+			if b.inline == nil || q.From == nil || b.blocked[q.From.ID] || !b.allowed[q.From.ID] {
+				return
+			}
+			next(ctx, tg, update)
+			return
+		}
+		msg := update.Message
+		if msg == nil {
+			msg = update.EditedMessage
+		}
+		if q := update.CallbackQuery; q != nil && q.Message.Message != nil {
+			// Button taps are checked as if the tapper had posted in the
+			// chat the button is in.
+			msg = &models.Message{Chat: q.Message.Message.Chat, MessageThreadID: q.Message.Message.MessageThreadID, From: &q.From}
+		}
+		if msg == nil || msg.From == nil {
+			return
+		}
+		// The block list wins over every allow rule, including group admins.
+		if b.blocked[msg.From.ID] {
+			slog.Warn("blocked user", "chat_id", msg.Chat.ID, "user_id", msg.From.ID)
+			return
+		}
+		if b.observer[msg.Chat.ID] {
+			return // observers receive, they never drive turns
+		}
+		if !b.authorized(ctx, msg) {
+			slog.Warn("unauthorized message", "user_id", msg.From.ID)
+			return
+		}
+		next(ctx, tg, update)
+	}
+}
+
+// recordHistory attaches the conversation's recent messages to the context
+// for a new session, then records this message for later turns. It runs
+// after authMiddleware; blocked senders are skipped here as well.
+func (b *Bot) recordHistory(next bot.HandlerFunc) bot.HandlerFunc {
+	return func(ctx context.Context, tg *bot.Bot, update *models.Update) {
+		if msg := update.Message; msg != nil && !b.observer[msg.Chat.ID] && (msg.From == nil || !b.blocked[msg.From.ID]) {
+			if recent := b.history.recent(msg); recent != "" {
+				ctx = session.WithRecentHistory(ctx, recent)
+			}
+			b.history.add(msg)
+		}
+		next(ctx, tg, update)
+	}
+}
+
+// authorized reports whether the sender may use the bot: allowlisted users
+// always may, and in group_admins mode so may the administrators of a group
+// listed in admin_group_ids.
+func (b *Bot) authorized(ctx context.Context, msg *models.Message) bool {
+	if b.allowed[msg.From.ID] {
+		return true
+	}
+	if b.admins == nil || !originOf(msg).Group || !b.adminGrp[msg.Chat.ID] {
+		return false
+	}
+	ok, err := b.admins.isAdmin(ctx, msg.Chat.ID, msg.From.ID)
+	if err != nil {
+		slog.Error("admin check failed", "chat_id", msg.Chat.ID, "error", err)
+		return false
+	}
+	return ok
+}
+
+// commandAccess enforces telegram.command_access before any command
+// handler runs.
+func (b *Bot) commandAccess(next bot.HandlerFunc) bot.HandlerFunc {
+	return func(ctx context.Context, tg *bot.Bot, update *models.Update) {
+		if msg := update.Message; msg != nil && msg.From != nil {
+			if cmd := commandName(msg.Text); cmd != "" && !b.permits(cmd, msg.From.ID) {
+				b.reply(ctx, tg, msg, b.msg(msgCommandDenied, cmd))
+				return
+			}
+		}
+		next(ctx, tg, update)
+	}
+}
+
+// permits reports whether userID may run cmd. The owner is also an admin.
+func (b *Bot) permits(cmd string, userID int64) bool {
+	switch b.cfg.CommandAccess[cmd] {
+	case config.AccessOwner:
+		return b.cfg.OwnerUserID != 0 && userID == b.cfg.OwnerUserID
+	case config.AccessAdmins:
+		return b.isAdmin(userID)
+	default:
+		return true
+	}
+}
+
+// flushOnCommand sends any buffered messages before a command is handled,
+// so a command never overtakes text the user sent ahead of it. /cancel
+// instead drops the buffer, since that text was never sent.
+func (b *Bot) flushOnCommand(next bot.HandlerFunc) bot.HandlerFunc {
+	return func(ctx context.Context, tg *bot.Bot, update *models.Update) {
+		if update.Message != nil && strings.HasPrefix(update.Message.Text, "/") {
+			if strings.HasPrefix(update.Message.Text, "/cancel") {
+				b.debounce.discard(update.Message)
+			} else {
+				b.debounce.flush(update.Message)
+			}
+		}
+		next(ctx, tg, update)
+	}
+}
+
+// handleMessage processes an incoming text message, buffering it first when
+// debouncing is enabled.
+func (b *Bot) handleMessage(ctx context.Context, tg *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	if len(update.Message.Photo) > 0 && !b.sessions.Capabilities(originOf(update.Message)).SupportsImages {
+		b.reply(ctx, tg, update.Message, b.msg(msgImagesUnsupported))
+		return
+	}
+	if doc := update.Message.Document; doc != nil {
+		// Documents aren't downloaded yet; say so rather than drop them.
+		if !b.uploads.Allows(doc.FileName) {
+			b.reply(ctx, tg, update.Message, b.msg(msgUploadRejected, strings.Join(b.uploads.AllowedExtensions, ", ")))
+		} else {
+			b.reply(ctx, tg, update.Message, b.msg(msgUploadUnsupported))
+		}
+		return
+	}
+	if update.Message.Text == "" {
+		return
+	}
+
+	if n := utf8.RuneCountInString(update.Message.Text); b.maxInput > 0 && n > b.maxInput {
+		b.reply(ctx, tg, update.Message, b.msg(msgInputTooLong, n, b.maxInput))
+		return
+	}
+
+	ctx = reqid.With(ctx, reqid.New())
+	if b.debounce != nil {
+		b.debounce.add(ctx, update.Message, update.Message.Text)
+		return
+	}
+	b.runTurn(ctx, tg, update.Message, update.Message.Text)
+}
+
+// runTurn sends text to the message's session and streams the response back.
+func (b *Bot) runTurn(ctx context.Context, tg *bot.Bot, msg *models.Message, text string) {
+	b.respond(ctx, tg, msg, text, 0)
+}
+
+// respond runs a turn for msg, streaming the response into a new message,
+// or by editing replyID when set. With session.rerun_on_edit it remembers
+// the response so an edit to msg can re-run the turn.
+func (b *Bot) respond(ctx context.Context, tg *bot.Bot, msg *models.Message, text string, replyID int) {
+	origin := originOf(msg)
+	if b.turnsPaused(ctx, tg, msg) {
+		return
+	}
+	if b.sessions.TurnsFull(origin) {
+		b.reply(ctx, tg, msg, b.msg(msgTurnQueued))
+	}
+
+	b.sendChatAction(ctx, tg, origin.ChatID, origin.ThreadID, models.ChatActionTyping)
+
+	var react *turnReactions
+	if b.reactions {
+		react = &turnReactions{tg: tg, chatID: origin.ChatID, msgID: msg.ID}
+		react.set(ctx, reactReceived)
+	}
+
+	events, err := b.sessions.Send(ctx, origin, text)
+	if err != nil {
+		slog.Error("session send failed", "chat_id", origin.ChatID, "request_id", reqid.FromContext(ctx), "error", err)
+		reply := b.msg(msgSendFailed)
+		var (
+			notDir       *session.WorkspaceNotDirError
+			unknownModel *executor.UnknownModelError
+		)
+		switch {
+		case errors.Is(err, session.ErrSessionFailing):
+			reply = b.msg(msgSessionFailing)
+		case errors.Is(err, session.ErrWorkspaceForbidden):
+			reply = b.msg(msgSessionForbidden)
+		case errors.As(err, &notDir):
+			reply = b.msg(msgWorkspaceNotDir, notDir.Path)
+		case errors.As(err, &unknownModel):
+			reply = b.msg(msgUnknownModel, unknownModel.Model)
+		}
+		b.reply(ctx, tg, msg, reply)
+		return
+	}
+
+	if react != nil {
+		events = react.relay(ctx, events)
+		// streamResponse can return before the stream ends; keep draining
+		// so the relay goroutine finishes.
+		defer func() {
+			for range events {
+			}
+		}()
+	}
+	keyboard := quickActionsKeyboard(b.sessions.QuickActions(origin))
+	replyID = b.streamInto(ctx, tg, origin.ChatID, origin.ThreadID, replyID, keyboard, events)
+	// A debounced batch answers several messages; only a lone one can be
+	// re-run by editing it.
+	if b.answers != nil && replyID != 0 && text == msg.Text {
+		b.answers.put(origin.ChatID, msg.ID, answer{text: text, replyID: replyID})
+	}
+}
+
+// handleNew clears the active session so the next message starts a fresh
+// conversation. "/new <workspace>" also switches the chat to that workspace.
+func (b *Bot) handleNew(ctx context.Context, tg *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	origin := originOf(update.Message)
+	name := commandArgs(update.Message.Text)
+	if name == "" {
+		b.sessions.Reset(origin)
+		b.reply(ctx, tg, update.Message, b.msg(msgSessionCleared))
+		return
+	}
+
+	text := b.msg(msgSessionClearedIn, name)
+	if err := b.sessions.ResetInWorkspace(origin, name); err != nil {
+		var notDir *session.WorkspaceNotDirError
+		switch {
+		case errors.Is(err, session.ErrNoWorkspace):
+			text = b.msg(msgWorkspaceUnknown, name)
+		case errors.Is(err, session.ErrWorkspaceForbidden):
+			text = b.msg(msgWorkspaceDenied, name)
+		case errors.As(err, &notDir):
+			text = b.msg(msgWorkspaceNotDir, notDir.Path)
+		default:
+			slog.Error("switch workspace failed", "chat_id", origin.ChatID, "error", err)
+			text = b.msg(msgSendFailed)
+		}
+	}
+	b.reply(ctx, tg, update.Message, text)
+}
+
+// handleCancel aborts the in-flight response for the chat.
+func (b *Bot) handleCancel(ctx context.Context, tg *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	origin := originOf(update.Message)
+
+	ok, err := b.sessions.Interrupt(ctx, origin)
+	var text string
+	switch {
+	case err != nil:
+		slog.Error("interrupt failed", "chat_id", origin.ChatID, "error", err)
+		text = b.msg(msgCancelFailed)
+	case !ok:
+		text = b.msg(msgCancelNothing)
+	default:
+		text = b.msg(msgCancelled)
+	}
+
+	b.reply(ctx, tg, update.Message, text)
+}
+
+// handleStatus reports the current session state for the chat.
+func (b *Bot) handleStatus(ctx context.Context, tg *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	origin := originOf(update.Message)
+	info := b.sessions.Status(origin)
+
+	var text string
+	if !info.Exists {
+		text = b.msg(msgStatusNone)
+	} else {
+		age := time.Since(info.CreatedAt).Round(time.Second)
+		text = b.msg(msgStatusActive,
+			info.CreatedAt.Format("15:04"),
+			formatDuration(age),
+			info.Workspace,
+		)
+	}
+	if !info.TurnStarted.IsZero() {
+		text += "\n" + b.msg(msgStatusTurn, formatDuration(time.Since(info.TurnStarted).Round(time.Second)))
+		if info.Queued > 0 {
+			text += "\n" + b.msg(msgStatusQueued, info.Queued)
+		}
+	}
+	if b.isAdmin(origin.UserID) {
+		text += "\n" + b.connLine(b.Connectivity())
+	}
+
+	b.reply(ctx, tg, update.Message, text)
+}
+
+// connLine describes the bot's Telegram connectivity for /status.
+func (b *Bot) connLine(status ConnStatus) string {
+	since := status.Since.Format("15:04")
+	if status.Connected {
+		return b.msg(msgStatusConnected, since)
+	}
+	return b.msg(msgStatusReconnecting, since, status.Attempts)
+}
+
+// handleUsage summarizes the chat's spend today and this week. Token
+// counts are only shown to admins.
+func (b *Bot) handleUsage(ctx context.Context, tg *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	origin := originOf(update.Message)
+	report, err := b.sessions.Usage(ctx, origin)
+	switch {
+	case errors.Is(err, session.ErrNoTranscripts):
+		b.reply(ctx, tg, update.Message, b.msg(msgUsageDisabled))
+		return
+	case err != nil:
+		slog.Error("usage failed", "chat_id", origin.ChatID, "error", err)
+		b.reply(ctx, tg, update.Message, b.msg(msgUsageFailed))
+		return
+	}
+
+	admin := b.isAdmin(origin.UserID)
+	line := func(key msgKey, u transcript.Usage) string {
+		text := b.msg(key, u.CostUSD, u.Turns)
+		if admin {
+			text += b.msg(msgUsageTokens, formatTokens(u.InputTokens), formatTokens(u.OutputTokens))
+		}
+		return text
+	}
+	b.reply(ctx, tg, update.Message, b.msg(msgUsageHeader)+"\n"+line(msgUsageToday, report.Today)+"\n"+line(msgUsageWeek, report.Week))
+}
+
+// formatTokens abbreviates a token count, e.g. "950", "35.1k" or "1.2M".
+func formatTokens(n int) string {
+	switch {
+	case n >= 1_000_000:
+		return strconv.FormatFloat(float64(n)/1_000_000, 'f', 1, 64) + "M"
+	case n >= 1_000:
+		return strconv.FormatFloat(float64(n)/1_000, 'f', 1, 64) + "k"
+	default:
+		return strconv.Itoa(n)
+	}
+}
+
+// handleWhoami reports the caller's IDs and the workspace their chat resolves
+// to, to help with configuring allowed_user_ids and chat_map.
+func (b *Bot) handleWhoami(ctx context.Context, tg *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	origin := originOf(update.Message)
+
+	lines := []string{
+		b.msg(msgWhoamiUser, origin.UserID),
+		b.msg(msgWhoamiChat, origin.ChatID),
+	}
+	if origin.Username != "" {
+		lines = append(lines, b.msg(msgWhoamiUsername, origin.Username))
+	}
+	if origin.Title != "" {
+		lines = append(lines, b.msg(msgWhoamiTitle, origin.Title))
+	}
+	lines = append(lines, b.msg(msgWhoamiWorkspace, b.sessions.WorkDir(origin)))
+
+	b.reply(ctx, tg, update.Message, strings.Join(lines, "\n"))
+}
+
+// handleReadOnly reports or toggles read-only mode: "/readonly on|off".
+func (b *Bot) handleReadOnly(ctx context.Context, tg *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	origin := originOf(update.Message)
+
+	var text string
+	switch commandArgs(update.Message.Text) {
+	case "":
+		if b.sessions.ReadOnly(origin) {
+			text = b.msg(msgReadOnlyIsOn)
+		} else {
+			text = b.msg(msgReadOnlyIsOff)
+		}
+	case "on":
+		b.sessions.SetReadOnly(origin, true)
+		text = b.msg(msgReadOnlyOn)
+	case "off":
+		b.sessions.SetReadOnly(origin, false)
+		text = b.msg(msgReadOnlyOff)
+	default:
+		text = b.msg(msgReadOnlyUsage)
+	}
+
+	b.reply(ctx, tg, update.Message, text)
+}
+
+// handleModel reports or switches the chat's model: "/model <name>", with
+// "/model list" or "/models" listing the allowed ones.
+func (b *Bot) handleModel(ctx context.Context, tg *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	origin := originOf(update.Message)
+	arg := commandArgs(update.Message.Text)
+	if commandName(update.Message.Text) == "models" {
+		arg = "list"
+	}
+
+	var text string
+	switch arg {
+	case "":
+		text = b.msg(msgModelCurrent, b.modelName(b.sessions.Model(origin)))
+	case "list":
+		text = b.modelList(origin)
+	default:
+		err := b.sessions.SetModel(origin, arg)
+		switch {
+		case errors.Is(err, session.ErrUnknownModel):
+			text = b.msg(msgModelUnknown, arg, strings.Join(b.sessions.Models(), ", "))
+		case err != nil:
+			slog.Error("set model failed", "chat_id", update.Message.Chat.ID, "error", err)
+			text = b.msg(msgSendFailed)
+		default:
+			text = b.msg(msgModelSet, arg)
+		}
+	}
+
+	b.reply(ctx, tg, update.Message, text)
+}
+
+// modelList lists the allowed models, marking the chat's current one.
+func (b *Bot) modelList(origin session.Origin) string {
+	current := b.sessions.Model(origin)
+	allowed := b.sessions.Models()
+	if len(allowed) == 0 {
+		return b.msg(msgModelAny, b.modelName(current))
+	}
+	lines := []string{b.msg(msgModelsHeader)}
+	for _, name := range allowed {
+		if name == current {
+			lines = append(lines, b.msg(msgModelsActive, name))
+		} else {
+			lines = append(lines, b.msg(msgModelsItem, name))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// modelName names model for display, with the backend's default shown as
+// such.
+func (b *Bot) modelName(model string) string {
+	if model == "" {
+		return b.msg(msgModelDefault)
+	}
+	return model
+}
+
+// nudge tells the origin's chat its idle session closes in left, so it can
+// keep it alive.
+func (b *Bot) nudge(ctx context.Context, tg sender, origin session.Origin, left time.Duration) {
+	if b.sched.wait(ctx, origin.ChatID) != nil {
+		return
+	}
+	_, err := tg.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:          origin.ChatID,
+		MessageThreadID: origin.ThreadID,
+		Text:            b.msg(msgIdleNudge, formatDuration(left.Round(time.Second))),
+	})
+	if err != nil {
+		slog.Warn("send idle nudge failed", "chat_id", origin.ChatID, "error", err)
+	}
+}
+
+// reapNotice tells the origin's chat its session was stopped for
+// inactivity, so the next message starts fresh.
+func (b *Bot) reapNotice(ctx context.Context, tg sender, origin session.Origin) {
+	if b.sched.wait(ctx, origin.ChatID) != nil {
+		return
+	}
+	_, err := tg.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:              origin.ChatID,
+		MessageThreadID:     origin.ThreadID,
+		Text:                b.msg(msgSessionReaped),
+		DisableNotification: true,
+	})
+	if err != nil {
+		slog.Warn("send reap notice failed", "chat_id", origin.ChatID, "error", err)
+	}
+}
+
+// handleLane returns the handler for a session.lanes command, which answers
+// "/name <message>" with a one-off turn on model, leaving the chat's
+// session untouched.
+func (b *Bot) handleLane(name, model string) bot.HandlerFunc {
+	return func(ctx context.Context, tg *bot.Bot, update *models.Update) {
+		if update.Message == nil {
+			return
+		}
+		if b.turnsPaused(ctx, tg, update.Message) {
+			return
+		}
+		text := commandArgs(update.Message.Text)
+		if text == "" {
+			b.reply(ctx, tg, update.Message, b.msg(msgLaneUsage, name))
+			return
+		}
+		origin := originOf(update.Message)
+		ctx = reqid.With(ctx, reqid.New())
+		b.sendChatAction(ctx, tg, origin.ChatID, origin.ThreadID, models.ChatActionTyping)
+
+		answer, err := b.sessions.Quick(ctx, origin, model, text)
+		if err != nil {
+			slog.Error("lane turn failed", "chat_id", origin.ChatID, "request_id", reqid.FromContext(ctx), "lane", name, "error", err)
+			b.reply(ctx, tg, update.Message, b.msg(msgSendFailed))
+			return
+		}
+		events := make(chan executor.Event, 1)
+		events <- executor.Event{Type: executor.EventDone, Text: answer}
+		close(events)
+		b.streamResponse(ctx, tg, origin.ChatID, origin.ThreadID, events)
+	}
+}
+
+// handlePersona shows or sets the chat's persona: "/persona <text>", with
+// "/persona clear" removing it.
+func (b *Bot) handlePersona(ctx context.Context, tg *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	origin := originOf(update.Message)
+
+	var text string
+	switch arg := commandArgs(update.Message.Text); arg {
+	case "":
+		if persona := b.sessions.Persona(origin); persona != "" {
+			text = b.msg(msgPersonaCurrent, persona)
+		} else {
+			text = b.msg(msgPersonaNone)
+		}
+	case "clear":
+		if err := b.sessions.SetPersona(origin, ""); err != nil {
+			slog.Error("clear persona failed", "chat_id", update.Message.Chat.ID, "error", err)
+			text = b.msg(msgSendFailed)
+		} else {
+			text = b.msg(msgPersonaCleared)
+		}
+	default:
+		err := b.sessions.SetPersona(origin, arg)
+		switch {
+		case errors.Is(err, session.ErrPersonaTooLong):
+			text = b.msg(msgPersonaTooLong)
+		case err != nil:
+			slog.Error("set persona failed", "chat_id", update.Message.Chat.ID, "error", err)
+			text = b.msg(msgSendFailed)
+		default:
+			text = b.msg(msgPersonaSet)
+		}
+	}
+
+	b.reply(ctx, tg, update.Message, text)
+}
+
+// handleTimeout reports or overrides the chat's inactivity timeout:
+// "/timeout <duration>|off". Changing it is restricted to admins.
+func (b *Bot) handleTimeout(ctx context.Context, tg *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	origin := originOf(update.Message)
+	arg := commandArgs(update.Message.Text)
+
+	if arg != "" && !b.isAdmin(origin.UserID) {
+		b.reply(ctx, tg, update.Message, b.msg(msgTimeoutAdminOnly))
+		return
+	}
+
+	var text string
+	switch arg {
+	case "":
+		if d := b.sessions.IdleTimeout(origin); d > 0 {
+			text = b.msg(msgTimeoutExpires, formatDuration(d))
+		} else {
+			text = b.msg(msgTimeoutNever)
+		}
+	case "off":
+		b.sessions.SetIdleTimeout(origin, 0)
+		text = b.msg(msgTimeoutOff)
+	default:
+		d, err := time.ParseDuration(arg)
+		if err != nil || d <= 0 {
+			text = b.msg(msgTimeoutUsage)
+			break
+		}
+		b.sessions.SetIdleTimeout(origin, d)
+		text = b.msg(msgTimeoutSet, formatDuration(d))
+	}
+
+	b.reply(ctx, tg, update.Message, text)
+}
+
+// handleSettings lists the chat's per-chat overrides, "/settings", or
+// drops them all, "/settings clear". Clearing is refused unless the user
+// could have changed each override that's set with its own command.
+func (b *Bot) handleSettings(ctx context.Context, tg *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	origin := originOf(update.Message)
+	overrides := b.sessions.Overrides(origin)
+
+	var text string
+	switch commandArgs(update.Message.Text) {
+	case "":
+		text = b.formatOverrides(overrides)
+	case "clear":
+		if !b.mayClear(overrides, origin.UserID) {
+			text = b.msg(msgSettingsDenied)
+			break
+		}
+		b.sessions.ClearOverrides(origin)
+		text = b.msg(msgSettingsCleared)
+	default:
+		text = b.msg(msgSettingsUsage)
+	}
+
+	b.reply(ctx, tg, update.Message, text)
+}
+
+// mayClear reports whether userID may drop every override in o: each one
+// set must be one telegram.command_access lets them change, and a timeout
+// is admin-only, as with /timeout.
+func (b *Bot) mayClear(o session.Overrides, userID int64) bool {
+	set := map[string]bool{
+		"new":      o.Workspace != "",
+		"model":    o.Model != "",
+		"persona":  o.Persona != "",
+		"readonly": o.ReadOnly,
+		"timeout":  o.Timeout != nil,
+	}
+	for cmd, on := range set {
+		if on && !b.permits(cmd, userID) {
+			return false
+		}
+	}
+	return o.Timeout == nil || b.isAdmin(userID)
+}
+
+// formatOverrides renders the /settings listing, one line per override.
+func (b *Bot) formatOverrides(o session.Overrides) string {
+	var lines []string
+	if o.Workspace != "" {
+		lines = append(lines, b.msg(msgSettingsWorkspace, o.Workspace))
+	}
+	if o.Model != "" {
+		lines = append(lines, b.msg(msgSettingsModel, o.Model))
+	}
+	if o.Persona != "" {
+		lines = append(lines, b.msg(msgSettingsPersona))
+	}
+	if o.ReadOnly {
+		lines = append(lines, b.msg(msgSettingsReadOnly))
+	}
+	if o.Timeout != nil {
+		timeout := b.msg(msgSettingsTimeoutOff)
+		if *o.Timeout > 0 {
+			timeout = formatDuration(*o.Timeout)
+		}
+		lines = append(lines, b.msg(msgSettingsTimeout, timeout))
+	}
+	if len(lines) == 0 {
+		return b.msg(msgSettingsNone)
+	}
+	return b.msg(msgSettingsHeader) + "\n" + strings.Join(lines, "\n")
+}
+
+// handleKeep pauses the session's inactivity timer, "/keep", or resumes
+// it, "/keep off".
+func (b *Bot) handleKeep(ctx context.Context, tg *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	origin := originOf(update.Message)
+
+	var on bool
+	switch commandArgs(update.Message.Text) {
+	case "", "on":
+		on = true
+	case "off":
+	default:
+		b.reply(ctx, tg, update.Message, b.msg(msgKeepUsage))
+		return
+	}
+
+	limit, err := b.sessions.Keep(origin, on)
+	var text string
+	switch {
+	case errors.Is(err, session.ErrNoSession):
+		text = b.msg(msgKeepNoSession)
+	case err != nil:
+		slog.Error("keep failed", "chat_id", update.Message.Chat.ID, "error", err)
+		text = b.msg(msgSendFailed)
+	case on:
+		text = b.msg(msgKeepOn, formatDuration(limit))
+	default:
+		text = b.msg(msgKeepOff)
+	}
+	b.reply(ctx, tg, update.Message, text)
+}
+
+// isAdmin reports whether userID may run admin commands: a listed admin or
+// the owner. With neither configured, nobody may.
+func (b *Bot) isAdmin(userID int64) bool {
+	return b.adminIDs[userID] || (b.cfg.OwnerUserID != 0 && userID == b.cfg.OwnerUserID)
+}
+
+// handleRemember appends "/remember <text>" to the shared memory file.
+func (b *Bot) handleRemember(ctx context.Context, tg *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	note := commandArgs(update.Message.Text)
+	if note == "" {
+		b.reply(ctx, tg, update.Message, b.msg(msgRememberUsage))
+		return
+	}
+
+	text := b.msg(msgRememberSaved)
+	if err := b.sessions.Remember(note); err != nil {
+		slog.Error("remember failed", "chat_id", update.Message.Chat.ID, "error", err)
+		text = b.msg(msgRememberFailed)
+	}
+	b.reply(ctx, tg, update.Message, text)
+}
+
+// handleCompactMemory has the agent rewrite the shared memory file more
+// concisely and reports the size change. It rewrites what every session
+// loads, so it is admin-only.
+func (b *Bot) handleCompactMemory(ctx context.Context, tg *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	if !b.isAdmin(originOf(update.Message).UserID) {
+		b.reply(ctx, tg, update.Message, b.msg(msgCompactAdminOnly))
+		return
+	}
+	if b.turnsPaused(ctx, tg, update.Message) {
+		return
+	}
+
+	b.reply(ctx, tg, update.Message, b.msg(msgCompactStarted))
+	before, after, err := b.sessions.CompactMemory(ctx)
+	if err != nil {
+		text := b.msg(msgCompactFailed)
+		switch {
+		case errors.Is(err, session.ErrNoMemory):
+			text = b.msg(msgCompactEmpty)
+		case errors.Is(err, session.ErrMemoryChanged):
+			text = b.msg(msgCompactChanged)
+		default:
+			slog.Error("compact memory failed", "chat_id", update.Message.Chat.ID, "error", err)
+		}
+		b.reply(ctx, tg, update.Message, text)
+		return
+	}
+	b.reply(ctx, tg, update.Message, b.msg(msgCompactDone, before, after))
+}
+
+// handleRun sends the workspace prompt template named by "/run <name>" as
+// if the user had typed it.
+func (b *Bot) handleRun(ctx context.Context, tg *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	name := commandArgs(update.Message.Text)
+	if name == "" {
+		b.reply(ctx, tg, update.Message, b.msg(msgRunUsage))
+		return
+	}
+
+	prompt, err := b.sessions.Prompt(originOf(update.Message), name)
+	if err != nil {
+		text := b.msg(msgSendFailed)
+		switch {
+		case errors.Is(err, session.ErrNoPrompt):
+			text = b.msg(msgRunNoPrompt, name, session.PromptDir)
+		case errors.Is(err, session.ErrInvalidPromptName):
+			text = b.msg(msgRunBadName)
+		default:
+			slog.Error("load prompt failed", "chat_id", update.Message.Chat.ID, "prompt", name, "error", err)
+		}
+		b.reply(ctx, tg, update.Message, text)
+		return
 	}
 
-	opts := []bot.Option{
-		bot.WithMiddlewares(b.authMiddleware),
-		bot.WithMessageTextHandler("/new", bot.MatchTypePrefix, b.handleNew),
-		bot.WithMessageTextHandler("/status", bot.MatchTypePrefix, b.handleStatus),
-		bot.WithDefaultHandler(b.handleMessage),
+	b.runTurn(reqid.With(ctx, reqid.New()), tg, update.Message, prompt)
+}
+
+// handleReloadIdentity re-sends the soul and shared memory into the chat's
+// running session and streams the agent's acknowledgement. It can't change
+// the spawn-time system prompt, so it's a best-effort refresh; /new starts
+// a session with the new identity for real. Telegram command names can't
+// contain hyphens, so the menu lists it as /reload_identity.
+func (b *Bot) handleReloadIdentity(ctx context.Context, tg *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	if b.turnsPaused(ctx, tg, update.Message) {
+		return
 	}
+	origin := originOf(update.Message)
 
-	tgBot, err := bot.New(cfg.BotToken, opts...)
+	events, err := b.sessions.ReloadIdentity(ctx, origin)
 	if err != nil {
-		return nil, fmt.Errorf("create telegram bot: %w", err)
+		text := b.msg(msgSendFailed)
+		switch {
+		case errors.Is(err, session.ErrNoSession):
+			text = b.msg(msgReloadNoSession)
+		case errors.Is(err, session.ErrNoIdentity):
+			text = b.msg(msgReloadNoIdentity)
+		default:
+			slog.Error("reload identity failed", "chat_id", origin.ChatID, "error", err)
+		}
+		b.reply(ctx, tg, update.Message, text)
+		return
 	}
 
-	b.bot = tgBot
-	return b, nil
+	b.reply(ctx, tg, update.Message, b.msg(msgReloadSent))
+	b.streamResponse(ctx, tg, origin.ChatID, origin.ThreadID, events)
 }
 
-// Start begins long polling. Blocks until ctx is cancelled.
-func (b *Bot) Start(ctx context.Context) {
-	slog.Info("telegram bot starting long poll")
-	b.bot.Start(ctx)
-}
+// handleLog shows "/log [N]" recent activity lines from the chat's session.
+// Stderr can leak paths and secrets, so it is admin-only.
+func (b *Bot) handleLog(ctx context.Context, tg *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	origin := originOf(update.Message)
+	if !b.isAdmin(origin.UserID) {
+		b.reply(ctx, tg, update.Message, b.msg(msgLogAdminOnly))
+		return
+	}
 
-// authMiddleware silently drops messages from unauthorized users.
-func (b *Bot) authMiddleware(next bot.HandlerFunc) bot.HandlerFunc {
-	return func(ctx context.Context, tg *bot.Bot, update *models.Update) {
-		if update.Message == nil || update.Message.From == nil {
-			return
-		}
-		if !b.allowed[update.Message.From.ID] {
-			slog.Warn("unauthorized message", "user_id", update.Message.From.ID)
+	n := defaultLogLines
+	if arg := commandArgs(update.Message.Text); arg != "" {
+		v, err := strconv.Atoi(arg)
+		if err != nil || v <= 0 || v > maxLogLines {
+			b.reply(ctx, tg, update.Message, b.msg(msgLogUsage, maxLogLines))
 			return
 		}
-		next(ctx, tg, update)
+		n = v
+	}
+
+	lines, ok := b.sessions.Activity(origin, n)
+	if !ok || len(lines) == 0 {
+		b.reply(ctx, tg, update.Message, b.msg(msgLogEmpty))
+		return
+	}
+
+	_, err := tg.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:          origin.ChatID,
+		MessageThreadID: origin.ThreadID,
+		Text:            logBlockV2(lines, maxMessageLen),
+		ParseMode:       models.ParseModeMarkdown,
+	})
+	if err != nil {
+		slog.Error("send activity log failed", "chat_id", origin.ChatID, "error", err)
 	}
 }
 
-// handleMessage processes an incoming text message.
-func (b *Bot) handleMessage(ctx context.Context, tg *bot.Bot, update *models.Update) {
-	if update.Message == nil || update.Message.Text == "" {
+// handleDebugLast sends the raw stream output of the chat's last turn as an
+// NDJSON file. Admin-only, like /log.
+func (b *Bot) handleDebugLast(ctx context.Context, tg *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	origin := originOf(update.Message)
+	if !b.isAdmin(origin.UserID) {
+		b.reply(ctx, tg, update.Message, b.msg(msgDebugAdminOnly))
 		return
 	}
 
-	chat := update.Message.Chat
-	chatID := chat.ID
-	text := update.Message.Text
+	lines, ok := b.sessions.LastTurnRaw(origin)
+	if !ok || len(lines) == 0 {
+		b.reply(ctx, tg, update.Message, b.msg(msgDebugEmpty))
+		return
+	}
 
-	// Send typing indicator
-	tg.SendChatAction(ctx, &bot.SendChatActionParams{
-		ChatID: chatID,
-		Action: models.ChatActionTyping,
+	// A turn's NDJSON is mostly long delta lines; only the whole of it is
+	// useful, so it goes as a file rather than a cut message.
+	b.sendChatAction(ctx, tg, origin.ChatID, origin.ThreadID, models.ChatActionUploadDocument)
+	_, err := tg.SendDocument(ctx, &bot.SendDocumentParams{
+		ChatID:          origin.ChatID,
+		MessageThreadID: origin.ThreadID,
+		Document: &models.InputFileUpload{
+			Filename: "last-turn.ndjson",
+			Data:     strings.NewReader(strings.Join(lines, "\n") + "\n"),
+		},
 	})
-
-	events, err := b.sessions.Send(ctx, chatID, chat.Username, chat.Title, text)
 	if err != nil {
-		slog.Error("session send failed", "chat_id", chatID, "error", err)
-		tg.SendMessage(ctx, &bot.SendMessageParams{
-			ChatID: chatID,
-			Text:   "Something went wrong. Please try again.",
-		})
-		return
+		slog.Error("send raw turn output failed", "chat_id", origin.ChatID, "error", err)
 	}
+}
 
-	b.streamResponse(ctx, tg, chatID, events)
+// logBlockV2 renders lines as a MarkdownV2 code block of at most limit
+// runes, shortening long lines and dropping the oldest lines to fit.
+func logBlockV2(lines []string, limit int) string {
+	escaped := make([]string, len(lines))
+	for i, line := range lines {
+		if utf8.RuneCountInString(line) > maxLogLineLen {
+			line = truncateRunes(line, maxLogLineLen-3) + "..."
+		}
+		line = strings.ReplaceAll(line, `\`, `\\`)
+		escaped[i] = strings.ReplaceAll(line, "`", "\\`")
+	}
+	for {
+		text := "```\n" + strings.Join(escaped, "\n") + "\n```"
+		if utf8.RuneCountInString(text) <= limit || len(escaped) == 1 {
+			return text
+		}
+		escaped = escaped[1:]
+	}
 }
 
-// handleNew clears the active session so the next message starts a fresh conversation.
-func (b *Bot) handleNew(ctx context.Context, tg *bot.Bot, update *models.Update) {
+// handleWorkspaces lists the available workspaces, marking the chat's.
+func (b *Bot) handleWorkspaces(ctx context.Context, tg *bot.Bot, update *models.Update) {
 	if update.Message == nil {
 		return
 	}
-	chatID := update.Message.Chat.ID
-	b.sessions.Reset(chatID)
-	tg.SendMessage(ctx, &bot.SendMessageParams{
-		ChatID: chatID,
-		Text:   "Session cleared. Starting fresh.",
-	})
+
+	lines := []string{b.msg(msgWorkspacesHeader)}
+	for _, ws := range b.sessions.Workspaces(originOf(update.Message)) {
+		if ws.Active {
+			lines = append(lines, b.msg(msgWorkspacesActive, ws.Name))
+		} else {
+			lines = append(lines, b.msg(msgWorkspacesItem, ws.Name))
+		}
+	}
+
+	b.reply(ctx, tg, update.Message, strings.Join(lines, "\n"))
 }
 
-// handleStatus reports the current session state for the chat.
-func (b *Bot) handleStatus(ctx context.Context, tg *bot.Bot, update *models.Update) {
+// handleRaw replays the last response as plain text, showing the markdown
+// exactly as the agent wrote it rather than the MarkdownV2 rendering.
+func (b *Bot) handleRaw(ctx context.Context, tg *bot.Bot, update *models.Update) {
 	if update.Message == nil {
 		return
 	}
-	chatID := update.Message.Chat.ID
-	info := b.sessions.Status(chatID)
 
-	var text string
-	if !info.Exists {
-		text = "No active session. Send a message to start one."
-	} else {
-		age := time.Since(info.CreatedAt).Round(time.Second)
-		text = fmt.Sprintf("Active since %s (%s ago)\nWorkspace: %s",
-			info.CreatedAt.Format("15:04"),
-			formatDuration(age),
-			info.Workspace,
-		)
+	text, truncated := b.sessions.LastResponse(originOf(update.Message))
+	if text == "" {
+		b.reply(ctx, tg, update.Message, b.msg(msgRawEmpty))
+		return
 	}
 
-	tg.SendMessage(ctx, &bot.SendMessageParams{
-		ChatID: chatID,
-		Text:   text,
+	notice := "\n\n" + b.msg(msgRawTruncated)
+	if truncated || utf8.RuneCountInString(text) > maxMessageLen {
+		text = truncateRunes(text, maxMessageLen-utf8.RuneCountInString(notice)) + notice
+	}
+	b.reply(ctx, tg, update.Message, text)
+}
+
+// reply sends a plain-text message to the chat (and forum topic) msg came from.
+func (b *Bot) reply(ctx context.Context, tg *bot.Bot, msg *models.Message, text string) {
+	origin := originOf(msg)
+	_, err := tg.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:          origin.ChatID,
+		MessageThreadID: origin.ThreadID,
+		Text:            text,
 	})
+	if err != nil {
+		slog.Error("send reply failed", "chat_id", origin.ChatID, "error", err)
+	}
+}
+
+// commandName returns the lowercase command in text without its slash or
+// bot mention, so "/New@natronbot x" yields "new". Hyphens read as
+// underscores, so aliases like /reload-identity share their command's
+// policy. It is "" for text that isn't a command.
+func commandName(text string) string {
+	if !strings.HasPrefix(text, "/") {
+		return ""
+	}
+	fields := strings.Fields(text[1:])
+	if len(fields) == 0 {
+		return ""
+	}
+	token, _, _ := strings.Cut(fields[0], "@")
+	return strings.ReplaceAll(strings.ToLower(token), "-", "_")
+}
+
+// commandArgs returns the trimmed text after the command token, so both
+// "/cmd a b" and "/cmd@natronbot a b" yield "a b".
+func commandArgs(text string) string {
+	_, args, _ := strings.Cut(strings.TrimSpace(text), " ")
+	return strings.TrimSpace(args)
+}
+
+// originOf describes the chat and sender of a message for the session layer.
+func originOf(msg *models.Message) session.Origin {
+	origin := session.Origin{
+		ChatID:   msg.Chat.ID,
+		Username: msg.Chat.Username,
+		Title:    msg.Chat.Title,
+		Group:    msg.Chat.Type == models.ChatTypeGroup || msg.Chat.Type == models.ChatTypeSupergroup,
+	}
+	if msg.From != nil {
+		origin.UserID = msg.From.ID
+		origin.Sender = msg.From.Username
+		if origin.Sender == "" {
+			origin.Sender = strings.TrimSpace(msg.From.FirstName + " " + msg.From.LastName)
+		}
+	}
+	if msg.IsTopicMessage {
+		origin.ThreadID = msg.MessageThreadID
+	}
+	return origin
 }
 
 // formatDuration returns a human-readable duration string (e.g. "2h 5m", "45s").
@@ -173,17 +1451,96 @@ func formatDuration(d time.Duration) string {
 	return fmt.Sprintf("%ds", s)
 }
 
+// errNoMessage is a SendMessage that returned neither a message nor an
+// error, which leaves nothing to edit.
+var errNoMessage = errors.New("telegram returned no message")
+
 // streamResponse sends an initial message and edits it in place as events
 // arrive. Splits into new messages if the response exceeds 4096 chars.
-// Intermediate edits are plain text; the final edit uses MarkdownV2.
-func (b *Bot) streamResponse(ctx context.Context, tg *bot.Bot, chatID int64, events <-chan executor.Event) {
+// Intermediate edits are plain text unless session.format_streaming is set;
+// the final edit uses MarkdownV2, falling back to plain text if Telegram
+// can't parse it. Its Telegram calls go through the send scheduler when one
+// is configured.
+func (b *Bot) streamResponse(ctx context.Context, tg sender, chatID int64, threadID int, events <-chan executor.Event) {
+	b.streamInto(ctx, tg, chatID, threadID, 0, nil, events)
+}
+
+// streamInto is streamResponse starting from an existing message, replyID,
+// when set. A non-nil keyboard goes on the finished response, and the
+// first message sent removes the one left by the previous turn. It returns
+// the ID of the response's first message, or 0 if none was sent.
+func (b *Bot) streamInto(ctx context.Context, tg sender, chatID int64, threadID, replyID int, keyboard models.ReplyMarkup, events <-chan executor.Event) (firstID int) {
 	var (
-		msgID    int
+		msgID    = replyID
 		buf      strings.Builder
 		lastEdit string
-		ticker   = time.NewTicker(b.editIvl)
+		lastLen  int // rune length of buf at the previous tick
+		edits    int // EditMessageText calls, for session.max_edits_per_turn
+		// The first interval uses the unscaled base cadence.
+		timer  = time.NewTimer(nextEditInterval(b.editIvl, trickleRunes, rand.Float64))
+		status *turnStatus // nil unless status messages are enabled
+		reqID  = reqid.FromContext(ctx)
+		// Typing is refreshed until the turn ends; respond shows it first.
+		actionTick = time.NewTicker(chatActionInterval)
+	)
+	defer timer.Stop()
+	defer actionTick.Stop()
+	firstID = replyID
+
+	// markup goes on the next message sent.
+	var markup models.ReplyMarkup
+	if keyboard != nil {
+		markup = &models.ReplyKeyboardRemove{RemoveKeyboard: true}
+	}
+
+	if b.statusMsg {
+		status = &turnStatus{started: time.Now(), locale: b.locale}
+		defer b.clearStatus(tg, chatID, status)
+	}
+
+	// deliver sends the response's first message or edits it in place.
+	deliver := func(text string, parseMode models.ParseMode) error {
+		if msgID == 0 {
+			params := &bot.SendMessageParams{
+				ChatID:          chatID,
+				MessageThreadID: threadID,
+				Text:            text,
+				ParseMode:       parseMode,
+				ReplyMarkup:     markup,
+			}
+			sent, err := tg.SendMessage(ctx, params)
+			if err != nil {
+				return err
+			}
+			if sent == nil || sent.ID == 0 {
+				return errNoMessage
+			}
+			markup = nil
+			msgID = sent.ID
+			if firstID == 0 {
+				firstID = msgID
+			}
+			return nil
+		}
+		edits++
+		_, err := tg.EditMessageText(ctx, &bot.EditMessageTextParams{
+			ChatID:    chatID,
+			MessageID: msgID,
+			Text:      text,
+			ParseMode: parseMode,
+		})
+		return err
+	}
+
+	// unparsable is the last buffer whose MarkdownV2 render Telegram
+	// rejected, so intermediate edits don't retry it every tick. aborted
+	// is set when a send came back without a message; the turn can't be
+	// shown, so the stream gives up on it.
+	var (
+		unparsable string
+		aborted    bool
+		split      bool // The response outgrew its first message
 	)
-	defer ticker.Stop()
 
 	flush := func(final bool) {
 		raw := buf.String()
@@ -191,90 +1548,224 @@ func (b *Bot) streamResponse(ctx context.Context, tg *bot.Bot, chatID int64, eve
 			return
 		}
 
-		var sendText string
-		var parseMode models.ParseMode
-		if final {
-			sendText = formatV2(raw)
-			parseMode = models.ParseModeMarkdown // maps to "MarkdownV2" in this library
-		} else {
-			sendText = raw
+		sendText, parseMode := raw, models.ParseMode("")
+		if final || (b.fmtStream && raw != unparsable) {
+			sendText, parseMode = renderFinal(raw)
 		}
 
 		if sendText == lastEdit {
 			return
 		}
 
-		// Truncate to max length for current message
+		// Truncate to max length for current message. A render that
+		// doesn't fit goes out as plain text, with a notice if even the
+		// raw text had to be cut from a final one.
 		if utf8.RuneCountInString(sendText) > maxMessageLen {
-			sendText = truncateRunes(sendText, maxMessageLen-3) + "..."
+			switch {
+			case !final:
+				sendText, parseMode = truncateRunes(raw, maxMessageLen-3)+"...", ""
+			case utf8.RuneCountInString(raw) <= maxMessageLen:
+				sendText, parseMode = raw, ""
+			default:
+				notice := "\n\n" + b.msg(msgResponseTruncated)
+				sendText = truncateRunes(raw, maxMessageLen-utf8.RuneCountInString(notice)) + notice
+				parseMode = ""
+			}
 		}
 
-		if msgID == 0 {
-			sent, err := tg.SendMessage(ctx, &bot.SendMessageParams{
-				ChatID:    chatID,
-				Text:      sendText,
-				ParseMode: parseMode,
-			})
-			if err != nil {
-				slog.Error("send message failed", "error", err)
-				return
-			}
-			msgID = sent.ID
-		} else {
-			_, err := tg.EditMessageText(ctx, &bot.EditMessageTextParams{
-				ChatID:    chatID,
-				MessageID: msgID,
-				Text:      sendText,
-				ParseMode: parseMode,
-			})
-			if err != nil {
-				slog.Debug("edit message failed", "error", err)
+		if b.sched.wait(ctx, chatID) != nil {
+			return
+		}
+		sending := msgID == 0
+		err := deliver(sendText, parseMode)
+		if err != nil && parseMode != "" && isParseError(err) {
+			// Formatting a half-streamed reply can leave markdown open;
+			// show it plain until more text arrives.
+			slog.Debug("markdown rejected, sending plain", "chat_id", chatID, "request_id", reqID, "error", err)
+			unparsable = raw
+			sendText = raw
+			if utf8.RuneCountInString(sendText) > maxMessageLen {
+				sendText = truncateRunes(raw, maxMessageLen-3) + "..."
 			}
+			err = deliver(sendText, "")
+		}
+		switch {
+		case err != nil && sending:
+			slog.Error("send message failed", "chat_id", chatID, "request_id", reqID, "error", err)
+			aborted = errors.Is(err, errNoMessage)
+			return
+		// "Not modified" happens when the final MarkdownV2 render displays
+		// identically to the last plain edit; it's benign.
+		case err != nil && !isNotModified(err):
+			slog.Debug("edit message failed", "chat_id", chatID, "request_id", reqID, "error", err)
 		}
 		lastEdit = sendText
 	}
 
+	// endWith appends notice to the partial response, moving it to a new
+	// message if it doesn't fit, and sends it.
+	endWith := func(notice string) {
+		if buf.Len() > 0 {
+			notice = "\n\n" + notice
+		}
+		if utf8.RuneCountInString(buf.String())+utf8.RuneCountInString(notice) > maxMessageLen {
+			flush(false)
+			buf.Reset()
+			lastEdit = ""
+			msgID = 0
+			notice = strings.TrimPrefix(notice, "\n\n")
+		}
+		buf.WriteString(notice)
+		flush(false)
+	}
+
+	// detach deletes the message the response is streaming into, so the
+	// final text goes out as a new one: Telegram only attaches reply
+	// keyboards to new messages. If the delete fails the message is
+	// edited as usual, without the keyboard.
+	detach := func() {
+		if msgID == 0 {
+			return
+		}
+		_, err := tg.DeleteMessage(ctx, &bot.DeleteMessageParams{ChatID: chatID, MessageID: msgID})
+		if err != nil {
+			slog.Debug("delete streamed message failed", "chat_id", chatID, "request_id", reqID, "error", err)
+			return
+		}
+		if firstID == msgID {
+			firstID = 0
+		}
+		msgID, lastEdit = 0, ""
+	}
+
 	for {
+		if aborted {
+			slog.Error("response aborted", "chat_id", chatID, "request_id", reqID, "error", errNoMessage)
+			// Keep draining so the executor never blocks on the turn.
+			go func() {
+				for range events {
+				}
+			}()
+			// Say so in a fresh message rather than leave the chat silent.
+			if b.sched.wait(ctx, chatID) == nil {
+				_, err := tg.SendMessage(ctx, &bot.SendMessageParams{
+					ChatID:          chatID,
+					MessageThreadID: threadID,
+					Text:            b.msg(msgTurnAborted),
+				})
+				if err != nil {
+					slog.Debug("send abort notice failed", "chat_id", chatID, "request_id", reqID, "error", err)
+				}
+			}
+			return
+		}
 		select {
 		case evt, ok := <-events:
 			if !ok {
-				// Channel closed — final flush
-				flush(true)
+				// Closed without EventDone or EventError: the turn was
+				// cut off, so what arrived is partial.
+				slog.Warn("response ended abruptly", "chat_id", chatID, "request_id", reqID)
+				endWith(b.msg(msgTurnAborted))
 				return
 			}
 
+			if status != nil {
+				status.observe(evt)
+			}
+
 			switch evt.Type {
 			case executor.EventText:
+				text := validText(evt.Text)
 				// If adding this text would exceed the limit, flush current
 				// message and start a new one.
-				if utf8.RuneCountInString(buf.String())+utf8.RuneCountInString(evt.Text) > maxMessageLen {
+				if utf8.RuneCountInString(buf.String())+utf8.RuneCountInString(text) > maxMessageLen {
 					flush(true)
 					buf.Reset()
 					lastEdit = ""
+					lastLen = 0
 					msgID = 0
+					split = true
+				}
+				buf.WriteString(text)
+
+			case executor.EventToolResult:
+				if b.toolOut != nil {
+					b.sendToolOutput(ctx, tg, chatID, threadID, evt.Tool, evt.Text)
 				}
-				buf.WriteString(evt.Text)
 
 			case executor.EventDone:
 				// Final text — replace buffer if non-empty
 				if evt.Text != "" {
 					buf.Reset()
-					buf.WriteString(evt.Text)
+					buf.WriteString(validText(evt.Text))
+				}
+				// After a split the start of the response, preamble
+				// and all, already went out in an earlier message.
+				if trimmed := trimPreamble(buf.String(), b.preambles); !split && trimmed != buf.String() {
+					buf.Reset()
+					buf.WriteString(trimmed)
+				}
+				// Tool-only turns end without prose; still give closure.
+				if strings.TrimSpace(buf.String()) == "" {
+					buf.Reset()
+					buf.WriteString(b.emptyReply)
+				}
+				full := buf.String()
+				var blocks []string
+				if b.splitCode {
+					var prose string
+					prose, blocks = splitCodeBlocks(buf.String(), maxCodeMessages)
+					buf.Reset()
+					buf.WriteString(prose)
+				}
+				if keyboard != nil {
+					detach()
+					markup = keyboard
 				}
 				flush(true)
+				b.sendCodeBlocks(ctx, tg, chatID, threadID, blocks)
+				b.mirror(ctx, tg, chatID, full)
 				return
 
 			case executor.EventError:
-				slog.Error("executor error", "error", evt.Error)
-				if buf.Len() == 0 {
-					buf.WriteString("An error occurred while processing your message.")
+				if errors.Is(evt.Error, session.ErrSessionReset) {
+					slog.Info("response stopped by session reset", "chat_id", chatID, "request_id", reqID)
+					endWith(b.msg(msgTurnReset))
+					return
+				}
+				slog.Error("executor error", "chat_id", chatID, "request_id", reqID, "error", evt.Error)
+				var unknownModel *executor.UnknownModelError
+				if errors.Is(evt.Error, executor.ErrNotAuthenticated) {
+					buf.Reset()
+					buf.WriteString(b.msg(msgNotAuthenticated))
+				} else if errors.As(evt.Error, &unknownModel) {
+					buf.Reset()
+					buf.WriteString(b.msg(msgUnknownModel, unknownModel.Model))
+				} else if buf.Len() == 0 {
+					buf.WriteString(b.msg(msgTurnError))
 				}
 				flush(false)
 				return
 			}
 
-		case <-ticker.C:
-			flush(false)
+		case <-timer.C:
+			n := utf8.RuneCountInString(buf.String())
+			grown := n - lastLen
+			lastLen = n
+			// Hold back a tiny first message until there's enough to read,
+			// and stop editing once the turn's cap is spent; the end of
+			// the turn sends whatever is buffered.
+			capped := msgID != 0 && b.maxEdits > 0 && edits >= b.maxEdits
+			if (msgID != 0 || n >= b.minFirst) && !capped {
+				flush(false)
+			}
+			if status != nil {
+				b.updateStatus(ctx, tg, chatID, threadID, status)
+			}
+			timer.Reset(nextEditInterval(b.editIvl, grown, rand.Float64))
+
+		case <-actionTick.C:
+			b.sendChatAction(ctx, tg, chatID, threadID, models.ChatActionTyping)
 
 		case <-ctx.Done():
 			return
@@ -282,6 +1773,113 @@ func (b *Bot) streamResponse(ctx context.Context, tg *bot.Bot, chatID int64, eve
 	}
 }
 
+// sendCodeBlocks sends each extracted code block as its own message so it
+// can be copied on its own. Blocks too long for MarkdownV2 go as plain text.
+func (b *Bot) sendCodeBlocks(ctx context.Context, tg sender, chatID int64, threadID int, blocks []string) {
+	for _, block := range blocks {
+		params := &bot.SendMessageParams{
+			ChatID:          chatID,
+			MessageThreadID: threadID,
+			Text:            formatV2(block),
+			ParseMode:       models.ParseModeMarkdown,
+		}
+		if utf8.RuneCountInString(params.Text) > maxMessageLen {
+			params.Text = truncateRunes(block, maxMessageLen-3) + "..."
+			params.ParseMode = ""
+		}
+		if b.sched.wait(ctx, chatID) != nil {
+			return
+		}
+		if _, err := tg.SendMessage(ctx, params); err != nil {
+			slog.Error("send code block failed", "chat_id", chatID, "error", err)
+			return
+		}
+	}
+}
+
+// mirror posts a finished response to the chat's observer chats. Failures
+// are logged and never affect the primary chat.
+func (b *Bot) mirror(ctx context.Context, tg sender, chatID int64, raw string) {
+	for _, id := range b.cfg.Observers[chatID] {
+		params := &bot.SendMessageParams{ChatID: id}
+		params.Text, params.ParseMode = renderFinal(raw)
+		if utf8.RuneCountInString(params.Text) > maxMessageLen {
+			params.Text, params.ParseMode = truncateRunes(raw, maxMessageLen-3)+"...", ""
+		}
+		if b.sched.wait(ctx, id) != nil {
+			return
+		}
+		if _, err := tg.SendMessage(ctx, params); err != nil {
+			slog.Warn("mirror to observer failed", "chat_id", chatID, "observer_id", id, "error", err)
+		}
+	}
+}
+
+// renderFinal picks the text and parse mode for a response's last edit.
+// Text without code, bold spans or tables displays the same in MarkdownV2
+// as in plain text, so it stays plain; the final flush then matches the last
+// streamed edit and is skipped instead of re-sending identical content.
+func renderFinal(raw string) (string, models.ParseMode) {
+	if !strings.Contains(raw, "`") && !strings.Contains(raw, "**") && !hasTable(raw) {
+		return raw, ""
+	}
+	return formatV2(raw), models.ParseModeMarkdown // maps to "MarkdownV2" in this library
+}
+
+// isParseError reports whether err is Telegram rejecting a message's
+// formatting, such as MarkdownV2 with an unclosed entity.
+func isParseError(err error) bool {
+	return errors.Is(err, bot.ErrorBadRequest) && strings.Contains(err.Error(), "can't parse entities")
+}
+
+// isNotModified reports whether err is Telegram rejecting an edit because
+// the new content is identical to the message's current content.
+func isNotModified(err error) bool {
+	return errors.Is(err, bot.ErrorBadRequest) && strings.Contains(err.Error(), "message is not modified")
+}
+
+// nextEditInterval picks the delay before the next streaming edit. grown is
+// the number of runes that arrived during the previous interval: a burst of
+// new text shortens the delay, a trickle lengthens it. rnd returns values in
+// [0, 1) and drives the jitter.
+func nextEditInterval(base time.Duration, grown int, rnd func() float64) time.Duration {
+	factor := 1.0
+	switch {
+	case grown >= burstRunes:
+		factor = burstFactor
+	case grown < trickleRunes:
+		factor = trickleFactor
+	}
+	factor *= 1 + editJitter*(2*rnd()-1)
+	return time.Duration(float64(base) * factor)
+}
+
+// trimPreamble removes each matching session.trim_preambles pattern, in
+// order, from the start of text. A response that is nothing but preamble
+// is kept as is.
+func trimPreamble(text string, patterns []*regexp.Regexp) string {
+	trimmed := text
+	for _, re := range patterns {
+		if loc := re.FindStringIndex(trimmed); loc != nil {
+			trimmed = trimmed[loc[1]:]
+		}
+	}
+	if strings.TrimSpace(trimmed) == "" {
+		return text
+	}
+	return trimmed
+}
+
+// validText replaces invalid UTF-8, such as binary output a tool dumped
+// into the response, with U+FFFD, so rune counting and truncation stay
+// sound and Telegram accepts the message.
+func validText(s string) string {
+	if utf8.ValidString(s) {
+		return s
+	}
+	return strings.ToValidUTF8(s, "\uFFFD")
+}
+
 // truncateRunes returns the first n runes of s.
 func truncateRunes(s string, n int) string {
 	i := 0
@@ -298,29 +1896,37 @@ func truncateRunes(s string, n int) string {
 //
 // Code fences (``` ... ```) are preserved with their language hint; content
 // inside is escaped (only \ and ` need escaping in a code block). Inline code
-// spans (` ... `) are preserved similarly. All other MarkdownV2 special
-// characters are escaped in plain-text segments so the message is never
-// rejected by Telegram. Bold/italic/headers are not converted — they render
-// as their literal characters, which is readable.
+// spans (` ... `) are preserved similarly. Markdown tables become aligned
+// preformatted blocks, since escaping every | and - makes them unreadable.
+// All other MarkdownV2 special characters are escaped in plain-text segments
+// so the message is never rejected by Telegram. Bold/italic/headers are not
+// converted — they render as their literal characters, which is readable.
 func formatV2(text string) string {
 	lines := strings.Split(text, "\n")
 	out := make([]string, 0, len(lines))
 	inFence := false
 
-	for _, line := range lines {
-		if strings.HasPrefix(line, "```") {
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if isFence(line) {
 			inFence = !inFence
 			out = append(out, line) // fence delimiters pass through unchanged
 			continue
 		}
 		if inFence {
-			// Escape only backslash and backtick inside code blocks.
-			line = strings.ReplaceAll(line, `\`, `\\`)
-			line = strings.ReplaceAll(line, "`", "\\`")
-			out = append(out, line)
-		} else {
-			out = append(out, escapeV2Line(line))
+			out = append(out, escapeCode(line))
+			continue
 		}
+		if n := tableLen(lines[i:]); n > 0 {
+			out = append(out, "```")
+			for _, row := range alignTable(lines[i : i+n]) {
+				out = append(out, escapeCode(row))
+			}
+			out = append(out, "```")
+			i += n - 1
+			continue
+		}
+		out = append(out, escapeV2Line(line))
 	}
 
 	// If input had an unclosed fence, close it so Telegram doesn't reject it.
@@ -331,6 +1937,142 @@ func formatV2(text string) string {
 	return strings.Join(out, "\n")
 }
 
+// escapeCode escapes a line for a MarkdownV2 code block, where only
+// backslash and backtick are special.
+func escapeCode(line string) string {
+	line = strings.ReplaceAll(line, `\`, `\\`)
+	return strings.ReplaceAll(line, "`", "\\`")
+}
+
+// tableLen returns how many lines at the start of lines form a markdown
+// table: a header row, a separator row of dashes, and the rows after it
+// that contain a pipe. It returns 0 when lines don't start with a table.
+func tableLen(lines []string) int {
+	if len(lines) < 2 || !strings.Contains(lines[0], "|") || !isTableSeparator(lines[1]) {
+		return 0
+	}
+	n := 2
+	for n < len(lines) && strings.Contains(lines[n], "|") {
+		n++
+	}
+	return n
+}
+
+// isTableSeparator reports whether line is a table's header separator,
+// like "|---|:---:|".
+func isTableSeparator(line string) bool {
+	if !strings.Contains(line, "-") {
+		return false
+	}
+	for _, cell := range tableCells(line) {
+		cell = strings.TrimSuffix(strings.TrimPrefix(cell, ":"), ":")
+		if cell == "" || strings.Trim(cell, "-") != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// tableCells splits a table row into trimmed cells, ignoring the optional
+// outer pipes.
+func tableCells(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimSuffix(strings.TrimPrefix(line, "|"), "|")
+	cells := strings.Split(line, "|")
+	for i, cell := range cells {
+		cells[i] = strings.TrimSpace(cell)
+	}
+	return cells
+}
+
+// alignTable pads a table's cells to common column widths. The separator
+// row becomes a plain rule.
+func alignTable(lines []string) []string {
+	rows := make([][]string, len(lines))
+	var widths []int
+	for i, line := range lines {
+		rows[i] = tableCells(line)
+		if i == 1 {
+			continue // the separator doesn't set widths
+		}
+		for j, cell := range rows[i] {
+			if j == len(widths) {
+				widths = append(widths, 0)
+			}
+			widths[j] = max(widths[j], utf8.RuneCountInString(cell))
+		}
+	}
+
+	out := make([]string, len(rows))
+	for i, cells := range rows {
+		parts := make([]string, len(widths))
+		for j, w := range widths {
+			if i == 1 {
+				parts[j] = strings.Repeat("-", w)
+				continue
+			}
+			var cell string
+			if j < len(cells) {
+				cell = cells[j]
+			}
+			parts[j] = cell + strings.Repeat(" ", w-utf8.RuneCountInString(cell))
+		}
+		if i == 1 {
+			out[i] = strings.Join(parts, "-+-")
+		} else {
+			out[i] = strings.TrimRight(strings.Join(parts, " | "), " ")
+		}
+	}
+	return out
+}
+
+// hasTable reports whether text contains a markdown table.
+func hasTable(text string) bool {
+	lines := strings.Split(text, "\n")
+	for i := range lines {
+		if tableLen(lines[i:]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// isFence reports whether line opens or closes a fenced code block.
+func isFence(line string) bool {
+	return strings.HasPrefix(line, "```")
+}
+
+// splitCodeBlocks moves up to limit fenced code blocks out of text, leaving
+// a "[code N]" marker where each was. Blocks past the limit stay inline.
+// Each returned block keeps its fences, and an unclosed final block is
+// closed.
+func splitCodeBlocks(text string, limit int) (prose string, blocks []string) {
+	var out, block []string
+	inFence := false
+
+	for _, line := range strings.Split(text, "\n") {
+		switch {
+		case !inFence && isFence(line) && len(blocks) < limit:
+			inFence = true
+			block = []string{line}
+		case inFence && isFence(line):
+			inFence = false
+			blocks = append(blocks, strings.Join(append(block, line), "\n"))
+			out = append(out, fmt.Sprintf("[code %d]", len(blocks)))
+		case inFence:
+			block = append(block, line)
+		default:
+			out = append(out, line)
+		}
+	}
+	if inFence {
+		blocks = append(blocks, strings.Join(append(block, "```"), "\n"))
+		out = append(out, fmt.Sprintf("[code %d]", len(blocks)))
+	}
+
+	return strings.Join(out, "\n"), blocks
+}
+
 // escapeV2Line escapes a single plain-text line for Telegram MarkdownV2.
 // Inline code spans (` ... `) and bold spans (**...**) are preserved and
 // converted to their MarkdownV2 equivalents. Everything else has special
@@ -0,0 +1,1123 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+
+	"github.com/zette-dev/natron/internal/config"
+	"github.com/zette-dev/natron/internal/executor"
+	"github.com/zette-dev/natron/internal/session"
+	"github.com/zette-dev/natron/internal/transcript"
+)
+
+func TestNextEditInterval(t *testing.T) {
+	base := 2 * time.Second
+	mid := func() float64 { return 0.5 } // no jitter
+
+	tests := []struct {
+		name  string
+		grown int
+		want  time.Duration
+	}{
+		{"steady", 100, base},
+		{"burst", burstRunes, time.Second},
+		{"trickle", 5, 3 * time.Second},
+		{"nothing new", 0, 3 * time.Second},
+		{"after split", -50, 3 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := nextEditInterval(base, tt.grown, mid); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestNextEditInterval_JitterBounded(t *testing.T) {
+	base := 2 * time.Second
+
+	low := nextEditInterval(base, 100, func() float64 { return 0 })
+	high := nextEditInterval(base, 100, func() float64 { return 0.999999 })
+
+	if low != time.Duration(float64(base)*(1-editJitter)) {
+		t.Errorf("expected lower bound %v, got %v", time.Duration(float64(base)*(1-editJitter)), low)
+	}
+	if high <= base || high > time.Duration(float64(base)*(1+editJitter)) {
+		t.Errorf("upper bound %v outside (%v, %v]", high, base, time.Duration(float64(base)*(1+editJitter)))
+	}
+
+	// Jitter never overrides the adaptive direction
+	if burst := nextEditInterval(base, burstRunes, func() float64 { return 0.999999 }); burst >= base {
+		t.Errorf("burst with max jitter should still be below base, got %v", burst)
+	}
+	if trickle := nextEditInterval(base, 0, func() float64 { return 0 }); trickle <= base {
+		t.Errorf("trickle with min jitter should still be above base, got %v", trickle)
+	}
+}
+
+func TestIsNotModified(t *testing.T) {
+	notModified := fmt.Errorf("%w, %s", bot.ErrorBadRequest,
+		"Bad Request: message is not modified: specified new message content and reply markup are exactly the same")
+	if !isNotModified(notModified) {
+		t.Error("expected not-modified bad request to be recognized")
+	}
+
+	otherBadRequest := fmt.Errorf("%w, %s", bot.ErrorBadRequest, "Bad Request: can't parse entities")
+	if isNotModified(otherBadRequest) {
+		t.Error("other bad requests must not be treated as not-modified")
+	}
+	if isNotModified(errors.New("message is not modified")) {
+		t.Error("expected only Telegram bad-request errors to match")
+	}
+}
+
+func TestRenderFinal(t *testing.T) {
+	tests := []struct {
+		raw      string
+		wantText string
+		wantMode models.ParseMode
+	}{
+		// Plain prose matches the last streamed edit, so the flush is skipped.
+		{"Done. See notes (v1.2)!", "Done. See notes (v1.2)!", ""},
+		{"Run `make test`.", "Run `make test`\\.", models.ParseModeMarkdown},
+		{"**Done**", "*Done*", models.ParseModeMarkdown},
+		{"| a |\n|---|\n| 1 |", "```\na\n-\n1\n```", models.ParseModeMarkdown},
+	}
+	for _, tt := range tests {
+		text, mode := renderFinal(tt.raw)
+		if text != tt.wantText || mode != tt.wantMode {
+			t.Errorf("renderFinal(%q) = %q, %q; want %q, %q", tt.raw, text, mode, tt.wantText, tt.wantMode)
+		}
+	}
+}
+
+func TestSupervise_RestartsUntilCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	calls := 0
+	start := func(ctx context.Context, connected func()) error {
+		calls++
+		if calls <= 3 {
+			return errors.New("network unreachable")
+		}
+		// The fourth attempt polls normally until shutdown.
+		connected()
+		cancel()
+		<-ctx.Done()
+		return nil
+	}
+
+	done := make(chan struct{})
+	go func() {
+		supervise(ctx, &connState{}, start, time.Millisecond, 4*time.Millisecond)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("supervise did not return after cancellation")
+	}
+	if calls != 4 {
+		t.Errorf("expected 4 start attempts, got %d", calls)
+	}
+}
+
+func TestSupervise_ExitsDuringBackoff(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	start := func(context.Context, func()) error {
+		calls++
+		// Cancel once the supervisor is waiting out its backoff.
+		time.AfterFunc(20*time.Millisecond, cancel)
+		return errors.New("boom")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		supervise(ctx, &connState{}, start, time.Hour, time.Hour)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("supervise kept waiting after cancellation")
+	}
+	if calls != 1 {
+		t.Errorf("expected a single attempt, got %d", calls)
+	}
+}
+
+func TestFormatV2(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{"specials", "a_b*c[d](e)~f>g#h+i-j=k|l{m}n.o!p", `a\_b\*c\[d\]\(e\)\~f\>g\#h\+i\-j\=k\|l\{m\}n\.o\!p`},
+		{"backslash", `C:\tmp`, `C:\\tmp`},
+		{"inline code", "run `a_b\\c` now.", "run `a_b\\\\c` now\\."},
+		{"unclosed backtick", "a ` b", "a \\` b"},
+		{"bold", "**x.y**", "*x\\.y*"},
+		{"fence", "```go\nx := `a\\b`\n```", "```go\nx := \\`a\\\\b\\`\n```"},
+		{"unclosed fence", "```\nfoo", "```\nfoo\n```"},
+		{
+			"table",
+			"Results:\n| Name | Count |\n|------|------:|\n| alpha | 1 |\n| b | 22 |\nDone.",
+			"Results:\n```\nName  | Count\n------+------\nalpha | 1\nb     | 22\n```\nDone\\.",
+		},
+		{
+			"table without outer pipes",
+			"a | b\n--- | ---\n1 | 2",
+			"```\na | b\n--+--\n1 | 2\n```",
+		},
+		{"pipe without table", "use a | b here\nnext line", "use a \\| b here\nnext line"},
+		{"pipe before non-separator", "a | b\n- item", "a \\| b\n\\- item"},
+	}
+	for _, tt := range tests {
+		if got := formatV2(tt.raw); got != tt.want {
+			t.Errorf("%s: formatV2(%q) = %q, want %q", tt.name, tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestSplitCodeBlocks(t *testing.T) {
+	text := "Intro\n```go\nfunc a() {}\n```\nMiddle\n```\nplain\n```\nEnd\n```sh\nls"
+
+	prose, blocks := splitCodeBlocks(text, 10)
+	if want := "Intro\n[code 1]\nMiddle\n[code 2]\nEnd\n[code 3]"; prose != want {
+		t.Errorf("prose = %q, want %q", prose, want)
+	}
+	want := []string{"```go\nfunc a() {}\n```", "```\nplain\n```", "```sh\nls\n```"}
+	if len(blocks) != len(want) {
+		t.Fatalf("expected %d blocks, got %d: %q", len(want), len(blocks), blocks)
+	}
+	for i := range want {
+		if blocks[i] != want[i] {
+			t.Errorf("block %d = %q, want %q", i, blocks[i], want[i])
+		}
+	}
+
+	// Blocks past the limit stay inline.
+	prose, blocks = splitCodeBlocks(text, 1)
+	if len(blocks) != 1 || prose != "Intro\n[code 1]\nMiddle\n```\nplain\n```\nEnd\n```sh\nls" {
+		t.Errorf("unexpected split with limit 1: prose=%q blocks=%q", prose, blocks)
+	}
+}
+
+// fakeTelegram is a stand-in Bot API server that records method calls.
+type fakeTelegram struct {
+	mu        sync.Mutex
+	calls     []fakeCall
+	next      int
+	failChat  string // requests addressed to this chat return an API error
+	failSends int    // sendMessage calls to fail before succeeding
+	editErr   string // API error description for every editMessageText
+	noParse   bool   // Reject every MarkdownV2 send or edit as unparsable
+	nilSends  bool   // sendMessage succeeds without returning a message
+
+	actions []models.ChatAction // Kept apart from calls, which tests count
+}
+
+type fakeCall struct {
+	method    string
+	chatID    string
+	messageID string
+	msgID     int // Message edited, deleted or pinned; the new ID for sends
+	text      string
+	parseMode models.ParseMode
+	reaction  string // raw JSON of the reaction field
+	results   string // raw JSON of the results field
+	markup    string // raw JSON of the reply_markup field
+	document  string // contents of an uploaded document
+}
+
+func newFakeTelegram(t *testing.T) (*fakeTelegram, *bot.Bot) {
+	t.Helper()
+	f := &fakeTelegram{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseMultipartForm(1 << 20)
+		method := path.Base(r.URL.Path)
+
+		f.mu.Lock()
+		if method == "sendChatAction" {
+			f.actions = append(f.actions, models.ChatAction(r.FormValue("action")))
+			f.mu.Unlock()
+			fmt.Fprint(w, `{"ok":true,"result":true}`)
+			return
+		}
+		call := fakeCall{
+			method:    method,
+			chatID:    r.FormValue("chat_id"),
+			messageID: r.FormValue("message_id"),
+			text:      r.FormValue("text"),
+			parseMode: models.ParseMode(r.FormValue("parse_mode")),
+			reaction:  r.FormValue("reaction"),
+			results:   r.FormValue("results"),
+			markup:    r.FormValue("reply_markup"),
+		}
+		call.msgID, _ = strconv.Atoi(call.messageID)
+		if file, _, err := r.FormFile("document"); err == nil {
+			data, _ := io.ReadAll(file)
+			call.document = string(data)
+		}
+		failure := ""
+		switch {
+		case f.failChat != "" && call.chatID == f.failChat:
+			failure = "Bad Request: chat not found"
+		case f.noParse && call.parseMode != "" && (method == "sendMessage" || method == "editMessageText"):
+			failure = "Bad Request: can't parse entities: Can't find end of the entity"
+		case method == "sendMessage" && f.failSends > 0:
+			f.failSends--
+			failure = "Bad Request: network down"
+		case method == "editMessageText" && f.editErr != "":
+			failure = f.editErr
+		}
+		id := 0
+		if failure == "" && !(method == "sendMessage" && f.nilSends) {
+			f.next++
+			id = f.next
+			if method == "sendMessage" || method == "sendDocument" {
+				call.msgID = id
+			}
+		}
+		f.calls = append(f.calls, call)
+		nilSend := method == "sendMessage" && f.nilSends
+		f.mu.Unlock()
+
+		switch {
+		case failure != "":
+			fmt.Fprintf(w, `{"ok":false,"error_code":400,"description":%q}`, failure)
+		case nilSend:
+			fmt.Fprint(w, `{"ok":true,"result":null}`)
+		case method == "sendMessage" || method == "editMessageText" || method == "sendDocument":
+			fmt.Fprintf(w, `{"ok":true,"result":{"message_id":%d,"chat":{"id":1}}}`, id)
+		default:
+			fmt.Fprint(w, `{"ok":true,"result":true}`)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	tg, err := bot.New("test-token", bot.WithServerURL(srv.URL), bot.WithSkipGetMe())
+	if err != nil {
+		t.Fatalf("bot.New: %v", err)
+	}
+	return f, tg
+}
+
+// methods returns the recorded calls to method.
+func (f *fakeTelegram) methods(method string) []fakeCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []fakeCall
+	for _, c := range f.calls {
+		if c.method == method {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// recorded returns a copy of the calls so far.
+func (f *fakeTelegram) recorded() []fakeCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.calls)
+}
+
+// sequence returns the recorded methods in order.
+func (f *fakeTelegram) sequence() []string {
+	var out []string
+	for _, c := range f.recorded() {
+		out = append(out, c.method)
+	}
+	return out
+}
+
+// chatActions returns the chat actions sent so far.
+func (f *fakeTelegram) chatActions() []models.ChatAction {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.actions)
+}
+
+// visible returns what each message sent shows after its last send or
+// edit, in the order the messages were sent.
+func (f *fakeTelegram) visible() []fakeCall {
+	var order []int
+	shown := map[int]fakeCall{}
+	for _, c := range f.recorded() {
+		switch c.method {
+		case "sendMessage":
+			if c.msgID != 0 {
+				order = append(order, c.msgID)
+				shown[c.msgID] = c
+			}
+		case "editMessageText":
+			shown[c.msgID] = c
+		}
+	}
+	var out []fakeCall
+	for _, id := range order {
+		out = append(out, shown[id])
+	}
+	return out
+}
+
+func TestStreamResponse_MinFirstChars(t *testing.T) {
+	fake, tg := newFakeTelegram(t)
+	b := &Bot{editIvl: 5 * time.Millisecond, minFirst: 20}
+
+	events := make(chan executor.Event)
+	done := make(chan struct{})
+	go func() {
+		b.streamResponse(context.Background(), tg, 1, 0, events)
+		close(done)
+	}()
+
+	events <- executor.Event{Type: executor.EventText, Text: "Hi"}
+	time.Sleep(50 * time.Millisecond) // several edit ticks
+	if sends := fake.methods("sendMessage"); len(sends) != 0 {
+		t.Fatalf("expected no send below the threshold, got %+v", sends)
+	}
+
+	events <- executor.Event{Type: executor.EventDone, Text: "Hi there"}
+	close(events)
+	<-done
+
+	sends := fake.methods("sendMessage")
+	if len(sends) != 1 || sends[0].text != "Hi there" {
+		t.Errorf("expected one send with the final text at turn end, got %+v", sends)
+	}
+}
+
+func TestStreamResponse_EmptyDone(t *testing.T) {
+	fake, tg := newFakeTelegram(t)
+	b := &Bot{editIvl: time.Hour, emptyReply: "Done — no text output."}
+
+	events := make(chan executor.Event, 2)
+	events <- executor.Event{Type: executor.EventToolUse, Tool: "Bash"}
+	events <- executor.Event{Type: executor.EventDone}
+	close(events)
+	b.streamResponse(context.Background(), tg, 1, 0, events)
+
+	sends := fake.methods("sendMessage")
+	if len(sends) != 1 || sends[0].text != "Done — no text output." {
+		t.Errorf("expected the fallback reply, got %+v", sends)
+	}
+}
+
+func TestStreamResponse_Closure(t *testing.T) {
+	const cutOff = "The response was cut off"
+	tests := []struct {
+		name       string
+		events     []executor.Event
+		want       string
+		wantCutOff bool
+	}{
+		{
+			name:   "clean done",
+			events: []executor.Event{{Type: executor.EventText, Text: "Hello"}, {Type: executor.EventDone, Text: "Hello there"}},
+			want:   "Hello there",
+		},
+		{
+			name:   "error",
+			events: []executor.Event{{Type: executor.EventText, Text: "Hello"}, {Type: executor.EventError, Error: errors.New("boom")}},
+			want:   "Hello",
+		},
+		{
+			name:       "closed mid-stream",
+			events:     []executor.Event{{Type: executor.EventText, Text: "Hello"}},
+			want:       "Hello\n\n⚠️ " + cutOff,
+			wantCutOff: true,
+		},
+		{
+			name:       "closed before any output",
+			want:       "⚠️ " + cutOff,
+			wantCutOff: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, tg := newFakeTelegram(t)
+			b := &Bot{editIvl: time.Hour}
+
+			events := make(chan executor.Event, len(tt.events))
+			for _, evt := range tt.events {
+				events <- evt
+			}
+			close(events)
+			b.streamResponse(context.Background(), tg, 1, 0, events)
+
+			sends := fake.methods("sendMessage")
+			if len(sends) != 1 {
+				t.Fatalf("expected one message, got %+v", sends)
+			}
+			if !strings.HasPrefix(sends[0].text, tt.want) {
+				t.Errorf("message = %q, want prefix %q", sends[0].text, tt.want)
+			}
+			if got := strings.Contains(sends[0].text, cutOff); got != tt.wantCutOff {
+				t.Errorf("cut-off warning shown = %v, want %v", got, tt.wantCutOff)
+			}
+		})
+	}
+}
+
+func TestStreamResponse_TruncationNotice(t *testing.T) {
+	fake, tg := newFakeTelegram(t)
+	b := &Bot{editIvl: time.Hour}
+
+	events := make(chan executor.Event, 1)
+	events <- executor.Event{Type: executor.EventDone, Text: strings.Repeat("x", maxMessageLen+500)}
+	close(events)
+	b.streamResponse(context.Background(), tg, 1, 0, events)
+
+	sends := fake.methods("sendMessage")
+	if len(sends) != 1 {
+		t.Fatalf("expected one message, got %d", len(sends))
+	}
+	text := sends[0].text
+	if !strings.HasSuffix(text, "\n\n[truncated — response exceeded Telegram's limit]") {
+		t.Errorf("expected the truncation notice at the end, got ...%q", text[len(text)-80:])
+	}
+	if n := utf8.RuneCountInString(text); n > maxMessageLen {
+		t.Errorf("message is %d runes, over the %d limit", n, maxMessageLen)
+	}
+}
+
+func TestStreamResponse_MirrorsToObservers(t *testing.T) {
+	fake, tg := newFakeTelegram(t)
+	fake.failChat = "2"
+	b := &Bot{
+		editIvl: time.Hour,
+		cfg:     config.TelegramConfig{Observers: map[int64][]int64{1: {2, 3}}},
+	}
+
+	events := make(chan executor.Event, 1)
+	events <- executor.Event{Type: executor.EventDone, Text: "All done"}
+	close(events)
+	b.streamResponse(context.Background(), tg, 1, 0, events)
+
+	got := map[string]string{}
+	for _, c := range fake.methods("sendMessage") {
+		got[c.chatID] = c.text
+	}
+	if got["1"] != "All done" {
+		t.Errorf("primary chat: got %q, want the final text", got["1"])
+	}
+	if got["3"] != "All done" {
+		t.Errorf("observer after a failing one: got %q, want the final text", got["3"])
+	}
+}
+
+func TestLogBlockV2(t *testing.T) {
+	got := logBlockV2([]string{"old", "run `ls` in C:\\tmp", "new"}, 4096)
+	want := "```\nold\nrun \\`ls\\` in C:\\\\tmp\nnew\n```"
+	if got != want {
+		t.Errorf("logBlockV2 = %q, want %q", got, want)
+	}
+
+	// Oldest lines are dropped to fit the limit.
+	got = logBlockV2([]string{"aaaaaaaaaa", "bbb"}, 12)
+	if got != "```\nbbb\n```" {
+		t.Errorf("logBlockV2 over limit = %q, want only the newest line", got)
+	}
+}
+
+// recordingSessions is a SessionProvider that records prompts. Methods a
+// test doesn't expect to be called are left to the nil embedded interface.
+type recordingSessions struct {
+	SessionProvider
+	mu      sync.Mutex
+	sent    []string
+	resets  []string // Workspace each reset switched to; "" for a plain reset
+	actions []string // Quick actions offered with responses
+
+	interrupted int  // Reported by InterruptAll
+	full        bool // Reported by TurnsFull
+
+	quick []string // "model: message" for each Quick call
+}
+
+func (r *recordingSessions) Send(ctx context.Context, origin session.Origin, message string) (<-chan executor.Event, error) {
+	r.mu.Lock()
+	r.sent = append(r.sent, message)
+	r.mu.Unlock()
+	ch := make(chan executor.Event, 1)
+	ch <- executor.Event{Type: executor.EventDone, Text: "ok"}
+	close(ch)
+	return ch, nil
+}
+
+func (r *recordingSessions) Capabilities(origin session.Origin) executor.ExecutorCapabilities {
+	return executor.ExecutorCapabilities{}
+}
+
+func (r *recordingSessions) Quick(ctx context.Context, origin session.Origin, model, message string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.quick = append(r.quick, model+": "+message)
+	return "quick answer", nil
+}
+
+func (r *recordingSessions) TurnsFull(session.Origin) bool {
+	return r.full
+}
+
+func (r *recordingSessions) InterruptAll() int {
+	return r.interrupted
+}
+
+func (r *recordingSessions) QuickActions(origin session.Origin) []string {
+	return r.actions
+}
+
+func (r *recordingSessions) Reset(origin session.Origin) {
+	r.resets = append(r.resets, "")
+}
+
+func (r *recordingSessions) ResetInWorkspace(origin session.Origin, name string) error {
+	switch name {
+	case "project":
+	case "private":
+		return fmt.Errorf("workspace %q: %w", name, session.ErrWorkspaceForbidden)
+	default:
+		return fmt.Errorf("workspace %q: %w", name, session.ErrNoWorkspace)
+	}
+	r.resets = append(r.resets, name)
+	return nil
+}
+
+func TestHandleMessage_MaxInputChars(t *testing.T) {
+	fake, tg := newFakeTelegram(t)
+	sessions := &recordingSessions{}
+	b := &Bot{sessions: sessions, editIvl: time.Hour, maxInput: 5}
+
+	msg := func(text string) *models.Update {
+		return &models.Update{Message: &models.Message{Chat: models.Chat{ID: 1}, Text: text}}
+	}
+	b.handleMessage(context.Background(), tg, msg("héllo wörld")) // 11 runes, 13 bytes
+	b.handleMessage(context.Background(), tg, msg("héllo"))       // 5 runes, 6 bytes
+
+	if len(sessions.sent) != 1 || sessions.sent[0] != "héllo" {
+		t.Errorf("expected only the in-limit message to be sent, got %q", sessions.sent)
+	}
+	sends := fake.methods("sendMessage")
+	if len(sends) == 0 || !strings.Contains(sends[0].text, "11 characters") {
+		t.Errorf("expected a rejection reply counting runes, got %+v", sends)
+	}
+}
+
+func TestHandleLane(t *testing.T) {
+	fake, tg := newFakeTelegram(t)
+	sessions := &recordingSessions{}
+	b := &Bot{sessions: sessions, editIvl: time.Hour}
+	msg := func(text string) *models.Update {
+		return &models.Update{Message: &models.Message{Chat: models.Chat{ID: 1}, Text: text}}
+	}
+	quick := b.handleLane("quick", "haiku")
+
+	quick(context.Background(), tg, msg("/quick what's 2+2?"))
+	b.handleMessage(context.Background(), tg, msg("hello"))
+	quick(context.Background(), tg, msg("/quick"))
+
+	if !slices.Equal(sessions.quick, []string{"haiku: what's 2+2?"}) {
+		t.Errorf("Quick calls = %q, want one on haiku", sessions.quick)
+	}
+	if !slices.Equal(sessions.sent, []string{"hello"}) {
+		t.Errorf("session sends = %q, want only the plain message", sessions.sent)
+	}
+	var texts []string
+	for _, c := range fake.methods("sendMessage") {
+		texts = append(texts, c.text)
+	}
+	if len(texts) != 3 || texts[0] != "quick answer" || texts[2] != b.msg(msgLaneUsage, "quick") {
+		t.Errorf("sent %q, want the lane answer, the reply, then usage", texts)
+	}
+}
+
+// settingsSessions serves /settings from a fixed set of overrides.
+type settingsSessions struct {
+	SessionProvider
+	overrides session.Overrides
+	cleared   bool
+}
+
+func (s *settingsSessions) Overrides(session.Origin) session.Overrides { return s.overrides }
+func (s *settingsSessions) ClearOverrides(session.Origin) {
+	s.overrides = session.Overrides{}
+	s.cleared = true
+}
+
+// rawSessions serves /debug_last from fixed lines.
+type rawSessions struct {
+	SessionProvider
+	lines []string
+}
+
+func (r *rawSessions) LastTurnRaw(session.Origin) ([]string, bool) { return r.lines, len(r.lines) > 0 }
+
+func TestHandleDebugLast_SendsFile(t *testing.T) {
+	fake, tg := newFakeTelegram(t)
+	lines := []string{`{"type":"stream_event"}`, `{"type":"result"}`}
+	b := &Bot{sessions: &rawSessions{lines: lines}, adminIDs: map[int64]bool{1: true}}
+
+	b.handleDebugLast(context.Background(), tg, &models.Update{Message: &models.Message{
+		Chat: models.Chat{ID: 1}, From: &models.User{ID: 1}, Text: "/debug_last",
+	}})
+
+	docs := fake.methods("sendDocument")
+	if len(docs) != 1 || docs[0].document != strings.Join(lines, "\n")+"\n" {
+		t.Errorf("sent documents %+v, want the raw lines as one file", docs)
+	}
+	if sends := fake.methods("sendMessage"); len(sends) != 0 {
+		t.Errorf("expected no text messages, got %+v", sends)
+	}
+}
+
+func TestHandleSettings(t *testing.T) {
+	fake, tg := newFakeTelegram(t)
+	off := time.Duration(0)
+	sessions := &settingsSessions{overrides: session.Overrides{Model: "opus", Persona: "Be brief.", ReadOnly: true, Timeout: &off}}
+	b := &Bot{sessions: sessions, adminIDs: map[int64]bool{42: true}}
+	msg := func(userID int64, text string) *models.Update {
+		return &models.Update{Message: &models.Message{Chat: models.Chat{ID: 1}, From: &models.User{ID: userID}, Text: text}}
+	}
+
+	b.handleSettings(context.Background(), tg, msg(7, "/settings"))
+	b.handleSettings(context.Background(), tg, msg(7, "/settings clear"))
+	if sessions.cleared {
+		t.Fatal("a non-admin cleared a timeout override")
+	}
+	b.handleSettings(context.Background(), tg, msg(42, "/settings clear"))
+	b.handleSettings(context.Background(), tg, msg(42, "/settings"))
+
+	if !sessions.cleared {
+		t.Error("admin /settings clear did not clear the overrides")
+	}
+	sends := fake.methods("sendMessage")
+	if len(sends) != 4 {
+		t.Fatalf("sent %d messages, want 4", len(sends))
+	}
+	for _, want := range []string{"Model: opus", b.msg(msgSettingsPersona), b.msg(msgSettingsReadOnly), "Timeout: off"} {
+		if !strings.Contains(sends[0].text, want) {
+			t.Errorf("listing %q missing %q", sends[0].text, want)
+		}
+	}
+	if strings.Contains(sends[0].text, "Workspace") {
+		t.Errorf("listing %q shows an unset workspace", sends[0].text)
+	}
+	if sends[1].text != b.msg(msgSettingsDenied) || sends[2].text != b.msg(msgSettingsCleared) || sends[3].text != b.msg(msgSettingsNone) {
+		t.Errorf("sent %+v, want refusal, cleared, then no overrides", sends[1:])
+	}
+}
+
+func TestMayClear_FollowsCommandAccess(t *testing.T) {
+	b := &Bot{
+		cfg:      config.TelegramConfig{CommandAccess: map[string]string{"readonly": config.AccessAdmins}},
+		adminIDs: map[int64]bool{42: true},
+	}
+
+	if !b.mayClear(session.Overrides{Model: "opus"}, 7) {
+		t.Error("expected a member to clear an override open to them")
+	}
+	if b.mayClear(session.Overrides{Model: "opus", ReadOnly: true}, 7) {
+		t.Error("a member cleared read-only, which command_access keeps from them")
+	}
+	if !b.mayClear(session.Overrides{ReadOnly: true}, 42) {
+		t.Error("expected an admin to clear read-only")
+	}
+}
+
+func TestHandleMessage_TurnsFullNotice(t *testing.T) {
+	fake, tg := newFakeTelegram(t)
+	sessions := &recordingSessions{full: true}
+	b := &Bot{sessions: sessions, editIvl: time.Hour}
+
+	b.handleMessage(context.Background(), tg, &models.Update{Message: &models.Message{Chat: models.Chat{ID: 1}, Text: "hi"}})
+
+	if len(sessions.sent) != 1 {
+		t.Fatalf("expected the message to still be sent, got %q", sessions.sent)
+	}
+	sends := fake.methods("sendMessage")
+	if len(sends) == 0 || sends[0].text != b.msg(msgTurnQueued) {
+		t.Errorf("expected a queued notice before the reply, got %+v", sends)
+	}
+}
+
+func TestHandleMessage_ImagesUnsupported(t *testing.T) {
+	fake, tg := newFakeTelegram(t)
+	sessions := &recordingSessions{}
+	b := &Bot{sessions: sessions, editIvl: time.Hour}
+
+	b.handleMessage(context.Background(), tg, &models.Update{Message: &models.Message{
+		Chat:    models.Chat{ID: 1},
+		Photo:   []models.PhotoSize{{FileID: "p1"}},
+		Caption: "what is this?",
+	}})
+
+	if len(sessions.sent) != 0 {
+		t.Errorf("expected no prompt for an unsupported image, got %q", sessions.sent)
+	}
+	sends := fake.methods("sendMessage")
+	if len(sends) != 1 || !strings.Contains(sends[0].text, "can't read images") {
+		t.Errorf("expected an unsupported-image reply, got %+v", sends)
+	}
+}
+
+func TestHandleMessage_UploadRejected(t *testing.T) {
+	fake, tg := newFakeTelegram(t)
+	sessions := &recordingSessions{}
+	b := &Bot{sessions: sessions, editIvl: time.Hour, uploads: config.UploadsConfig{AllowedExtensions: []string{".md", ".py"}}}
+
+	for _, name := range []string{"setup.EXE", "notes.MD"} {
+		b.handleMessage(context.Background(), tg, &models.Update{Message: &models.Message{
+			Chat:     models.Chat{ID: 1},
+			Document: &models.Document{FileID: name, FileName: name},
+		}})
+	}
+
+	sends := fake.methods("sendMessage")
+	if len(sends) != 2 {
+		t.Fatalf("expected a reply per document, got %+v", sends)
+	}
+	if !strings.Contains(sends[0].text, "Allowed: .md, .py") {
+		t.Errorf("expected the executable rejected, got %q", sends[0].text)
+	}
+	if sends[1].text != lookup("en", msgUploadUnsupported) {
+		t.Errorf("expected the allowed document told uploads aren't supported, got %q", sends[1].text)
+	}
+	if len(sessions.sent) != 0 {
+		t.Errorf("no document should start a turn, got %q", sessions.sent)
+	}
+}
+
+func TestHandleNew_Workspace(t *testing.T) {
+	fake, tg := newFakeTelegram(t)
+	sessions := &recordingSessions{}
+	b := &Bot{sessions: sessions, locale: defaultLocale}
+
+	for _, text := range []string{"/new", "/new project", "/new missing", "/new private"} {
+		b.handleNew(context.Background(), tg, &models.Update{Message: &models.Message{Chat: models.Chat{ID: 1}, Text: text}})
+	}
+
+	if want := []string{"", "project"}; !slices.Equal(sessions.resets, want) {
+		t.Errorf("resets = %q, want %q", sessions.resets, want)
+	}
+	sends := fake.methods("sendMessage")
+	if len(sends) != 4 {
+		t.Fatalf("expected a reply per command, got %+v", sends)
+	}
+	if !strings.Contains(sends[1].text, "in project") {
+		t.Errorf("expected the switch to name the workspace, got %q", sends[1].text)
+	}
+	if !strings.Contains(sends[2].text, "No workspace named missing") {
+		t.Errorf("expected an unknown-workspace reply, got %q", sends[2].text)
+	}
+	if !strings.Contains(sends[3].text, "don't have access to the private workspace") {
+		t.Errorf("expected a denied-workspace reply, got %q", sends[3].text)
+	}
+}
+
+func TestCommandName(t *testing.T) {
+	tests := map[string]string{
+		"/new":              "new",
+		"/New@natronbot hi": "new",
+		"/status\nmore":     "status",
+		"/reload-identity":  "reload_identity",
+		"hello /new":        "",
+		"/":                 "",
+	}
+	for text, want := range tests {
+		if got := commandName(text); got != want {
+			t.Errorf("commandName(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestPermits(t *testing.T) {
+	const owner, admin, member = 1, 2, 3
+	b := &Bot{
+		cfg: config.TelegramConfig{
+			OwnerUserID: owner,
+			CommandAccess: map[string]string{
+				"status": config.AccessAll,
+				"new":    config.AccessAdmins,
+				"log":    config.AccessOwner,
+			},
+		},
+		adminIDs: map[int64]bool{admin: true},
+	}
+
+	tests := []struct {
+		cmd    string
+		userID int64
+		want   bool
+	}{
+		{"status", member, true},
+		{"raw", member, true}, // unlisted commands are open
+		{"new", member, false},
+		{"new", admin, true},
+		{"new", owner, true},
+		{"log", admin, false},
+		{"log", owner, true},
+	}
+	for _, tt := range tests {
+		if got := b.permits(tt.cmd, tt.userID); got != tt.want {
+			t.Errorf("permits(%q, %d) = %v, want %v", tt.cmd, tt.userID, got, tt.want)
+		}
+	}
+
+	// With no admin list only the owner is an admin.
+	b.adminIDs = nil
+	if b.permits("new", member) || b.isAdmin(member) || !b.isAdmin(owner) {
+		t.Error("expected only the owner to be an admin without admin_user_ids")
+	}
+}
+
+func TestCommandAccess_Denies(t *testing.T) {
+	fake, tg := newFakeTelegram(t)
+	b := &Bot{cfg: config.TelegramConfig{CommandAccess: map[string]string{"new": config.AccessAdmins}},
+		adminIDs: map[int64]bool{2: true}}
+
+	called := false
+	h := b.commandAccess(func(context.Context, *bot.Bot, *models.Update) { called = true })
+	h(context.Background(), tg, &models.Update{Message: &models.Message{
+		Chat: models.Chat{ID: 1}, From: &models.User{ID: 3}, Text: "/new",
+	}})
+
+	if called {
+		t.Error("expected the handler not to run for a denied command")
+	}
+	if sends := fake.methods("sendMessage"); len(sends) != 1 || !strings.Contains(sends[0].text, "/new") {
+		t.Errorf("expected a denial naming the command, got %+v", sends)
+	}
+}
+
+func TestAuthMiddleware_BlockedUser(t *testing.T) {
+	b := &Bot{
+		allowed: map[int64]bool{1: true, 2: true},
+		blocked: map[int64]bool{2: true, 4: true},
+		admins: newAdminCache(time.Minute, func(context.Context, int64) ([]int64, error) {
+			return []int64{3, 4}, nil
+		}),
+		adminGrp: map[int64]bool{-100: true},
+	}
+	var passed []int64
+	h := b.authMiddleware(func(_ context.Context, _ *bot.Bot, update *models.Update) {
+		passed = append(passed, update.Message.From.ID)
+	})
+
+	group := models.Chat{ID: -100, Type: models.ChatTypeSupergroup}
+	for _, userID := range []int64{1, 2, 3, 4} {
+		h(context.Background(), nil, &models.Update{Message: &models.Message{Chat: group, From: &models.User{ID: userID}, Text: "hi"}})
+	}
+
+	// 2 is allowlisted and 4 a group admin, but both are blocked.
+	if want := []int64{1, 3}; !slices.Equal(passed, want) {
+		t.Errorf("passed = %v, want %v", passed, want)
+	}
+
+	// Admins of a group that isn't configured are strangers.
+	passed = nil
+	other := models.Chat{ID: -200, Type: models.ChatTypeSupergroup}
+	for _, userID := range []int64{1, 3} {
+		h(context.Background(), nil, &models.Update{Message: &models.Message{Chat: other, From: &models.User{ID: userID}, Text: "hi"}})
+	}
+	if want := []int64{1}; !slices.Equal(passed, want) {
+		t.Errorf("passed in an unlisted group = %v, want %v", passed, want)
+	}
+}
+
+// statusSessions reports a fixed StatusInfo.
+type statusSessions struct {
+	SessionProvider
+	info session.StatusInfo
+}
+
+func (s *statusSessions) Status(session.Origin) session.StatusInfo { return s.info }
+
+func TestHandleStatus_TurnQueue(t *testing.T) {
+	fake, tg := newFakeTelegram(t)
+	sessions := &statusSessions{info: session.StatusInfo{
+		Exists:      true,
+		Workspace:   "home",
+		CreatedAt:   time.Now().Add(-time.Hour),
+		TurnStarted: time.Now().Add(-90 * time.Second),
+		Queued:      2,
+	}}
+	b := &Bot{sessions: sessions, adminIDs: map[int64]bool{99: true}}
+	status := &models.Update{Message: &models.Message{Chat: models.Chat{ID: 1}, From: &models.User{ID: 1}, Text: "/status"}}
+
+	b.handleStatus(context.Background(), tg, status)
+	sessions.info.TurnStarted, sessions.info.Queued = time.Time{}, 0
+	b.handleStatus(context.Background(), tg, status)
+
+	sends := fake.methods("sendMessage")
+	if len(sends) != 2 {
+		t.Fatalf("expected two replies, got %+v", sends)
+	}
+	if !strings.Contains(sends[0].text, "Replying for 1m 3") || !strings.Contains(sends[0].text, "waiting behind this reply: 2") {
+		t.Errorf("expected the running turn and queue, got %q", sends[0].text)
+	}
+	if strings.Contains(sends[1].text, "Replying") {
+		t.Errorf("idle chat shouldn't report a turn, got %q", sends[1].text)
+	}
+}
+
+type usageSessions struct {
+	SessionProvider
+	report session.UsageReport
+	err    error
+}
+
+func (u *usageSessions) Usage(context.Context, session.Origin) (session.UsageReport, error) {
+	return u.report, u.err
+}
+
+func TestHandleUsage(t *testing.T) {
+	report := session.UsageReport{
+		Today: transcript.Usage{Turns: 3, CostUSD: 0.5, InputTokens: 35_100, OutputTokens: 950},
+		Week:  transcript.Usage{Turns: 40, CostUSD: 4.25, InputTokens: 1_200_000, OutputTokens: 61_000},
+	}
+	tests := []struct {
+		name     string
+		sessions *usageSessions
+		userID   int64
+		want     string
+	}{
+		{
+			name:     "admin sees tokens",
+			sessions: &usageSessions{report: report},
+			userID:   1,
+			want:     "Usage in this chat\nToday: $0.50 over 3 turns · 35.1k in / 950 out tokens\nThis week: $4.25 over 40 turns · 1.2M in / 61.0k out tokens",
+		},
+		{
+			name:     "others see cost only",
+			sessions: &usageSessions{report: report},
+			userID:   2,
+			want:     "Usage in this chat\nToday: $0.50 over 3 turns\nThis week: $4.25 over 40 turns",
+		},
+		{
+			name:     "transcripts disabled",
+			sessions: &usageSessions{err: session.ErrNoTranscripts},
+			userID:   1,
+			want:     lookup("en", msgUsageDisabled),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, tg := newFakeTelegram(t)
+			b := &Bot{sessions: tt.sessions, adminIDs: map[int64]bool{1: true}}
+			b.handleUsage(context.Background(), tg, &models.Update{Message: &models.Message{
+				Chat: models.Chat{ID: 1}, From: &models.User{ID: tt.userID}, Text: "/usage",
+			}})
+
+			sends := fake.methods("sendMessage")
+			if len(sends) != 1 || sends[0].text != tt.want {
+				t.Errorf("reply = %+v, want %q", sends, tt.want)
+			}
+		})
+	}
+}
+
+type modelSessions struct {
+	SessionProvider
+	allowed []string
+	current string
+}
+
+func (m *modelSessions) Models() []string            { return m.allowed }
+func (m *modelSessions) Model(session.Origin) string { return m.current }
+func (m *modelSessions) SetModel(_ session.Origin, model string) error {
+	if len(m.allowed) > 0 && !slices.Contains(m.allowed, model) {
+		return fmt.Errorf("%w: %q", session.ErrUnknownModel, model)
+	}
+	m.current = model
+	return nil
+}
+
+func TestHandleModel(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		text    string
+		want    string
+		current string
+	}{
+		{
+			name:    "list marks current",
+			allowed: []string{"opus", "sonnet"},
+			text:    "/model list",
+			want:    "Models:\n• opus\n• sonnet (this chat)",
+			current: "sonnet",
+		},
+		{
+			name:    "models alias",
+			allowed: []string{"opus", "sonnet"},
+			text:    "/models",
+			want:    "Models:\n• opus\n• sonnet (this chat)",
+			current: "sonnet",
+		},
+		{
+			name:    "list with any allowed",
+			text:    "/model list",
+			want:    "Any model name is accepted. Current: sonnet",
+			current: "sonnet",
+		},
+		{
+			name:    "switch to listed",
+			allowed: []string{"opus", "sonnet"},
+			text:    "/model opus",
+			want:    "Model set to opus. It applies from the next message.",
+			current: "opus",
+		},
+		{
+			name:    "reject unlisted",
+			allowed: []string{"opus", "sonnet"},
+			text:    "/model gpt-5",
+			want:    `Unknown model "gpt-5". Available: opus, sonnet`,
+			current: "sonnet",
+		},
+		{
+			name:    "switch with any allowed",
+			text:    "/model whatever-new",
+			want:    "Model set to whatever-new. It applies from the next message.",
+			current: "whatever-new",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, tg := newFakeTelegram(t)
+			sessions := &modelSessions{allowed: tt.allowed, current: "sonnet"}
+			b := &Bot{sessions: sessions}
+			b.handleModel(context.Background(), tg, &models.Update{Message: &models.Message{
+				Chat: models.Chat{ID: 1}, From: &models.User{ID: 1}, Text: tt.text,
+			}})
+
+			sends := fake.methods("sendMessage")
+			if len(sends) != 1 || sends[0].text != tt.want {
+				t.Errorf("reply = %+v, want %q", sends, tt.want)
+			}
+			if sessions.current != tt.current {
+				t.Errorf("model = %q, want %q", sessions.current, tt.current)
+			}
+		})
+	}
+}
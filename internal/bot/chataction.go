@@ -0,0 +1,32 @@
+package bot
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// chatActionInterval is how often a turn's typing action is re-sent.
+// Telegram shows one for about five seconds. Agent tools, file edits
+// included, only touch the workspace, so a turn shows typing throughout;
+// upload_document goes with the files the bot itself sends.
+const chatActionInterval = 4 * time.Second
+
+// sendChatAction shows action in the chat, paced with the bot's other
+// sends. It's cosmetic, so failures are only logged at debug.
+func (b *Bot) sendChatAction(ctx context.Context, tg sender, chatID int64, threadID int, action models.ChatAction) {
+	if b.sched.wait(ctx, chatID) != nil {
+		return
+	}
+	_, err := tg.SendChatAction(ctx, &bot.SendChatActionParams{
+		ChatID:          chatID,
+		MessageThreadID: threadID,
+		Action:          action,
+	})
+	if err != nil {
+		slog.Debug("send chat action failed", "chat_id", chatID, "action", action, "error", err)
+	}
+}
@@ -0,0 +1,49 @@
+package bot
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-telegram/bot/models"
+
+	"github.com/zette-dev/natron/internal/executor"
+)
+
+func TestStreamResponse_FileToolsKeepTyping(t *testing.T) {
+	fake, tg := newFakeTelegram(t)
+	b := &Bot{editIvl: time.Hour}
+	runStream(b, tg,
+		executor.Event{Type: executor.EventToolUse, Tool: "Bash"},
+		executor.Event{Type: executor.EventToolUse, Tool: "Write"},
+		executor.Event{Type: executor.EventToolUse, Tool: "Edit"},
+		executor.Event{Type: executor.EventText, Text: "Saved."},
+		executor.Event{Type: executor.EventDone, Text: "Saved."},
+	)
+
+	// Typing is already showing when the stream starts, and editing
+	// workspace files sends nothing to the chat.
+	if got := fake.chatActions(); len(got) != 0 {
+		t.Errorf("chat actions = %q, want none", got)
+	}
+}
+
+func TestHandleToolOutput_ShowsUpload(t *testing.T) {
+	fake, tg := newFakeTelegram(t)
+	b := &Bot{toolOut: newToolOutputs(5)}
+	_, id := b.toolOut.preview(1, "Bash", "the full output")
+
+	b.handleToolOutput(context.Background(), tg, &models.Update{CallbackQuery: &models.CallbackQuery{
+		ID:      "q",
+		Data:    toolOutputPrefix + id,
+		Message: models.MaybeInaccessibleMessage{Message: &models.Message{ID: 9, Chat: models.Chat{ID: 1}}},
+	}})
+
+	if want := []models.ChatAction{models.ChatActionUploadDocument}; !slices.Equal(fake.chatActions(), want) {
+		t.Errorf("chat actions = %q, want %q", fake.chatActions(), want)
+	}
+	if docs := fake.methods("sendDocument"); len(docs) != 1 {
+		t.Errorf("expected the output sent as a file, got %+v", docs)
+	}
+}
@@ -0,0 +1,162 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+
+	"github.com/zette-dev/natron/internal/config"
+	"github.com/zette-dev/natron/internal/session"
+)
+
+// menuCommand is an entry in the bot's command menu.
+type menuCommand struct {
+	name string
+	desc msgKey
+
+	// admin marks commands whose handlers refuse non-admins, whatever
+	// telegram.command_access says.
+	admin bool
+
+	// member shows the command to every member of a group. Commands that
+	// change how the shared session behaves are only shown to the group's
+	// administrators.
+	member bool
+
+	// lane is the model a session.lanes command answers with.
+	lane string
+}
+
+// menuCommands lists the commands published with SetMyCommands, in menu
+// order.
+var menuCommands = []menuCommand{
+	{name: "new", desc: msgCmdNew, member: true},
+	{name: "cancel", desc: msgCmdCancel, member: true},
+	{name: "status", desc: msgCmdStatus, member: true},
+	{name: "workspaces", desc: msgCmdWorkspaces},
+	{name: "readonly", desc: msgCmdReadOnly},
+	{name: "model", desc: msgCmdModel},
+	{name: "persona", desc: msgCmdPersona},
+	{name: "timeout", desc: msgCmdTimeout},
+	{name: "keep", desc: msgCmdKeep},
+	{name: "settings", desc: msgCmdSettings},
+	{name: "raw", desc: msgCmdRaw, member: true},
+	{name: "remember", desc: msgCmdRemember},
+	{name: "compact_memory", desc: msgCmdCompactMemory, admin: true},
+	{name: "run", desc: msgCmdRun, member: true},
+	{name: "log", desc: msgCmdLog, admin: true},
+	{name: "debug_last", desc: msgCmdDebugLast, admin: true},
+	{name: "reload_identity", desc: msgCmdReloadIdentity},
+	{name: "whoami", desc: msgCmdWhoami, member: true},
+	{name: "usage", desc: msgCmdUsage, member: true},
+	{name: "pause_all", desc: msgCmdPauseAll, admin: true},
+	{name: "resume_all", desc: msgCmdResumeAll, admin: true},
+}
+
+// scopedCommands is the command list published for one scope.
+type scopedCommands struct {
+	scope    models.BotCommandScope
+	commands []models.BotCommand
+}
+
+// commandScopes builds the menus for each scope from telegram.command_access
+// and the admin list:
+//
+//   - private chats and group administrators see every command open to all
+//     users;
+//   - other group members only see the conversational ones;
+//   - each admin's and the owner's private chat also lists the commands
+//     restricted to them.
+func (b *Bot) commandScopes(cancelDesc string) []scopedCommands {
+	list := func(keep func(menuCommand, string) bool) []models.BotCommand {
+		var cmds []models.BotCommand
+		for _, c := range b.menu() {
+			if !keep(c, b.audience(c)) {
+				continue
+			}
+			desc := b.msg(c.desc)
+			switch {
+			case c.name == "cancel":
+				desc = cancelDesc
+			case c.lane != "":
+				desc = b.msg(c.desc, c.lane)
+			}
+			cmds = append(cmds, models.BotCommand{Command: c.name, Description: desc})
+		}
+		return cmds
+	}
+	everyone := list(func(_ menuCommand, aud string) bool { return aud == config.AccessAll })
+
+	scopes := []scopedCommands{
+		{scope: &models.BotCommandScopeDefault{}, commands: everyone},
+		{scope: &models.BotCommandScopeAllPrivateChats{}, commands: everyone},
+		{scope: &models.BotCommandScopeAllGroupChats{}, commands: list(func(c menuCommand, aud string) bool {
+			return aud == config.AccessAll && c.member
+		})},
+		{scope: &models.BotCommandScopeAllChatAdministrators{}, commands: everyone},
+	}
+
+	admins := make([]int64, 0, len(b.adminIDs))
+	for id := range b.adminIDs {
+		if id != b.cfg.OwnerUserID {
+			admins = append(admins, id)
+		}
+	}
+	slices.Sort(admins)
+	adminCmds := list(func(_ menuCommand, aud string) bool { return aud != config.AccessOwner })
+	for _, id := range admins {
+		scopes = append(scopes, scopedCommands{scope: &models.BotCommandScopeChat{ChatID: id}, commands: adminCmds})
+	}
+	if b.cfg.OwnerUserID != 0 {
+		all := list(func(menuCommand, string) bool { return true })
+		scopes = append(scopes, scopedCommands{scope: &models.BotCommandScopeChat{ChatID: b.cfg.OwnerUserID}, commands: all})
+	}
+	return scopes
+}
+
+// menu returns menuCommands followed by the session.lanes commands, by
+// name.
+func (b *Bot) menu() []menuCommand {
+	cmds := slices.Clone(menuCommands)
+	for _, name := range slices.Sorted(maps.Keys(b.lanes)) {
+		cmds = append(cmds, menuCommand{name: name, desc: msgCmdLane, member: true, lane: b.lanes[name]})
+	}
+	return cmds
+}
+
+// audience returns who may run c: its telegram.command_access entry, or
+// admins for admin-only commands.
+func (b *Bot) audience(c menuCommand) string {
+	aud := b.cfg.CommandAccess[c.name]
+	if aud == "" {
+		aud = config.AccessAll
+	}
+	if aud == config.AccessAll && c.admin {
+		aud = config.AccessAdmins
+	}
+	return aud
+}
+
+// setCommands publishes the command menu for each scope, describing
+// /cancel by whether the backend can interrupt a turn or has to restart
+// the session.
+func (b *Bot) setCommands(ctx context.Context) {
+	cancel := b.msg(msgCmdCancel)
+	if !b.sessions.Capabilities(session.Origin{}).SupportsInterrupt {
+		cancel = b.msg(msgCmdCancelRestart)
+	}
+	for _, sc := range b.commandScopes(cancel) {
+		_, err := b.bot.SetMyCommands(ctx, &bot.SetMyCommandsParams{
+			Commands: sc.commands,
+			Scope:    sc.scope,
+		})
+		if err != nil {
+			slog.Warn("set bot commands failed", "scope", fmt.Sprintf("%T", sc.scope), "error", err)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package bot
+
+import (
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/go-telegram/bot/models"
+
+	"github.com/zette-dev/natron/internal/config"
+)
+
+// scopeNames flattens scopes to a comparable form: the scope type, with
+// the chat ID for per-chat scopes, mapped to its command names.
+func scopeNames(scopes []scopedCommands) map[string][]string {
+	out := make(map[string][]string)
+	for _, sc := range scopes {
+		key := ""
+		switch s := sc.scope.(type) {
+		case *models.BotCommandScopeDefault:
+			key = "default"
+		case *models.BotCommandScopeAllPrivateChats:
+			key = "private"
+		case *models.BotCommandScopeAllGroupChats:
+			key = "groups"
+		case *models.BotCommandScopeAllChatAdministrators:
+			key = "group_admins"
+		case *models.BotCommandScopeChat:
+			key = "chat:" + strconv.FormatInt(s.ChatID.(int64), 10)
+		}
+		var names []string
+		for _, c := range sc.commands {
+			names = append(names, c.Command)
+		}
+		out[key] = names
+	}
+	return out
+}
+
+func TestCommandScopes(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      config.TelegramConfig
+		adminIDs map[int64]bool
+		lanes    map[string]string
+		want     map[string][]string
+	}{
+		{
+			name: "no admin list keeps admin commands off every menu",
+			want: map[string][]string{
+				"default":      {"new", "cancel", "status", "workspaces", "readonly", "model", "persona", "timeout", "keep", "settings", "raw", "remember", "run", "reload_identity", "whoami", "usage"},
+				"private":      {"new", "cancel", "status", "workspaces", "readonly", "model", "persona", "timeout", "keep", "settings", "raw", "remember", "run", "reload_identity", "whoami", "usage"},
+				"groups":       {"new", "cancel", "status", "raw", "run", "whoami", "usage"},
+				"group_admins": {"new", "cancel", "status", "workspaces", "readonly", "model", "persona", "timeout", "keep", "settings", "raw", "remember", "run", "reload_identity", "whoami", "usage"},
+			},
+		},
+		{
+			name: "command access and admins",
+			cfg: config.TelegramConfig{
+				OwnerUserID:   1,
+				CommandAccess: map[string]string{"remember": config.AccessAdmins, "run": config.AccessOwner},
+			},
+			adminIDs: map[int64]bool{1: true, 3: true, 2: true},
+			want: map[string][]string{
+				"default":      {"new", "cancel", "status", "workspaces", "readonly", "model", "persona", "timeout", "keep", "settings", "raw", "reload_identity", "whoami", "usage"},
+				"private":      {"new", "cancel", "status", "workspaces", "readonly", "model", "persona", "timeout", "keep", "settings", "raw", "reload_identity", "whoami", "usage"},
+				"groups":       {"new", "cancel", "status", "raw", "whoami", "usage"},
+				"group_admins": {"new", "cancel", "status", "workspaces", "readonly", "model", "persona", "timeout", "keep", "settings", "raw", "reload_identity", "whoami", "usage"},
+				"chat:2":       {"new", "cancel", "status", "workspaces", "readonly", "model", "persona", "timeout", "keep", "settings", "raw", "remember", "compact_memory", "log", "debug_last", "reload_identity", "whoami", "usage", "pause_all", "resume_all"},
+				"chat:3":       {"new", "cancel", "status", "workspaces", "readonly", "model", "persona", "timeout", "keep", "settings", "raw", "remember", "compact_memory", "log", "debug_last", "reload_identity", "whoami", "usage", "pause_all", "resume_all"},
+				"chat:1":       {"new", "cancel", "status", "workspaces", "readonly", "model", "persona", "timeout", "keep", "settings", "raw", "remember", "compact_memory", "run", "log", "debug_last", "reload_identity", "whoami", "usage", "pause_all", "resume_all"},
+			},
+		},
+		{
+			name:  "lanes follow the built-ins",
+			cfg:   config.TelegramConfig{CommandAccess: map[string]string{"deep": config.AccessAdmins}},
+			lanes: map[string]string{"quick": "haiku", "deep": "opus"},
+			want: map[string][]string{
+				"default":      {"new", "cancel", "status", "workspaces", "readonly", "model", "persona", "timeout", "keep", "settings", "raw", "remember", "run", "reload_identity", "whoami", "usage", "quick"},
+				"private":      {"new", "cancel", "status", "workspaces", "readonly", "model", "persona", "timeout", "keep", "settings", "raw", "remember", "run", "reload_identity", "whoami", "usage", "quick"},
+				"groups":       {"new", "cancel", "status", "raw", "run", "whoami", "usage", "quick"},
+				"group_admins": {"new", "cancel", "status", "workspaces", "readonly", "model", "persona", "timeout", "keep", "settings", "raw", "remember", "run", "reload_identity", "whoami", "usage", "quick"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &Bot{cfg: tt.cfg, adminIDs: tt.adminIDs, lanes: tt.lanes}
+			scopes := b.commandScopes("Stop the current reply")
+			got := scopeNames(scopes)
+			if len(got) != len(tt.want) {
+				t.Errorf("scopes = %v, want %v", got, tt.want)
+			}
+			for scope, want := range tt.want {
+				if !slices.Equal(got[scope], want) {
+					t.Errorf("%s = %q, want %q", scope, got[scope], want)
+				}
+			}
+			if c := scopes[0].commands[1]; c.Command != "cancel" || c.Description != "Stop the current reply" {
+				t.Errorf("expected the given /cancel description, got %+v", c)
+			}
+			if tt.lanes != nil {
+				if c := scopes[0].commands[len(scopes[0].commands)-1]; c.Description != "Ask haiku a one-off question" {
+					t.Errorf("expected the lane described by its model, got %+v", c)
+				}
+			}
+		})
+	}
+}
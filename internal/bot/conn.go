@@ -0,0 +1,104 @@
+package bot
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ConnStatus is the bot's Telegram connectivity as reported by /status and
+// the health endpoint.
+type ConnStatus struct {
+	Connected bool      `json:"connected"`
+	Since     time.Time `json:"since"`                        // When the current state began
+	Attempts  int       `json:"reconnect_attempts,omitempty"` // Failed polls since the last connect
+	LastError string    `json:"last_error,omitempty"`
+	Draining  bool      `json:"draining,omitempty"` // Shutting down; readiness reports not ready
+}
+
+// connState tracks the long poll's connectivity for the supervisor. The zero
+// value reads as disconnected since the zero time, until the first poll.
+type connState struct {
+	mu     sync.Mutex
+	status ConnStatus
+}
+
+// up records that Telegram was reached and a poll is running.
+func (c *connState) up() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.status.Connected {
+		c.status = ConnStatus{Connected: true, Since: time.Now(), Draining: c.status.Draining}
+	}
+}
+
+// down records a failed or exited poll with a reconnect pending. Since
+// keeps the first failure of a streak, so repeated attempts don't reset it.
+func (c *connState) down(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.status.Connected || c.status.Since.IsZero() {
+		c.status = ConnStatus{Since: time.Now(), Draining: c.status.Draining}
+	}
+	c.status.Attempts++
+	if err != nil {
+		c.status.LastError = err.Error()
+	}
+}
+
+// drain records that shutdown has begun. It sticks across reconnects.
+func (c *connState) drain() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.status.Draining = true
+}
+
+func (c *connState) get() ConnStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.status
+}
+
+// Connectivity reports whether the Telegram long poll is running or
+// reconnecting, and since when.
+func (b *Bot) Connectivity() ConnStatus {
+	return b.conn.get()
+}
+
+// Drain marks the bot as shutting down, so HealthHandler reports not ready
+// and load balancers stop routing to it. Start calls it once its context is
+// cancelled; callers shutting down otherwise should call it first, then
+// session.Manager.Shutdown, and stop the health server last.
+func (b *Bot) Drain() {
+	b.conn.drain()
+}
+
+// HealthHandler serves Connectivity as JSON for mounting as a readiness
+// probe. It answers 503 while the bot is reconnecting, so probes can tell
+// "the bot is down" from "the agent is slow", and once it starts draining.
+func (b *Bot) HealthHandler() http.Handler {
+	return b.statusHandler(func(s ConnStatus) bool { return s.Connected && !s.Draining })
+}
+
+// LivenessHandler serves Connectivity like HealthHandler but always
+// answers 200, so the process isn't restarted while it reconnects or
+// drains.
+func (b *Bot) LivenessHandler() http.Handler {
+	return b.statusHandler(func(ConnStatus) bool { return true })
+}
+
+// statusHandler serves Connectivity as JSON, answering 503 unless ok.
+func (b *Bot) statusHandler(ok func(ConnStatus) bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := b.Connectivity()
+		w.Header().Set("Content-Type", "application/json")
+		if !ok(status) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if err := json.NewEncoder(w).Encode(status); err != nil {
+			slog.Error("encode connectivity", "error", err)
+		}
+	})
+}
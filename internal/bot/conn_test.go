@@ -0,0 +1,112 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSupervise_TracksConnectivity(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var conn connState
+	var seen []ConnStatus
+	var firstFailure time.Time
+	calls := 0
+	start := func(ctx context.Context, connected func()) error {
+		calls++
+		seen = append(seen, conn.get())
+		switch calls {
+		case 1:
+			connected()
+			seen = append(seen, conn.get())
+			return errors.New("long poll stopped")
+		case 2:
+			firstFailure = conn.get().Since
+			return errors.New("network unreachable")
+		case 3:
+			return errors.New("still unreachable")
+		}
+		connected()
+		seen = append(seen, conn.get())
+		cancel()
+		<-ctx.Done()
+		return nil
+	}
+	supervise(ctx, &conn, start, time.Millisecond, time.Millisecond)
+
+	if seen[0].Connected || !seen[0].Since.IsZero() {
+		t.Errorf("expected no state before the first poll, got %+v", seen[0])
+	}
+	if !seen[1].Connected {
+		t.Errorf("expected connected after a successful poll start, got %+v", seen[1])
+	}
+	if s := seen[2]; s.Connected || s.Attempts != 1 || s.LastError != "long poll stopped" {
+		t.Errorf("expected reconnecting after the poll exited, got %+v", s)
+	}
+	if s := seen[4]; s.Connected || s.Attempts != 3 || s.LastError != "still unreachable" || !s.Since.Equal(firstFailure) {
+		t.Errorf("expected failures to accumulate since the first, got %+v", s)
+	}
+	if s := seen[5]; !s.Connected || s.Attempts != 0 || s.LastError != "" {
+		t.Errorf("expected a clean connected state after reconnecting, got %+v", s)
+	}
+	if !conn.get().Connected {
+		t.Error("shutdown shouldn't count as a disconnect")
+	}
+}
+
+func TestHealthHandler(t *testing.T) {
+	b := &Bot{}
+	b.conn.down(errors.New("network unreachable"))
+
+	rec := httptest.NewRecorder()
+	b.HealthHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 while reconnecting, got %d", rec.Code)
+	}
+	var status ConnStatus
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Connected || status.Attempts != 1 || status.LastError != "network unreachable" {
+		t.Errorf("unexpected status %+v", status)
+	}
+
+	b.conn.up()
+	rec = httptest.NewRecorder()
+	b.HealthHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected 200 once connected, got %d", rec.Code)
+	}
+}
+
+func TestHealthHandler_Draining(t *testing.T) {
+	b := &Bot{}
+	b.conn.up()
+
+	probe := func(h http.Handler) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec.Code
+	}
+	if got := probe(b.HealthHandler()); got != http.StatusOK {
+		t.Fatalf("expected ready before draining, got %d", got)
+	}
+
+	b.Drain()
+	if got := probe(b.HealthHandler()); got != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 from readiness once draining, got %d", got)
+	}
+	if got := probe(b.LivenessHandler()); got != http.StatusOK {
+		t.Errorf("expected liveness to stay up while draining, got %d", got)
+	}
+	b.conn.down(errors.New("poll stopped"))
+	if !b.Connectivity().Draining {
+		t.Error("draining should survive a disconnect")
+	}
+}
@@ -0,0 +1,114 @@
+package bot
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-telegram/bot/models"
+)
+
+// batchKey identifies a sender within a chat (and forum topic), so rapid
+// messages from different group members are never merged.
+type batchKey struct {
+	chatID   int64
+	threadID int
+	userID   int64
+}
+
+// batch collects the messages received during one debounce window.
+type batch struct {
+	ctx   context.Context
+	msg   *models.Message // latest message, used to address the reply
+	parts []string
+	timer *time.Timer
+}
+
+// debouncer batches messages arriving in quick succession and runs them as
+// a single prompt once the sender has been quiet for the window.
+type debouncer struct {
+	window time.Duration
+	run    func(ctx context.Context, msg *models.Message, text string)
+
+	mu      sync.Mutex
+	pending map[batchKey]*batch
+}
+
+func newDebouncer(window time.Duration, run func(ctx context.Context, msg *models.Message, text string)) *debouncer {
+	return &debouncer{
+		window:  window,
+		run:     run,
+		pending: make(map[batchKey]*batch),
+	}
+}
+
+// add queues text and restarts the sender's quiet window.
+func (d *debouncer) add(ctx context.Context, msg *models.Message, text string) {
+	key := batchKeyOf(msg)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if b, ok := d.pending[key]; ok {
+		b.ctx = ctx
+		b.msg = msg
+		b.parts = append(b.parts, text)
+		b.timer.Reset(d.window)
+		return
+	}
+
+	d.pending[key] = &batch{
+		ctx:   ctx,
+		msg:   msg,
+		parts: []string{text},
+		timer: time.AfterFunc(d.window, func() { d.fire(key) }),
+	}
+}
+
+// flush runs the sender's pending batch right away, if there is one.
+// Commands call this so buffered text isn't held back behind them.
+func (d *debouncer) flush(msg *models.Message) {
+	b := d.take(batchKeyOf(msg))
+	if b == nil {
+		return
+	}
+	b.timer.Stop()
+	go d.run(b.ctx, b.msg, strings.Join(b.parts, "\n\n"))
+}
+
+// discard drops the sender's pending batch without running it.
+func (d *debouncer) discard(msg *models.Message) {
+	if b := d.take(batchKeyOf(msg)); b != nil {
+		b.timer.Stop()
+	}
+}
+
+// fire runs a batch whose window elapsed. A concurrent flush may already
+// have taken it.
+func (d *debouncer) fire(key batchKey) {
+	b := d.take(key)
+	if b == nil {
+		return
+	}
+	d.run(b.ctx, b.msg, strings.Join(b.parts, "\n\n"))
+}
+
+func (d *debouncer) take(key batchKey) *batch {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	b := d.pending[key]
+	delete(d.pending, key)
+	return b
+}
+
+func batchKeyOf(msg *models.Message) batchKey {
+	key := batchKey{chatID: msg.Chat.ID}
+	if msg.IsTopicMessage {
+		key.threadID = msg.MessageThreadID
+	}
+	if msg.From != nil {
+		key.userID = msg.From.ID
+	}
+	return key
+}
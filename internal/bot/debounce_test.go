@@ -0,0 +1,84 @@
+package bot
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-telegram/bot/models"
+)
+
+type runRecorder struct {
+	mu    sync.Mutex
+	texts []string
+	done  chan struct{}
+}
+
+func newRunRecorder() *runRecorder {
+	return &runRecorder{done: make(chan struct{}, 10)}
+}
+
+func (r *runRecorder) run(_ context.Context, _ *models.Message, text string) {
+	r.mu.Lock()
+	r.texts = append(r.texts, text)
+	r.mu.Unlock()
+	r.done <- struct{}{}
+}
+
+func (r *runRecorder) wait(t *testing.T) {
+	t.Helper()
+	select {
+	case <-r.done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for batch to run")
+	}
+}
+
+func testMessage(chatID, userID int64) *models.Message {
+	return &models.Message{Chat: models.Chat{ID: chatID}, From: &models.User{ID: userID}}
+}
+
+func TestDebouncer_BatchesRapidMessages(t *testing.T) {
+	rec := newRunRecorder()
+	d := newDebouncer(50*time.Millisecond, rec.run)
+	ctx := context.Background()
+
+	d.add(ctx, testMessage(1, 10), "do X")
+	d.add(ctx, testMessage(1, 10), "also Y")
+	d.add(ctx, testMessage(2, 20), "other chat")
+	rec.wait(t)
+	rec.wait(t)
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if len(rec.texts) != 2 {
+		t.Fatalf("expected 2 runs, got %d: %q", len(rec.texts), rec.texts)
+	}
+	got := map[string]bool{rec.texts[0]: true, rec.texts[1]: true}
+	if !got["do X\n\nalso Y"] || !got["other chat"] {
+		t.Errorf("unexpected batches: %q", rec.texts)
+	}
+}
+
+func TestDebouncer_FlushRunsImmediately(t *testing.T) {
+	rec := newRunRecorder()
+	d := newDebouncer(time.Hour, rec.run)
+
+	d.add(context.Background(), testMessage(1, 10), "pending")
+	d.flush(testMessage(1, 10))
+	rec.wait(t)
+
+	d.flush(testMessage(1, 10)) // nothing pending; must not run again
+	select {
+	case <-rec.done:
+		t.Fatal("empty flush ran a batch")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if len(rec.texts) != 1 || rec.texts[0] != "pending" {
+		t.Errorf("unexpected runs: %q", rec.texts)
+	}
+}
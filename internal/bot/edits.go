@@ -0,0 +1,95 @@
+package bot
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+
+	"github.com/zette-dev/natron/internal/reqid"
+)
+
+// maxTrackedAnswers caps how many answered messages are remembered for
+// session.rerun_on_edit; editing an older message does nothing.
+const maxTrackedAnswers = 256
+
+// answerKey identifies a user's message.
+type answerKey struct {
+	chatID int64
+	msgID  int
+}
+
+// answer is the text a message was answered for and the first message of
+// the response.
+type answer struct {
+	text    string
+	replyID int
+}
+
+// answers remembers the response to each recent message, so an edit to the
+// message can re-run its turn and edit the response in place.
+type answers struct {
+	mu    sync.Mutex
+	byMsg map[answerKey]answer
+	order []answerKey // Oldest first, for eviction
+}
+
+func newAnswers() *answers {
+	return &answers{byMsg: make(map[answerKey]answer)}
+}
+
+// get returns the answer to the message, if it's still remembered.
+func (a *answers) get(chatID int64, msgID int) (answer, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ans, ok := a.byMsg[answerKey{chatID, msgID}]
+	return ans, ok
+}
+
+// put records the answer to the message, forgetting the oldest one when
+// full.
+func (a *answers) put(chatID int64, msgID int, ans answer) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	key := answerKey{chatID, msgID}
+	if _, ok := a.byMsg[key]; !ok {
+		if len(a.order) == maxTrackedAnswers {
+			delete(a.byMsg, a.order[0])
+			a.order = a.order[1:]
+		}
+		a.order = append(a.order, key)
+	}
+	a.byMsg[key] = ans
+}
+
+// isEditedMessage matches updates carrying an edited message.
+func isEditedMessage(update *models.Update) bool {
+	return update.EditedMessage != nil
+}
+
+// handleEditedMessage re-runs the turn for an edited message whose text
+// changed since it was answered, editing the earlier response in place.
+// Edits to messages that weren't answered, or were answered too long ago,
+// are ignored.
+func (b *Bot) handleEditedMessage(ctx context.Context, tg *bot.Bot, update *models.Update) {
+	msg := update.EditedMessage
+	if msg.Text == "" || strings.HasPrefix(msg.Text, "/") {
+		return
+	}
+	prev, ok := b.answers.get(msg.Chat.ID, msg.ID)
+	if !ok || prev.text == msg.Text {
+		return // Only a reaction or formatting changed
+	}
+	if n := utf8.RuneCountInString(msg.Text); b.maxInput > 0 && n > b.maxInput {
+		b.reply(ctx, tg, msg, b.msg(msgInputTooLong, n, b.maxInput))
+		return
+	}
+
+	ctx = reqid.With(ctx, reqid.New())
+	slog.Info("re-running edited message", "chat_id", msg.Chat.ID, "request_id", reqid.FromContext(ctx))
+	b.respond(ctx, tg, msg, msg.Text, prev.replyID)
+}
@@ -0,0 +1,58 @@
+package bot
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-telegram/bot/models"
+)
+
+func TestHandleEditedMessage_RerunsAndEditsInPlace(t *testing.T) {
+	fake, tg := newFakeTelegram(t)
+	sessions := &recordingSessions{}
+	b := &Bot{sessions: sessions, editIvl: time.Hour, answers: newAnswers()}
+	ctx := context.Background()
+	message := func(id int, text string) *models.Message {
+		return &models.Message{ID: id, Chat: models.Chat{ID: 1}, From: &models.User{ID: 1}, Text: text}
+	}
+
+	b.handleMessage(ctx, tg, &models.Update{Message: message(10, "waht is go")})
+	prev, ok := b.answers.get(1, 10)
+	if !ok || prev.replyID == 0 {
+		t.Fatalf("expected the answer to message 10 to be tracked, got %+v", prev)
+	}
+
+	b.handleEditedMessage(ctx, tg, &models.Update{EditedMessage: message(10, "waht is go")})
+	b.handleEditedMessage(ctx, tg, &models.Update{EditedMessage: message(11, "never answered")})
+	b.handleEditedMessage(ctx, tg, &models.Update{EditedMessage: message(10, "what is go")})
+
+	if want := []string{"waht is go", "what is go"}; !slices.Equal(sessions.sent, want) {
+		t.Errorf("sent = %q, want only the changed edit re-run", sessions.sent)
+	}
+	if sends := fake.methods("sendMessage"); len(sends) != 1 {
+		t.Errorf("expected the re-run to reuse the first response, got sends %+v", sends)
+	}
+	edits := fake.methods("editMessageText")
+	if len(edits) != 1 || edits[0].messageID != strconv.Itoa(prev.replyID) || edits[0].text != "ok" {
+		t.Errorf("expected one edit of message %d, got %+v", prev.replyID, edits)
+	}
+	if cur, _ := b.answers.get(1, 10); cur.text != "what is go" || cur.replyID != prev.replyID {
+		t.Errorf("tracked answer = %+v, want the edited text and same reply", cur)
+	}
+}
+
+func TestAnswers_EvictsOldest(t *testing.T) {
+	a := newAnswers()
+	for id := range maxTrackedAnswers + 1 {
+		a.put(1, id, answer{text: "q", replyID: id + 1000})
+	}
+	if _, ok := a.get(1, 0); ok {
+		t.Error("expected the oldest answer to be forgotten")
+	}
+	if ans, ok := a.get(1, maxTrackedAnswers); !ok || ans.replyID != maxTrackedAnswers+1000 {
+		t.Errorf("expected the newest answer kept, got %+v", ans)
+	}
+}
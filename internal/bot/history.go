@@ -0,0 +1,91 @@
+package bot
+
+import (
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-telegram/bot/models"
+)
+
+// maxHistoryLineLen caps each remembered message so one long paste doesn't
+// crowd out the rest of the history.
+const maxHistoryLineLen = 500
+
+// maxHistoryChats caps how many conversations are remembered; the one
+// seen first is forgotten to make room.
+const maxHistoryChats = 512
+
+// historyKey identifies a conversation: a chat, or a forum topic within one.
+type historyKey struct {
+	chatID   int64
+	threadID int
+}
+
+// chatHistory keeps the last few messages of each conversation the bot can
+// see, so new sessions start with the recent discussion as context.
+type chatHistory struct {
+	size int
+
+	mu    sync.Mutex
+	chats map[historyKey][]string
+	order []historyKey // Oldest first, for eviction
+}
+
+func newChatHistory(size int) *chatHistory {
+	return &chatHistory{size: size, chats: make(map[historyKey][]string)}
+}
+
+// add records msg if it's ordinary text from a person. Commands and bot
+// messages are skipped.
+func (h *chatHistory) add(msg *models.Message) {
+	if msg.Text == "" || strings.HasPrefix(msg.Text, "/") || msg.From == nil || msg.From.IsBot {
+		return
+	}
+	text := msg.Text
+	if utf8.RuneCountInString(text) > maxHistoryLineLen {
+		text = truncateRunes(text, maxHistoryLineLen-3) + "..."
+	}
+	line := "[" + time.Unix(int64(msg.Date), 0).Format("15:04") + "] " + senderName(msg.From) + ": " + text
+
+	key := historyKeyOf(msg)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.chats[key]; !ok {
+		if len(h.order) == maxHistoryChats {
+			delete(h.chats, h.order[0])
+			h.order = h.order[1:]
+		}
+		h.order = append(h.order, key)
+	}
+	lines := append(h.chats[key], line)
+	if len(lines) > h.size {
+		lines = lines[len(lines)-h.size:]
+	}
+	h.chats[key] = lines
+}
+
+// recent returns msg's conversation history, oldest first, one message per
+// line.
+func (h *chatHistory) recent(msg *models.Message) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return strings.Join(h.chats[historyKeyOf(msg)], "\n")
+}
+
+func historyKeyOf(msg *models.Message) historyKey {
+	key := historyKey{chatID: msg.Chat.ID}
+	if msg.IsTopicMessage {
+		key.threadID = msg.MessageThreadID
+	}
+	return key
+}
+
+// senderName is how a message's author appears in the history.
+func senderName(u *models.User) string {
+	if u.Username != "" {
+		return "@" + u.Username
+	}
+	return strings.TrimSpace(u.FirstName + " " + u.LastName)
+}
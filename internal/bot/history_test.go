@@ -0,0 +1,83 @@
+package bot
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+func TestChatHistory_Ring(t *testing.T) {
+	h := newChatHistory(2)
+	date := int(time.Date(2026, 1, 2, 15, 4, 0, 0, time.Local).Unix())
+	msg := func(chatID int64, from *models.User, text string) *models.Message {
+		return &models.Message{Chat: models.Chat{ID: chatID}, From: from, Date: date, Text: text}
+	}
+	alice := &models.User{ID: 1, Username: "alice"}
+	bob := &models.User{ID: 2, FirstName: "Bob"}
+
+	h.add(msg(1, alice, "one"))
+	h.add(msg(1, bob, "two"))
+	h.add(msg(1, alice, "/status"))                              // command
+	h.add(msg(1, &models.User{ID: 3, IsBot: true}, "bot reply")) // bot
+	h.add(msg(2, bob, "other chat"))
+	h.add(msg(1, alice, "three"))
+
+	want := "[15:04] Bob: two\n[15:04] @alice: three"
+	if got := h.recent(msg(1, alice, "")); got != want {
+		t.Errorf("recent = %q, want %q", got, want)
+	}
+	if got := h.recent(msg(2, bob, "")); got != "[15:04] Bob: other chat" {
+		t.Errorf("recent for another chat = %q", got)
+	}
+	if got := h.recent(msg(3, bob, "")); got != "" {
+		t.Errorf("recent for an unseen chat = %q, want empty", got)
+	}
+}
+
+func TestChatHistory_BoundedChats(t *testing.T) {
+	h := newChatHistory(1)
+	from := &models.User{ID: 1, FirstName: "A"}
+	for id := range int64(maxHistoryChats + 1) {
+		h.add(&models.Message{Chat: models.Chat{ID: id}, From: from, Text: "hi"})
+	}
+
+	if len(h.chats) != maxHistoryChats {
+		t.Errorf("remembered %d chats, want %d", len(h.chats), maxHistoryChats)
+	}
+	if got := h.recent(&models.Message{Chat: models.Chat{ID: 0}}); got != "" {
+		t.Errorf("expected the first chat forgotten, got %q", got)
+	}
+	if got := h.recent(&models.Message{Chat: models.Chat{ID: maxHistoryChats}}); got == "" {
+		t.Error("expected the newest chat remembered")
+	}
+}
+
+func TestRecordHistory_SkipsBlocked(t *testing.T) {
+	b := &Bot{history: newChatHistory(5), blocked: map[int64]bool{2: true}}
+	h := b.recordHistory(func(context.Context, *bot.Bot, *models.Update) {})
+	for _, userID := range []int64{1, 2} {
+		h(context.Background(), nil, &models.Update{Message: &models.Message{
+			Chat: models.Chat{ID: 1}, From: &models.User{ID: userID, FirstName: "U" + strconv.FormatInt(userID, 10)}, Text: "hi",
+		}})
+	}
+
+	if got := b.history.recent(&models.Message{Chat: models.Chat{ID: 1}}); strings.Contains(got, "U2") || !strings.Contains(got, "U1") {
+		t.Errorf("recent = %q, want only the unblocked sender", got)
+	}
+}
+
+func TestChatHistory_LongMessage(t *testing.T) {
+	h := newChatHistory(5)
+	h.add(&models.Message{Chat: models.Chat{ID: 1}, From: &models.User{ID: 1, FirstName: "A"},
+		Text: strings.Repeat("x", 2*maxHistoryLineLen)})
+
+	got := h.recent(&models.Message{Chat: models.Chat{ID: 1}})
+	if !strings.HasSuffix(got, "...") || len(got) > maxHistoryLineLen+20 {
+		t.Errorf("expected a truncated line, got %d bytes", len(got))
+	}
+}
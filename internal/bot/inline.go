@@ -0,0 +1,177 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// Inline query answers are shown in a popup above the user's keyboard, and
+// Telegram drops an answer that arrives more than about ten seconds after
+// the query. Clients also send a new query as the user types, so each turn
+// is bounded by telegram.inline.timeout, a user's newer query cancels their
+// older one, and answers are cached by query text.
+
+// maxInlineTitle caps the query echoed as the result's title.
+const maxInlineTitle = 64
+
+// maxInlineDescription caps the answer preview shown under the title.
+const maxInlineDescription = 200
+
+// inlineAnswers caches inline answers and tracks each user's in-flight
+// query.
+type inlineAnswers struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu      sync.Mutex
+	cache   map[string]inlineAnswer
+	pending map[int64]*inlineQuery // In-flight query per user
+}
+
+type inlineQuery struct {
+	cancel context.CancelFunc
+}
+
+type inlineAnswer struct {
+	text    string
+	expires time.Time
+}
+
+func newInlineAnswers(ttl time.Duration) *inlineAnswers {
+	return &inlineAnswers{
+		ttl:     ttl,
+		now:     time.Now,
+		cache:   make(map[string]inlineAnswer),
+		pending: make(map[int64]*inlineQuery),
+	}
+}
+
+// get returns the cached answer to query, if it hasn't expired.
+func (a *inlineAnswers) get(query string) (string, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ans, ok := a.cache[query]
+	if !ok || !a.now().Before(ans.expires) {
+		return "", false
+	}
+	return ans.text, true
+}
+
+// put caches the answer to query, dropping expired entries.
+func (a *inlineAnswers) put(query, text string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := a.now()
+	for q, ans := range a.cache {
+		if !now.Before(ans.expires) {
+			delete(a.cache, q)
+		}
+	}
+	a.cache[query] = inlineAnswer{text: text, expires: now.Add(a.ttl)}
+}
+
+// begin returns a context for userID's new query that is cancelled when
+// the user sends another one, along with its release func.
+func (a *inlineAnswers) begin(ctx context.Context, userID int64) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	q := &inlineQuery{cancel: cancel}
+	a.mu.Lock()
+	if prev, ok := a.pending[userID]; ok {
+		prev.cancel()
+	}
+	a.pending[userID] = q
+	a.mu.Unlock()
+
+	return ctx, func() {
+		cancel()
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		if a.pending[userID] == q {
+			delete(a.pending, userID)
+		}
+	}
+}
+
+// isInlineQuery matches updates carrying an inline query.
+func isInlineQuery(update *models.Update) bool {
+	return update.InlineQuery != nil
+}
+
+// handleInlineQuery answers "@bot question" with a bounded one-off turn.
+// Superseded queries are dropped without an answer; a turn that runs out of
+// time answers with a pointer to asking in a chat instead.
+func (b *Bot) handleInlineQuery(ctx context.Context, tg *bot.Bot, update *models.Update) {
+	q := update.InlineQuery
+	query := strings.TrimSpace(q.Query)
+	if query == "" {
+		return
+	}
+
+	answer, cached := b.inline.get(query)
+	switch {
+	case cached:
+	case b.paused.Load():
+		// Paused by /pause_all; cached answers still go out, but no turn starts.
+		answer = b.msg(msgPaused)
+	default:
+		turnCtx, release := b.inline.begin(ctx, q.From.ID)
+		defer release()
+		turnCtx, cancel := context.WithTimeout(turnCtx, b.cfg.Inline.Timeout)
+		defer cancel()
+
+		var err error
+		answer, err = b.sessions.Ask(turnCtx, b.cfg.Inline.Model, query)
+		if err == nil && answer == "" {
+			err = errors.New("empty answer")
+		}
+		switch {
+		case err == nil:
+			b.inline.put(query, answer)
+		case errors.Is(err, context.DeadlineExceeded):
+			slog.Info("inline query timed out", "user_id", q.From.ID, "timeout", b.cfg.Inline.Timeout)
+			answer = b.msg(msgInlineTimeout)
+		case errors.Is(err, context.Canceled):
+			return // superseded by the user's next keystroke
+		default:
+			slog.Error("inline query failed", "user_id", q.From.ID, "error", err)
+			answer = b.msg(msgInlineFailed)
+		}
+	}
+
+	text := answer
+	if utf8.RuneCountInString(text) > maxMessageLen {
+		text = truncateRunes(text, maxMessageLen-3) + "..."
+	}
+	_, err := tg.AnswerInlineQuery(ctx, &bot.AnswerInlineQueryParams{
+		InlineQueryID: q.ID,
+		Results: []models.InlineQueryResult{&models.InlineQueryResultArticle{
+			ID:                  inlineResultID(query),
+			Title:               truncateRunes(query, maxInlineTitle),
+			Description:         truncateRunes(answer, maxInlineDescription),
+			InputMessageContent: &models.InputTextMessageContent{MessageText: text},
+		}},
+		CacheTime:  int(b.cfg.Inline.CacheTTL.Seconds()),
+		IsPersonal: true,
+	})
+	if err != nil {
+		slog.Warn("answer inline query failed", "user_id", q.From.ID, "error", err)
+	}
+}
+
+// inlineResultID derives a result ID, which Telegram caps at 64 bytes,
+// from the query.
+func inlineResultID(query string) string {
+	h := fnv.New64a()
+	h.Write([]byte(query))
+	return fmt.Sprintf("%x", h.Sum64())
+}
@@ -0,0 +1,182 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+
+	"github.com/zette-dev/natron/internal/config"
+)
+
+// askSessions answers inline questions, counting the turns it runs. With
+// block set, each turn waits for its context to end instead.
+type askSessions struct {
+	SessionProvider
+	asks  atomic.Int32
+	model atomic.Value
+	block bool
+}
+
+func (a *askSessions) Ask(ctx context.Context, model, question string) (string, error) {
+	a.asks.Add(1)
+	a.model.Store(model)
+	if a.block {
+		<-ctx.Done()
+		return "", ctx.Err()
+	}
+	return "Answer to " + question, nil
+}
+
+func inlineUpdate(query string) *models.Update {
+	return &models.Update{InlineQuery: &models.InlineQuery{ID: "q1", From: &models.User{ID: 7}, Query: query}}
+}
+
+func TestInlineAnswers_CacheExpires(t *testing.T) {
+	a := newInlineAnswers(time.Minute)
+	now := time.Now()
+	a.now = func() time.Time { return now }
+
+	a.put("q", "answer")
+	if got, ok := a.get("q"); !ok || got != "answer" {
+		t.Fatalf("get = %q, %v; want the cached answer", got, ok)
+	}
+	now = now.Add(time.Minute)
+	if _, ok := a.get("q"); ok {
+		t.Error("expected the answer to expire after the TTL")
+	}
+	a.put("other", "x")
+	if len(a.cache) != 1 {
+		t.Errorf("expected expired entries pruned on put, got %d entries", len(a.cache))
+	}
+}
+
+func TestInlineAnswers_NewQueryCancelsOlder(t *testing.T) {
+	a := newInlineAnswers(time.Minute)
+
+	first, releaseFirst := a.begin(context.Background(), 7)
+	second, releaseSecond := a.begin(context.Background(), 7)
+	other, releaseOther := a.begin(context.Background(), 8)
+	defer releaseOther()
+
+	if !errors.Is(first.Err(), context.Canceled) {
+		t.Error("expected the older query cancelled by the newer one")
+	}
+	if second.Err() != nil || other.Err() != nil {
+		t.Error("the newest query and other users' queries should keep running")
+	}
+
+	releaseFirst()
+	if a.pending[7] == nil {
+		t.Error("releasing a superseded query must not clear the newer one")
+	}
+	releaseSecond()
+	if _, ok := a.pending[7]; ok {
+		t.Error("expected the user's slot cleared once the newest query is released")
+	}
+}
+
+func TestHandleInlineQuery(t *testing.T) {
+	fake, tg := newFakeTelegram(t)
+	sessions := &askSessions{}
+	b := &Bot{
+		sessions: sessions,
+		cfg:      config.TelegramConfig{Inline: config.InlineConfig{Enabled: true, Model: "haiku", Timeout: time.Second, CacheTTL: time.Minute}},
+		inline:   newInlineAnswers(time.Minute),
+	}
+
+	b.handleInlineQuery(context.Background(), tg, inlineUpdate("  what is go?  "))
+	b.handleInlineQuery(context.Background(), tg, inlineUpdate("what is go?"))
+	b.handleInlineQuery(context.Background(), tg, inlineUpdate(" "))
+
+	if n := sessions.asks.Load(); n != 1 {
+		t.Errorf("expected one turn with the repeat served from cache, got %d", n)
+	}
+	if m := sessions.model.Load(); m != "haiku" {
+		t.Errorf("expected the inline model, got %v", m)
+	}
+	answers := fake.methods("answerInlineQuery")
+	if len(answers) != 2 {
+		t.Fatalf("expected an answer per non-empty query, got %d", len(answers))
+	}
+	for _, a := range answers {
+		if !strings.Contains(a.results, `"message_text":"Answer to what is go?"`) {
+			t.Errorf("unexpected results %s", a.results)
+		}
+	}
+}
+
+func TestHandleInlineQuery_Timeout(t *testing.T) {
+	fake, tg := newFakeTelegram(t)
+	sessions := &askSessions{block: true}
+	b := &Bot{
+		sessions: sessions,
+		cfg:      config.TelegramConfig{Inline: config.InlineConfig{Timeout: 10 * time.Millisecond, CacheTTL: time.Minute}},
+		inline:   newInlineAnswers(time.Minute),
+	}
+
+	b.handleInlineQuery(context.Background(), tg, inlineUpdate("slow question"))
+
+	answers := fake.methods("answerInlineQuery")
+	if len(answers) != 1 || !strings.Contains(answers[0].results, "Ask me in a chat instead") {
+		t.Fatalf("expected a too-slow answer, got %+v", answers)
+	}
+	if _, ok := b.inline.get("slow question"); ok {
+		t.Error("a timed-out answer must not be cached")
+	}
+}
+
+func TestHandleInlineQuery_Paused(t *testing.T) {
+	fake, tg := newFakeTelegram(t)
+	sessions := &askSessions{}
+	b := &Bot{
+		sessions: sessions,
+		cfg:      config.TelegramConfig{Inline: config.InlineConfig{Timeout: time.Second, CacheTTL: time.Minute}},
+		inline:   newInlineAnswers(time.Minute),
+	}
+	b.inline.put("cached question", "Cached answer")
+	b.paused.Store(true)
+
+	b.handleInlineQuery(context.Background(), tg, inlineUpdate("new question"))
+	b.handleInlineQuery(context.Background(), tg, inlineUpdate("cached question"))
+
+	if n := sessions.asks.Load(); n != 0 {
+		t.Errorf("expected no turns while paused, got %d", n)
+	}
+	answers := fake.methods("answerInlineQuery")
+	if len(answers) != 2 || !strings.Contains(answers[0].results, "temporarily paused") || !strings.Contains(answers[1].results, "Cached answer") {
+		t.Fatalf("expected a paused notice and the cached answer, got %+v", answers)
+	}
+}
+
+func TestAuthMiddleware_InlineQuery(t *testing.T) {
+	b := &Bot{allowed: map[int64]bool{7: true}, inline: newInlineAnswers(time.Minute)}
+	var passed int
+	h := b.authMiddleware(func(context.Context, *bot.Bot, *models.Update) { passed++ })
+
+	h(context.Background(), nil, inlineUpdate("hi"))
+	stranger := inlineUpdate("hi")
+	stranger.InlineQuery.From.ID = 8
+	h(context.Background(), nil, stranger)
+	if passed != 1 {
+		t.Errorf("expected only the allowed user's query through, got %d", passed)
+	}
+
+	b.blocked = map[int64]bool{7: true}
+	h(context.Background(), nil, inlineUpdate("hi"))
+	if passed != 1 {
+		t.Error("a blocked user's inline query must be dropped even when allowed")
+	}
+
+	b.blocked = nil
+	b.inline = nil
+	h(context.Background(), nil, inlineUpdate("hi"))
+	if passed != 1 {
+		t.Error("inline queries must be dropped when inline mode is off")
+	}
+}
@@ -0,0 +1,389 @@
+package bot
+
+import "fmt"
+
+// defaultLocale is used for any message a locale doesn't translate.
+const defaultLocale = "en"
+
+// msgKey names one of the bot's own UI strings. The agent's responses are
+// never translated.
+type msgKey string
+
+const (
+	msgCmdNew            msgKey = "cmd_new"
+	msgCmdCancel         msgKey = "cmd_cancel"
+	msgCmdCancelRestart  msgKey = "cmd_cancel_restart"
+	msgCmdStatus         msgKey = "cmd_status"
+	msgCmdWorkspaces     msgKey = "cmd_workspaces"
+	msgCmdReadOnly       msgKey = "cmd_readonly"
+	msgCmdTimeout        msgKey = "cmd_timeout"
+	msgCmdRaw            msgKey = "cmd_raw"
+	msgCmdRemember       msgKey = "cmd_remember"
+	msgCmdCompactMemory  msgKey = "cmd_compact_memory"
+	msgCmdLog            msgKey = "cmd_log"
+	msgCmdDebugLast      msgKey = "cmd_debug_last"
+	msgCmdRun            msgKey = "cmd_run"
+	msgCmdReloadIdentity msgKey = "cmd_reload_identity"
+	msgCmdWhoami         msgKey = "cmd_whoami"
+	msgCmdUsage          msgKey = "cmd_usage"
+	msgCmdModel          msgKey = "cmd_model"
+	msgCmdKeep           msgKey = "cmd_keep"
+	msgCmdPersona        msgKey = "cmd_persona"
+	msgCmdSettings       msgKey = "cmd_settings"
+	msgCmdPauseAll       msgKey = "cmd_pause_all"
+	msgCmdResumeAll      msgKey = "cmd_resume_all"
+	msgCmdLane           msgKey = "cmd_lane"
+
+	msgImagesUnsupported msgKey = "images_unsupported"
+	msgUploadRejected    msgKey = "upload_rejected"
+	msgUploadUnsupported msgKey = "upload_unsupported"
+	msgInputTooLong      msgKey = "input_too_long"
+	msgSendFailed        msgKey = "send_failed"
+	msgSessionFailing    msgKey = "session_failing"
+	msgWorkspaceNotDir   msgKey = "workspace_not_dir"
+	msgNotAuthenticated  msgKey = "not_authenticated"
+	msgUnknownModel      msgKey = "unknown_model"
+	msgTurnQueued        msgKey = "turn_queued"
+	msgIdleNudge         msgKey = "idle_nudge"
+	msgSessionReaped     msgKey = "session_reaped"
+	msgLaneUsage         msgKey = "lane_usage"
+	msgTurnError         msgKey = "turn_error"
+	msgTurnAborted       msgKey = "turn_aborted"
+	msgTurnReset         msgKey = "turn_reset"
+	msgResponseTruncated msgKey = "response_truncated"
+	msgCommandDenied     msgKey = "command_denied"
+
+	msgSessionCleared   msgKey = "session_cleared"
+	msgSessionClearedIn msgKey = "session_cleared_in"
+	msgWorkspaceUnknown msgKey = "workspace_unknown"
+	msgWorkspaceDenied  msgKey = "workspace_denied"
+	msgSessionForbidden msgKey = "session_forbidden"
+	msgCancelFailed     msgKey = "cancel_failed"
+	msgCancelNothing    msgKey = "cancel_nothing"
+	msgCancelled        msgKey = "cancelled"
+
+	msgStatusNone         msgKey = "status_none"
+	msgStatusActive       msgKey = "status_active"
+	msgStatusWorking      msgKey = "status_working"
+	msgStatusSteps        msgKey = "status_steps"
+	msgStatusTurn         msgKey = "status_turn"
+	msgStatusQueued       msgKey = "status_queued"
+	msgStatusConnected    msgKey = "status_connected"
+	msgStatusReconnecting msgKey = "status_reconnecting"
+
+	msgWhoamiUser      msgKey = "whoami_user"
+	msgWhoamiChat      msgKey = "whoami_chat"
+	msgWhoamiUsername  msgKey = "whoami_username"
+	msgWhoamiTitle     msgKey = "whoami_title"
+	msgWhoamiWorkspace msgKey = "whoami_workspace"
+
+	msgReadOnlyIsOn  msgKey = "readonly_is_on"
+	msgReadOnlyIsOff msgKey = "readonly_is_off"
+	msgReadOnlyOn    msgKey = "readonly_on"
+	msgReadOnlyOff   msgKey = "readonly_off"
+	msgReadOnlyUsage msgKey = "readonly_usage"
+
+	msgModelCurrent msgKey = "model_current"
+	msgModelDefault msgKey = "model_default"
+	msgModelSet     msgKey = "model_set"
+	msgModelUnknown msgKey = "model_unknown"
+	msgModelAny     msgKey = "model_any"
+	msgModelsHeader msgKey = "models_header"
+	msgModelsActive msgKey = "models_active"
+	msgModelsItem   msgKey = "models_item"
+
+	msgPersonaCurrent msgKey = "persona_current"
+	msgPersonaNone    msgKey = "persona_none"
+	msgPersonaSet     msgKey = "persona_set"
+	msgPersonaCleared msgKey = "persona_cleared"
+	msgPersonaTooLong msgKey = "persona_too_long"
+
+	msgSettingsHeader     msgKey = "settings_header"
+	msgSettingsNone       msgKey = "settings_none"
+	msgSettingsWorkspace  msgKey = "settings_workspace"
+	msgSettingsModel      msgKey = "settings_model"
+	msgSettingsPersona    msgKey = "settings_persona"
+	msgSettingsReadOnly   msgKey = "settings_readonly"
+	msgSettingsTimeout    msgKey = "settings_timeout"
+	msgSettingsTimeoutOff msgKey = "settings_timeout_off"
+	msgSettingsCleared    msgKey = "settings_cleared"
+	msgSettingsDenied     msgKey = "settings_denied"
+	msgSettingsUsage      msgKey = "settings_usage"
+
+	msgToolOutput         msgKey = "tool_output"
+	msgToolOutputShowFull msgKey = "tool_output_show_full"
+	msgToolOutputGone     msgKey = "tool_output_gone"
+
+	msgTimeoutAdminOnly msgKey = "timeout_admin_only"
+	msgTimeoutExpires   msgKey = "timeout_expires"
+	msgTimeoutNever     msgKey = "timeout_never"
+	msgTimeoutOff       msgKey = "timeout_off"
+	msgTimeoutSet       msgKey = "timeout_set"
+	msgTimeoutUsage     msgKey = "timeout_usage"
+
+	msgKeepOn        msgKey = "keep_on"
+	msgKeepOff       msgKey = "keep_off"
+	msgKeepNoSession msgKey = "keep_no_session"
+	msgKeepUsage     msgKey = "keep_usage"
+
+	msgPaused             msgKey = "paused"
+	msgPauseAdminOnly     msgKey = "pause_admin_only"
+	msgPauseUsage         msgKey = "pause_usage"
+	msgPausedAll          msgKey = "paused_all"
+	msgPausedAllCancelled msgKey = "paused_all_cancelled"
+	msgResumedAll         msgKey = "resumed_all"
+	msgNotPaused          msgKey = "not_paused"
+
+	msgRememberUsage  msgKey = "remember_usage"
+	msgRememberSaved  msgKey = "remember_saved"
+	msgRememberFailed msgKey = "remember_failed"
+
+	msgCompactAdminOnly msgKey = "compact_admin_only"
+	msgCompactStarted   msgKey = "compact_started"
+	msgCompactDone      msgKey = "compact_done"
+	msgCompactEmpty     msgKey = "compact_empty"
+	msgCompactChanged   msgKey = "compact_changed"
+	msgCompactFailed    msgKey = "compact_failed"
+
+	msgInlineTimeout msgKey = "inline_timeout"
+	msgInlineFailed  msgKey = "inline_failed"
+
+	msgUsageHeader   msgKey = "usage_header"
+	msgUsageToday    msgKey = "usage_today"
+	msgUsageWeek     msgKey = "usage_week"
+	msgUsageTokens   msgKey = "usage_tokens"
+	msgUsageDisabled msgKey = "usage_disabled"
+	msgUsageFailed   msgKey = "usage_failed"
+
+	msgLogAdminOnly msgKey = "log_admin_only"
+	msgLogUsage     msgKey = "log_usage"
+	msgLogEmpty     msgKey = "log_empty"
+
+	msgDebugAdminOnly msgKey = "debug_admin_only"
+	msgDebugEmpty     msgKey = "debug_empty"
+
+	msgRunUsage    msgKey = "run_usage"
+	msgRunNoPrompt msgKey = "run_no_prompt"
+	msgRunBadName  msgKey = "run_bad_name"
+
+	msgReloadSent       msgKey = "reload_sent"
+	msgReloadNoSession  msgKey = "reload_no_session"
+	msgReloadNoIdentity msgKey = "reload_no_identity"
+
+	msgWorkspacesHeader msgKey = "workspaces_header"
+	msgWorkspacesActive msgKey = "workspaces_active"
+	msgWorkspacesItem   msgKey = "workspaces_item"
+
+	msgRawEmpty     msgKey = "raw_empty"
+	msgRawTruncated msgKey = "raw_truncated"
+)
+
+// catalogs maps a locale to its translations. English is complete; other
+// locales may translate a subset and fall back to English for the rest.
+var catalogs = map[string]map[msgKey]string{
+	"en": {
+		msgCmdNew:            "Start a fresh session, optionally in another workspace",
+		msgCmdCancel:         "Stop the current reply",
+		msgCmdCancelRestart:  "Stop the current reply (restarts the session)",
+		msgCmdStatus:         "Show session state",
+		msgCmdWorkspaces:     "List workspaces",
+		msgCmdReadOnly:       "Toggle tool-less mode",
+		msgCmdTimeout:        "Show or set the inactivity timeout",
+		msgCmdRaw:            "Replay the last reply as plain text",
+		msgCmdRemember:       "Save a note to shared memory",
+		msgCmdCompactMemory:  "Condense the shared memory file",
+		msgCmdLog:            "Show recent session activity",
+		msgCmdDebugLast:      "Show the raw output of the last turn",
+		msgCmdRun:            "Send a prompt template from the workspace",
+		msgCmdReloadIdentity: "Re-send the soul and memory to this session",
+		msgCmdWhoami:         "Show your user and chat IDs",
+		msgCmdUsage:          "Show this chat's spend today and this week",
+		msgCmdModel:          "Show, list or switch the model",
+		msgCmdKeep:           "Keep the session from expiring while you're away",
+		msgCmdPersona:        "Show, set or clear this chat's persona",
+		msgCmdSettings:       "Show or clear this chat's setting overrides",
+		msgCmdPauseAll:       "Stop all agent activity until resumed",
+		msgCmdResumeAll:      "Lift /pause_all",
+		msgCmdLane:           "Ask %s a one-off question",
+
+		msgImagesUnsupported: "This backend can't read images. Describe it in text instead.",
+		msgUploadRejected:    "That file type isn't accepted here. Allowed: %s",
+		msgUploadUnsupported: "File uploads aren't supported yet. Paste the contents as a message instead.",
+		msgInputTooLong:      "That message is %d characters; the limit is %d. Please shorten it or send it as a file.",
+		msgSendFailed:        "Something went wrong. Please try again.",
+		msgSessionFailing:    "The session for this chat keeps crashing. Send /new to try again.",
+		msgWorkspaceNotDir:   "Workspace path %s is not a directory.",
+		msgNotAuthenticated:  "Claude is not authenticated on the server — run `claude login`.",
+		msgUnknownModel:      "Unknown model '%s' — check your configuration.",
+		msgTurnQueued:        "Busy with other chats — your message is queued and will start shortly.",
+		msgLaneUsage:         "Usage: /%s <message>",
+		msgIdleNudge:         "Still here if you need anything — this session will close in %s. Send a message or /keep to hold it open.",
+		msgSessionReaped:     "Your session expired due to inactivity; your next message starts a fresh one.",
+		msgTurnError:         "An error occurred while processing your message.",
+		msgTurnAborted:       "⚠️ The response was cut off before it finished. Send another message to continue.",
+		msgTurnReset:         "⚠️ The session was reset, so this response stopped here.",
+		msgResponseTruncated: "[truncated — response exceeded Telegram's limit]",
+		msgCommandDenied:     "Sorry, you're not allowed to use /%s here.",
+
+		msgSessionCleared:   "Session cleared. Starting fresh.",
+		msgSessionClearedIn: "Session cleared. Starting fresh in %s.",
+		msgWorkspaceUnknown: "No workspace named %s. Send /workspaces to list them.",
+		msgWorkspaceDenied:  "You don't have access to the %s workspace.",
+		msgSessionForbidden: "This chat's session is in a workspace you don't have access to.",
+		msgCancelFailed:     "Couldn't cancel. Send /new to start over.",
+		msgCancelNothing:    "Nothing to cancel.",
+		msgCancelled:        "Cancelled.",
+
+		msgStatusNone:         "No active session. Send a message to start one.",
+		msgStatusActive:       "Active since %s (%s ago)\nWorkspace: %s",
+		msgStatusWorking:      "⏳ Working",
+		msgStatusSteps:        "%d steps",
+		msgStatusTurn:         "Replying for %s",
+		msgStatusQueued:       "Messages waiting behind this reply: %d",
+		msgStatusConnected:    "Telegram: connected since %s",
+		msgStatusReconnecting: "Telegram: reconnecting since %s (%d failed attempts)",
+
+		msgWhoamiUser:      "User ID: %d",
+		msgWhoamiChat:      "Chat ID: %d",
+		msgWhoamiUsername:  "Chat username: @%s",
+		msgWhoamiTitle:     "Chat title: %s",
+		msgWhoamiWorkspace: "Workspace: %s",
+
+		msgReadOnlyIsOn:  "Read-only mode is on. Tools are disabled.",
+		msgReadOnlyIsOff: "Read-only mode is off.",
+		msgReadOnlyOn:    "Read-only mode on. Tools are disabled from the next message.",
+		msgReadOnlyOff:   "Read-only mode off. Tools are enabled from the next message.",
+		msgReadOnlyUsage: "Usage: /readonly on|off",
+
+		msgModelCurrent: "Model: %s",
+		msgModelDefault: "the backend's default",
+		msgModelSet:     "Model set to %s. It applies from the next message.",
+		msgModelUnknown: "Unknown model %q. Available: %s",
+		msgModelAny:     "Any model name is accepted. Current: %s",
+		msgModelsHeader: "Models:",
+		msgModelsActive: "• %s (this chat)",
+		msgModelsItem:   "• %s",
+
+		msgPersonaCurrent: "This chat's persona:\n%s",
+		msgPersonaNone:    "This chat has no persona. Set one with /persona <text>.",
+		msgPersonaSet:     "Persona set. It applies from the next message.",
+		msgPersonaCleared: "Persona cleared. It no longer applies from the next message.",
+		msgPersonaTooLong: "That persona is too long for this bot's identity limit. Shorten it and try again.",
+
+		msgSettingsHeader:     "Overrides for this chat:",
+		msgSettingsNone:       "This chat uses the default settings.",
+		msgSettingsWorkspace:  "Workspace: %s",
+		msgSettingsModel:      "Model: %s",
+		msgSettingsPersona:    "Persona: set (see /persona)",
+		msgSettingsReadOnly:   "Read-only: on",
+		msgSettingsTimeout:    "Timeout: %s",
+		msgSettingsTimeoutOff: "off",
+		msgSettingsCleared:    "Overrides cleared. The default settings apply from the next message.",
+		msgSettingsDenied:     "You can't change some of this chat's overrides, so none were cleared.",
+		msgSettingsUsage:      "Usage: /settings [clear]",
+
+		msgToolOutput:         "%s output:\n%s",
+		msgToolOutputShowFull: "Show full output",
+		msgToolOutputGone:     "That output is no longer available.",
+
+		msgTimeoutAdminOnly: "Only admins can change the timeout.",
+		msgTimeoutExpires:   "Sessions in this chat expire after %s of inactivity.",
+		msgTimeoutNever:     "Sessions in this chat never expire.",
+		msgTimeoutOff:       "Inactivity timeout off. Sessions in this chat won't expire.",
+		msgTimeoutSet:       "Sessions in this chat now expire after %s of inactivity.",
+		msgTimeoutUsage:     "Usage: /timeout <duration>|off (e.g. /timeout 30m)",
+
+		msgKeepOn:        "Session kept alive for up to %s. /keep off resumes the inactivity timer.",
+		msgKeepOff:       "Inactivity timer resumed.",
+		msgKeepNoSession: "No active session to keep.",
+		msgKeepUsage:     "Usage: /keep [off]",
+
+		msgPaused:             "The bot is temporarily paused. Try again later.",
+		msgPauseAdminOnly:     "Only admins can pause or resume the bot.",
+		msgPauseUsage:         "Usage: /pause_all [cancel]",
+		msgPausedAll:          "Paused. Running turns will finish; nothing new starts until /resume_all.",
+		msgPausedAllCancelled: "Paused, and %d running turn(s) cancelled. Nothing new starts until /resume_all.",
+		msgResumedAll:         "Resumed. Messages are answered again.",
+		msgNotPaused:          "The bot isn't paused.",
+
+		msgRememberUsage:  "Usage: /remember <text>",
+		msgRememberSaved:  "Noted. New sessions will remember this; send /new to apply it here now.",
+		msgRememberFailed: "Couldn't save that note.",
+
+		msgCompactAdminOnly: "Only admins can compact shared memory.",
+		msgCompactStarted:   "Compacting shared memory…",
+		msgCompactDone:      "Shared memory compacted from %d to %d bytes. New sessions will load the compacted version.",
+		msgCompactEmpty:     "Shared memory is empty; nothing to compact.",
+		msgCompactChanged:   "Shared memory was edited while compacting, so it was left unchanged. Try again.",
+		msgCompactFailed:    "Couldn't compact shared memory.",
+
+		msgInlineTimeout: "That needs more time than an inline answer allows. Ask me in a chat instead.",
+		msgInlineFailed:  "Couldn't answer that right now.",
+
+		msgUsageHeader:   "Usage in this chat",
+		msgUsageToday:    "Today: $%.2f over %d turns",
+		msgUsageWeek:     "This week: $%.2f over %d turns",
+		msgUsageTokens:   " · %s in / %s out tokens",
+		msgUsageDisabled: "Usage history needs transcripts enabled (transcripts.dir).",
+		msgUsageFailed:   "Couldn't total usage for this chat.",
+
+		msgLogAdminOnly: "Only admins can view the activity log.",
+		msgLogUsage:     "Usage: /log [lines], at most %d",
+		msgLogEmpty:     "No activity recorded for this session.",
+
+		msgDebugAdminOnly: "Only admins can view raw turn output.",
+		msgDebugEmpty:     "No raw output recorded for this session's last turn.",
+
+		msgRunUsage:    "Usage: /run <prompt>",
+		msgRunNoPrompt: "No prompt named %s. Prompts are .md files in %s in the workspace.",
+		msgRunBadName:  "Prompt names can't contain slashes or start with a dot.",
+
+		msgReloadSent:       "Sent the current identity to this session. It's an in-band refresh, not a new system prompt; send /new for a clean start.",
+		msgReloadNoSession:  "No active session. The next one will start with the current identity.",
+		msgReloadNoIdentity: "The soul and memory files are empty or missing.",
+
+		msgWorkspacesHeader: "Workspaces:",
+		msgWorkspacesActive: "• %s (this chat)",
+		msgWorkspacesItem:   "• %s",
+
+		msgRawEmpty:     "No response to replay yet.",
+		msgRawTruncated: "[truncated]",
+	},
+	// Spanish is a partial stub; untranslated messages appear in English.
+	"es": {
+		msgCmdNew:         "Empezar una sesión nueva",
+		msgCmdCancel:      "Detener la respuesta actual",
+		msgCmdStatus:      "Mostrar el estado de la sesión",
+		msgSendFailed:     "Algo salió mal. Inténtalo de nuevo.",
+		msgSessionCleared: "Sesión reiniciada. Empezamos de nuevo.",
+		msgCancelNothing:  "No hay nada que cancelar.",
+		msgCancelled:      "Cancelado.",
+		msgStatusNone:     "No hay ninguna sesión activa. Envía un mensaje para empezar una.",
+		msgStatusWorking:  "⏳ Trabajando",
+		msgStatusSteps:    "%d pasos",
+	},
+}
+
+// knownLocale reports whether locale has a catalog.
+func knownLocale(locale string) bool {
+	_, ok := catalogs[locale]
+	return ok
+}
+
+// lookup returns the locale's text for key, falling back to English, and
+// formats it with args when any are given.
+func lookup(locale string, key msgKey, args ...any) string {
+	text, ok := catalogs[locale][key]
+	if !ok {
+		text = catalogs[defaultLocale][key]
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(text, args...)
+	}
+	return text
+}
+
+// msg returns the bot's text for key in its configured locale.
+func (b *Bot) msg(key msgKey, args ...any) string {
+	return lookup(b.locale, key, args...)
+}
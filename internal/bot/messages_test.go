@@ -0,0 +1,48 @@
+package bot
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLookup_Fallback(t *testing.T) {
+	if got := lookup("es", msgCancelled); got != "Cancelado." {
+		t.Errorf("translated message = %q", got)
+	}
+	if got := lookup("es", msgRawEmpty); got != catalogs[defaultLocale][msgRawEmpty] {
+		t.Errorf("untranslated message = %q, want the English text", got)
+	}
+	if got := lookup("", msgStatusSteps, 3); got != "3 steps" {
+		t.Errorf("formatted message = %q", got)
+	}
+}
+
+// Translations must use the same format verbs as English so the caller's
+// arguments fit every locale.
+func TestCatalogs_MatchEnglish(t *testing.T) {
+	en := catalogs[defaultLocale]
+	for locale, catalog := range catalogs {
+		for key, text := range catalog {
+			want, ok := en[key]
+			if !ok {
+				t.Errorf("%s: key %q has no English text", locale, key)
+				continue
+			}
+			if verbs(text) != verbs(want) {
+				t.Errorf("%s: %q uses verbs %q, English uses %q", locale, key, verbs(text), verbs(want))
+			}
+		}
+	}
+}
+
+// verbs returns the printf verbs in text, in order.
+func verbs(text string) string {
+	var out []string
+	for i := 0; i+1 < len(text); i++ {
+		if text[i] == '%' {
+			out = append(out, text[i:i+2])
+			i++
+		}
+	}
+	return strings.Join(out, " ")
+}
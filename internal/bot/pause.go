@@ -0,0 +1,97 @@
+package bot
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// pauseGate turns non-admins away while the bot is paused by /pause_all,
+// so only operators can reach it until /resume_all.
+func (b *Bot) pauseGate(next bot.HandlerFunc) bot.HandlerFunc {
+	return func(ctx context.Context, tg *bot.Bot, update *models.Update) {
+		if !b.paused.Load() {
+			next(ctx, tg, update)
+			return
+		}
+		switch {
+		case update.Message != nil && update.Message.From != nil:
+			if !b.isAdmin(update.Message.From.ID) {
+				b.reply(ctx, tg, update.Message, b.msg(msgPaused))
+				return
+			}
+		case update.EditedMessage != nil && update.EditedMessage.From != nil:
+			if !b.isAdmin(update.EditedMessage.From.ID) {
+				return
+			}
+		case update.InlineQuery != nil && update.InlineQuery.From != nil:
+			if !b.isAdmin(update.InlineQuery.From.ID) {
+				return
+			}
+		}
+		next(ctx, tg, update)
+	}
+}
+
+// turnsPaused reports whether the bot is paused, telling msg's sender so.
+// Admins get through pauseGate to run commands, but no turns start for
+// anyone until /resume_all.
+func (b *Bot) turnsPaused(ctx context.Context, tg *bot.Bot, msg *models.Message) bool {
+	if !b.paused.Load() {
+		return false
+	}
+	b.reply(ctx, tg, msg, b.msg(msgPaused))
+	return true
+}
+
+// handlePauseAll stops all agent activity: "/pause_all [cancel]". Turns
+// already running finish unless cancel is given. Restricted to admins.
+func (b *Bot) handlePauseAll(ctx context.Context, tg *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	origin := originOf(update.Message)
+	if !b.isAdmin(origin.UserID) {
+		b.reply(ctx, tg, update.Message, b.msg(msgPauseAdminOnly))
+		return
+	}
+
+	var cancel bool
+	switch commandArgs(update.Message.Text) {
+	case "":
+	case "cancel":
+		cancel = true
+	default:
+		b.reply(ctx, tg, update.Message, b.msg(msgPauseUsage))
+		return
+	}
+
+	b.paused.Store(true)
+	slog.Warn("bot paused", "chat_id", origin.ChatID, "user_id", origin.UserID, "cancel", cancel)
+	text := b.msg(msgPausedAll)
+	if cancel {
+		text = b.msg(msgPausedAllCancelled, b.sessions.InterruptAll())
+	}
+	b.reply(ctx, tg, update.Message, text)
+}
+
+// handleResumeAll lifts /pause_all. Restricted to admins.
+func (b *Bot) handleResumeAll(ctx context.Context, tg *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	origin := originOf(update.Message)
+	if !b.isAdmin(origin.UserID) {
+		b.reply(ctx, tg, update.Message, b.msg(msgPauseAdminOnly))
+		return
+	}
+
+	if !b.paused.Swap(false) {
+		b.reply(ctx, tg, update.Message, b.msg(msgNotPaused))
+		return
+	}
+	slog.Warn("bot resumed", "chat_id", origin.ChatID, "user_id", origin.UserID)
+	b.reply(ctx, tg, update.Message, b.msg(msgResumedAll))
+}
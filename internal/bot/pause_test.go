@@ -0,0 +1,61 @@
+package bot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-telegram/bot/models"
+)
+
+func TestPauseAll(t *testing.T) {
+	fake, tg := newFakeTelegram(t)
+	sessions := &recordingSessions{interrupted: 2}
+	b := &Bot{sessions: sessions, editIvl: time.Hour, adminIDs: map[int64]bool{1: true}}
+	handle := b.pauseGate(b.handleMessage)
+
+	msg := func(userID int64, text string) *models.Update {
+		return &models.Update{Message: &models.Message{Chat: models.Chat{ID: userID}, From: &models.User{ID: userID}, Text: text}}
+	}
+	lastReply := func() string {
+		sends := fake.methods("sendMessage")
+		return sends[len(sends)-1].text
+	}
+	ctx := context.Background()
+
+	b.handlePauseAll(ctx, tg, msg(2, "/pause_all"))
+	if b.paused.Load() || lastReply() != lookup("en", msgPauseAdminOnly) {
+		t.Fatal("expected non-admins to be refused")
+	}
+
+	b.handlePauseAll(ctx, tg, msg(1, "/pause_all cancel"))
+	if !b.paused.Load() || lastReply() != "Paused, and 2 running turn(s) cancelled. Nothing new starts until /resume_all." {
+		t.Fatalf("expected a pause cancelling running turns, got %q", lastReply())
+	}
+
+	handle(ctx, tg, msg(2, "hello"))
+	if lastReply() != lookup("en", msgPaused) {
+		t.Errorf("expected a non-admin turned away while paused, got %q", lastReply())
+	}
+	handle(ctx, tg, msg(1, "hello"))
+	if lastReply() != lookup("en", msgPaused) {
+		t.Errorf("expected no turn for an admin either while paused, got %q", lastReply())
+	}
+	if len(sessions.sent) != 0 {
+		t.Fatalf("expected no turns while paused, got %q", sessions.sent)
+	}
+
+	b.handleResumeAll(ctx, tg, msg(1, "/resume_all"))
+	if b.paused.Load() || lastReply() != lookup("en", msgResumedAll) {
+		t.Fatalf("expected resume, got %q", lastReply())
+	}
+	handle(ctx, tg, msg(2, "hello again"))
+	if len(sessions.sent) != 1 || sessions.sent[0] != "hello again" {
+		t.Errorf("expected turns to flow after resuming, got %q", sessions.sent)
+	}
+
+	b.handleResumeAll(ctx, tg, msg(1, "/resume_all"))
+	if lastReply() != lookup("en", msgNotPaused) {
+		t.Errorf("expected a not-paused reply, got %q", lastReply())
+	}
+}
@@ -0,0 +1,28 @@
+package bot
+
+import "github.com/go-telegram/bot/models"
+
+// quickActionsPerRow is how many quick action buttons share a keyboard row.
+const quickActionsPerRow = 3
+
+// quickActionsKeyboard builds the reply keyboard offering a workspace's
+// workspaces.quick_actions, or nil when it has none. Telegram only attaches
+// reply keyboards to new messages, so a finished response is resent with
+// it. It's one-time, folding away once used, and typing stays available.
+func quickActionsKeyboard(actions []string) models.ReplyMarkup {
+	if len(actions) == 0 {
+		return nil
+	}
+	var rows [][]models.KeyboardButton
+	for i, label := range actions {
+		if i%quickActionsPerRow == 0 {
+			rows = append(rows, nil)
+		}
+		rows[len(rows)-1] = append(rows[len(rows)-1], models.KeyboardButton{Text: label})
+	}
+	return &models.ReplyKeyboardMarkup{
+		Keyboard:        rows,
+		ResizeKeyboard:  true,
+		OneTimeKeyboard: true,
+	}
+}
@@ -0,0 +1,97 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-telegram/bot/models"
+
+	"github.com/zette-dev/natron/internal/executor"
+)
+
+func TestQuickActionsKeyboard(t *testing.T) {
+	if kb := quickActionsKeyboard(nil); kb != nil {
+		t.Errorf("expected no keyboard without actions, got %+v", kb)
+	}
+
+	kb, ok := quickActionsKeyboard([]string{"Continue", "Explain more", "Run tests", "Commit"}).(*models.ReplyKeyboardMarkup)
+	if !ok {
+		t.Fatal("expected a reply keyboard")
+	}
+	var rows [][]string
+	for _, row := range kb.Keyboard {
+		var labels []string
+		for _, btn := range row {
+			labels = append(labels, btn.Text)
+		}
+		rows = append(rows, labels)
+	}
+	want := [][]string{{"Continue", "Explain more", "Run tests"}, {"Commit"}}
+	if fmt.Sprintf("%q", rows) != fmt.Sprintf("%q", want) {
+		t.Errorf("rows = %q, want %q", rows, want)
+	}
+	if !kb.OneTimeKeyboard || !kb.ResizeKeyboard {
+		t.Errorf("expected a one-time, resized keyboard, got %+v", kb)
+	}
+}
+
+func TestRespond_AttachesQuickActions(t *testing.T) {
+	fake, tg := newFakeTelegram(t)
+	sessions := &recordingSessions{actions: []string{"Continue"}}
+	b := &Bot{sessions: sessions, editIvl: time.Hour}
+
+	b.handleMessage(context.Background(), tg, &models.Update{Message: &models.Message{Chat: models.Chat{ID: 1}, Text: "hi"}})
+
+	sends := fake.methods("sendMessage")
+	if len(sends) != 1 {
+		t.Fatalf("expected one response message, got %+v", sends)
+	}
+	var markup models.ReplyKeyboardMarkup
+	if err := json.Unmarshal([]byte(sends[0].markup), &markup); err != nil {
+		t.Fatalf("reply_markup %q: %v", sends[0].markup, err)
+	}
+	if len(markup.Keyboard) != 1 || markup.Keyboard[0][0].Text != "Continue" {
+		t.Errorf("expected the quick actions on the response, got %+v", markup)
+	}
+}
+
+func TestStreamInto_QuickActionsOnFinalMessage(t *testing.T) {
+	fake, tg := newFakeTelegram(t)
+	b := &Bot{editIvl: time.Millisecond}
+	keyboard := quickActionsKeyboard([]string{"Continue"})
+
+	events := make(chan executor.Event)
+	done := make(chan int)
+	go func() { done <- b.streamInto(context.Background(), tg, 1, 0, 0, keyboard, events) }()
+
+	events <- executor.Event{Type: executor.EventText, Text: "partial"}
+	waitFor(t, "streamed send", func() bool { return len(fake.methods("sendMessage")) == 1 })
+	events <- executor.Event{Type: executor.EventDone, Text: "partial and done"}
+	close(events)
+	firstID := <-done
+
+	sends := fake.methods("sendMessage")
+	if len(sends) != 2 {
+		t.Fatalf("expected the finished response resent, got %+v", sends)
+	}
+	var remove models.ReplyKeyboardRemove
+	if err := json.Unmarshal([]byte(sends[0].markup), &remove); err != nil || !remove.RemoveKeyboard {
+		t.Errorf("expected the streamed message to dismiss the last keyboard, got %q", sends[0].markup)
+	}
+	var markup models.ReplyKeyboardMarkup
+	if err := json.Unmarshal([]byte(sends[1].markup), &markup); err != nil || len(markup.Keyboard) != 1 {
+		t.Errorf("expected the quick actions on the final message, got %q", sends[1].markup)
+	}
+	if sends[1].text != "partial and done" {
+		t.Errorf("final message = %q, want the finished response", sends[1].text)
+	}
+	if dels := fake.methods("deleteMessage"); len(dels) != 1 || dels[0].messageID != "1" {
+		t.Errorf("expected the streamed message deleted, got %+v", dels)
+	}
+	if firstID != 3 {
+		t.Errorf("streamInto = %d, want the resent message's ID", firstID)
+	}
+}
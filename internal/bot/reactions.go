@@ -0,0 +1,77 @@
+package bot
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+
+	"github.com/zette-dev/natron/internal/executor"
+)
+
+// Progress reactions. Telegram only accepts emoji from a fixed set, which
+// has no check mark, so a finished turn gets 👌.
+const (
+	reactReceived = "👀"
+	reactThinking = "🤔"
+	reactWriting  = "✍"
+	reactDone     = "👌"
+)
+
+// turnReactions shows a turn's phase as a reaction on the user's message:
+// received, then thinking during tool use and writing while text streams,
+// then done. If Telegram rejects a reaction, for instance because the chat
+// disallows them, it stops trying for the rest of the turn.
+type turnReactions struct {
+	tg     *bot.Bot
+	chatID int64
+	msgID  int
+	shown  string
+	off    bool
+}
+
+// set replaces the reaction with emoji unless it's already shown.
+func (r *turnReactions) set(ctx context.Context, emoji string) {
+	if r.off || emoji == r.shown {
+		return
+	}
+	_, err := r.tg.SetMessageReaction(ctx, &bot.SetMessageReactionParams{
+		ChatID:    r.chatID,
+		MessageID: r.msgID,
+		Reaction: []models.ReactionType{{
+			Type:              models.ReactionTypeTypeEmoji,
+			ReactionTypeEmoji: &models.ReactionTypeEmoji{Emoji: emoji},
+		}},
+	})
+	if err != nil {
+		slog.Debug("set progress reaction failed, disabling for this turn", "chat_id", r.chatID, "error", err)
+		r.off = true
+		return
+	}
+	r.shown = emoji
+}
+
+func (r *turnReactions) observe(ctx context.Context, evt executor.Event) {
+	switch evt.Type {
+	case executor.EventToolUse:
+		r.set(ctx, reactThinking)
+	case executor.EventText:
+		r.set(ctx, reactWriting)
+	case executor.EventDone:
+		r.set(ctx, reactDone)
+	}
+}
+
+// relay passes events through unchanged, updating the reaction as they go.
+func (r *turnReactions) relay(ctx context.Context, in <-chan executor.Event) <-chan executor.Event {
+	out := make(chan executor.Event, cap(in))
+	go func() {
+		defer close(out)
+		for evt := range in {
+			r.observe(ctx, evt)
+			out <- evt
+		}
+	}()
+	return out
+}
@@ -0,0 +1,72 @@
+package bot
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/zette-dev/natron/internal/executor"
+)
+
+func TestTurnReactions_Phases(t *testing.T) {
+	fake, tg := newFakeTelegram(t)
+	r := &turnReactions{tg: tg, chatID: 1, msgID: 10}
+	ctx := context.Background()
+
+	r.set(ctx, reactReceived)
+	for _, evt := range []executor.Event{
+		{Type: executor.EventToolUse, Tool: "Bash"},
+		{Type: executor.EventToolUse, Tool: "Read"}, // same phase, no call
+		{Type: executor.EventText, Text: "Hi"},
+		{Type: executor.EventText, Text: " there"},
+		{Type: executor.EventDone},
+	} {
+		r.observe(ctx, evt)
+	}
+
+	var got []string
+	for _, c := range fake.methods("setMessageReaction") {
+		for _, emoji := range []string{reactReceived, reactThinking, reactWriting, reactDone} {
+			if strings.Contains(c.reaction, emoji) {
+				got = append(got, emoji)
+			}
+		}
+	}
+	want := []string{reactReceived, reactThinking, reactWriting, reactDone}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("reactions = %q, want %q", got, want)
+	}
+}
+
+func TestTurnReactions_DisallowedChat(t *testing.T) {
+	fake, tg := newFakeTelegram(t)
+	fake.failChat = "1"
+	r := &turnReactions{tg: tg, chatID: 1, msgID: 10}
+	ctx := context.Background()
+
+	r.set(ctx, reactReceived)
+	r.observe(ctx, executor.Event{Type: executor.EventText, Text: "Hi"})
+	r.observe(ctx, executor.Event{Type: executor.EventDone})
+
+	if calls := fake.methods("setMessageReaction"); len(calls) != 1 {
+		t.Errorf("expected reactions to stop after the first rejection, got %d calls", len(calls))
+	}
+}
+
+func TestTurnReactions_RelayPassesEvents(t *testing.T) {
+	_, tg := newFakeTelegram(t)
+	r := &turnReactions{tg: tg, chatID: 1, msgID: 10}
+
+	in := make(chan executor.Event, 2)
+	in <- executor.Event{Type: executor.EventText, Text: "Hi"}
+	in <- executor.Event{Type: executor.EventDone, Text: "Hi"}
+	close(in)
+
+	var n int
+	for range r.relay(context.Background(), in) {
+		n++
+	}
+	if n != 2 {
+		t.Errorf("relay delivered %d events, want 2", n)
+	}
+}
@@ -0,0 +1,108 @@
+package bot
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// sendScheduler paces the Telegram calls of streaming responses under a
+// global calls-per-second budget. Chats with calls waiting are granted one
+// call each in turn, so a chat with a fast, long stream can't starve the
+// others. Callers make the call themselves once granted, so a slow request
+// doesn't hold up the next grant.
+type sendScheduler struct {
+	interval time.Duration // Minimum gap between grants
+
+	mu     sync.Mutex
+	queues map[int64][]chan struct{} // Waiting callers per chat, oldest first
+	order  []int64                   // Chats with waiters, next to be granted first
+	next   time.Time                 // Earliest time the next grant may go out
+	timer  *time.Timer               // Pending grant; nil when nothing waits
+}
+
+func newSendScheduler(perSecond float64) *sendScheduler {
+	return &sendScheduler{
+		interval: time.Duration(float64(time.Second) / perSecond),
+		queues:   make(map[int64][]chan struct{}),
+	}
+}
+
+// wait blocks until chatID may make one call, or ctx ends. A nil scheduler
+// grants at once.
+func (s *sendScheduler) wait(ctx context.Context, chatID int64) error {
+	if s == nil {
+		return nil
+	}
+	ticket := make(chan struct{})
+
+	s.mu.Lock()
+	if len(s.queues[chatID]) == 0 {
+		s.order = append(s.order, chatID)
+	}
+	s.queues[chatID] = append(s.queues[chatID], ticket)
+	s.arm()
+	s.mu.Unlock()
+
+	select {
+	case <-ticket:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		s.drop(chatID, ticket)
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// arm schedules the next grant if one is due and none is pending. Callers
+// hold s.mu.
+func (s *sendScheduler) arm() {
+	if s.timer != nil || len(s.order) == 0 {
+		return
+	}
+	s.timer = time.AfterFunc(max(time.Until(s.next), 0), s.grant)
+}
+
+// grant releases the oldest caller of the chat at the head of the rotation
+// and moves that chat to the back if it has more waiting.
+func (s *sendScheduler) grant() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.timer = nil
+	if len(s.order) == 0 {
+		return
+	}
+
+	chatID := s.order[0]
+	s.order = s.order[1:]
+	queue := s.queues[chatID]
+	close(queue[0])
+	if len(queue) > 1 {
+		s.queues[chatID] = queue[1:]
+		s.order = append(s.order, chatID)
+	} else {
+		delete(s.queues, chatID)
+	}
+
+	s.next = time.Now().Add(s.interval)
+	s.arm()
+}
+
+// drop removes a caller that gave up waiting. A ticket already granted is
+// gone from the queue, and its slot is simply spent. Callers hold s.mu.
+func (s *sendScheduler) drop(chatID int64, ticket chan struct{}) {
+	queue := s.queues[chatID]
+	i := slices.Index(queue, ticket)
+	if i < 0 {
+		return
+	}
+	queue = slices.Delete(queue, i, i+1)
+	if len(queue) > 0 {
+		s.queues[chatID] = queue
+		return
+	}
+	delete(s.queues, chatID)
+	s.order = slices.DeleteFunc(s.order, func(id int64) bool { return id == chatID })
+}
@@ -0,0 +1,99 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zette-dev/natron/internal/executor"
+)
+
+func TestSendScheduler_FairAcrossStreams(t *testing.T) {
+	fake, tg := newFakeTelegram(t)
+	const rate = 200
+	b := &Bot{editIvl: time.Millisecond, sched: newSendScheduler(rate)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Chat 1 runs four streams at once (say, a busy group with per-user
+	// sessions); chats 2 and 3 run one each. Every stream always has new
+	// text, so each wants to edit on every tick.
+	chats := []int64{1, 1, 1, 1, 2, 3}
+	var wg sync.WaitGroup
+	for _, chatID := range chats {
+		events := make(chan executor.Event)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case events <- executor.Event{Type: executor.EventText, Text: "word "}:
+				case <-ctx.Done():
+					return
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			b.streamResponse(ctx, tg, chatID, 0, events)
+		}()
+	}
+
+	const window = 400 * time.Millisecond
+	time.Sleep(window)
+	cancel()
+	wg.Wait()
+
+	counts := map[string]int{}
+	total := 0
+	for _, method := range []string{"sendMessage", "editMessageText"} {
+		for _, c := range fake.methods(method) {
+			counts[c.chatID]++
+			total++
+		}
+	}
+	if limit := int(rate*window.Seconds()) + 10; total > limit {
+		t.Errorf("expected at most ~%d calls under the budget, got %d", limit, total)
+	}
+	if total < 30 {
+		t.Fatalf("too few calls to judge fairness: %d", total)
+	}
+	for _, chatID := range []int64{1, 2, 3} {
+		share := float64(counts[strconv.FormatInt(chatID, 10)]) / float64(total)
+		// An even split is 1/3; a FIFO queue would give chat 1 about 2/3.
+		if share < 0.25 || share > 0.42 {
+			t.Errorf("chat %d got %.0f%% of %d calls, want about a third (%v)", chatID, share*100, total, counts)
+		}
+	}
+}
+
+func TestSendScheduler_CancelledWaiter(t *testing.T) {
+	s := newSendScheduler(1.0 / 3600) // one grant an hour
+	if err := s.wait(context.Background(), 1); err != nil {
+		t.Fatalf("first call should be granted at once: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := s.wait(ctx, 2); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the waiter to give up with its context, got %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.queues) != 0 || len(s.order) != 0 {
+		t.Errorf("expected the abandoned waiter to be removed, got queues %v order %v", s.queues, s.order)
+	}
+}
+
+func TestSendScheduler_NilGrantsAtOnce(t *testing.T) {
+	var s *sendScheduler
+	if err := s.wait(context.Background(), 1); err != nil {
+		t.Errorf("nil scheduler should not pace, got %v", err)
+	}
+}
@@ -0,0 +1,21 @@
+package bot
+
+import (
+	"context"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// sender is the part of the Telegram API that streaming a response uses:
+// the reply itself, code blocks, observer mirrors and the status message.
+// *bot.Bot implements it.
+type sender interface {
+	SendMessage(ctx context.Context, params *bot.SendMessageParams) (*models.Message, error)
+	EditMessageText(ctx context.Context, params *bot.EditMessageTextParams) (*models.Message, error)
+	PinChatMessage(ctx context.Context, params *bot.PinChatMessageParams) (bool, error)
+	DeleteMessage(ctx context.Context, params *bot.DeleteMessageParams) (bool, error)
+	SendChatAction(ctx context.Context, params *bot.SendChatActionParams) (bool, error)
+}
+
+var _ sender = (*bot.Bot)(nil)
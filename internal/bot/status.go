@@ -0,0 +1,105 @@
+package bot
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/go-telegram/bot"
+
+	"github.com/zette-dev/natron/internal/executor"
+)
+
+// turnStatus tracks a turn's progress for the optional status message that
+// sits beside the streamed answer. The message is created on the first edit
+// tick, so quick replies never get one, and deleted when the turn ends.
+type turnStatus struct {
+	started time.Time
+	tool    string // most recent tool
+	steps   int    // tool calls so far
+	msgID   int
+	shown   string
+	locale  string
+}
+
+func (s *turnStatus) observe(evt executor.Event) {
+	if evt.Type == executor.EventToolUse {
+		s.tool = evt.Tool
+		s.steps++
+	}
+}
+
+// text renders the compact progress summary.
+func (s *turnStatus) text(now time.Time) string {
+	text := lookup(s.locale, msgStatusWorking) + " · " + formatDuration(now.Sub(s.started).Round(time.Second))
+	if s.tool != "" {
+		text += " · " + s.tool
+	}
+	if s.steps > 0 {
+		text += " · " + lookup(s.locale, msgStatusSteps, s.steps)
+	}
+	return text
+}
+
+// updateStatus creates or edits the status message, pinning it on creation
+// when configured.
+func (b *Bot) updateStatus(ctx context.Context, tg sender, chatID int64, threadID int, s *turnStatus) {
+	text := s.text(time.Now())
+	if text == s.shown {
+		return
+	}
+	if b.sched.wait(ctx, chatID) != nil {
+		return
+	}
+
+	if s.msgID == 0 {
+		sent, err := tg.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID:              chatID,
+			MessageThreadID:     threadID,
+			Text:                text,
+			DisableNotification: true,
+		})
+		if err == nil && (sent == nil || sent.ID == 0) {
+			err = errNoMessage
+		}
+		if err != nil {
+			slog.Debug("send status message failed", "chat_id", chatID, "error", err)
+			return
+		}
+		s.msgID = sent.ID
+		if b.pinStatus {
+			_, err := tg.PinChatMessage(ctx, &bot.PinChatMessageParams{
+				ChatID:              chatID,
+				MessageID:           s.msgID,
+				DisableNotification: true,
+			})
+			if err != nil {
+				slog.Debug("pin status message failed", "chat_id", chatID, "error", err)
+			}
+		}
+	} else {
+		_, err := tg.EditMessageText(ctx, &bot.EditMessageTextParams{
+			ChatID:    chatID,
+			MessageID: s.msgID,
+			Text:      text,
+		})
+		if err != nil && !isNotModified(err) {
+			slog.Debug("edit status message failed", "chat_id", chatID, "error", err)
+		}
+	}
+	s.shown = text
+}
+
+// clearStatus deletes the status message, which also unpins it. It uses a
+// fresh context so the message is removed even when the turn was cancelled.
+func (b *Bot) clearStatus(tg sender, chatID int64, s *turnStatus) {
+	if s.msgID == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := tg.DeleteMessage(ctx, &bot.DeleteMessageParams{ChatID: chatID, MessageID: s.msgID})
+	if err != nil {
+		slog.Debug("delete status message failed", "chat_id", chatID, "error", err)
+	}
+}
@@ -0,0 +1,24 @@
+package bot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zette-dev/natron/internal/executor"
+)
+
+func TestTurnStatus_Text(t *testing.T) {
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	s := &turnStatus{started: start}
+
+	if got := s.text(start.Add(42 * time.Second)); got != "⏳ Working · 42s" {
+		t.Errorf("unexpected status before tools: %q", got)
+	}
+
+	s.observe(executor.Event{Type: executor.EventText, Text: "thinking"})
+	s.observe(executor.Event{Type: executor.EventToolUse, Tool: "Read"})
+	s.observe(executor.Event{Type: executor.EventToolUse, Tool: "Bash"})
+	if got := s.text(start.Add(95 * time.Second)); got != "⏳ Working · 1m 35s · Bash · 2 steps" {
+		t.Errorf("unexpected status after tools: %q", got)
+	}
+}
@@ -0,0 +1,471 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-telegram/bot/models"
+
+	"github.com/zette-dev/natron/internal/executor"
+	"github.com/zette-dev/natron/internal/session"
+)
+
+// waitFor polls cond until it holds or a second passes.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// startStream runs streamResponse for chat 1 in the background. The
+// returned func closes events and waits for the stream to finish.
+func startStream(ctx context.Context, b *Bot, tg sender) (chan<- executor.Event, func()) {
+	events := make(chan executor.Event)
+	done := make(chan struct{})
+	go func() {
+		b.streamResponse(ctx, tg, 1, 0, events)
+		close(done)
+	}()
+	return events, func() {
+		close(events)
+		<-done
+	}
+}
+
+// runStream feeds events to streamResponse for chat 1 and waits for it.
+func runStream(b *Bot, tg sender, events ...executor.Event) {
+	ch := make(chan executor.Event, len(events))
+	for _, evt := range events {
+		ch <- evt
+	}
+	close(ch)
+	b.streamResponse(context.Background(), tg, 1, 0, ch)
+}
+
+func TestStreamResponse_EditCadence(t *testing.T) {
+	fake, tg := newFakeTelegram(t)
+	b := &Bot{editIvl: 5 * time.Millisecond}
+	events, finish := startStream(context.Background(), b, tg)
+
+	events <- executor.Event{Type: executor.EventText, Text: "Hello"}
+	waitFor(t, "first send", func() bool { return len(fake.recorded()) == 1 })
+	time.Sleep(40 * time.Millisecond) // several ticks with nothing new
+	if got := fake.sequence(); len(got) != 1 || got[0] != "sendMessage" {
+		t.Fatalf("expected a single send while the text is unchanged, got %v", got)
+	}
+
+	events <- executor.Event{Type: executor.EventText, Text: ", world"}
+	waitFor(t, "edit with new text", func() bool { return len(fake.recorded()) == 2 })
+	calls := fake.recorded()
+	if calls[1].method != "editMessageText" || calls[1].msgID != calls[0].msgID || calls[1].text != "Hello, world" {
+		t.Errorf("expected the first message edited to the full text, got %+v", calls[1])
+	}
+
+	events <- executor.Event{Type: executor.EventDone, Text: "Hello, world"}
+	finish()
+	if got := fake.sequence(); len(got) != 2 {
+		t.Errorf("a final render identical to the last edit should be skipped, got %v", got)
+	}
+}
+
+func TestStreamResponse_NilSentMessage(t *testing.T) {
+	fake, tg := newFakeTelegram(t)
+	fake.nilSends = true
+	b := &Bot{editIvl: 5 * time.Millisecond}
+	events, finish := startStream(context.Background(), b, tg)
+
+	events <- executor.Event{Type: executor.EventText, Text: "Hello"}
+	waitFor(t, "first send", func() bool { return len(fake.recorded()) > 0 })
+	// The aborted stream still drains the turn, so these don't block.
+	events <- executor.Event{Type: executor.EventText, Text: ", world"}
+	events <- executor.Event{Type: executor.EventDone, Text: "Hello, world"}
+	finish()
+
+	calls := fake.recorded()
+	if len(calls) != 2 || calls[1].text != lookup("en", msgTurnAborted) {
+		t.Errorf("expected the stream to stop with an abort notice after the empty send, got %+v", calls)
+	}
+}
+
+func TestStreamResponse_MaxEditsPerTurn(t *testing.T) {
+	fake, tg := newFakeTelegram(t)
+	b := &Bot{editIvl: time.Millisecond, maxEdits: 3}
+	events, finish := startStream(context.Background(), b, tg)
+	edits := func() int {
+		n := 0
+		for _, m := range fake.sequence() {
+			if m == "editMessageText" {
+				n++
+			}
+		}
+		return n
+	}
+
+	var want strings.Builder
+	for i := range 60 {
+		chunk := fmt.Sprintf("chunk %d. ", i)
+		want.WriteString(chunk)
+		events <- executor.Event{Type: executor.EventText, Text: chunk}
+		time.Sleep(2 * time.Millisecond)
+		if i == 20 {
+			waitFor(t, "the edit cap", func() bool { return edits() == 3 })
+		}
+	}
+	if got := edits(); got != 3 {
+		t.Fatalf("%d intermediate edits, want the cap of 3", got)
+	}
+
+	events <- executor.Event{Type: executor.EventDone}
+	finish()
+	if got := edits(); got != 4 {
+		t.Errorf("%d edits in all, want 3 plus the final one", got)
+	}
+	if shown := fake.visible(); len(shown) != 1 || shown[0].text != want.String() {
+		t.Errorf("final message = %+v, want the full response", shown)
+	}
+}
+
+func TestStreamResponse_PlainThenMarkdownFinal(t *testing.T) {
+	const raw = "Run `go test` now."
+	fake, tg := newFakeTelegram(t)
+	b := &Bot{editIvl: 5 * time.Millisecond}
+	events, finish := startStream(context.Background(), b, tg)
+
+	events <- executor.Event{Type: executor.EventText, Text: raw}
+	waitFor(t, "intermediate send", func() bool { return len(fake.recorded()) == 1 })
+	events <- executor.Event{Type: executor.EventDone, Text: raw}
+	finish()
+
+	calls := fake.recorded()
+	if len(calls) != 2 {
+		t.Fatalf("expected a send and a final edit, got %+v", calls)
+	}
+	if calls[0].text != raw || calls[0].parseMode != "" {
+		t.Errorf("intermediate message should be plain, got %+v", calls[0])
+	}
+	if calls[1].method != "editMessageText" || calls[1].parseMode != models.ParseModeMarkdown || calls[1].text != formatV2(raw) {
+		t.Errorf("final edit should be MarkdownV2, got %+v", calls[1])
+	}
+}
+
+func TestStreamResponse_FormatStreaming(t *testing.T) {
+	const raw = "Run `go test` now."
+	fake, tg := newFakeTelegram(t)
+	b := &Bot{editIvl: 5 * time.Millisecond, fmtStream: true}
+	events, finish := startStream(context.Background(), b, tg)
+
+	events <- executor.Event{Type: executor.EventText, Text: raw}
+	waitFor(t, "intermediate send", func() bool { return len(fake.recorded()) == 1 })
+	events <- executor.Event{Type: executor.EventDone, Text: raw}
+	finish()
+
+	calls := fake.recorded()
+	if len(calls) != 1 {
+		t.Fatalf("expected the final render to match the formatted intermediate, got %+v", calls)
+	}
+	if calls[0].parseMode != models.ParseModeMarkdown || calls[0].text != formatV2(raw) {
+		t.Errorf("intermediate message should be MarkdownV2, got %+v", calls[0])
+	}
+}
+
+func TestStreamResponse_FormatStreamingFallsBack(t *testing.T) {
+	const partial = "Try:\n```go\nfmt.Println(1)"
+	const full = partial + "\n```"
+	fake, tg := newFakeTelegram(t)
+	fake.noParse = true
+	b := &Bot{editIvl: 5 * time.Millisecond, fmtStream: true}
+	events, finish := startStream(context.Background(), b, tg)
+
+	events <- executor.Event{Type: executor.EventText, Text: partial}
+	waitFor(t, "plain fallback", func() bool { return len(fake.visible()) == 1 })
+	time.Sleep(30 * time.Millisecond) // ticks with the same text
+	events <- executor.Event{Type: executor.EventDone, Text: full}
+	finish()
+
+	var got []string
+	for _, c := range fake.recorded() {
+		got = append(got, fmt.Sprintf("%s/%s", c.method, c.parseMode))
+	}
+	want := []string{"sendMessage/MarkdownV2", "sendMessage/", "editMessageText/MarkdownV2", "editMessageText/"}
+	if !slices.Equal(got, want) {
+		t.Errorf("calls = %v, want %v", got, want)
+	}
+	if shown := fake.visible(); len(shown) != 1 || shown[0].text != full {
+		t.Errorf("expected the full reply shown plain, got %+v", shown)
+	}
+}
+
+func TestStreamResponse_FinalTooLongForMarkdown(t *testing.T) {
+	// Escaping pushes the MarkdownV2 render over the limit while the raw
+	// text still fits, so the final message goes out as plain text.
+	raw := "`x`" + strings.Repeat(".", maxMessageLen-10)
+	fake, tg := newFakeTelegram(t)
+	runStream(&Bot{editIvl: time.Hour}, tg, executor.Event{Type: executor.EventDone, Text: raw})
+
+	calls := fake.recorded()
+	if len(calls) != 1 {
+		t.Fatalf("expected one send, got %d calls", len(calls))
+	}
+	if calls[0].text != raw || calls[0].parseMode != "" {
+		t.Errorf("expected the raw text sent plain, got parse mode %q and %d runes", calls[0].parseMode, utf8.RuneCountInString(calls[0].text))
+	}
+}
+
+func TestStreamResponse_SplitsLongResponses(t *testing.T) {
+	first := strings.Repeat("a", 3000)
+	second := strings.Repeat("b", 3000)
+	fake, tg := newFakeTelegram(t)
+	runStream(&Bot{editIvl: time.Hour}, tg,
+		executor.Event{Type: executor.EventText, Text: first},
+		executor.Event{Type: executor.EventText, Text: second},
+		executor.Event{Type: executor.EventDone},
+	)
+
+	shown := fake.visible()
+	if len(shown) != 2 {
+		t.Fatalf("expected the response split over two messages, got %d", len(shown))
+	}
+	if shown[0].text != first || shown[1].text != second {
+		t.Errorf("split messages don't hold the chunks in order: %d and %d runes",
+			utf8.RuneCountInString(shown[0].text), utf8.RuneCountInString(shown[1].text))
+	}
+}
+
+func TestStreamResponse_TruncatesLongIntermediateEdit(t *testing.T) {
+	fake, tg := newFakeTelegram(t)
+	b := &Bot{editIvl: 5 * time.Millisecond}
+	events, finish := startStream(context.Background(), b, tg)
+
+	events <- executor.Event{Type: executor.EventText, Text: strings.Repeat("x", maxMessageLen+100)}
+	waitFor(t, "intermediate send", func() bool { return len(fake.recorded()) == 1 })
+	finish()
+
+	text := fake.recorded()[0].text
+	if n := utf8.RuneCountInString(text); n != maxMessageLen || !strings.HasSuffix(text, "...") {
+		t.Errorf("expected an intermediate message cut to %d runes with an ellipsis, got %d runes", maxMessageLen, n)
+	}
+}
+
+func TestStreamResponse_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []executor.Event
+		want   string
+	}{
+		{
+			name:   "error before any text",
+			events: []executor.Event{{Type: executor.EventError, Error: errors.New("boom")}},
+			want:   lookup("en", msgTurnError),
+		},
+		{
+			name:   "error keeps partial text",
+			events: []executor.Event{{Type: executor.EventText, Text: "Partial"}, {Type: executor.EventError, Error: errors.New("boom")}},
+			want:   "Partial",
+		},
+		{
+			name: "not authenticated replaces partial text",
+			events: []executor.Event{
+				{Type: executor.EventText, Text: "Partial"},
+				{Type: executor.EventError, Error: fmt.Errorf("turn: %w", executor.ErrNotAuthenticated)},
+			},
+			want: lookup("en", msgNotAuthenticated),
+		},
+		{
+			name: "session reset appends a notice",
+			events: []executor.Event{
+				{Type: executor.EventText, Text: "Partial"},
+				{Type: executor.EventError, Error: session.ErrSessionReset},
+			},
+			want: "Partial\n\n" + lookup("en", msgTurnReset),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, tg := newFakeTelegram(t)
+			runStream(&Bot{editIvl: time.Hour}, tg, tt.events...)
+
+			shown := fake.visible()
+			if len(shown) != 1 || shown[0].text != tt.want {
+				t.Errorf("expected one message reading %q, got %+v", tt.want, shown)
+			}
+		})
+	}
+}
+
+func TestStreamResponse_RetriesFailedSend(t *testing.T) {
+	fake, tg := newFakeTelegram(t)
+	fake.failSends = 1
+	b := &Bot{editIvl: 5 * time.Millisecond}
+	events, finish := startStream(context.Background(), b, tg)
+
+	events <- executor.Event{Type: executor.EventText, Text: "Hello"}
+	waitFor(t, "send retried", func() bool { return len(fake.visible()) == 1 })
+	events <- executor.Event{Type: executor.EventDone, Text: "Hello"}
+	finish()
+
+	if got := fake.sequence(); len(got) != 2 || got[0] != "sendMessage" || got[1] != "sendMessage" {
+		t.Errorf("expected a failed send then a successful one, got %v", got)
+	}
+}
+
+func TestStreamResponse_EditNotModifiedIsBenign(t *testing.T) {
+	fake, tg := newFakeTelegram(t)
+	fake.editErr = "Bad Request: message is not modified"
+	b := &Bot{editIvl: 5 * time.Millisecond}
+	events, finish := startStream(context.Background(), b, tg)
+
+	events <- executor.Event{Type: executor.EventText, Text: "Hello"}
+	waitFor(t, "first send", func() bool { return len(fake.recorded()) == 1 })
+	events <- executor.Event{Type: executor.EventText, Text: " again"}
+	waitFor(t, "edit", func() bool { return len(fake.recorded()) == 2 })
+	time.Sleep(30 * time.Millisecond)
+	events <- executor.Event{Type: executor.EventDone, Text: "Hello again"}
+	finish()
+
+	// The rejected edit still counts as shown, so it isn't retried on
+	// every tick and the identical final render is skipped.
+	if got := fake.sequence(); len(got) != 2 {
+		t.Errorf("expected no repeated edits, got %v", got)
+	}
+}
+
+func TestStreamResponse_CancelStops(t *testing.T) {
+	fake, tg := newFakeTelegram(t)
+	b := &Bot{editIvl: 5 * time.Millisecond}
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan executor.Event)
+	done := make(chan struct{})
+	go func() {
+		b.streamResponse(ctx, tg, 1, 0, events)
+		close(done)
+	}()
+
+	events <- executor.Event{Type: executor.EventText, Text: "Hello"}
+	waitFor(t, "first send", func() bool { return len(fake.recorded()) == 1 })
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("streamResponse didn't return after cancellation")
+	}
+	if n := len(fake.recorded()); n != 1 {
+		t.Errorf("expected no calls after cancellation, got %d in total", n)
+	}
+}
+
+func TestStreamResponse_StatusMessage(t *testing.T) {
+	fake, tg := newFakeTelegram(t)
+	b := &Bot{editIvl: 5 * time.Millisecond, statusMsg: true, pinStatus: true}
+	events, finish := startStream(context.Background(), b, tg)
+
+	events <- executor.Event{Type: executor.EventToolUse, Tool: "Bash"}
+	waitFor(t, "status message", func() bool { return len(fake.recorded()) >= 2 })
+	events <- executor.Event{Type: executor.EventDone, Text: "Done"}
+	finish()
+
+	calls := fake.recorded()
+	status := calls[0]
+	if status.method != "sendMessage" || !strings.Contains(status.text, "Bash") {
+		t.Fatalf("expected a status message naming the tool first, got %+v", status)
+	}
+	if calls[1].method != "pinChatMessage" || calls[1].msgID != status.msgID {
+		t.Errorf("expected the status message pinned, got %+v", calls[1])
+	}
+	last := calls[len(calls)-1]
+	if last.method != "deleteMessage" || last.msgID != status.msgID {
+		t.Errorf("expected the status message deleted at the end, got %+v", last)
+	}
+	shown := fake.visible()
+	if got := shown[len(shown)-1]; got.text != "Done" {
+		t.Errorf("expected the answer sent alongside the status, got %+v", got)
+	}
+}
+
+func TestStreamResponse_InvalidUTF8(t *testing.T) {
+	binary := "dump: \xff\xfe\x00\xc3(" + strings.Repeat("\x80", maxMessageLen)
+	fake, tg := newFakeTelegram(t)
+	b := &Bot{editIvl: time.Hour}
+	runStream(b, tg,
+		executor.Event{Type: executor.EventText, Text: "Output follows.\n"},
+		executor.Event{Type: executor.EventText, Text: binary},
+		executor.Event{Type: executor.EventDone},
+	)
+
+	sent := fake.visible()
+	if len(sent) == 0 {
+		t.Fatal("expected the response to be sent")
+	}
+	for _, c := range sent {
+		if !utf8.ValidString(c.text) {
+			t.Errorf("sent invalid UTF-8: %q", c.text)
+		}
+		if n := utf8.RuneCountInString(c.text); n > maxMessageLen {
+			t.Errorf("message of %d runes exceeds the limit", n)
+		}
+	}
+	if got := sent[0].text; !strings.Contains(got, "dump: �") {
+		t.Errorf("expected invalid bytes replaced with U+FFFD, got %q", got)
+	}
+}
+
+func TestTrimPreamble(t *testing.T) {
+	patterns := []*regexp.Regexp{
+		regexp.MustCompile(`\A(?:I'll help you with that\.)\s*`),
+		regexp.MustCompile(`\A(?:Let me (?:check|look)[^.]*\.)\s*`),
+	}
+	tests := []struct {
+		in, want string
+	}{
+		{"I'll help you with that. Let me check the config.\n\nThe port is 8080.", "The port is 8080."},
+		{"Let me look at it. Found it.", "Found it."},
+		{"The port is 8080. I'll help you with that.", "The port is 8080. I'll help you with that."},
+		{"Sure, the port is 8080.", "Sure, the port is 8080."},
+		{"I'll help you with that.", "I'll help you with that."}, // Nothing left; keep it
+	}
+	for _, tt := range tests {
+		if got := trimPreamble(tt.in, patterns); got != tt.want {
+			t.Errorf("trimPreamble(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestStreamResponse_TrimsPreamble(t *testing.T) {
+	fake, tg := newFakeTelegram(t)
+	b := &Bot{editIvl: time.Hour, preambles: []*regexp.Regexp{regexp.MustCompile(`\A(?:I'll help you with that\.)\s*`)}}
+	runStream(b, tg,
+		executor.Event{Type: executor.EventText, Text: "I'll help you with that. "},
+		executor.Event{Type: executor.EventText, Text: "Done"},
+		executor.Event{Type: executor.EventDone},
+	)
+
+	if sent := fake.visible(); len(sent) != 1 || sent[0].text != "Done" {
+		t.Errorf("expected the preamble trimmed from the final response, got %+v", sent)
+	}
+}
+
+func TestStreamResponse_KeepsTailAfterSplit(t *testing.T) {
+	fake, tg := newFakeTelegram(t)
+	b := &Bot{editIvl: time.Hour, preambles: []*regexp.Regexp{regexp.MustCompile(`\A(?:I'll help you with that\.)\s*`)}}
+	runStream(b, tg,
+		executor.Event{Type: executor.EventText, Text: strings.Repeat("a", maxMessageLen-10)},
+		executor.Event{Type: executor.EventText, Text: "I'll help you with that. tail"},
+		executor.Event{Type: executor.EventDone},
+	)
+
+	sent := fake.visible()
+	if len(sent) != 2 || sent[1].text != "I'll help you with that. tail" {
+		t.Errorf("expected the second message kept whole, got %d messages ending %+v", len(sent), sent[len(sent)-1])
+	}
+}
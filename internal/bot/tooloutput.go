@@ -0,0 +1,173 @@
+package bot
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+
+	"github.com/zette-dev/natron/internal/reqid"
+)
+
+// maxToolOutputs and maxToolOutputBytes cap how many cut tool outputs, and
+// how many bytes of them, are kept for their "show full output" buttons;
+// older buttons report the output as gone. An output over maxToolOutputSize
+// is kept cut to that size.
+const (
+	maxToolOutputs     = 128
+	maxToolOutputBytes = 16 << 20
+	maxToolOutputSize  = 1 << 20
+)
+
+// toolOutputPrefix starts the callback data of a "show full output" button.
+const toolOutputPrefix = "tool_output:"
+
+// toolOutput is the full output of one tool call and the chat it was
+// shown in.
+type toolOutput struct {
+	chatID int64
+	tool   string
+	text   string
+}
+
+// toolOutputs keeps the full text of tool outputs shown cut in the chat,
+// keyed on the random ID in their button's callback data. An output is only
+// handed back to the chat it was shown in, so a forged callback from
+// another chat gets nothing.
+type toolOutputs struct {
+	limit int // Runes shown in the chat
+
+	mu    sync.Mutex
+	byID  map[string]toolOutput
+	order []string // Oldest first, for eviction
+	bytes int      // Kept text across all outputs
+}
+
+func newToolOutputs(limit int) *toolOutputs {
+	return &toolOutputs{limit: limit, byID: make(map[string]toolOutput)}
+}
+
+// preview returns text cut to the limit. When it was cut, the full text is
+// kept for chatID under id, forgetting the oldest outputs when full; id is
+// "" for text that fits.
+func (s *toolOutputs) preview(chatID int64, tool, text string) (shown, id string) {
+	if utf8.RuneCountInString(text) <= s.limit {
+		return text, ""
+	}
+	shown = truncateRunes(text, s.limit)
+	if len(text) > maxToolOutputSize {
+		text = strings.ToValidUTF8(text[:maxToolOutputSize], "")
+	}
+	var token [8]byte
+	if _, err := rand.Read(token[:]); err != nil {
+		return shown, ""
+	}
+	id = hex.EncodeToString(token[:])
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.order) > 0 && (len(s.order) == maxToolOutputs || s.bytes+len(text) > maxToolOutputBytes) {
+		s.bytes -= len(s.byID[s.order[0]].text)
+		delete(s.byID, s.order[0])
+		s.order = s.order[1:]
+	}
+	s.order = append(s.order, id)
+	s.byID[id] = toolOutput{chatID: chatID, tool: tool, text: text}
+	s.bytes += len(text)
+	return shown, id
+}
+
+// get returns the output kept under id for chatID, if it's still
+// remembered.
+func (s *toolOutputs) get(chatID int64, id string) (toolOutput, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out, ok := s.byID[id]
+	if !ok || out.chatID != chatID {
+		return toolOutput{}, false
+	}
+	return out, true
+}
+
+// sendToolOutput posts a tool call's output beside the streamed answer,
+// cut to session.tool_output_limit with a button offering the rest.
+func (b *Bot) sendToolOutput(ctx context.Context, tg sender, chatID int64, threadID int, tool, text string) {
+	text = strings.TrimSpace(validText(text))
+	if text == "" {
+		return
+	}
+	tool = cmp.Or(tool, "Tool")
+
+	shown, id := b.toolOut.preview(chatID, tool, text)
+	params := &bot.SendMessageParams{
+		ChatID:              chatID,
+		MessageThreadID:     threadID,
+		Text:                b.msg(msgToolOutput, tool, shown),
+		DisableNotification: true,
+	}
+	if id != "" {
+		params.Text += "\n…"
+		params.ReplyMarkup = &models.InlineKeyboardMarkup{
+			InlineKeyboard: [][]models.InlineKeyboardButton{{
+				{Text: b.msg(msgToolOutputShowFull), CallbackData: toolOutputPrefix + id},
+			}},
+		}
+	}
+
+	if b.sched.wait(ctx, chatID) != nil {
+		return
+	}
+	if _, err := tg.SendMessage(ctx, params); err != nil {
+		slog.Debug("send tool output failed", "chat_id", chatID, "request_id", reqid.FromContext(ctx), "error", err)
+	}
+}
+
+// isToolOutputButton matches taps on a "show full output" button.
+func isToolOutputButton(update *models.Update) bool {
+	return update.CallbackQuery != nil && strings.HasPrefix(update.CallbackQuery.Data, toolOutputPrefix)
+}
+
+// handleToolOutput answers a "show full output" tap by sending the kept
+// output as a file, in reply to the cut message.
+func (b *Bot) handleToolOutput(ctx context.Context, tg *bot.Bot, update *models.Update) {
+	q := update.CallbackQuery
+	msg := q.Message.Message
+	var (
+		out toolOutput
+		ok  bool
+	)
+	if msg != nil {
+		out, ok = b.toolOut.get(msg.Chat.ID, strings.TrimPrefix(q.Data, toolOutputPrefix))
+	}
+
+	answer := &bot.AnswerCallbackQueryParams{CallbackQueryID: q.ID}
+	if !ok {
+		answer.Text = b.msg(msgToolOutputGone)
+	}
+	if _, err := tg.AnswerCallbackQuery(ctx, answer); err != nil {
+		slog.Debug("answer callback query failed", "error", err)
+	}
+	if !ok {
+		return
+	}
+	b.sendChatAction(ctx, tg, msg.Chat.ID, msg.MessageThreadID, models.ChatActionUploadDocument)
+	_, err := tg.SendDocument(ctx, &bot.SendDocumentParams{
+		ChatID:          msg.Chat.ID,
+		MessageThreadID: msg.MessageThreadID,
+		Document: &models.InputFileUpload{
+			Filename: strings.ToLower(out.tool) + "-output.txt",
+			Data:     strings.NewReader(out.text),
+		},
+		ReplyParameters: &models.ReplyParameters{MessageID: msg.ID},
+	})
+	if err != nil {
+		slog.Error("send tool output file failed", "chat_id", msg.Chat.ID, "error", err)
+	}
+}
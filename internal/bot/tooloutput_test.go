@@ -0,0 +1,114 @@
+package bot
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-telegram/bot/models"
+
+	"github.com/zette-dev/natron/internal/executor"
+)
+
+func TestToolOutputs_TruncateAndRetain(t *testing.T) {
+	s := newToolOutputs(5)
+
+	if shown, id := s.preview(1, "Bash", "short"); shown != "short" || id != "" {
+		t.Errorf("preview(short) = %q, %q; want it whole and not kept", shown, id)
+	}
+
+	shown, id := s.preview(1, "Read", "héllo world")
+	if shown != "héllo" || id == "" {
+		t.Fatalf("preview(long) = %q, %q; want the first five runes and an ID", shown, id)
+	}
+	out, ok := s.get(1, id)
+	if !ok || out.tool != "Read" || out.text != "héllo world" {
+		t.Errorf("get(%q) = %+v, %v; want the full Read output", id, out, ok)
+	}
+	if _, ok := s.get(2, id); ok {
+		t.Error("another chat got the output")
+	}
+	if _, other := s.preview(1, "Read", "héllo world"); other == id {
+		t.Error("expected every kept output to get its own ID")
+	}
+
+	for i := range maxToolOutputs {
+		s.preview(1, "Bash", "output "+strconv.Itoa(i))
+	}
+	if _, ok := s.get(1, id); ok {
+		t.Error("expected the oldest output to be forgotten once full")
+	}
+	if _, ok := s.get(1, "missing"); ok {
+		t.Error("get of an unknown ID should fail")
+	}
+}
+
+func TestToolOutputs_ByteBudget(t *testing.T) {
+	s := newToolOutputs(5)
+	big := strings.Repeat("x", maxToolOutputSize+10)
+
+	var ids []string
+	for range maxToolOutputBytes/maxToolOutputSize + 1 {
+		_, id := s.preview(1, "Bash", big)
+		ids = append(ids, id)
+	}
+	if s.bytes > maxToolOutputBytes {
+		t.Errorf("kept %d bytes, want at most %d", s.bytes, maxToolOutputBytes)
+	}
+	if _, ok := s.get(1, ids[0]); ok {
+		t.Error("expected the oldest output forgotten to stay in budget")
+	}
+	if out, ok := s.get(1, ids[len(ids)-1]); !ok || len(out.text) != maxToolOutputSize {
+		t.Errorf("newest kept output is %d bytes, want it cut to %d", len(out.text), maxToolOutputSize)
+	}
+}
+
+func TestHandleToolOutput_OwnChatOnly(t *testing.T) {
+	fake, tg := newFakeTelegram(t)
+	b := &Bot{toolOut: newToolOutputs(5)}
+	_, id := b.toolOut.preview(1, "Bash", "the full output")
+	tap := func(chatID int64) *models.Update {
+		return &models.Update{CallbackQuery: &models.CallbackQuery{
+			ID:      "q",
+			Data:    toolOutputPrefix + id,
+			Message: models.MaybeInaccessibleMessage{Message: &models.Message{ID: 9, Chat: models.Chat{ID: chatID}}},
+		}}
+	}
+
+	b.handleToolOutput(context.Background(), tg, tap(2))
+	if docs := fake.methods("sendDocument"); len(docs) != 0 {
+		t.Fatalf("a tap from another chat got %+v", docs)
+	}
+	b.handleToolOutput(context.Background(), tg, tap(1))
+	if docs := fake.methods("sendDocument"); len(docs) != 1 || docs[0].document != "the full output" || docs[0].chatID != "1" {
+		t.Errorf("sent documents %+v, want the output in chat 1", docs)
+	}
+	if answers := fake.methods("answerCallbackQuery"); len(answers) != 2 {
+		t.Errorf("expected both taps answered, got %d", len(answers))
+	}
+}
+
+func TestStreamResponse_ToolOutput(t *testing.T) {
+	fake, tg := newFakeTelegram(t)
+	b := &Bot{editIvl: time.Hour, toolOut: newToolOutputs(10)}
+	events, finish := startStream(context.Background(), b, tg)
+
+	events <- executor.Event{Type: executor.EventToolResult, Tool: "Bash", Text: "ok\n"}
+	events <- executor.Event{Type: executor.EventToolResult, Tool: "Bash", Text: strings.Repeat("x", 40)}
+	events <- executor.Event{Type: executor.EventToolResult, Tool: "Bash", Text: "  \n"}
+	events <- executor.Event{Type: executor.EventDone, Text: "Done."}
+	finish()
+
+	calls := fake.recorded()
+	if len(calls) != 3 {
+		t.Fatalf("expected two tool outputs and the answer, got %+v", calls)
+	}
+	if calls[0].text != b.msg(msgToolOutput, "Bash", "ok") {
+		t.Errorf("short output = %q, want it whole", calls[0].text)
+	}
+	if want := b.msg(msgToolOutput, "Bash", strings.Repeat("x", 10)) + "\n…"; calls[1].text != want {
+		t.Errorf("long output = %q, want %q", calls[1].text, want)
+	}
+}
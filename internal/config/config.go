@@ -2,47 +2,302 @@ package config
 
 import (
 	"fmt"
+	"log/slog"
+	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	Telegram   TelegramConfig   `yaml:"telegram"`
-	Session    SessionConfig    `yaml:"session"`
-	Claude     ClaudeConfig     `yaml:"claude"`
-	Workspaces WorkspacesConfig `yaml:"workspaces"`
-	Memory     MemoryConfig     `yaml:"memory"`
+	Telegram     TelegramConfig     `yaml:"telegram"`
+	Session      SessionConfig      `yaml:"session"`
+	Claude       ClaudeConfig       `yaml:"claude"`
+	Workspaces   WorkspacesConfig   `yaml:"workspaces"`
+	Memory       MemoryConfig       `yaml:"memory"`
+	Transcripts  TranscriptsConfig  `yaml:"transcripts"`
+	Uploads      UploadsConfig      `yaml:"uploads"`
+	Integrations IntegrationsConfig `yaml:"integrations"`
 }
 
 type TelegramConfig struct {
 	BotToken       string  `yaml:"bot_token"`
 	AllowedUserIDs []int64 `yaml:"allowed_user_ids"`
+
+	AdminUserIDs        []int64       `yaml:"admin_user_ids"`        // May run admin commands, as may owner_user_id; empty means only the owner
+	BlockedUserIDs      []int64       `yaml:"blocked_user_ids"`      // Always ignored, even when allowed or a group admin
+	APIBaseURL          string        `yaml:"api_base_url"`          // Bot API server or proxy; empty uses api.telegram.org
+	ReconnectMaxBackoff time.Duration `yaml:"reconnect_max_backoff"` // Cap on long-poll restart delay
+	SendRate            float64       `yaml:"send_rate"`             // Streaming API calls per second across all chats; 0 is unpaced
+	Locale              string        `yaml:"locale"`                // Language of the bot's own messages; default en
+
+	// AuthMode "group_admins" also authorizes the administrators of the
+	// groups in AdminGroupIDs, in addition to AllowedUserIDs. Admins of
+	// other groups the bot is added to get nothing.
+	AuthMode      string        `yaml:"auth_mode"`       // allowlist (default) or group_admins
+	AdminGroupIDs []int64       `yaml:"admin_group_ids"` // Groups whose admins group_admins trusts
+	AdminCacheTTL time.Duration `yaml:"admin_cache_ttl"` // How long a group's admin list is trusted
+
+	// Observers maps a chat ID to chats that receive a copy of its final
+	// responses. Messages posted in observer chats are ignored.
+	Observers map[int64][]int64 `yaml:"observers"`
+
+	// CommandAccess restricts commands (named without the slash) to an
+	// audience: all, admins or owner. Unlisted commands are open to every
+	// authorized user.
+	CommandAccess map[string]string `yaml:"command_access"`
+	OwnerUserID   int64             `yaml:"owner_user_id"` // The owner audience; also counts as an admin
+
+	// Inline answers "@bot question" inline queries from allowed users in
+	// any chat, with a one-off turn outside their sessions.
+	Inline InlineConfig `yaml:"inline"`
 }
 
+// InlineConfig tunes inline query answers. Telegram only accepts an answer
+// for about ten seconds after the query, so turns are bounded by Timeout
+// and should use a fast model.
+type InlineConfig struct {
+	Enabled  bool          `yaml:"enabled"`   // Inline mode must also be enabled with BotFather's /setinline
+	Model    string        `yaml:"model"`     // Model for inline turns; empty uses claude.model
+	Timeout  time.Duration `yaml:"timeout"`   // Turn budget before answering that it took too long
+	CacheTTL time.Duration `yaml:"cache_ttl"` // How long an answer is reused for the same query
+}
+
+// Telegram authorization modes.
+const (
+	AuthAllowlist   = "allowlist"
+	AuthGroupAdmins = "group_admins"
+)
+
+// Command audiences for TelegramConfig.CommandAccess.
+const (
+	AccessAll    = "all"
+	AccessAdmins = "admins"
+	AccessOwner  = "owner"
+)
+
 type SessionConfig struct {
+	InactivityTimeout time.Duration `yaml:"inactivity_timeout"` // Stop idle sessions after this long; 0 never does
+	KeepWarm          []string      `yaml:"keep_warm"`          // Chat IDs or workspace names exempt from expiry
 	MaxResponseLength int           `yaml:"max_response_length"`
 	EditInterval      time.Duration `yaml:"edit_interval"`
+	MaxEditsPerTurn   int           `yaml:"max_edits_per_turn"` // Cap on intermediate edits; 0 is unlimited
+	ToolOutputLimit   int           `yaml:"tool_output_limit"`  // Show tool output cut to this many characters; 0 hides it
+	CrashLimit        int           `yaml:"crash_limit"`        // Crashes within CrashWindow before recovery pauses
+	CrashWindow       time.Duration `yaml:"crash_window"`
+	PerTopic          bool          `yaml:"per_topic"`          // Separate session per forum topic
+	Debounce          time.Duration `yaml:"debounce"`           // Batch rapid messages; 0 disables
+	StatusMessage     bool          `yaml:"status_message"`     // Progress message during long turns
+	PinStatus         bool          `yaml:"pin_status"`         // Pin the progress message
+	ProgressReactions bool          `yaml:"progress_reactions"` // React to the user's message with the turn's phase
+	SplitCodeBlocks   bool          `yaml:"split_code_blocks"`  // Send code blocks as separate messages
+	FormatStreaming   bool          `yaml:"format_streaming"`   // MarkdownV2 in intermediate edits, not just the final one
+	MinFirstChars     int           `yaml:"min_first_chars"`    // Buffer before the first send; 0 sends at once
+	RerunOnEdit       bool          `yaml:"rerun_on_edit"`      // Re-answer an edited message in place, at the cost of a turn
+	EmptyResponse     string        `yaml:"empty_response"`     // Reply when a turn ends without text
+	MaxInputChars     int           `yaml:"max_input_chars"`    // Reject longer messages; 0 disables
+
+	// ParkAfter marks an idle session parked: its process is kept and it
+	// still answers at once. ReapAfter stops it, so the next message cold
+	// starts; when set it takes precedence over InactivityTimeout.
+	ParkAfter time.Duration `yaml:"park_after"`
+	ReapAfter time.Duration `yaml:"reap_after"`
+
+	// KeepLimit caps how long /keep holds off expiry before normal reaping
+	// resumes, so a forgotten pause doesn't keep a session running forever.
+	KeepLimit time.Duration `yaml:"keep_limit"`
+
+	// Lanes maps extra command names to models, so "/quick <message>" with
+	// quick: haiku gets a one-off, tool-less answer from haiku outside the
+	// chat's session. Names follow Telegram's command rules and can't start
+	// with a built-in command or another lane.
+	Lanes map[string]string `yaml:"lanes"`
+
+	// IdleNudge tells an idle chat this long before its session is reaped,
+	// once per idle period, so it can keep it alive. 0 disables the nudge.
+	IdleNudge time.Duration `yaml:"idle_nudge"`
+
+	// ReapNotice tells a chat when its session is stopped for inactivity,
+	// so the next message starting fresh doesn't come as a surprise.
+	ReapNotice bool `yaml:"reap_notice"`
+
+	// ReadyTimeout bounds how long a new session waits for the executor's
+	// startup handshake before its first message is sent; 0 skips the wait.
+	// Off by default, since the CLI may hold its init until the first input.
+	ReadyTimeout time.Duration `yaml:"ready_timeout"`
+
+	// Redactions are regexes whose matches in the agent's output are
+	// replaced with "[redacted]" before reaching Telegram or transcripts.
+	// Load compiles them into RedactPatterns.
+	Redactions     []string         `yaml:"redactions"`
+	RedactPatterns []*regexp.Regexp `yaml:"-"`
+
+	// TrimPreambles are regexes matched at the start of each final
+	// response, like `I'll help you with that\.`, and removed with the
+	// whitespace after them before rendering. Load compiles them, anchored,
+	// into PreamblePatterns.
+	TrimPreambles    []string         `yaml:"trim_preambles"`
+	PreamblePatterns []*regexp.Regexp `yaml:"-"`
+
+	MaxConcurrentSpawns int `yaml:"max_concurrent_spawns"` // 0 means unlimited
+	MaxConcurrentTurns  int `yaml:"max_concurrent_turns"`  // Turns in flight across all chats; more wait. 0 means unlimited
+
+	// AutoRetry resends a turn that fails with a transient agent error,
+	// such as an overloaded or rate-limited API, before showing the error.
+	AutoRetry RetryConfig `yaml:"auto_retry"`
+
+	// FlushTimeout bounds each flush of buffered state, such as the one
+	// on shutdown.
+	FlushTimeout time.Duration `yaml:"flush_timeout"`
+
+	// TurnContext re-states the workspace at the start of every turn, for
+	// backends that lose track of the system prompt in long sessions.
+	TurnContext TurnContextConfig `yaml:"turn_context"`
+}
+
+type TurnContextConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Header  string `yaml:"header"` // {workspace} and {dir} are filled in; default names both
+}
+
+type RetryConfig struct {
+	Attempts int           `yaml:"attempts"` // Retries per turn; 0 disables
+	Backoff  time.Duration `yaml:"backoff"`  // Delay before the first retry, doubling after each
 }
 
 type ClaudeConfig struct {
-	Model        string  `yaml:"model"`
-	MaxBudgetUSD float64 `yaml:"max_budget_usd"`
-	SoulPath     string  `yaml:"soul_path"`
-	MemoryPath   string  `yaml:"memory_path"`
+	Model         string   `yaml:"model"`
+	AllowedModels []string `yaml:"allowed_models"` // Models /model may switch to; empty allows any
+	MaxBudgetUSD  float64  `yaml:"max_budget_usd"`
+	SoulPath      string   `yaml:"soul_path"`   // Identity prompt; default ~/.natron/soul.md
+	MemoryPath    string   `yaml:"memory_path"` // Shared memory, appended by /remember; default ~/.natron/memory.md
+
+	// MaxIdentityChars caps the soul, shared memory and /persona given to
+	// each new session; the oldest memory is dropped first, and a longer
+	// persona is refused. 0 means no cap.
+	MaxIdentityChars int `yaml:"max_identity_chars"`
+
+	// ThinkingBudgetTokens enables extended thinking with this many tokens
+	// per turn, trading speed for quality. 0 leaves thinking off.
+	ThinkingBudgetTokens int `yaml:"thinking_budget_tokens"`
+
+	StderrLogLevel   string `yaml:"stderr_log_level"`   // debug (default), info or warn
+	StderrCaptureDir string `yaml:"stderr_capture_dir"` // Empty disables capture
+
+	// ToolPermissions answers the CLI's tool permission requests: approve
+	// (default) or deny. The executor's ask mode isn't offered until the
+	// bot has an approver to route requests to.
+	ToolPermissions string `yaml:"tool_permissions"`
+
+	// Downgrade switches a session to a cheaper model once it has spent a
+	// share of MaxBudgetUSD, resuming the conversation in a new process.
+	Downgrade DowngradeConfig `yaml:"downgrade"`
+}
+
+type DowngradeConfig struct {
+	Threshold float64  `yaml:"threshold"` // Fraction of max_budget_usd; 0 disables
+	Ladder    []string `yaml:"ladder"`    // Models from most to least expensive
+}
+
+// AllowsModel reports whether /model may switch to name.
+func (c ClaudeConfig) AllowsModel(name string) bool {
+	return len(c.AllowedModels) == 0 || slices.Contains(c.AllowedModels, name)
+}
+
+// StderrLevel returns the slog level for subprocess stderr lines.
+// validate guarantees StderrLogLevel is one of the accepted names.
+func (c ClaudeConfig) StderrLevel() slog.Level {
+	switch c.StderrLogLevel {
+	case "info":
+		return slog.LevelInfo
+	case "warn":
+		return slog.LevelWarn
+	default:
+		return slog.LevelDebug
+	}
 }
 
 type WorkspacesConfig struct {
 	BasePath string            `yaml:"base_path"`
 	ChatMap  map[string]string `yaml:"chat_map"`
 	Default  string            `yaml:"default"`
+
+	// PerUserInGroups gives each user in a group chat their own session and
+	// a workspace subdirectory instead of sharing one per chat.
+	PerUserInGroups bool `yaml:"per_user_in_groups"`
+
+	// ListDirectories makes /workspaces include every directory under
+	// BasePath, not just the ones named in the config.
+	ListDirectories bool `yaml:"list_directories"`
+
+	// InitPrompts, keyed by workspace name, are sent as the first turn of
+	// each new session in that workspace.
+	InitPrompts map[string]InitPrompt `yaml:"init_prompts"`
+
+	// AllowedUserIDs restricts workspaces, by name, to these users on top
+	// of telegram.allowed_user_ids. Unlisted workspaces are open to all.
+	AllowedUserIDs map[string][]int64 `yaml:"allowed_user_ids"`
+
+	// QuickActions, keyed by workspace name, are follow-ups offered as a
+	// reply keyboard with each response; tapping one sends its label.
+	QuickActions map[string][]string `yaml:"quick_actions"`
+
+	// AttributeSenders lists workspaces whose group-chat messages reach the
+	// agent prefixed with the sender's name, like "alice: hi", so it can
+	// tell the people sharing a session apart.
+	AttributeSenders []string `yaml:"attribute_senders"`
+}
+
+// Permits reports whether userID may use the named workspace.
+func (c WorkspacesConfig) Permits(name string, userID int64) bool {
+	ids, restricted := c.AllowedUserIDs[name]
+	return !restricted || slices.Contains(ids, userID)
+}
+
+type InitPrompt struct {
+	Prompt  string        `yaml:"prompt"`
+	Show    bool          `yaml:"show"`    // Prepend the reply to the first response; hidden by default
+	Timeout time.Duration `yaml:"timeout"` // Start without it if it takes longer
 }
 
 type MemoryConfig struct {
-	DBPath          string        `yaml:"db_path"`
+	DBPath           string        `yaml:"db_path"`
 	BriefingInterval time.Duration `yaml:"briefing_interval"`
-	HistoryMessages int           `yaml:"history_messages"`
+	HistoryMessages  int           `yaml:"history_messages"`
+
+	// TelegramHistoryMessages is how many recent messages from authorized
+	// users the bot keeps per chat, up to 100, and gives each new session
+	// as context; 0 disables.
+	TelegramHistoryMessages int `yaml:"telegram_history_messages"`
+}
+
+type TranscriptsConfig struct {
+	Dir string `yaml:"dir"` // Empty disables transcripts
+}
+
+type IntegrationsConfig struct {
+	WebhookURL     string        `yaml:"webhook_url"`     // POST each turn's final response here; empty disables
+	WebhookTimeout time.Duration `yaml:"webhook_timeout"` // Per POST; failures are logged and dropped
+}
+
+type UploadsConfig struct {
+	// AllowedExtensions restricts uploaded documents to these lowercase,
+	// dotted extensions (e.g. ".md"). Empty allows every file type.
+	AllowedExtensions []string `yaml:"allowed_extensions"`
+}
+
+// Allows reports whether a document with the given filename may be
+// uploaded. Extensions match case-insensitively.
+func (c UploadsConfig) Allows(filename string) bool {
+	if len(c.AllowedExtensions) == 0 {
+		return true
+	}
+	return slices.Contains(c.AllowedExtensions, strings.ToLower(filepath.Ext(filename)))
 }
 
 func Load(path string) (*Config, error) {
@@ -66,30 +321,261 @@ func Load(path string) (*Config, error) {
 	return &cfg, nil
 }
 
+// laneName matches the command names session.lanes accepts.
+var laneName = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+
+// builtinCommands are the bot's own commands. Commands match "/name" as a
+// prefix with built-ins tried first, so a lane can't start with one.
+var builtinCommands = []string{
+	"new", "status", "whoami", "usage", "readonly", "model", "persona",
+	"workspaces", "raw", "cancel", "timeout", "keep", "settings", "remember",
+	"compact_memory", "log", "debug_last", "run", "reload_identity",
+	"pause_all", "resume_all",
+}
+
 func (c *Config) validate() error {
 	if c.Telegram.BotToken == "" {
 		return fmt.Errorf("telegram.bot_token is required")
 	}
-	if len(c.Telegram.AllowedUserIDs) == 0 {
+	if c.Telegram.APIBaseURL != "" {
+		u, err := url.Parse(c.Telegram.APIBaseURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("telegram.api_base_url must be an absolute http(s) URL, got %q", c.Telegram.APIBaseURL)
+		}
+	}
+	switch c.Telegram.AuthMode {
+	case "":
+		c.Telegram.AuthMode = AuthAllowlist
+	case AuthAllowlist, AuthGroupAdmins:
+	default:
+		return fmt.Errorf("telegram.auth_mode must be allowlist or group_admins, got %q", c.Telegram.AuthMode)
+	}
+	if c.Telegram.AuthMode == AuthAllowlist && len(c.Telegram.AllowedUserIDs) == 0 {
 		return fmt.Errorf("telegram.allowed_user_ids must have at least one entry")
 	}
+	if c.Telegram.AuthMode == AuthGroupAdmins && len(c.Telegram.AdminGroupIDs) == 0 {
+		return fmt.Errorf("telegram.admin_group_ids must have at least one entry when telegram.auth_mode is group_admins")
+	}
+	if i := c.Telegram.Inline; i.Timeout < 0 || i.CacheTTL < 0 {
+		return fmt.Errorf("telegram.inline.timeout and telegram.inline.cache_ttl must not be negative")
+	}
+	if c.Telegram.SendRate < 0 {
+		return fmt.Errorf("telegram.send_rate must not be negative, got %v", c.Telegram.SendRate)
+	}
+	for cmd, audience := range c.Telegram.CommandAccess {
+		if cmd == "" || strings.HasPrefix(cmd, "/") || cmd != strings.ToLower(cmd) {
+			return fmt.Errorf("telegram.command_access keys must be lowercase command names without the slash, got %q", cmd)
+		}
+		switch audience {
+		case AccessAll, AccessAdmins:
+		case AccessOwner:
+			if c.Telegram.OwnerUserID == 0 {
+				return fmt.Errorf("telegram.command_access %q is owner-only but telegram.owner_user_id is not set", cmd)
+			}
+		default:
+			return fmt.Errorf("telegram.command_access %q must be all, admins or owner, got %q", cmd, audience)
+		}
+	}
 	if c.Workspaces.BasePath == "" {
 		return fmt.Errorf("workspaces.base_path is required")
 	}
+	switch c.Claude.StderrLogLevel {
+	case "", "debug", "info", "warn":
+	default:
+		return fmt.Errorf("claude.stderr_log_level must be debug, info or warn, got %q", c.Claude.StderrLogLevel)
+	}
+	switch c.Claude.ToolPermissions {
+	case "", "approve", "deny":
+	default:
+		return fmt.Errorf("claude.tool_permissions must be approve or deny, got %q", c.Claude.ToolPermissions)
+	}
+	if d := c.Claude.Downgrade; d.Threshold != 0 {
+		if d.Threshold < 0 || d.Threshold > 1 {
+			return fmt.Errorf("claude.downgrade.threshold must be between 0 and 1, got %v", d.Threshold)
+		}
+		if c.Claude.MaxBudgetUSD <= 0 {
+			return fmt.Errorf("claude.downgrade requires claude.max_budget_usd")
+		}
+		if len(d.Ladder) < 2 {
+			return fmt.Errorf("claude.downgrade.ladder needs at least two models")
+		}
+	}
+	// Both files are optional, but a directory at either path is a mistake.
+	for name, path := range map[string]string{"claude.soul_path": c.Claude.SoulPath, "claude.memory_path": c.Claude.MemoryPath} {
+		if info, err := os.Stat(path); path != "" && err == nil && info.IsDir() {
+			return fmt.Errorf("%s must be a file, got directory %s", name, path)
+		}
+	}
+	c.Session.RedactPatterns = nil
+	for _, expr := range c.Session.Redactions {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return fmt.Errorf("session.redactions: %w", err)
+		}
+		c.Session.RedactPatterns = append(c.Session.RedactPatterns, re)
+	}
+	c.Session.PreamblePatterns = nil
+	for _, expr := range c.Session.TrimPreambles {
+		re, err := regexp.Compile(`\A(?:` + expr + `)\s*`)
+		if err != nil {
+			return fmt.Errorf("session.trim_preambles: %w", err)
+		}
+		c.Session.PreamblePatterns = append(c.Session.PreamblePatterns, re)
+	}
+	if c.Memory.TelegramHistoryMessages < 0 || c.Memory.TelegramHistoryMessages > 100 {
+		return fmt.Errorf("memory.telegram_history_messages must be between 0 and 100, got %d", c.Memory.TelegramHistoryMessages)
+	}
+	if c.Session.InactivityTimeout < 0 {
+		return fmt.Errorf("session.inactivity_timeout must not be negative, got %v", c.Session.InactivityTimeout)
+	}
+	if c.Session.ParkAfter < 0 || c.Session.ReapAfter < 0 {
+		return fmt.Errorf("session.park_after and session.reap_after must not be negative")
+	}
+	if c.Session.KeepLimit < 0 {
+		return fmt.Errorf("session.keep_limit must not be negative, got %v", c.Session.KeepLimit)
+	}
+	for name, model := range c.Session.Lanes {
+		if !laneName.MatchString(name) {
+			return fmt.Errorf("session.lanes name %q must be 1-32 lowercase letters, digits or underscores", name)
+		}
+		if model == "" {
+			return fmt.Errorf("session.lanes.%s needs a model", name)
+		}
+		for _, cmd := range builtinCommands {
+			if strings.HasPrefix(name, cmd) {
+				return fmt.Errorf("session.lanes name %q clashes with the built-in /%s command", name, cmd)
+			}
+		}
+		for other := range c.Session.Lanes {
+			if other != name && strings.HasPrefix(name, other) {
+				return fmt.Errorf("session.lanes name %q starts with lane %q, which would answer it", name, other)
+			}
+		}
+	}
+	if c.Session.IdleNudge < 0 {
+		return fmt.Errorf("session.idle_nudge must not be negative, got %v", c.Session.IdleNudge)
+	}
+	if c.Session.ReadyTimeout < 0 {
+		return fmt.Errorf("session.ready_timeout must not be negative, got %v", c.Session.ReadyTimeout)
+	}
+	if r := c.Session.AutoRetry; r.Attempts < 0 || r.Backoff < 0 {
+		return fmt.Errorf("session.auto_retry.attempts and session.auto_retry.backoff must not be negative")
+	}
+	for ws, actions := range c.Workspaces.QuickActions {
+		if slices.Contains(actions, "") {
+			return fmt.Errorf("workspaces.quick_actions.%s has an empty label", ws)
+		}
+	}
+	if c.Session.FlushTimeout < 0 {
+		return fmt.Errorf("session.flush_timeout must not be negative, got %v", c.Session.FlushTimeout)
+	}
+	if c.Claude.Model != "" && !c.Claude.AllowsModel(c.Claude.Model) {
+		return fmt.Errorf("claude.model %q is not in claude.allowed_models", c.Claude.Model)
+	}
+	if c.Claude.ThinkingBudgetTokens < 0 {
+		return fmt.Errorf("claude.thinking_budget_tokens must not be negative, got %d", c.Claude.ThinkingBudgetTokens)
+	}
+	if c.Claude.MaxIdentityChars < 0 {
+		return fmt.Errorf("claude.max_identity_chars must not be negative, got %d", c.Claude.MaxIdentityChars)
+	}
+	if c.Session.MaxConcurrentTurns < 0 {
+		return fmt.Errorf("session.max_concurrent_turns must not be negative, got %d", c.Session.MaxConcurrentTurns)
+	}
+	if c.Session.MaxEditsPerTurn < 0 {
+		return fmt.Errorf("session.max_edits_per_turn must not be negative, got %d", c.Session.MaxEditsPerTurn)
+	}
+	if c.Session.ToolOutputLimit < 0 || c.Session.ToolOutputLimit > 3500 {
+		return fmt.Errorf("session.tool_output_limit must be between 0 and 3500, got %d", c.Session.ToolOutputLimit)
+	}
+	if c.Session.MaxInputChars < 0 {
+		return fmt.Errorf("session.max_input_chars must not be negative, got %d", c.Session.MaxInputChars)
+	}
+	if c.Integrations.WebhookURL != "" {
+		u, err := url.Parse(c.Integrations.WebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("integrations.webhook_url must be an absolute http(s) URL, got %q", c.Integrations.WebhookURL)
+		}
+	}
+	if c.Integrations.WebhookTimeout < 0 {
+		return fmt.Errorf("integrations.webhook_timeout must not be negative, got %v", c.Integrations.WebhookTimeout)
+	}
+	if c.Workspaces.Default == "" {
+		c.Workspaces.Default = "home"
+	}
+	if _, ok := c.Workspaces.AllowedUserIDs[c.Workspaces.Default]; ok {
+		// Users denied a workspace fall back to the default one.
+		return fmt.Errorf("workspaces.allowed_user_ids must not restrict the default workspace %q", c.Workspaces.Default)
+	}
+	for ws, init := range c.Workspaces.InitPrompts {
+		if init.Timeout < 0 {
+			return fmt.Errorf("workspaces.init_prompts %q timeout must not be negative, got %v", ws, init.Timeout)
+		}
+		if init.Timeout == 0 {
+			init.Timeout = 2 * time.Minute
+			c.Workspaces.InitPrompts[ws] = init
+		}
+	}
+	for _, ext := range c.Uploads.AllowedExtensions {
+		if len(ext) < 2 || ext[0] != '.' || ext != strings.ToLower(ext) || strings.Count(ext, ".") != 1 {
+			return fmt.Errorf("uploads.allowed_extensions entries must be lowercase and dotted like \".md\", got %q", ext)
+		}
+	}
 
 	// Apply defaults
+	if c.Session.ReapAfter > 0 {
+		c.Session.InactivityTimeout = c.Session.ReapAfter
+	}
+	if c.Session.KeepLimit == 0 {
+		c.Session.KeepLimit = 8 * time.Hour
+	}
+	if c.Session.ParkAfter > 0 && c.Session.InactivityTimeout > 0 && c.Session.ParkAfter >= c.Session.InactivityTimeout {
+		return fmt.Errorf("session.park_after (%v) must be shorter than the reap timeout (%v)", c.Session.ParkAfter, c.Session.InactivityTimeout)
+	}
+	if c.Session.AutoRetry.Attempts > 0 && c.Session.AutoRetry.Backoff == 0 {
+		c.Session.AutoRetry.Backoff = 5 * time.Second
+	}
+	if c.Session.FlushTimeout == 0 {
+		c.Session.FlushTimeout = 5 * time.Second
+	}
+	if c.Session.TurnContext.Header == "" {
+		c.Session.TurnContext.Header = "[Workspace: {workspace}, working directory {dir}]"
+	}
+	if c.Integrations.WebhookTimeout == 0 {
+		c.Integrations.WebhookTimeout = 5 * time.Second
+	}
+	if c.Session.EmptyResponse == "" {
+		c.Session.EmptyResponse = "Done — no text output."
+	}
 	if c.Session.MaxResponseLength == 0 {
 		c.Session.MaxResponseLength = 4096
 	}
 	if c.Session.EditInterval == 0 {
 		c.Session.EditInterval = 2 * time.Second
 	}
+	if c.Session.CrashLimit == 0 {
+		c.Session.CrashLimit = 3
+	}
+	if c.Session.CrashWindow == 0 {
+		c.Session.CrashWindow = 5 * time.Minute
+	}
+	if c.Telegram.Inline.Timeout == 0 {
+		c.Telegram.Inline.Timeout = 8 * time.Second
+	}
+	if c.Telegram.Inline.CacheTTL == 0 {
+		c.Telegram.Inline.CacheTTL = 10 * time.Minute
+	}
+	if c.Telegram.AdminCacheTTL == 0 {
+		c.Telegram.AdminCacheTTL = 5 * time.Minute
+	}
+	if c.Telegram.Locale == "" {
+		c.Telegram.Locale = "en"
+	}
+	if c.Telegram.ReconnectMaxBackoff == 0 {
+		c.Telegram.ReconnectMaxBackoff = time.Minute
+	}
 	if c.Claude.Model == "" {
 		c.Claude.Model = "sonnet"
 	}
-	if c.Workspaces.Default == "" {
-		c.Workspaces.Default = "home"
-	}
 	if c.Claude.SoulPath == "" {
 		if home, err := os.UserHomeDir(); err == nil {
 			c.Claude.SoulPath = home + "/.natron/soul.md"
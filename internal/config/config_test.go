@@ -0,0 +1,61 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// minimal returns the smallest config that validates.
+func minimal(t *testing.T) Config {
+	return Config{
+		Telegram:   TelegramConfig{BotToken: "token", AllowedUserIDs: []int64{1}},
+		Workspaces: WorkspacesConfig{BasePath: t.TempDir()},
+	}
+}
+
+func TestValidate_DefaultWorkspaceNotRestricted(t *testing.T) {
+	tests := []struct {
+		name       string
+		defaultWS  string
+		restricted string
+		wantErr    bool
+	}{
+		{"restricted home, default unset", "", "home", true},
+		{"restricted explicit default", "work", "work", true},
+		{"restricted other workspace", "", "work", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := minimal(t)
+			c.Workspaces.Default = tt.defaultWS
+			c.Workspaces.AllowedUserIDs = map[string][]int64{tt.restricted: {1}}
+			err := c.validate()
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "must not restrict the default workspace") {
+					t.Errorf("validate = %v, want the default workspace rejected", err)
+				}
+			} else if err != nil {
+				t.Errorf("validate: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidate_InactivityTimeoutOffByDefault(t *testing.T) {
+	c := minimal(t)
+	c.Session.ParkAfter = time.Hour
+	if err := c.validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	if c.Session.InactivityTimeout != 0 {
+		t.Errorf("InactivityTimeout = %v, want 0 so sessions never expire unless configured", c.Session.InactivityTimeout)
+	}
+
+	c = minimal(t)
+	c.Session.InactivityTimeout = 10 * time.Minute
+	c.Session.ParkAfter = time.Hour
+	if err := c.validate(); err == nil {
+		t.Error("validate accepted park_after longer than inactivity_timeout")
+	}
+}
@@ -0,0 +1,72 @@
+package claude
+
+import "sync"
+
+// activitySize bounds how many lines of recent activity are kept.
+const activitySize = 200
+
+// activityLog is a fixed-size ring of recent stderr and tool lines. The
+// zero value is ready to use.
+type activityLog struct {
+	mu    sync.Mutex
+	lines [activitySize]string
+	next  int // index the next line is written to
+	count int
+}
+
+func (a *activityLog) add(line string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.lines[a.next] = line
+	a.next = (a.next + 1) % activitySize
+	if a.count < activitySize {
+		a.count++
+	}
+}
+
+// last returns up to n of the newest lines, oldest first.
+func (a *activityLog) last(n int) []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if n > a.count {
+		n = a.count
+	}
+	out := make([]string, 0, n)
+	for i := n; i > 0; i-- {
+		out = append(out, a.lines[(a.next-i+activitySize)%activitySize])
+	}
+	return out
+}
+
+// rawTurnLines bounds how many raw output lines of a turn are kept.
+const rawTurnLines = 100
+
+// rawTurn holds the newest raw NDJSON lines since the last turn started,
+// for debugging the stream parser. The zero value is ready to use.
+type rawTurn struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (r *rawTurn) add(line string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.lines) == rawTurnLines {
+		r.lines = append(r.lines[:0], r.lines[1:]...)
+	}
+	r.lines = append(r.lines, line)
+}
+
+// reset drops the previous turn's lines.
+func (r *rawTurn) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lines = nil
+}
+
+// snapshot returns a copy of the lines, oldest first.
+func (r *rawTurn) snapshot() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.lines...)
+}
@@ -0,0 +1,47 @@
+package claude
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestActivityLog(t *testing.T) {
+	var a activityLog
+	if got := a.last(5); len(got) != 0 {
+		t.Fatalf("empty log returned %q", got)
+	}
+
+	a.add("first")
+	a.add("second")
+	if got := a.last(5); !slices.Equal(got, []string{"first", "second"}) {
+		t.Errorf("last(5) = %q, want both lines oldest first", got)
+	}
+
+	for i := range activitySize {
+		a.add(fmt.Sprintf("line %d", i))
+	}
+	got := a.last(2)
+	want := []string{fmt.Sprintf("line %d", activitySize-2), fmt.Sprintf("line %d", activitySize-1)}
+	if !slices.Equal(got, want) {
+		t.Errorf("after wrapping, last(2) = %q, want %q", got, want)
+	}
+	if n := len(a.last(activitySize + 10)); n != activitySize {
+		t.Errorf("last beyond capacity returned %d lines, want %d", n, activitySize)
+	}
+}
+
+func TestRawTurn_Bounded(t *testing.T) {
+	var r rawTurn
+	for i := range rawTurnLines + 5 {
+		r.add(fmt.Sprintf("line %d", i))
+	}
+	got := r.snapshot()
+	if len(got) != rawTurnLines || got[0] != "line 5" || got[len(got)-1] != fmt.Sprintf("line %d", rawTurnLines+4) {
+		t.Errorf("snapshot kept %d lines from %q to %q, want the newest %d", len(got), got[0], got[len(got)-1], rawTurnLines)
+	}
+	r.reset()
+	if got := r.snapshot(); len(got) != 0 {
+		t.Errorf("after reset, snapshot = %q", got)
+	}
+}
@@ -2,51 +2,178 @@ package claude
 
 import (
 	"bufio"
+	"cmp"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/zette-dev/natron/internal/executor"
+	"github.com/zette-dev/natron/internal/reqid"
 )
 
 const (
 	shutdownTimeout = 5 * time.Second
 	scanBufSize     = 1024 * 1024 // 1MB max line length for NDJSON
+
+	maxActivityInput = 200 // Bytes of tool input kept per activity line
 )
 
 // Executor spawns and manages a persistent Claude Code CLI subprocess
 // using the stream-json protocol for bidirectional communication.
 type Executor struct {
-	model string
+	bin         string // CLI to run; "claude" unless a test swaps it
+	model       string
+	stderrLevel slog.Level
+	captureDir  string
+	thinking    int // Extended thinking budget in tokens; 0 leaves it off
+	permissions PermissionMode
+	approve     ToolApprover
+	approveWait time.Duration // Longest wait for approve before refusing
 
 	mu        sync.Mutex
 	cmd       *exec.Cmd
+	ctx       context.Context // The process's context, for approvers
+	workDir   string          // From the last Start, for Restart
+	exited    chan struct{}   // Closed once the read loop ends; replaced per Start
 	stdin     io.WriteCloser
 	cancel    context.CancelFunc
 	alive     bool
 	sessionID string
+	requests  int           // control request counter
+	ready     chan struct{} // closed on system/init; replaced per Start
+
+	// writeMu serializes stdin writes, since Interrupt may race a Send.
+	writeMu sync.Mutex
+
+	// activity keeps recent stderr and tool lines for RecentActivity. It
+	// spans restarts, so a crash's last words stay visible.
+	activity activityLog
+
+	// raw keeps the current turn's stdout lines for LastTurnRaw.
+	raw rawTurn
+
+	// authFailed is set when stderr or the stream reports an authentication
+	// problem, so the failure can be surfaced as ErrNotAuthenticated.
+	authFailed bool
+
+	// modelFailed is set when the CLI rejects startModel, the model the
+	// current process was started with. exitErr keeps the resulting
+	// UnknownModelError once the process has exited, for Send to return.
+	modelFailed bool
+	startModel  string
+	exitErr     error
 
 	// respCh is set by Send() and consumed by the reader goroutine.
 	// Only one response can be in flight at a time (enforced by
 	// the session manager's per-chat lock).
 	respMu sync.Mutex
 	respCh chan<- executor.Event
+	respID string // request ID of the in-flight turn, for log correlation
+
+	// Tool calls being assembled from stream events, keyed on content
+	// block index, and the IDs of those already emitted so the complete
+	// assistant message doesn't repeat them. Owned by the read loop and
+	// reset when a turn ends.
+	partialTools  map[int]*partialTool
+	streamedTools map[string]bool
+
+	// toolNames maps the turn's tool call IDs to tool names, so results,
+	// which only carry the ID, can say which tool produced them. Owned by
+	// the read loop and reset when a turn ends.
+	toolNames map[string]string
+
+	// reportedUSD is the process's cumulative cost as of the last result,
+	// for deriving each turn's cost. Reset by Start.
+	reportedUSD float64
+}
+
+// partialTool is a tool_use block whose input is still streaming in.
+type partialTool struct {
+	id    string
+	name  string
+	input strings.Builder
+}
+
+// Option configures an Executor.
+type Option func(*Executor)
+
+// WithStderrLevel sets the level subprocess stderr lines are logged at.
+// The default is debug.
+func WithStderrLevel(level slog.Level) Option {
+	return func(e *Executor) { e.stderrLevel = level }
+}
+
+// WithStderrCapture additionally writes each subprocess's stderr to its own
+// file under dir for post-mortem inspection.
+func WithStderrCapture(dir string) Option {
+	return func(e *Executor) { e.captureDir = dir }
+}
+
+// WithThinkingBudget enables extended thinking with a budget of tokens
+// per turn. 0 leaves thinking off.
+func WithThinkingBudget(tokens int) Option {
+	return func(e *Executor) { e.thinking = tokens }
 }
 
 // New creates a Claude Code executor with the given model.
-func New(model string) *Executor {
-	return &Executor{model: model}
+func New(model string, opts ...Option) *Executor {
+	e := &Executor{bin: "claude", model: model, stderrLevel: slog.LevelDebug, approveWait: approvalTimeout}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
 }
 
 func (e *Executor) Name() string { return "claude" }
 
+// SessionID returns the Claude session ID reported at startup.
+func (e *Executor) SessionID() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.sessionID
+}
+
+// Capabilities reports what this executor implements. The CLI itself can
+// take images, but Send only carries text. Tool approval needs
+// PermissionAsk mode with an approver.
+func (e *Executor) Capabilities() executor.ExecutorCapabilities {
+	return executor.ExecutorCapabilities{
+		SupportsInterrupt:    true,
+		SupportsResume:       true,
+		SupportsToolApproval: e.permissions == PermissionAsk && e.approve != nil,
+	}
+}
+
+// RecentActivity returns up to n recent stderr and tool lines.
+func (e *Executor) RecentActivity(n int) []string {
+	return e.activity.last(n)
+}
+
+// LastTurnRaw returns the newest stdout lines since the last Send, oldest
+// first.
+func (e *Executor) LastTurnRaw() []string {
+	return e.raw.snapshot()
+}
+
+// Ready returns a channel closed once the current process reports
+// system/init. Before the first Start it is nil and never closes.
+func (e *Executor) Ready() <-chan struct{} {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.ready
+}
+
 func (e *Executor) Alive() bool {
 	e.mu.Lock()
 	defer e.mu.Unlock()
@@ -64,19 +191,13 @@ func (e *Executor) Start(ctx context.Context, workDir string, sessionCtx executo
 
 	procCtx, cancel := context.WithCancel(ctx)
 	e.cancel = cancel
+	e.ctx = procCtx
+	e.ready = make(chan struct{})
+	e.reportedUSD = 0
+	e.startModel = cmp.Or(sessionCtx.Model, e.model)
+	e.modelFailed, e.exitErr = false, nil
 
-	args := []string{
-		"--print",
-		"--input-format", "stream-json",
-		"--output-format", "stream-json",
-		"--verbose",
-		"--model", e.model,
-	}
-	if sessionCtx.IdentityDoc != "" {
-		args = append(args, "--append-system-prompt", sessionCtx.IdentityDoc)
-	}
-
-	e.cmd = exec.CommandContext(procCtx, "claude", args...)
+	e.cmd = exec.CommandContext(procCtx, e.bin, e.buildArgs(sessionCtx)...)
 	e.cmd.Dir = workDir
 	e.cmd.Env = append(os.Environ(), "TERM=dumb")
 
@@ -105,13 +226,88 @@ func (e *Executor) Start(ctx context.Context, workDir string, sessionCtx executo
 	}
 
 	e.alive = true
+	e.workDir = workDir
+	exited := make(chan struct{})
+	e.exited = exited
 
-	go e.drainStderr(stderr)
-	go e.readLoop(stdout)
+	go e.drainStderr(stderr, e.openCapture())
+	go func() {
+		e.readLoop(stdout)
+		close(exited)
+	}()
 
 	return nil
 }
 
+// Restart replaces the subprocess with a fresh one started with
+// sessionCtx, resuming the current conversation so its context carries
+// over. It runs in the directory given to Start.
+func (e *Executor) Restart(ctx context.Context, sessionCtx executor.SessionContext) error {
+	e.mu.Lock()
+	workDir, exited, id := e.workDir, e.exited, e.sessionID
+	e.mu.Unlock()
+	if exited == nil {
+		return errors.New("restart before start")
+	}
+
+	if err := e.Stop(); err != nil {
+		return fmt.Errorf("stop for restart: %w", err)
+	}
+	// The old read loop marks the executor dead when it ends, so it must
+	// finish before the new process starts.
+	<-exited
+	if id != "" {
+		sessionCtx.ResumeID = id
+	}
+	return e.Start(ctx, workDir, sessionCtx)
+}
+
+// buildArgs returns the claude CLI arguments for a session.
+func (e *Executor) buildArgs(sessionCtx executor.SessionContext) []string {
+	model := cmp.Or(sessionCtx.Model, e.model)
+	args := []string{
+		"--print",
+		"--input-format", "stream-json",
+		"--output-format", "stream-json",
+		"--verbose",
+		"--include-partial-messages", // streams tool input as it's generated
+		"--model", model,
+	}
+	if sessionCtx.ResumeID != "" {
+		args = append(args, "--resume", sessionCtx.ResumeID)
+	}
+	if prompt := systemPrompt(sessionCtx); prompt != "" {
+		args = append(args, "--append-system-prompt", prompt)
+	}
+	if e.thinking > 0 {
+		args = append(args, "--max-thinking-tokens", strconv.Itoa(e.thinking))
+	}
+	if e.prompted() {
+		args = append(args, "--permission-prompt-tool", "stdio")
+	}
+	if sessionCtx.ReadOnly {
+		// An empty tool list disables all built-in tools.
+		args = append(args, "--tools", "")
+	}
+	return args
+}
+
+// systemPrompt composes the identity doc with the workspace brief and the
+// chat's recent messages.
+func systemPrompt(sessionCtx executor.SessionContext) string {
+	var parts []string
+	if sessionCtx.IdentityDoc != "" {
+		parts = append(parts, sessionCtx.IdentityDoc)
+	}
+	if sessionCtx.WorkspaceInfo != "" {
+		parts = append(parts, "---\n\n## Project Brief\n\n"+sessionCtx.WorkspaceInfo)
+	}
+	if sessionCtx.RecentHistory != "" {
+		parts = append(parts, "---\n\n## Recent Chat History\n\n"+sessionCtx.RecentHistory)
+	}
+	return strings.Join(parts, "\n\n")
+}
+
 // Send writes a user message to the Claude subprocess and returns a channel
 // of streaming events. The channel closes when the response is complete.
 //
@@ -120,7 +316,11 @@ func (e *Executor) Start(ctx context.Context, workDir string, sessionCtx executo
 func (e *Executor) Send(ctx context.Context, message string) (<-chan executor.Event, error) {
 	e.mu.Lock()
 	if !e.alive {
+		exitErr := e.exitErr
 		e.mu.Unlock()
+		if exitErr != nil {
+			return nil, fmt.Errorf("executor not running: %w", exitErr)
+		}
 		return nil, fmt.Errorf("executor not running")
 	}
 	stdin := e.stdin
@@ -145,9 +345,11 @@ func (e *Executor) Send(ctx context.Context, message string) (<-chan executor.Ev
 	ch := make(chan executor.Event, 64)
 	e.respMu.Lock()
 	e.respCh = ch
+	e.respID = reqid.FromContext(ctx)
 	e.respMu.Unlock()
+	e.raw.reset()
 
-	if _, err := stdin.Write(data); err != nil {
+	if err := e.write(stdin, data); err != nil {
 		e.respMu.Lock()
 		e.respCh = nil
 		e.respMu.Unlock()
@@ -172,6 +374,41 @@ func (e *Executor) Send(ctx context.Context, message string) (<-chan executor.Ev
 	return out, nil
 }
 
+// Interrupt asks Claude to abort the current turn via a control request.
+// The turn ends with a result message, so the response channel closes as
+// usual and the conversation continues in the same process.
+func (e *Executor) Interrupt() error {
+	e.mu.Lock()
+	if !e.alive {
+		e.mu.Unlock()
+		return fmt.Errorf("executor not running")
+	}
+	stdin := e.stdin
+	e.requests++
+	id := fmt.Sprintf("interrupt-%d", e.requests)
+	e.mu.Unlock()
+
+	data, err := json.Marshal(controlRequest{
+		Type:      "control_request",
+		RequestID: id,
+		Request:   controlRequestBody{Subtype: "interrupt"},
+	})
+	if err != nil {
+		return fmt.Errorf("marshal interrupt: %w", err)
+	}
+	if err := e.write(stdin, append(data, '\n')); err != nil {
+		return fmt.Errorf("write interrupt: %w", err)
+	}
+	return nil
+}
+
+func (e *Executor) write(stdin io.Writer, data []byte) error {
+	e.writeMu.Lock()
+	defer e.writeMu.Unlock()
+	_, err := stdin.Write(data)
+	return err
+}
+
 // Stop gracefully shuts down the Claude subprocess.
 func (e *Executor) Stop() error {
 	e.mu.Lock()
@@ -211,7 +448,16 @@ func (e *Executor) Stop() error {
 	return nil
 }
 
-var _ executor.Executor = (*Executor)(nil)
+var (
+	_ executor.Executor           = (*Executor)(nil)
+	_ executor.Interrupter        = (*Executor)(nil)
+	_ executor.Resumer            = (*Executor)(nil)
+	_ executor.ActivityLogger     = (*Executor)(nil)
+	_ executor.CapabilityReporter = (*Executor)(nil)
+	_ executor.Readier            = (*Executor)(nil)
+	_ executor.Restarter          = (*Executor)(nil)
+	_ executor.RawRecorder        = (*Executor)(nil)
+)
 
 // readLoop is the single goroutine that reads all NDJSON from stdout
 // and dispatches events to the current response channel.
@@ -224,9 +470,13 @@ func (e *Executor) readLoop(stdout io.Reader) {
 		if len(line) == 0 {
 			continue
 		}
+		e.raw.add(string(line))
 
 		evt, done := e.parseLine(line)
 		if evt != nil {
+			if evt.Type == executor.EventToolUse {
+				e.activity.add(toolActivity(time.Now(), *evt))
+			}
 			e.dispatch(*evt)
 		}
 		if done {
@@ -238,6 +488,20 @@ func (e *Executor) readLoop(stdout io.Reader) {
 		e.dispatch(executor.Event{Type: executor.EventError, Error: fmt.Errorf("read stdout: %w", err)})
 	}
 
+	e.mu.Lock()
+	authFailed := e.authFailed
+	if e.modelFailed && !authFailed {
+		e.exitErr = &executor.UnknownModelError{Model: e.startModel}
+	}
+	exitErr := e.exitErr
+	e.mu.Unlock()
+	switch {
+	case authFailed:
+		e.dispatch(executor.Event{Type: executor.EventError, Error: executor.ErrNotAuthenticated})
+	case exitErr != nil:
+		e.dispatch(executor.Event{Type: executor.EventError, Error: exitErr})
+	}
+
 	// Process exited — close any pending response channel
 	e.closeResp()
 
@@ -248,6 +512,19 @@ func (e *Executor) readLoop(stdout io.Reader) {
 	slog.Info("claude process exited")
 }
 
+// toolActivity formats a tool call for the activity log, with its input
+// cut to maxActivityInput bytes.
+func toolActivity(now time.Time, evt executor.Event) string {
+	line := now.Format("15:04:05") + " tool " + evt.Tool
+	if input := evt.ToolInput; input != "" && input != "{}" {
+		if len(input) > maxActivityInput {
+			input = strings.ToValidUTF8(input[:maxActivityInput], "") + "..."
+		}
+		line += " " + input
+	}
+	return line
+}
+
 func (e *Executor) dispatch(evt executor.Event) {
 	e.respMu.Lock()
 	ch := e.respCh
@@ -263,16 +540,25 @@ func (e *Executor) closeResp() {
 	if e.respCh != nil {
 		close(e.respCh)
 		e.respCh = nil
+		e.respID = ""
 	}
 	e.respMu.Unlock()
 }
 
+// requestID returns the request ID of the in-flight turn, or "" between
+// turns.
+func (e *Executor) requestID() string {
+	e.respMu.Lock()
+	defer e.respMu.Unlock()
+	return e.respID
+}
+
 // parseLine parses a single NDJSON line from Claude's stdout.
 // Returns an event (or nil) and whether this line signals end of response.
 func (e *Executor) parseLine(line []byte) (*executor.Event, bool) {
 	var msg streamMessage
 	if err := json.Unmarshal(line, &msg); err != nil {
-		slog.Warn("unparseable NDJSON line", "error", err, "line", string(line))
+		slog.Warn("unparseable NDJSON line", "request_id", e.requestID(), "error", err, "line", string(line))
 		return nil, false
 	}
 
@@ -282,35 +568,286 @@ func (e *Executor) parseLine(line []byte) (*executor.Event, bool) {
 		return nil, false
 
 	case "assistant":
+		if msg.Error == "authentication_failed" {
+			e.markAuthFailed()
+		}
+		// Whitespace-only blocks would produce edits that look empty.
+		// Non-blank text keeps its surrounding whitespace intact.
 		text := extractText(msg.Message)
-		if text != "" {
+		if strings.TrimSpace(text) != "" {
 			return &executor.Event{Type: executor.EventText, Text: text}, false
 		}
+		// The CLI emits one content block per assistant message, so a
+		// message without text is typically a lone tool call. One already
+		// assembled from stream events was emitted then.
+		if tool, ok := extractTool(msg.Message); ok && !e.streamedTools[tool.ID] {
+			e.nameTool(tool.ID, tool.Name)
+			return &executor.Event{Type: executor.EventToolUse, Tool: tool.Name, ToolInput: string(tool.Input)}, false
+		}
+		return nil, false
+
+	case "user":
+		// The CLI echoes each tool's output back as a user message.
+		if result, ok := extractToolResult(msg.Message); ok {
+			return &executor.Event{Type: executor.EventToolResult, Tool: e.toolNames[result.ToolUseID], Text: toolResultText(result.Content)}, false
+		}
+		return nil, false
+
+	case "stream_event":
+		return e.handleStreamEvent(msg.Event), false
+
+	case "control_request":
+		e.handleControlRequest(msg)
 		return nil, false
 
 	case "result":
+		e.partialTools, e.streamedTools, e.toolNames = nil, nil, nil
+		if msg.IsError && (isAuthFailure(string(msg.Result)) || e.takeAuthFailed()) {
+			return &executor.Event{Type: executor.EventError, Error: executor.ErrNotAuthenticated}, true
+		}
+		if msg.IsError && isModelError(string(msg.Result)) {
+			e.mu.Lock()
+			model := e.startModel
+			e.mu.Unlock()
+			return &executor.Event{Type: executor.EventError, Error: &executor.UnknownModelError{Model: model}}, true
+		}
 		text := extractText(msg.Result)
-		return &executor.Event{Type: executor.EventDone, Text: text}, true
+		if msg.IsError && isTransientError(msg.Subtype+" "+msg.Error+" "+string(msg.Result)) {
+			return &executor.Event{Type: executor.EventError, Error: fmt.Errorf("%w: %s", executor.ErrTransient, msg.Result)}, true
+		}
+		done := &executor.Event{Type: executor.EventDone, Text: text, CostUSD: msg.TotalCostUSD}
+		if msg.TotalCostUSD > e.reportedUSD {
+			done.TurnCostUSD = msg.TotalCostUSD - e.reportedUSD
+			e.reportedUSD = msg.TotalCostUSD
+		}
+		if u := msg.Usage; u != nil {
+			done.InputTokens = u.InputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens
+			done.OutputTokens = u.OutputTokens
+		}
+		return done, true
 
 	default:
 		return nil, false
 	}
 }
 
+// handleStreamEvent assembles tool calls from partial stream events: a
+// tool_use block starts, its input arrives as input_json_delta fragments,
+// and once the block stops the call is returned as an EventToolUse with
+// its full arguments. Other stream events are ignored; text is taken from
+// the complete assistant messages.
+func (e *Executor) handleStreamEvent(raw json.RawMessage) *executor.Event {
+	var evt streamEvent
+	if err := json.Unmarshal(raw, &evt); err != nil {
+		return nil
+	}
+
+	switch evt.Type {
+	case "content_block_start":
+		if evt.ContentBlock == nil || evt.ContentBlock.Type != "tool_use" {
+			return nil
+		}
+		if e.partialTools == nil {
+			e.partialTools = make(map[int]*partialTool)
+		}
+		e.partialTools[evt.Index] = &partialTool{id: evt.ContentBlock.ID, name: evt.ContentBlock.Name}
+
+	case "content_block_delta":
+		tool := e.partialTools[evt.Index]
+		if tool != nil && evt.Delta != nil && evt.Delta.Type == "input_json_delta" {
+			tool.input.WriteString(evt.Delta.PartialJSON)
+		}
+
+	case "content_block_stop":
+		tool := e.partialTools[evt.Index]
+		if tool == nil {
+			return nil
+		}
+		delete(e.partialTools, evt.Index)
+
+		input := tool.input.String()
+		if input == "" {
+			input = "{}"
+		}
+		if !json.Valid([]byte(input)) {
+			slog.Debug("malformed streamed tool input", "request_id", e.requestID(), "tool", tool.name)
+			input = ""
+		}
+		if tool.id != "" {
+			if e.streamedTools == nil {
+				e.streamedTools = make(map[string]bool)
+			}
+			e.streamedTools[tool.id] = true
+			e.nameTool(tool.id, tool.name)
+		}
+		return &executor.Event{Type: executor.EventToolUse, Tool: tool.name, ToolInput: input}
+	}
+	return nil
+}
+
+// nameTool records a tool call's name for its result.
+func (e *Executor) nameTool(id, name string) {
+	if id == "" {
+		return
+	}
+	if e.toolNames == nil {
+		e.toolNames = make(map[string]string)
+	}
+	e.toolNames[id] = name
+}
+
 func (e *Executor) handleSystem(msg streamMessage) {
 	if msg.Subtype == "init" && msg.SessionID != "" {
 		e.mu.Lock()
 		e.sessionID = msg.SessionID
+		if e.ready != nil {
+			select {
+			case <-e.ready: // a resumed process can report init again
+			default:
+				close(e.ready)
+			}
+		}
 		e.mu.Unlock()
 		slog.Info("claude session initialized", "session_id", msg.SessionID)
 	}
 }
 
-func (e *Executor) drainStderr(stderr io.Reader) {
+// drainStderr logs subprocess stderr line by line, copying it to capture
+// when non-nil.
+func (e *Executor) drainStderr(stderr io.Reader, capture io.WriteCloser) {
+	if capture != nil {
+		defer capture.Close()
+	}
+
 	scanner := bufio.NewScanner(stderr)
 	for scanner.Scan() {
-		slog.Debug("claude stderr", "line", scanner.Text())
+		if isAuthFailure(scanner.Text()) {
+			e.markAuthFailed()
+		}
+		if isModelError(scanner.Text()) {
+			e.mu.Lock()
+			e.modelFailed = true
+			e.mu.Unlock()
+		}
+		slog.Log(context.Background(), e.stderrLevel, "claude stderr", "request_id", e.requestID(), "line", scanner.Text())
+		e.activity.add(time.Now().Format("15:04:05") + " " + scanner.Text())
+		if capture != nil {
+			fmt.Fprintln(capture, scanner.Text())
+		}
+	}
+}
+
+func (e *Executor) markAuthFailed() {
+	e.mu.Lock()
+	e.authFailed = true
+	e.mu.Unlock()
+}
+
+// takeAuthFailed reports and clears the auth failure flag, so a failure
+// that's been surfaced in a result isn't reported again at exit.
+func (e *Executor) takeAuthFailed() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	failed := e.authFailed
+	e.authFailed = false
+	return failed
+}
+
+// authSignals are lowercase fragments of the messages the claude CLI and
+// the API produce when credentials are missing or rejected. Error type
+// identifiers come first since they're more stable than prose.
+var authSignals = []string{
+	"authentication_error",
+	"authentication_failed",
+	"invalid api key",
+	"oauth token has expired",
+	"please run /login",
+	"claude login",
+	"not logged in",
+}
+
+// isAuthFailure reports whether s looks like an authentication failure.
+func isAuthFailure(s string) bool {
+	s = strings.ToLower(s)
+	for _, sig := range authSignals {
+		if strings.Contains(s, sig) {
+			return true
+		}
+	}
+	return false
+}
+
+// modelSignals are lowercase fragments of the messages the claude CLI and
+// the API produce when the requested model doesn't exist or isn't
+// available to the account.
+var modelSignals = []string{
+	"issue with the selected model",
+	"invalid model",
+	"model not found",
+	"unknown model",
+}
+
+// isModelError reports whether s looks like the model being rejected. The
+// API's not_found_error only counts when it names the model.
+func isModelError(s string) bool {
+	s = strings.ToLower(s)
+	if strings.Contains(s, "not_found_error") && strings.Contains(s, "model") {
+		return true
+	}
+	for _, sig := range modelSignals {
+		if strings.Contains(s, sig) {
+			return true
+		}
+	}
+	return false
+}
+
+// transientSignals are substrings (lowercase) of error results for API
+// conditions that usually pass, so the turn can be retried.
+var transientSignals = []string{
+	"overloaded",
+	"rate_limit",
+	"rate limit",
+	"too many requests",
+}
+
+// transientStatus matches the CLI's report of an overloaded (529) API
+// response, e.g. "API Error: 529", so other numbers containing 529 don't.
+var transientStatus = regexp.MustCompile(`(?i)\b(?:api error|status(?: code)?)\W*529\b`)
+
+// isTransientError reports whether s looks like a transient API failure.
+func isTransientError(s string) bool {
+	if transientStatus.MatchString(s) {
+		return true
+	}
+	s = strings.ToLower(s)
+	for _, sig := range transientSignals {
+		if strings.Contains(s, sig) {
+			return true
+		}
+	}
+	return false
+}
+
+// openCapture creates the stderr capture file for the current process, or
+// returns nil if capture is disabled or the file can't be created. Callers
+// must hold e.mu.
+func (e *Executor) openCapture() io.WriteCloser {
+	if e.captureDir == "" {
+		return nil
 	}
+	if err := os.MkdirAll(e.captureDir, 0o700); err != nil {
+		slog.Warn("create stderr capture dir", "error", err)
+		return nil
+	}
+
+	name := fmt.Sprintf("claude-%s-%d.log", time.Now().Format("20060102-150405"), e.cmd.Process.Pid)
+	f, err := os.Create(filepath.Join(e.captureDir, name))
+	if err != nil {
+		slog.Warn("create stderr capture file", "error", err)
+		return nil
+	}
+	return f
 }
 
 // --- stream-json protocol types ---
@@ -325,12 +862,37 @@ type streamInputMessage struct {
 	Content string `json:"content"`
 }
 
+type controlRequest struct {
+	Type      string             `json:"type"`
+	RequestID string             `json:"request_id"`
+	Request   controlRequestBody `json:"request"`
+}
+
+type controlRequestBody struct {
+	Subtype string `json:"subtype"`
+}
+
 type streamMessage struct {
 	Type      string          `json:"type"`
 	Subtype   string          `json:"subtype,omitempty"`
 	SessionID string          `json:"session_id,omitempty"`
 	Message   json.RawMessage `json:"message,omitempty"`
 	Result    json.RawMessage `json:"result,omitempty"`
+	Event     json.RawMessage `json:"event,omitempty"`      // stream_event payload
+	RequestID string          `json:"request_id,omitempty"` // control_request ID
+	Request   json.RawMessage `json:"request,omitempty"`    // control_request payload
+	IsError   bool            `json:"is_error,omitempty"`
+	Error     string          `json:"error,omitempty"`
+
+	TotalCostUSD float64      `json:"total_cost_usd,omitempty"`
+	Usage        *resultUsage `json:"usage,omitempty"` // The turn's tokens, on result messages
+}
+
+type resultUsage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
 }
 
 type contentMessage struct {
@@ -338,8 +900,28 @@ type contentMessage struct {
 }
 
 type contentBlock struct {
-	Type string `json:"type"`
-	Text string `json:"text,omitempty"`
+	Type  string          `json:"type"`
+	Text  string          `json:"text,omitempty"`
+	ID    string          `json:"id,omitempty"`    // tool_use blocks
+	Name  string          `json:"name,omitempty"`  // tool_use blocks
+	Input json.RawMessage `json:"input,omitempty"` // tool_use blocks
+
+	ToolUseID string          `json:"tool_use_id,omitempty"` // tool_result blocks
+	Content   json.RawMessage `json:"content,omitempty"`     // tool_result blocks: a string or text blocks
+}
+
+// streamEvent is the Anthropic API streaming event carried by a
+// stream_event line.
+type streamEvent struct {
+	Type         string        `json:"type"`
+	Index        int           `json:"index"`
+	ContentBlock *contentBlock `json:"content_block,omitempty"`
+	Delta        *streamDelta  `json:"delta,omitempty"`
+}
+
+type streamDelta struct {
+	Type        string `json:"type"`
+	PartialJSON string `json:"partial_json,omitempty"` // input_json_delta
 }
 
 func extractText(raw json.RawMessage) string {
@@ -360,3 +942,62 @@ func extractText(raw json.RawMessage) string {
 	}
 	return b.String()
 }
+
+// extractTool returns the first named tool_use block in a message.
+func extractTool(raw json.RawMessage) (contentBlock, bool) {
+	if raw == nil {
+		return contentBlock{}, false
+	}
+
+	var msg contentMessage
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		return contentBlock{}, false
+	}
+
+	for _, block := range msg.Content {
+		if block.Type == "tool_use" && block.Name != "" {
+			return block, true
+		}
+	}
+	return contentBlock{}, false
+}
+
+// extractToolResult returns the first tool_result block in a message.
+func extractToolResult(raw json.RawMessage) (contentBlock, bool) {
+	if raw == nil {
+		return contentBlock{}, false
+	}
+
+	var msg contentMessage
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		return contentBlock{}, false
+	}
+
+	for _, block := range msg.Content {
+		if block.Type == "tool_result" {
+			return block, true
+		}
+	}
+	return contentBlock{}, false
+}
+
+// toolResultText flattens a tool_result's content, which is either a plain
+// string or a list of blocks of which only the text is kept.
+func toolResultText(raw json.RawMessage) string {
+	var text string
+	if json.Unmarshal(raw, &text) == nil {
+		return text
+	}
+
+	var blocks []contentBlock
+	if err := json.Unmarshal(raw, &blocks); err != nil {
+		return ""
+	}
+	var parts []string
+	for _, block := range blocks {
+		if block.Type == "text" {
+			parts = append(parts, block.Text)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
@@ -3,11 +3,17 @@ package claude
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"testing"
 	"time"
+	"unicode/utf8"
 
 	"github.com/zette-dev/natron/internal/executor"
 )
@@ -34,6 +40,21 @@ func TestParseLine_SystemInit(t *testing.T) {
 	}
 }
 
+func TestParseLine_SystemInitSignalsReady(t *testing.T) {
+	e := New("sonnet")
+	e.ready = make(chan struct{})
+
+	e.parseLine([]byte(`{"type":"system","subtype":"init","session_id":"sess-123"}`))
+	select {
+	case <-e.Ready():
+	default:
+		t.Fatal("expected Ready to be closed after system init")
+	}
+
+	// A repeated init must not panic on the closed channel.
+	e.parseLine([]byte(`{"type":"system","subtype":"init","session_id":"sess-123"}`))
+}
+
 func TestParseLine_AssistantText(t *testing.T) {
 	e := New("sonnet")
 	line := `{"type":"assistant","message":{"content":[{"type":"text","text":"Hello world"}]}}`
@@ -71,6 +92,185 @@ func TestParseLine_AssistantMultipleBlocks(t *testing.T) {
 	}
 }
 
+func TestParseLine_AssistantWhitespaceOnly(t *testing.T) {
+	e := New("sonnet")
+	line := `{"type":"assistant","message":{"content":[{"type":"text","text":"\n  \n"}]}}`
+
+	if evt, done := e.parseLine([]byte(line)); evt != nil || done {
+		t.Errorf("expected whitespace-only text to be skipped, got %+v (done=%v)", evt, done)
+	}
+
+	// Whitespace around real text is preserved for chunk joins.
+	line = `{"type":"assistant","message":{"content":[{"type":"text","text":"\nnext "}]}}`
+	evt, _ := e.parseLine([]byte(line))
+	if evt == nil || evt.Text != "\nnext " {
+		t.Errorf("expected text with whitespace preserved, got %+v", evt)
+	}
+}
+
+func TestParseLine_ResultCost(t *testing.T) {
+	e := New("sonnet")
+	line := `{"type":"result","total_cost_usd":0.42,"result":{"content":[{"type":"text","text":"ok"}]}}`
+
+	evt, _ := e.parseLine([]byte(line))
+	if evt == nil || evt.CostUSD != 0.42 {
+		t.Errorf("expected cost 0.42 on EventDone, got %+v", evt)
+	}
+}
+
+func TestParseLine_ResultTurnUsage(t *testing.T) {
+	e := New("sonnet")
+	first := `{"type":"result","total_cost_usd":0.25,"usage":{"input_tokens":10,"cache_read_input_tokens":90,"output_tokens":20},"result":"ok"}`
+	second := `{"type":"result","total_cost_usd":0.75,"usage":{"input_tokens":5,"output_tokens":7},"result":"ok"}`
+
+	evt, _ := e.parseLine([]byte(first))
+	if evt == nil || evt.TurnCostUSD != 0.25 || evt.InputTokens != 100 || evt.OutputTokens != 20 {
+		t.Errorf("first turn usage = %+v, want $0.25, 100 in, 20 out", evt)
+	}
+	evt, _ = e.parseLine([]byte(second))
+	if evt == nil || evt.TurnCostUSD != 0.5 || evt.CostUSD != 0.75 || evt.InputTokens != 5 || evt.OutputTokens != 7 {
+		t.Errorf("second turn usage = %+v, want $0.50 of $0.75, 5 in, 7 out", evt)
+	}
+}
+
+func TestParseLine_ToolUse(t *testing.T) {
+	e := New("sonnet")
+	line := `{"type":"assistant","message":{"content":[{"type":"tool_use","id":"t1","name":"Bash","input":{"command":"ls"}}]}}`
+
+	evt, done := e.parseLine([]byte(line))
+	if evt == nil || evt.Type != executor.EventToolUse || evt.Tool != "Bash" {
+		t.Fatalf("expected EventToolUse for Bash, got %+v", evt)
+	}
+	if done {
+		t.Error("tool use should not signal done")
+	}
+}
+
+func TestParseLine_ToolResult(t *testing.T) {
+	e := New("sonnet")
+	e.parseLine([]byte(`{"type":"assistant","message":{"content":[{"type":"tool_use","id":"t1","name":"Bash","input":{"command":"ls"}}]}}`))
+
+	tests := []struct {
+		name string
+		line string
+		tool string
+		text string
+	}{
+		{
+			name: "string content",
+			line: `{"type":"user","message":{"role":"user","content":[{"type":"tool_result","tool_use_id":"t1","content":"a.go\nb.go"}]}}`,
+			tool: "Bash",
+			text: "a.go\nb.go",
+		},
+		{
+			name: "block content",
+			line: `{"type":"user","message":{"role":"user","content":[{"type":"tool_result","tool_use_id":"t1","content":[{"type":"text","text":"one"},{"type":"image"},{"type":"text","text":"two"}]}]}}`,
+			tool: "Bash",
+			text: "one\ntwo",
+		},
+		{
+			name: "unknown call",
+			line: `{"type":"user","message":{"role":"user","content":[{"type":"tool_result","tool_use_id":"t9","content":"x"}]}}`,
+			text: "x",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			evt, done := e.parseLine([]byte(tt.line))
+			if evt == nil || evt.Type != executor.EventToolResult || evt.Tool != tt.tool || evt.Text != tt.text {
+				t.Fatalf("got %+v, want EventToolResult from %q with %q", evt, tt.tool, tt.text)
+			}
+			if done {
+				t.Error("tool result should not signal done")
+			}
+		})
+	}
+
+	if evt, _ := e.parseLine([]byte(`{"type":"user","message":{"role":"user","content":[{"type":"text","text":"hi"}]}}`)); evt != nil {
+		t.Errorf("expected no event for a plain user message, got %+v", evt)
+	}
+}
+
+func TestParseLine_StreamedToolInput(t *testing.T) {
+	e := New("sonnet")
+	lines := []string{
+		`{"type":"stream_event","event":{"type":"message_start"}}`,
+		`{"type":"stream_event","event":{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}}`,
+		`{"type":"stream_event","event":{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Checking."}}}`,
+		`{"type":"stream_event","event":{"type":"content_block_stop","index":0}}`,
+		`{"type":"stream_event","event":{"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"Bash","input":{}}}}`,
+		`{"type":"stream_event","event":{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":""}}}`,
+		`{"type":"stream_event","event":{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"comm"}}}`,
+		`{"type":"stream_event","event":{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"and\": \"git st"}}}`,
+		`{"type":"stream_event","event":{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"atus\"}"}}}`,
+	}
+	for _, line := range lines {
+		if evt, _ := e.parseLine([]byte(line)); evt != nil {
+			t.Fatalf("expected no event before the block stops, got %+v for %s", evt, line)
+		}
+	}
+
+	evt, done := e.parseLine([]byte(`{"type":"stream_event","event":{"type":"content_block_stop","index":1}}`))
+	if evt == nil || evt.Type != executor.EventToolUse || evt.Tool != "Bash" {
+		t.Fatalf("expected EventToolUse for Bash, got %+v", evt)
+	}
+	if evt.ToolInput != `{"command": "git status"}` {
+		t.Errorf("tool input = %q, want the assembled fragments", evt.ToolInput)
+	}
+	if done {
+		t.Error("tool use should not signal done")
+	}
+
+	// The complete assistant message repeats the call; it was already sent.
+	full := `{"type":"assistant","message":{"content":[{"type":"tool_use","id":"toolu_1","name":"Bash","input":{"command":"git status"}}]}}`
+	if evt, _ := e.parseLine([]byte(full)); evt != nil {
+		t.Errorf("expected the streamed call not to repeat, got %+v", evt)
+	}
+
+	// The turn boundary resets the state, so the next turn's calls through
+	// either path are reported.
+	e.parseLine([]byte(`{"type":"result","result":{"content":[]}}`))
+	if e.partialTools != nil || e.streamedTools != nil {
+		t.Error("expected tool state to reset at the end of the turn")
+	}
+	if evt, _ := e.parseLine([]byte(full)); evt == nil || evt.ToolInput != `{"command":"git status"}` {
+		t.Errorf("expected the next turn's call to be reported with its input, got %+v", evt)
+	}
+}
+
+func TestParseLine_StreamedToolInputEdgeCases(t *testing.T) {
+	e := New("sonnet")
+	start := `{"type":"stream_event","event":{"type":"content_block_start","index":0,"content_block":{"type":"tool_use","id":"toolu_2","name":"TodoRead"}}}`
+	stop := `{"type":"stream_event","event":{"type":"content_block_stop","index":0}}`
+
+	e.parseLine([]byte(start))
+	if evt, _ := e.parseLine([]byte(stop)); evt == nil || evt.ToolInput != "{}" {
+		t.Errorf("expected a call without input deltas to report {}, got %+v", evt)
+	}
+
+	e.parseLine([]byte(start))
+	e.parseLine([]byte(`{"type":"stream_event","event":{"type":"content_block_delta","index":0,"delta":{"type":"input_json_delta","partial_json":"{\"trunc"}}}`))
+	if evt, _ := e.parseLine([]byte(stop)); evt == nil || evt.Tool != "TodoRead" || evt.ToolInput != "" {
+		t.Errorf("expected a malformed input to be dropped but the call kept, got %+v", evt)
+	}
+
+	if evt, _ := e.parseLine([]byte(stop)); evt != nil {
+		t.Errorf("expected a stray stop to be ignored, got %+v", evt)
+	}
+}
+
+func TestToolActivity(t *testing.T) {
+	now := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+	if got := toolActivity(now, executor.Event{Tool: "Read", ToolInput: "{}"}); got != "15:04:05 tool Read" {
+		t.Errorf("got %q", got)
+	}
+	long := `{"command":"` + strings.Repeat("é", 150) + `"}`
+	got := toolActivity(now, executor.Event{Tool: "Bash", ToolInput: long})
+	if !strings.HasPrefix(got, `15:04:05 tool Bash {"command":"é`) || !strings.HasSuffix(got, "...") || !utf8.ValidString(got) {
+		t.Errorf("expected the input cut on a rune boundary, got %q", got)
+	}
+}
+
 func TestParseLine_Result(t *testing.T) {
 	e := New("sonnet")
 	line := `{"type":"result","result":{"content":[{"type":"text","text":"Final answer"}]}}`
@@ -91,6 +291,76 @@ func TestParseLine_Result(t *testing.T) {
 	}
 }
 
+func TestParseLine_AuthFailureResult(t *testing.T) {
+	e := New("sonnet")
+	line := `{"type":"result","subtype":"success","is_error":true,"result":"Invalid API key · Please run /login"}`
+
+	evt, done := e.parseLine([]byte(line))
+
+	if evt == nil || evt.Type != executor.EventError {
+		t.Fatalf("expected EventError, got %+v", evt)
+	}
+	if !errors.Is(evt.Error, executor.ErrNotAuthenticated) {
+		t.Errorf("expected ErrNotAuthenticated, got %v", evt.Error)
+	}
+	if !done {
+		t.Error("auth failure result should signal done")
+	}
+}
+
+func TestParseLine_AssistantAuthErrorFlagsResult(t *testing.T) {
+	e := New("sonnet")
+
+	// The assistant message carries the stable error identifier; the
+	// result's prose may change between CLI versions.
+	e.parseLine([]byte(`{"type":"assistant","error":"authentication_failed","message":{"content":[{"type":"text","text":"Something about credentials"}]}}`))
+	evt, _ := e.parseLine([]byte(`{"type":"result","is_error":true,"result":"Something about credentials"}`))
+
+	if evt == nil || !errors.Is(evt.Error, executor.ErrNotAuthenticated) {
+		t.Errorf("expected ErrNotAuthenticated from flagged result, got %+v", evt)
+	}
+}
+
+func TestParseLine_ErrorResultNotAuth(t *testing.T) {
+	e := New("sonnet")
+	line := `{"type":"result","is_error":true,"result":{"content":[{"type":"text","text":"Tool execution failed"}]}}`
+
+	evt, _ := e.parseLine([]byte(line))
+
+	if evt == nil || evt.Type != executor.EventDone {
+		t.Errorf("expected non-auth error result to stay EventDone, got %+v", evt)
+	}
+}
+
+func TestParseLine_TransientErrorResult(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want bool
+	}{
+		{"overloaded", `{"type":"result","subtype":"error_during_execution","is_error":true,"result":"API Error: 529 Overloaded"}`, true},
+		{"rate limited", `{"type":"result","is_error":true,"result":{"content":[{"type":"text","text":"rate_limit_error: Too many requests"}]}}`, true},
+		{"status only", `{"type":"result","is_error":true,"result":"API Error: 529 {\"type\":\"error\"}"}`, true},
+		{"overloaded type", `{"type":"result","is_error":true,"result":"{\"error\":{\"type\":\"overloaded_error\"}}"}`, true},
+		{"other error", `{"type":"result","subtype":"error_max_turns","is_error":true,"result":"Reached max turns"}`, false},
+		{"529 elsewhere", `{"type":"result","is_error":true,"result":"Edit failed at line 1529 of main.go"}`, false},
+		{"success mentioning overload", `{"type":"result","subtype":"success","result":"The server was overloaded yesterday."}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := New("sonnet")
+			evt, done := e.parseLine([]byte(tt.line))
+			if evt == nil || !done {
+				t.Fatalf("expected a terminal event, got %+v (done=%v)", evt, done)
+			}
+			got := evt.Type == executor.EventError && errors.Is(evt.Error, executor.ErrTransient)
+			if got != tt.want {
+				t.Errorf("transient = %v, want %v (event %+v)", got, tt.want, evt)
+			}
+		})
+	}
+}
+
 func TestParseLine_UnknownType(t *testing.T) {
 	e := New("sonnet")
 	line := `{"type":"stream_event","event":{"type":"content_block_delta"}}`
@@ -132,6 +402,76 @@ func TestParseLine_AssistantEmptyContent(t *testing.T) {
 	}
 }
 
+// --- buildArgs unit tests ---
+
+func TestBuildArgs_ReadOnly(t *testing.T) {
+	e := New("sonnet")
+
+	args := e.buildArgs(executor.SessionContext{})
+	if hasArg(args, "--tools") {
+		t.Errorf("expected no --tools flag by default, got %v", args)
+	}
+
+	args = e.buildArgs(executor.SessionContext{ReadOnly: true})
+	i := indexArg(args, "--tools")
+	if i < 0 || i+1 >= len(args) || args[i+1] != "" {
+		t.Errorf("expected --tools \"\" in read-only mode, got %v", args)
+	}
+}
+
+func TestBuildArgs_ThinkingBudget(t *testing.T) {
+	if args := New("sonnet").buildArgs(executor.SessionContext{}); hasArg(args, "--max-thinking-tokens") {
+		t.Errorf("expected thinking off by default, got %v", args)
+	}
+
+	args := New("sonnet", WithThinkingBudget(8000)).buildArgs(executor.SessionContext{})
+	if i := indexArg(args, "--max-thinking-tokens"); i < 0 || i+1 >= len(args) || args[i+1] != "8000" {
+		t.Errorf("expected --max-thinking-tokens 8000, got %v", args)
+	}
+}
+
+func TestBuildArgs_SystemPrompt(t *testing.T) {
+	e := New("sonnet")
+
+	if args := e.buildArgs(executor.SessionContext{}); hasArg(args, "--append-system-prompt") {
+		t.Errorf("expected no system prompt without identity or brief, got %v", args)
+	}
+
+	args := e.buildArgs(executor.SessionContext{IdentityDoc: "soul", WorkspaceInfo: "brief"})
+	i := indexArg(args, "--append-system-prompt")
+	if i < 0 || i+1 >= len(args) {
+		t.Fatalf("expected --append-system-prompt, got %v", args)
+	}
+	if want := "soul\n\n---\n\n## Project Brief\n\nbrief"; args[i+1] != want {
+		t.Errorf("system prompt = %q, want %q", args[i+1], want)
+	}
+
+	args = e.buildArgs(executor.SessionContext{RecentHistory: "[15:04] @alice: hi"})
+	if i := indexArg(args, "--append-system-prompt"); i < 0 || args[i+1] != "---\n\n## Recent Chat History\n\n[15:04] @alice: hi" {
+		t.Errorf("expected recent history in the system prompt, got %v", args)
+	}
+}
+
+func TestBuildArgs_ModelAndResume(t *testing.T) {
+	e := New("opus")
+
+	args := e.buildArgs(executor.SessionContext{})
+	if !hasArg(args, "--include-partial-messages") {
+		t.Errorf("expected partial messages for streamed tool input, got %v", args)
+	}
+	if i := indexArg(args, "--model"); i < 0 || args[i+1] != "opus" || hasArg(args, "--resume") {
+		t.Errorf("expected default model without resume, got %v", args)
+	}
+
+	args = e.buildArgs(executor.SessionContext{Model: "haiku", ResumeID: "abc"})
+	if i := indexArg(args, "--model"); i < 0 || args[i+1] != "haiku" {
+		t.Errorf("expected model override, got %v", args)
+	}
+	if i := indexArg(args, "--resume"); i < 0 || args[i+1] != "abc" {
+		t.Errorf("expected --resume abc, got %v", args)
+	}
+}
+
 // --- extractText unit tests ---
 
 func TestExtractText_Nil(t *testing.T) {
@@ -263,6 +603,40 @@ func TestReadLoop_MultiTurn(t *testing.T) {
 	pw.Close()
 }
 
+func TestLastTurnRaw_ResetsPerTurn(t *testing.T) {
+	e := New("sonnet")
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	e.stdin = &closeRecorder{}
+	e.alive = true
+	go e.readLoop(pr)
+
+	turn := func(lines ...string) []string {
+		t.Helper()
+		events, err := e.Send(context.Background(), "hi")
+		if err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		for _, line := range lines {
+			writeLine(t, pw, line)
+		}
+		collectEvents(t, events, 3*time.Second)
+		return e.LastTurnRaw()
+	}
+
+	first := []string{
+		`{"type":"assistant","message":{"content":[{"type":"text","text":"one"}]}}`,
+		`{"type":"result","result":"one"}`,
+	}
+	if got := turn(first...); !slices.Equal(got, first) {
+		t.Errorf("turn 1 raw = %q, want %q", got, first)
+	}
+	second := []string{`{"type":"result","result":"two"}`}
+	if got := turn(second...); !slices.Equal(got, second) {
+		t.Errorf("turn 2 raw = %q, want only its own lines %q", got, second)
+	}
+}
+
 // TestReadLoop_ProcessExit verifies that when the pipe closes (simulating
 // process exit), the response channel is closed and alive becomes false.
 func TestReadLoop_ProcessExit(t *testing.T) {
@@ -380,8 +754,204 @@ func TestSendWritesCorrectJSON(t *testing.T) {
 	pr.Close()
 }
 
+// TestDrainStderr_Capture verifies stderr lines are copied to the capture
+// writer, which is closed when stderr ends.
+func TestDrainStderr_Capture(t *testing.T) {
+	e := New("sonnet", WithStderrLevel(slog.LevelWarn))
+	if e.stderrLevel != slog.LevelWarn {
+		t.Errorf("expected warn level, got %v", e.stderrLevel)
+	}
+
+	capture := &closeRecorder{}
+	e.drainStderr(strings.NewReader("first\nsecond\n"), capture)
+
+	if got := capture.String(); got != "first\nsecond\n" {
+		t.Errorf("expected captured stderr lines, got %q", got)
+	}
+	if !capture.closed {
+		t.Error("expected capture writer to be closed")
+	}
+}
+
+// TestReadLoop_AuthFailureOnStderr simulates the CLI printing an auth error
+// to stderr and exiting without a result.
+func TestReadLoop_AuthFailureOnStderr(t *testing.T) {
+	e := New("sonnet")
+	e.drainStderr(strings.NewReader("Error: Invalid API key · Please run /login\n"), nil)
+
+	pr, pw := io.Pipe()
+	e.mu.Lock()
+	e.alive = true
+	e.mu.Unlock()
+	go e.readLoop(pr)
+
+	ch := make(chan executor.Event, 64)
+	e.respMu.Lock()
+	e.respCh = ch
+	e.respMu.Unlock()
+
+	pw.Close() // process exits
+
+	events := collectEvents(t, ch, 3*time.Second)
+	if len(events) != 1 || !errors.Is(events[0].Error, executor.ErrNotAuthenticated) {
+		t.Errorf("expected a single ErrNotAuthenticated event, got %+v", events)
+	}
+}
+
+func TestReadLoop_UnknownModelAtStartup(t *testing.T) {
+	e := New("sonnet")
+	e.startModel = "claude-sonet-9"
+	e.drainStderr(strings.NewReader("There's an issue with the selected model (claude-sonet-9). It may not exist or you may not have access to it.\n"), nil)
+
+	pr, pw := io.Pipe()
+	e.mu.Lock()
+	e.alive = true
+	e.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		e.readLoop(pr)
+		close(done)
+	}()
+
+	ch := make(chan executor.Event, 64)
+	e.respMu.Lock()
+	e.respCh = ch
+	e.respMu.Unlock()
+
+	pw.Close() // process exits
+	events := collectEvents(t, ch, 3*time.Second)
+	var unknown *executor.UnknownModelError
+	if len(events) != 1 || !errors.As(events[0].Error, &unknown) || unknown.Model != "claude-sonet-9" {
+		t.Fatalf("expected a single UnknownModelError for claude-sonet-9, got %+v", events)
+	}
+
+	<-done
+	if _, err := e.Send(context.Background(), "hi"); !errors.As(err, &unknown) {
+		t.Errorf("Send after the exit = %v, want it to wrap UnknownModelError", err)
+	}
+}
+
+func TestParseLine_UnknownModelResult(t *testing.T) {
+	e := New("opus")
+	e.startModel = "opus"
+	evt, done := e.parseLine([]byte(`{"type":"result","subtype":"error_during_execution","is_error":true,"result":"API Error: 404 {\"type\":\"error\",\"error\":{\"type\":\"not_found_error\",\"message\":\"model: opus\"}}"}`))
+	var unknown *executor.UnknownModelError
+	if !done || evt == nil || !errors.As(evt.Error, &unknown) || unknown.Model != "opus" {
+		t.Errorf("parseLine = %+v, %v; want a final UnknownModelError for opus", evt, done)
+	}
+}
+
+// fakeCLI is a stand-in for the claude binary: it reports the session it
+// resumes, or a new one named after its PID, then waits for stdin to close.
+const fakeCLI = `#!/bin/sh
+id="new-$$"
+prev=""
+for a in "$@"; do
+	[ "$prev" = "--resume" ] && id="$a"
+	prev="$a"
+done
+echo "{\"type\":\"system\",\"subtype\":\"init\",\"session_id\":\"$id\"}"
+cat >/dev/null
+`
+
+func TestRestart_ResumesSessionInFreshProcess(t *testing.T) {
+	bin := filepath.Join(t.TempDir(), "claude")
+	if err := os.WriteFile(bin, []byte(fakeCLI), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	e := New("opus")
+	e.bin = bin
+	if err := e.Restart(context.Background(), executor.SessionContext{}); err == nil {
+		t.Error("expected an error restarting before Start")
+	}
+
+	awaitInit := func() {
+		t.Helper()
+		select {
+		case <-e.Ready():
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for system/init")
+		}
+	}
+	dir := t.TempDir()
+	if err := e.Start(context.Background(), dir, executor.SessionContext{}); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer e.Stop()
+	awaitInit()
+	id := e.SessionID()
+	firstPID := e.cmd.Process.Pid
+
+	if err := e.Restart(context.Background(), executor.SessionContext{Model: "sonnet"}); err != nil {
+		t.Fatalf("Restart: %v", err)
+	}
+	awaitInit()
+
+	if e.cmd.Process.Pid == firstPID || !e.Alive() {
+		t.Error("expected a fresh live process after Restart")
+	}
+	if got := e.SessionID(); got != id {
+		t.Errorf("session ID = %q after restart, want %q", got, id)
+	}
+	args := e.cmd.Args
+	if i := indexArg(args, "--resume"); i < 0 || args[i+1] != id {
+		t.Errorf("expected --resume %s, got %v", id, args)
+	}
+	if i := indexArg(args, "--model"); i < 0 || args[i+1] != "sonnet" {
+		t.Errorf("expected the updated model, got %v", args)
+	}
+	if e.cmd.Dir != dir {
+		t.Errorf("restarted in %q, want %q", e.cmd.Dir, dir)
+	}
+}
+
 // --- test helpers ---
 
+func TestInterrupt_WritesControlRequest(t *testing.T) {
+	e := New("sonnet")
+	if err := e.Interrupt(); err == nil {
+		t.Error("expected error interrupting a stopped executor")
+	}
+
+	stdin := &closeRecorder{}
+	e.stdin = stdin
+	e.alive = true
+	if err := e.Interrupt(); err != nil {
+		t.Fatalf("Interrupt: %v", err)
+	}
+
+	var req controlRequest
+	if err := json.Unmarshal([]byte(stdin.String()), &req); err != nil {
+		t.Fatalf("unmarshal %q: %v", stdin.String(), err)
+	}
+	if req.Type != "control_request" || req.Request.Subtype != "interrupt" || req.RequestID == "" {
+		t.Errorf("unexpected control request: %+v", req)
+	}
+}
+
+type closeRecorder struct {
+	strings.Builder
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func hasArg(args []string, flag string) bool {
+	return indexArg(args, flag) >= 0
+}
+
+func indexArg(args []string, flag string) int {
+	for i, a := range args {
+		if a == flag {
+			return i
+		}
+	}
+	return -1
+}
+
 func writeLine(t *testing.T, w io.Writer, line string) {
 	t.Helper()
 	if _, err := io.WriteString(w, line+"\n"); err != nil {
@@ -0,0 +1,165 @@
+package claude
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+)
+
+// PermissionMode is how the executor answers the CLI's tool permission
+// requests.
+type PermissionMode string
+
+const (
+	PermissionApprove PermissionMode = "approve" // Allow every tool call
+	PermissionDeny    PermissionMode = "deny"    // Refuse every tool call
+	PermissionAsk     PermissionMode = "ask"     // Defer to the ToolApprover
+)
+
+// deniedMessage is what Claude is told when a tool call is refused
+// without a reason, and timedOutMessage when the approver didn't decide in
+// time.
+const (
+	deniedMessage   = "Tool use was not permitted."
+	timedOutMessage = "Tool use was not approved in time."
+)
+
+// approvalTimeout bounds how long a tool call waits on the approver, so an
+// unanswered prompt can't hold the turn forever.
+const approvalTimeout = 5 * time.Minute
+
+// ToolApprover decides a tool call in PermissionAsk mode. It may block,
+// e.g. on a user's answer; reason is passed to Claude on refusal.
+type ToolApprover func(ctx context.Context, tool string, input json.RawMessage) (allow bool, reason string)
+
+// WithPermissions sets how tool permission requests are answered. The
+// default is PermissionApprove. approve is only consulted in
+// PermissionAsk mode, where a nil approve refuses everything.
+func WithPermissions(mode PermissionMode, approve ToolApprover) Option {
+	return func(e *Executor) {
+		e.permissions = mode
+		e.approve = approve
+	}
+}
+
+// prompted reports whether the CLI should route permission prompts to
+// stdin. Approving everything needs no prompts, so the CLI runs as before.
+func (e *Executor) prompted() bool {
+	return e.permissions != "" && e.permissions != PermissionApprove
+}
+
+// handleControlRequest answers a control_request from the CLI. Anything
+// other than a tool permission request gets an error response so the CLI
+// doesn't wait on it. In PermissionAsk mode the approver runs on its own
+// goroutine, keeping the read loop free while it waits.
+func (e *Executor) handleControlRequest(msg streamMessage) {
+	var req permissionRequest
+	if err := json.Unmarshal(msg.Request, &req); err != nil || req.Subtype != "can_use_tool" {
+		slog.Warn("unsupported control request", "request_id", e.requestID(), "subtype", req.Subtype)
+		e.respondControl(controlResponseBody{Subtype: "error", RequestID: msg.RequestID, Error: "unsupported control request"})
+		return
+	}
+
+	switch e.permissions {
+	case PermissionDeny:
+		e.answerPermission(msg.RequestID, req, false, "")
+	case PermissionAsk:
+		e.mu.Lock()
+		ctx := e.ctx
+		e.mu.Unlock()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		go func() {
+			allow, reason := false, ""
+			if e.approve != nil {
+				allow, reason = e.awaitApproval(ctx, req)
+			}
+			e.answerPermission(msg.RequestID, req, allow, reason)
+		}()
+	default:
+		e.answerPermission(msg.RequestID, req, true, "")
+	}
+}
+
+// awaitApproval asks the approver about req, refusing the call if it hasn't
+// decided within approveWait.
+func (e *Executor) awaitApproval(ctx context.Context, req permissionRequest) (allow bool, reason string) {
+	ctx, cancel := context.WithTimeout(ctx, e.approveWait)
+	defer cancel()
+
+	type decision struct {
+		allow  bool
+		reason string
+	}
+	decided := make(chan decision, 1)
+	go func() {
+		allow, reason := e.approve(ctx, req.ToolName, req.Input)
+		decided <- decision{allow, reason}
+	}()
+
+	select {
+	case d := <-decided:
+		return d.allow, d.reason
+	case <-ctx.Done():
+		slog.Warn("tool approval timed out", "request_id", e.requestID(), "tool", req.ToolName, "wait", e.approveWait)
+		return false, timedOutMessage
+	}
+}
+
+// answerPermission writes the decision for a can_use_tool request.
+func (e *Executor) answerPermission(id string, req permissionRequest, allow bool, reason string) {
+	slog.Info("tool permission", "request_id", e.requestID(), "tool", req.ToolName, "allowed", allow)
+
+	result := permissionResult{Behavior: "allow", UpdatedInput: req.Input}
+	if !allow {
+		if reason == "" {
+			reason = deniedMessage
+		}
+		result = permissionResult{Behavior: "deny", Message: reason}
+	}
+	e.respondControl(controlResponseBody{Subtype: "success", RequestID: id, Response: &result})
+}
+
+// respondControl writes a control_response to stdin.
+func (e *Executor) respondControl(body controlResponseBody) {
+	e.mu.Lock()
+	stdin := e.stdin
+	e.mu.Unlock()
+	if stdin == nil {
+		return
+	}
+
+	data, err := json.Marshal(controlResponse{Type: "control_response", Response: body})
+	if err == nil {
+		err = e.write(stdin, append(data, '\n'))
+	}
+	if err != nil {
+		slog.Warn("control response failed", "request_id", e.requestID(), "error", err)
+	}
+}
+
+type permissionRequest struct {
+	Subtype  string          `json:"subtype"`
+	ToolName string          `json:"tool_name"`
+	Input    json.RawMessage `json:"input"`
+}
+
+type controlResponse struct {
+	Type     string              `json:"type"`
+	Response controlResponseBody `json:"response"`
+}
+
+type controlResponseBody struct {
+	Subtype   string            `json:"subtype"` // success or error
+	RequestID string            `json:"request_id"`
+	Response  *permissionResult `json:"response,omitempty"`
+	Error     string            `json:"error,omitempty"`
+}
+
+type permissionResult struct {
+	Behavior     string          `json:"behavior"` // allow or deny
+	UpdatedInput json.RawMessage `json:"updatedInput,omitempty"`
+	Message      string          `json:"message,omitempty"`
+}
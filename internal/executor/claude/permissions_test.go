@@ -0,0 +1,142 @@
+package claude
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/zette-dev/natron/internal/executor"
+)
+
+func TestReadLoop_AnswersPermissionRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		mode        PermissionMode
+		approve     ToolApprover
+		wantAllow   bool
+		wantMessage string
+	}{
+		{name: "default approves", wantAllow: true},
+		{name: "deny", mode: PermissionDeny, wantMessage: deniedMessage},
+		{
+			name: "ask approved",
+			mode: PermissionAsk,
+			approve: func(_ context.Context, tool string, _ json.RawMessage) (bool, string) {
+				return tool == "Bash", ""
+			},
+			wantAllow: true,
+		},
+		{
+			name: "ask refused",
+			mode: PermissionAsk,
+			approve: func(context.Context, string, json.RawMessage) (bool, string) {
+				return false, "not now"
+			},
+			wantMessage: "not now",
+		},
+		{name: "ask without approver", mode: PermissionAsk, wantMessage: deniedMessage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := New("sonnet", WithPermissions(tt.mode, tt.approve))
+			stdoutR, stdoutW := io.Pipe()
+			stdinR, stdinW := io.Pipe()
+			e.stdin = stdinW
+			e.alive = true
+			go e.readLoop(stdoutR)
+			defer stdoutW.Close()
+
+			writeLine(t, stdoutW, `{"type":"control_request","request_id":"req-7","request":{"subtype":"can_use_tool","tool_name":"Bash","input":{"command":"ls"}}}`)
+			line, err := bufio.NewReader(stdinR).ReadBytes('\n')
+			if err != nil {
+				t.Fatalf("read stdin: %v", err)
+			}
+
+			var resp controlResponse
+			if err := json.Unmarshal(line, &resp); err != nil {
+				t.Fatalf("unmarshal %q: %v", line, err)
+			}
+			if resp.Type != "control_response" || resp.Response.Subtype != "success" || resp.Response.RequestID != "req-7" {
+				t.Fatalf("unexpected response envelope: %s", line)
+			}
+			result := resp.Response.Response
+			if result == nil {
+				t.Fatalf("response has no permission result: %s", line)
+			}
+			if tt.wantAllow {
+				if result.Behavior != "allow" || string(result.UpdatedInput) != `{"command":"ls"}` {
+					t.Errorf("result = %s, want allow with the original input", line)
+				}
+				return
+			}
+			if result.Behavior != "deny" || result.Message != tt.wantMessage {
+				t.Errorf("result = %s, want deny with %q", line, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestReadLoop_ApprovalTimesOut(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	e := New("sonnet", WithPermissions(PermissionAsk, func(context.Context, string, json.RawMessage) (bool, string) {
+		<-block // An approver that ignores its context
+		return true, ""
+	}))
+	e.approveWait = 10 * time.Millisecond
+	stdoutR, stdoutW := io.Pipe()
+	stdinR, stdinW := io.Pipe()
+	e.stdin = stdinW
+	e.alive = true
+	go e.readLoop(stdoutR)
+	defer stdoutW.Close()
+
+	writeLine(t, stdoutW, `{"type":"control_request","request_id":"req-9","request":{"subtype":"can_use_tool","tool_name":"Bash","input":{}}}`)
+	line, err := bufio.NewReader(stdinR).ReadBytes('\n')
+	if err != nil {
+		t.Fatalf("read stdin: %v", err)
+	}
+	var resp controlResponse
+	if err := json.Unmarshal(line, &resp); err != nil {
+		t.Fatalf("unmarshal %q: %v", line, err)
+	}
+	if r := resp.Response.Response; r == nil || r.Behavior != "deny" || r.Message != timedOutMessage {
+		t.Errorf("response = %s, want a timed-out refusal", line)
+	}
+}
+
+func TestReadLoop_RejectsUnknownControlRequest(t *testing.T) {
+	e := New("sonnet")
+	stdoutR, stdoutW := io.Pipe()
+	stdinR, stdinW := io.Pipe()
+	e.stdin = stdinW
+	e.alive = true
+	go e.readLoop(stdoutR)
+	defer stdoutW.Close()
+
+	writeLine(t, stdoutW, `{"type":"control_request","request_id":"req-8","request":{"subtype":"hook_callback"}}`)
+	line, err := bufio.NewReader(stdinR).ReadBytes('\n')
+	if err != nil {
+		t.Fatalf("read stdin: %v", err)
+	}
+	var resp controlResponse
+	if err := json.Unmarshal(line, &resp); err != nil {
+		t.Fatalf("unmarshal %q: %v", line, err)
+	}
+	if resp.Response.Subtype != "error" || resp.Response.RequestID != "req-8" {
+		t.Errorf("response = %s, want an error for req-8", line)
+	}
+}
+
+func TestBuildArgs_PermissionPrompts(t *testing.T) {
+	if args := New("sonnet").buildArgs(executor.SessionContext{}); hasArg(args, "--permission-prompt-tool") {
+		t.Errorf("approve mode should not route prompts: %v", args)
+	}
+	args := New("sonnet", WithPermissions(PermissionAsk, nil)).buildArgs(executor.SessionContext{})
+	if i := indexArg(args, "--permission-prompt-tool"); i < 0 || i+1 >= len(args) || args[i+1] != "stdio" {
+		t.Errorf("ask mode should route prompts to stdio: %v", args)
+	}
+}
@@ -1,21 +1,62 @@
 package executor
 
-import "context"
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrNotAuthenticated is wrapped by EventError events when the underlying
+// CLI reports that it isn't logged in or its credentials were rejected.
+var ErrNotAuthenticated = errors.New("agent CLI is not authenticated")
+
+// ErrTransient is wrapped by EventError events when a turn failed for a
+// reason that may clear up on its own, such as an overloaded or
+// rate-limited API, so resending the message is worth a try.
+var ErrTransient = errors.New("transient agent error")
+
+// UnknownModelError is wrapped by EventError events, and by Send errors
+// once the process has died of it, when the CLI rejects the model it was
+// started with, typically a typo or a retired name in the configuration.
+type UnknownModelError struct {
+	Model string
+}
+
+func (e *UnknownModelError) Error() string {
+	return fmt.Sprintf("unknown model %q", e.Model)
+}
 
 // EventType classifies a streamed output event from an executor.
 type EventType int
 
 const (
-	EventText  EventType = iota // Partial text content
-	EventDone                   // Response complete
-	EventError                  // Error occurred
+	EventText       EventType = iota // Partial text content
+	EventDone                        // Response complete
+	EventError                       // Error occurred
+	EventToolUse                     // Agent invoked a tool
+	EventToolResult                  // A tool call returned its output
 )
 
 // Event is a unit of streamed output from an executor.
 type Event struct {
 	Type  EventType
-	Text  string // Partial text (EventText) or final text (EventDone)
+	Text  string // Partial text (EventText), final text (EventDone) or tool output (EventToolResult)
 	Error error  // Set for EventError
+	Tool  string // Tool name (EventToolUse, EventToolResult)
+
+	// ToolInput is the tool call's arguments as JSON, when the executor
+	// knows them (EventToolUse).
+	ToolInput string
+
+	// CostUSD is the process's cumulative spend so far, reported with
+	// EventDone by executors that know it.
+	CostUSD float64
+
+	// TurnCostUSD, InputTokens and OutputTokens are the turn's own usage,
+	// reported with EventDone by executors that know it.
+	TurnCostUSD  float64
+	InputTokens  int
+	OutputTokens int
 }
 
 // SessionContext is executor-agnostic context the session manager builds
@@ -27,6 +68,17 @@ type SessionContext struct {
 	RecentHistory  string
 	WorkspaceInfo  string
 	IdentityDoc    string
+
+	// ReadOnly asks the executor to start without tool access, making the
+	// session a chat-only assistant that cannot touch the workspace.
+	ReadOnly bool
+
+	// Model overrides the executor's default model when set.
+	Model string
+
+	// ResumeID continues an earlier conversation (see Resumer) in the new
+	// process instead of starting a fresh one.
+	ResumeID string
 }
 
 // Executor is the interface any CLI-based agent must implement.
@@ -36,7 +88,9 @@ type Executor interface {
 	Start(ctx context.Context, workDir string, sessionCtx SessionContext) error
 
 	// Send writes a message and returns a channel of streaming events.
-	// The channel closes when the response is complete.
+	// A turn that completes ends with EventDone and one that fails with
+	// EventError; the channel closes after either. A channel that closes
+	// without one means the turn was cut off, e.g. the process died.
 	Send(ctx context.Context, message string) (<-chan Event, error)
 
 	// Stop gracefully shuts down the process.
@@ -48,3 +102,75 @@ type Executor interface {
 	// Name returns a human-readable identifier ("claude", "codex", etc.)
 	Name() string
 }
+
+// Resumer is implemented by executors whose conversations can be continued
+// in a new process via SessionContext.ResumeID.
+type Resumer interface {
+	// SessionID identifies the running conversation, or "" if unknown.
+	SessionID() string
+}
+
+// Restarter is implemented by executors that can replace their process
+// while keeping the conversation, for changes that only take effect at
+// spawn, such as the model or tool access.
+type Restarter interface {
+	// Restart stops the process and starts a fresh one with sessionCtx in
+	// the same working directory, resuming the current conversation.
+	Restart(ctx context.Context, sessionCtx SessionContext) error
+}
+
+// Interrupter is implemented by executors that can abort the in-flight turn
+// natively, keeping the process and its conversation alive. The session
+// manager cancels other executors by stopping and replacing them.
+type Interrupter interface {
+	// Interrupt aborts the current turn, which still ends with EventDone.
+	Interrupt() error
+}
+
+// ActivityLogger is implemented by executors that keep a bounded log of
+// recent process activity, such as stderr lines and tool calls.
+type ActivityLogger interface {
+	// RecentActivity returns up to n of the most recent lines, oldest first.
+	RecentActivity(n int) []string
+}
+
+// RawRecorder is implemented by executors that keep the raw output of the
+// most recent turn, for debugging how it was parsed.
+type RawRecorder interface {
+	// LastTurnRaw returns the newest raw output lines since the last turn
+	// started, oldest first.
+	LastTurnRaw() []string
+}
+
+// ExecutorCapabilities describes optional features a backend supports, so
+// callers can enable commands and input types accordingly.
+type ExecutorCapabilities struct {
+	SupportsInterrupt    bool // Native turn interrupt (see Interrupter)
+	SupportsImages       bool // Image attachments in prompts
+	SupportsToolApproval bool // Per-call tool permission prompts
+	SupportsResume       bool // Continuing a conversation (see Resumer)
+}
+
+// CapabilityReporter is implemented by executors that describe their
+// capabilities explicitly.
+type CapabilityReporter interface {
+	Capabilities() ExecutorCapabilities
+}
+
+// CapabilitiesOf returns e's capabilities. Executors that don't report them
+// are assumed to support only what their optional interfaces provide.
+func CapabilitiesOf(e Executor) ExecutorCapabilities {
+	if r, ok := e.(CapabilityReporter); ok {
+		return r.Capabilities()
+	}
+	_, interrupt := e.(Interrupter)
+	_, resume := e.(Resumer)
+	return ExecutorCapabilities{SupportsInterrupt: interrupt, SupportsResume: resume}
+}
+
+// Readier is implemented by executors whose process completes a startup
+// handshake after Start returns.
+type Readier interface {
+	// Ready returns a channel that is closed once the handshake is done.
+	Ready() <-chan struct{}
+}
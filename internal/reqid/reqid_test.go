@@ -0,0 +1,23 @@
+package reqid
+
+import (
+	"context"
+	"testing"
+)
+
+func TestWithAndFromContext(t *testing.T) {
+	if id := FromContext(context.Background()); id != "" {
+		t.Errorf("expected no ID on a bare context, got %q", id)
+	}
+
+	id := New()
+	if len(id) != 8 {
+		t.Errorf("expected an 8-character ID, got %q", id)
+	}
+	if got := FromContext(With(context.Background(), id)); got != id {
+		t.Errorf("FromContext = %q, want %q", got, id)
+	}
+	if New() == id {
+		t.Error("expected successive IDs to differ")
+	}
+}
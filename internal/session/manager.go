@@ -1,206 +1,2025 @@
 package session
 
 import (
+	"cmp"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"log/slog"
+	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/zette-dev/natron/internal/config"
 	"github.com/zette-dev/natron/internal/executor"
+	"github.com/zette-dev/natron/internal/reqid"
+	"github.com/zette-dev/natron/internal/transcript"
 )
 
+// ErrSessionFailing is returned by Send when a chat's executor has crashed
+// repeatedly and auto-recovery is paused until the chat is Reset.
+var ErrSessionFailing = errors.New("session is failing repeatedly")
+
+// ErrNoSession is returned when an operation needs a running session and
+// the origin has none.
+var ErrNoSession = errors.New("no active session")
+
+// ErrNoIdentity is returned by ReloadIdentity when neither the soul nor the
+// shared memory file has content.
+var ErrNoIdentity = errors.New("no identity document")
+
+// ErrNoPrompt is returned by Prompt when the workspace has no such prompt
+// file, or it's empty.
+var ErrNoPrompt = errors.New("no such prompt")
+
+// ErrInvalidPromptName is returned by Prompt for names that aren't a plain
+// file name, such as ones containing a path separator.
+var ErrInvalidPromptName = errors.New("invalid prompt name")
+
+// ErrSessionReset ends a turn whose session was reset while it streamed.
+var ErrSessionReset = errors.New("session was reset mid-turn")
+
+// ErrNoTranscripts is returned by Usage when transcripts are disabled, since
+// usage is totalled from them.
+var ErrNoTranscripts = errors.New("transcripts are disabled")
+
+// ErrNoMemory is returned by CompactMemory when the shared memory is empty.
+var ErrNoMemory = errors.New("shared memory is empty")
+
+// ErrUnknownModel is returned by SetModel for a model claude.allowed_models
+// doesn't list.
+var ErrUnknownModel = errors.New("model not allowed")
+
+// ErrPersonaTooLong is returned by SetPersona for a persona longer than
+// claude.max_identity_chars.
+var ErrPersonaTooLong = errors.New("persona exceeds max_identity_chars")
+
+// ErrWorkspaceForbidden is returned by ResetInWorkspace, and by Send for a
+// shared session, when workspaces.allowed_user_ids doesn't list the user for
+// the workspace.
+var ErrWorkspaceForbidden = errors.New("workspace not allowed for user")
+
+// ErrNoWorkspace is returned by ResetInWorkspace when the named workspace
+// doesn't exist under the base path.
+var ErrNoWorkspace = errors.New("no such workspace")
+
+// WorkspaceNotDirError is returned by Send when the origin's workspace path
+// exists but is not a directory.
+type WorkspaceNotDirError struct {
+	Path string
+}
+
+func (e *WorkspaceNotDirError) Error() string {
+	return fmt.Sprintf("workspace path %s is not a directory", e.Path)
+}
+
 // ExecutorFactory creates a new executor instance for a session.
 type ExecutorFactory func() executor.Executor
 
+// WorkspaceInfo describes a workspace chats can be mapped to.
+type WorkspaceInfo struct {
+	Name   string
+	Active bool // The origin passed to Workspaces resolves here
+}
+
 // StatusInfo describes the current state of a chat's session.
 type StatusInfo struct {
 	Exists    bool
 	Workspace string
 	CreatedAt time.Time
+
+	// TurnStarted is when the chat's running turn began, zero when none
+	// is, and Queued counts messages waiting for it to finish.
+	TurnStarted time.Time
+	Queued      int
+}
+
+// SessionSnapshot is a serializable view of one session, for dashboards.
+type SessionSnapshot struct {
+	ChatID       int64     `json:"chat_id"`
+	UserID       int64     `json:"user_id,omitempty"`
+	ThreadID     int       `json:"thread_id,omitempty"`
+	Workspace    string    `json:"workspace"`
+	CreatedAt    time.Time `json:"created_at"`
+	LastActivity time.Time `json:"last_activity"`
+	Backend      string    `json:"backend"`
+	Model        string    `json:"model,omitempty"` // Set after a budget downgrade
+	Alive        bool      `json:"alive"`
+	Busy         bool      `json:"busy"`
+	Parked       bool      `json:"parked"`
+	SessionID    string    `json:"session_id,omitempty"`
+	CostUSD      float64   `json:"cost_usd"`
+}
+
+// Turn describes a single message/response exchange passing through the
+// manager.
+type Turn struct {
+	Origin    Origin
+	Message   string
+	Started   time.Time
+	RequestID string // From the Send context; see package reqid
 }
 
+// Tap observes the event stream of turns passing through the manager. It is
+// called once when a turn starts and returns the observer for that turn's
+// events, or nil to skip the turn. Observers run on the forwarding goroutine
+// in registration order, before the event is delivered, and must not block.
+type Tap func(turn Turn) func(executor.Event)
+
 // Manager maps Telegram chat IDs to active executor sessions and manages
 // their lifecycle (creation and cleanup).
 type Manager struct {
 	cfg     config.Config
 	factory ExecutorFactory
+	memory  MemoryStore
+
+	transcripts *transcript.Writer // nil when transcripts are disabled
+	webhook     *http.Client       // nil unless integrations.webhook_url is set
+	spawnSlots  chan struct{}      // nil when spawns are unlimited
+	turnSlots   chan struct{}      // Turns in flight across chats; nil when unlimited
+	now         func() time.Time   // Clock for idle tracking; swapped in tests
 
 	mu       sync.Mutex
-	sessions map[int64]*Session
+	sessions map[sessionKey]*Session
+	settings map[sessionKey]*chatSettings
+	crashes  map[sessionKey][]time.Time
+	lastResp map[sessionKey]lastResponse
+	gates    map[sessionKey]*turnGate
+	starting map[sessionKey]*pendingSpawn
+	taps     []Tap
+	nudge    func(Origin, time.Duration) // Set by OnIdleNudge
+	reaped   func(Origin)                // Set by OnReap
+}
+
+// pendingSpawn is a session being created. Callers that find one wait for
+// done and share its result rather than spawning a second executor.
+type pendingSpawn struct {
+	done chan struct{}
+	sess *Session
+	err  error
+}
+
+// lastResponse is the most recent final response text retained for /raw.
+type lastResponse struct {
+	text      string
+	truncated bool
 }
 
 // NewManager creates a session manager.
 func NewManager(cfg config.Config, factory ExecutorFactory) *Manager {
-	return &Manager{
+	m := &Manager{
 		cfg:      cfg,
 		factory:  factory,
-		sessions: make(map[int64]*Session),
+		memory:   NewFileMemory(cfg.Claude.MemoryPath),
+		sessions: make(map[sessionKey]*Session),
+		settings: make(map[sessionKey]*chatSettings),
+		crashes:  make(map[sessionKey][]time.Time),
+		lastResp: make(map[sessionKey]lastResponse),
+		gates:    make(map[sessionKey]*turnGate),
+		starting: make(map[sessionKey]*pendingSpawn),
+		now:      time.Now,
+	}
+	if n := cfg.Session.MaxConcurrentSpawns; n > 0 {
+		m.spawnSlots = make(chan struct{}, n)
+	}
+	if n := cfg.Session.MaxConcurrentTurns; n > 0 {
+		m.turnSlots = make(chan struct{}, n)
+	}
+	m.AddTap(logLatency)
+	m.AddTap(m.clearCrashes)
+	m.AddTap(m.retainResponse)
+	if cfg.Claude.Downgrade.Threshold > 0 {
+		m.AddTap(m.trackCost)
 	}
+	if cfg.Transcripts.Dir != "" {
+		m.transcripts = transcript.NewWriter(cfg.Transcripts.Dir)
+		m.AddTap(m.recordTranscript)
+	}
+	if cfg.Integrations.WebhookURL != "" {
+		m.webhook = &http.Client{Timeout: cfg.Integrations.WebhookTimeout}
+		m.AddTap(m.postWebhook)
+	}
+	return m
 }
 
-// Send routes a message to the session for the given chat ID, creating
-// one if needed. username and title are used for workspace resolution and
-// may be empty for DMs or when not provided by Telegram.
-func (m *Manager) Send(ctx context.Context, chatID int64, username, title, message string) (<-chan executor.Event, error) {
-	sess, err := m.acquire(ctx, chatID, username, title)
+// Send routes a message to the session for the given origin, creating
+// one if needed. The origin's username and title are used for workspace
+// resolution and may be empty for DMs or when not provided by Telegram.
+func (m *Manager) Send(ctx context.Context, origin Origin, message string) (<-chan executor.Event, error) {
+	// The turn starts on receipt so latency includes any session spawn.
+	turn := Turn{Origin: origin, Message: message, Started: time.Now(), RequestID: reqid.FromContext(ctx)}
+
+	release, err := m.waitTurn(ctx, m.key(origin))
+	if err != nil {
+		return nil, err
+	}
+	sess, err := m.acquire(ctx, origin)
 	if err != nil {
+		release()
 		return nil, err
 	}
 	defer sess.mu.Unlock()
 
-	events, err := sess.exec.Send(ctx, message)
+	m.beginTurn(sess)
+	prompt := m.turnPrompt(sess, origin, message)
+	events, err := sess.exec.Send(ctx, prompt)
 	if err != nil {
+		m.endTurn(sess)
+		release()
 		return nil, fmt.Errorf("send to executor: %w", err)
 	}
+	if m.cfg.Session.AutoRetry.Attempts > 0 {
+		events = m.retry(ctx, sess, turn, prompt, events)
+	}
+	if sess.initReply != "" {
+		events = prependReply(sess.initReply, events)
+		sess.initReply = ""
+	}
+	events = m.reportReset(sess, events)
 
-	return events, nil
+	return m.forward(ctx, turn, events, func() {
+		m.endTurn(sess)
+		release()
+	}), nil
 }
 
-// Reset stops and removes any active session for chatID.
-// The next message will create a fresh session.
-func (m *Manager) Reset(chatID int64) {
-	m.remove(chatID)
+// turnPrompt returns the message as sent to the executor: attributed to
+// its sender in group chats of workspaces.attribute_senders workspaces, and
+// headed by the session.turn_context reminder when enabled. Taps and
+// transcripts still see the message as the user wrote it.
+func (m *Manager) turnPrompt(sess *Session, origin Origin, message string) string {
+	if origin.Group && origin.Sender != "" && slices.Contains(m.cfg.Workspaces.AttributeSenders, sess.wsName) {
+		message = origin.Sender + ": " + message
+	}
+	tc := m.cfg.Session.TurnContext
+	if !tc.Enabled {
+		return message
+	}
+	header := strings.NewReplacer("{workspace}", sess.wsName, "{dir}", sess.workspace).Replace(tc.Header)
+	return header + "\n\n" + message
 }
 
-// Status returns the current session state for a chat.
-func (m *Manager) Status(chatID int64) StatusInfo {
+// AddTap registers a tap that observes every subsequent turn.
+func (m *Manager) AddTap(tap Tap) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	m.taps = append(m.taps, tap)
+}
+
+// forward interposes redaction and the registered taps between the executor
+// and the caller. Events are delivered in order and the returned channel
+// closes when the executor's does, after done is called.
+func (m *Manager) forward(ctx context.Context, turn Turn, in <-chan executor.Event, done func()) <-chan executor.Event {
+	m.mu.Lock()
+	taps := m.taps
+	m.mu.Unlock()
+
+	var observers []func(executor.Event)
+	for _, tap := range taps {
+		if obs := tap(turn); obs != nil {
+			observers = append(observers, obs)
+		}
+	}
+
+	// Redaction runs first so taps, like the transcript, only ever see
+	// redacted text.
+	var redact *redactor
+	if len(m.cfg.Session.RedactPatterns) > 0 {
+		redact = &redactor{patterns: m.cfg.Session.RedactPatterns}
+	}
+
+	out := make(chan executor.Event, cap(in))
+	deliver := func(evt executor.Event) {
+		for _, obs := range observers {
+			obs(evt)
+		}
+		// Once the caller has gone away keep draining so the executor
+		// never blocks on a full channel.
+		if ctx.Err() != nil {
+			return
+		}
+		select {
+		case out <- evt:
+		case <-ctx.Done():
+		}
+	}
+	go func() {
+		defer close(out)
+		defer done()
+		for evt := range in {
+			if redact == nil {
+				deliver(evt)
+				continue
+			}
+			for _, evt := range redact.process(evt) {
+				deliver(evt)
+			}
+		}
+		if redact != nil {
+			for _, evt := range redact.flush() {
+				deliver(evt)
+			}
+		}
+	}()
+	return out
+}
+
+// Reset stops and removes any active session for the origin and clears its
+// crash history. The next message will create a fresh session.
+func (m *Manager) Reset(origin Origin) {
+	key := m.key(origin)
+	m.remove(key)
+
+	m.mu.Lock()
+	delete(m.crashes, key)
+	m.mu.Unlock()
+}
+
+// ResetInWorkspace resets the origin's session like Reset and pins its chat
+// to the named workspace, so the next message starts fresh there. The name
+// must be an existing directory directly under the base path.
+func (m *Manager) ResetInWorkspace(origin Origin, name string) error {
+	if name == "." || name == ".." || filepath.Base(name) != name {
+		return fmt.Errorf("workspace %q: %w", name, ErrNoWorkspace)
+	}
+	if !m.cfg.Workspaces.Permits(name, origin.UserID) {
+		return fmt.Errorf("workspace %q: %w", name, ErrWorkspaceForbidden)
+	}
+	dir := filepath.Join(m.cfg.Workspaces.BasePath, name)
+	info, err := os.Stat(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("workspace %q: %w", name, ErrNoWorkspace)
+	}
+	if err != nil {
+		return fmt.Errorf("stat workspace: %w", err)
+	}
+	if !info.IsDir() {
+		return &WorkspaceNotDirError{Path: dir}
+	}
+
+	m.mu.Lock()
+	m.settingsFor(m.key(origin)).workspace = name
+	m.mu.Unlock()
+
+	m.Reset(origin)
+	return nil
+}
+
+// Interrupt cancels the origin's in-flight turn, reporting false when there
+// is no session. Executors that support it abort the turn natively; others
+// are stopped and replaced with a fresh session, discarding the turn and
+// the conversation with it.
+func (m *Manager) Interrupt(ctx context.Context, origin Origin) (bool, error) {
+	key := m.key(origin)
 
-	sess, ok := m.sessions[chatID]
+	m.mu.Lock()
+	sess, ok := m.sessions[key]
+	m.mu.Unlock()
 	if !ok {
-		return StatusInfo{}
+		return false, nil
+	}
+
+	if in, ok := sess.exec.(executor.Interrupter); ok {
+		if err := in.Interrupt(); err != nil {
+			return true, fmt.Errorf("interrupt executor: %w", err)
+		}
+		slog.Info("turn interrupted", key.logAttrs()...)
+		return true, nil
 	}
-	return StatusInfo{
-		Exists:    true,
-		Workspace: sess.workspace,
-		CreatedAt: sess.createdAt,
+
+	m.remove(key)
+	if _, err := m.getOrCreate(ctx, origin); err != nil {
+		return true, fmt.Errorf("replace session: %w", err)
 	}
+	slog.Info("session replaced to cancel turn", key.logAttrs()...)
+	return true, nil
 }
 
-// Shutdown stops all active sessions.
-func (m *Manager) Shutdown() {
+// InterruptAll stops every turn in progress, for the operator kill switch.
+// Sessions whose executor can't interrupt, or fails to, are stopped and
+// replaced on their next message. It returns how many turns were stopped.
+func (m *Manager) InterruptAll() int {
+	m.mu.Lock()
+	var busy []*Session
+	for _, sess := range m.sessions {
+		if sess.active > 0 {
+			busy = append(busy, sess)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, sess := range busy {
+		if in, ok := sess.exec.(executor.Interrupter); ok {
+			err := in.Interrupt()
+			if err == nil {
+				continue
+			}
+			slog.Warn("interrupt failed, stopping session", append(sess.key.logAttrs(), "error", err)...)
+		}
+		m.removeSession(sess)
+	}
+	if len(busy) > 0 {
+		slog.Warn("interrupted all turns", "turns", len(busy))
+	}
+	return len(busy)
+}
+
+// Status returns the current session state for the origin's session.
+func (m *Manager) Status(origin Origin) StatusInfo {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	for chatID, sess := range m.sessions {
-		slog.Info("stopping session", "chat_id", chatID)
-		sess.exec.Stop()
+	key := m.key(origin)
+	var info StatusInfo
+	if g := m.gates[key]; g != nil {
+		info.TurnStarted, info.Queued = g.started, g.waiting
 	}
-	m.sessions = make(map[int64]*Session)
+	if sess, ok := m.sessions[key]; ok {
+		info.Exists = true
+		info.Workspace = sess.workspace
+		info.CreatedAt = sess.createdAt
+	}
+	return info
 }
 
-// acquire returns a locked, alive session for the chat. If the existing
-// session's executor has died, it is replaced with a fresh one.
-func (m *Manager) acquire(ctx context.Context, chatID int64, username, title string) (*Session, error) {
-	sess, err := m.getOrCreate(ctx, chatID, username, title)
-	if err != nil {
-		return nil, err
+// SetReadOnly turns read-only (no tools) mode on or off for the origin's
+// chat. Tool access is fixed when a process starts, so a change restarts
+// the session before its next turn.
+func (m *Manager) SetReadOnly(origin Origin, on bool) {
+	key := m.key(origin)
+
+	m.mu.Lock()
+	st := m.settingsFor(key)
+	changed := st.readOnly != on
+	st.readOnly = on
+	m.mu.Unlock()
+
+	if changed {
+		m.respawn(key)
 	}
+}
 
-	sess.mu.Lock()
+// Overrides returns the per-chat settings in effect for the origin's chat.
+func (m *Manager) Overrides(origin Origin) Overrides {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st := m.settingsFor(m.key(origin))
+	o := Overrides{Workspace: st.workspace, Model: st.model, Persona: st.persona, ReadOnly: st.readOnly}
+	if st.timeout != nil {
+		timeout := *st.timeout
+		o.Timeout = &timeout
+	}
+	return o
+}
 
-	if sess.exec.Alive() {
-		return sess, nil
+// ClearOverrides drops every per-chat setting for the origin's chat so
+// it runs on the config defaults. The session restarts in place before its
+// next turn, or is reset if the workspace changed or it can't restart.
+func (m *Manager) ClearOverrides(origin Origin) {
+	key := m.key(origin)
+	m.mu.Lock()
+	st := m.settingsFor(key)
+	moved := st.workspace != ""
+	delete(m.settings, key)
+	if sess, ok := m.sessions[key]; ok {
+		m.armIdle(sess)
 	}
+	m.mu.Unlock()
 
-	// Executor died — unlock, replace, and lock the new session.
-	sess.mu.Unlock()
-	m.remove(chatID)
+	if moved {
+		m.remove(key)
+		return
+	}
+	m.respawn(key)
+}
 
-	sess, err = m.getOrCreate(ctx, chatID, username, title)
-	if err != nil {
-		return nil, err
+// ReadOnly reports whether read-only mode is on for the origin's chat.
+func (m *Manager) ReadOnly(origin Origin) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.settingsFor(m.key(origin)).readOnly
+}
+
+// SetModel switches the origin's chat to model, restarting its session
+// before the next turn if the model changed. An empty model returns to
+// claude.model.
+func (m *Manager) SetModel(origin Origin, model string) error {
+	if model != "" && !m.cfg.Claude.AllowsModel(model) {
+		return fmt.Errorf("%w: %q", ErrUnknownModel, model)
 	}
+	key := m.key(origin)
 
-	sess.mu.Lock()
-	return sess, nil
+	m.mu.Lock()
+	st := m.settingsFor(key)
+	changed := st.model != model
+	st.model = model
+	m.mu.Unlock()
+
+	if changed {
+		m.respawn(key)
+	}
+	return nil
+}
+
+// SetPersona sets text the origin's chat appends to its identity document,
+// restarting its session before the next turn if it changed. An empty
+// persona clears it. The persona counts against claude.max_identity_chars.
+func (m *Manager) SetPersona(origin Origin, persona string) error {
+	persona = strings.TrimSpace(persona)
+	if limit := m.cfg.Claude.MaxIdentityChars; limit > 0 && utf8.RuneCountInString(personaHeader+persona) > limit {
+		return fmt.Errorf("%w: %d characters, limit %d", ErrPersonaTooLong, utf8.RuneCountInString(persona), limit)
+	}
+	key := m.key(origin)
+
+	m.mu.Lock()
+	st := m.settingsFor(key)
+	changed := st.persona != persona
+	st.persona = persona
+	m.mu.Unlock()
+
+	if changed {
+		m.respawn(key)
+	}
+	return nil
+}
+
+// Persona returns the origin's chat's persona, or "" if it has none.
+func (m *Manager) Persona(origin Origin) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.settingsFor(m.key(origin)).persona
 }
 
-func (m *Manager) getOrCreate(ctx context.Context, chatID int64, username, title string) (*Session, error) {
+// Model returns the model the origin's chat uses: its running session's,
+// else the chat's override, else claude.model. It may be empty when none
+// is configured and the backend picks.
+func (m *Manager) Model(origin Origin) string {
+	key := m.key(origin)
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	if sess, ok := m.sessions[key]; ok && sess.model != "" {
+		return sess.model
+	}
+	return cmp.Or(m.settingsFor(key).model, m.cfg.Claude.Model)
+}
 
-	if sess, ok := m.sessions[chatID]; ok {
-		return sess, nil
+// QuickActions returns the follow-ups configured for the origin's
+// workspace in workspaces.quick_actions.
+func (m *Manager) QuickActions(origin Origin) []string {
+	return slices.Clone(m.cfg.Workspaces.QuickActions[m.resolveWorkspace(origin)])
+}
+
+// Models returns claude.allowed_models; empty means any model is accepted.
+func (m *Manager) Models() []string {
+	return slices.Clone(m.cfg.Claude.AllowedModels)
+}
+
+// SetIdleTimeout overrides the inactivity timeout for the origin's chat,
+// restarting the running session's timer; 0 disables expiry.
+func (m *Manager) SetIdleTimeout(origin Origin, timeout time.Duration) {
+	key := m.key(origin)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.settingsFor(key).timeout = &timeout
+	if sess, ok := m.sessions[key]; ok {
+		m.armIdle(sess)
 	}
+}
 
-	workDir := m.resolveWorkDir(chatID, username, title)
-	exec := m.factory()
+// Keep pauses expiry of the origin's running session, or resumes it when
+// on is false. A pause lasts at most session.keep_limit, which is returned,
+// before normal reaping resumes on its own. Either way, the idle timeout
+// then counts from the resume.
+func (m *Manager) Keep(origin Origin, on bool) (limit time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sess, ok := m.sessions[m.key(origin)]
+	if !ok {
+		return 0, ErrNoSession
+	}
+	if on {
+		limit = m.cfg.Session.KeepLimit
+		sess.keepUntil = m.now().Add(limit)
+		sess.parked, sess.nudged = false, false
+	} else {
+		sess.keepUntil = time.Time{}
+		sess.lastActive = m.now()
+		sess.nudged = false
+	}
+	m.armIdle(sess)
+	return limit, nil
+}
+
+// IdleTimeout returns the inactivity timeout in effect for the origin's
+// chat; 0 means sessions never expire.
+func (m *Manager) IdleTimeout(origin Origin) time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.idleTimeout(m.key(origin))
+}
+
+// identityUpdatePrefix introduces an identity document re-sent in-band.
+const identityUpdatePrefix = "System update: your identity document has changed. Treat the following as replacing the one you started with.\n\n"
 
-	if err := exec.Start(ctx, workDir, executor.SessionContext{IdentityDoc: m.loadIdentity()}); err != nil {
-		return nil, fmt.Errorf("start executor for chat %d: %w", chatID, err)
+// ReloadIdentity re-reads the soul and shared memory and sends them into
+// the origin's running session as a user message. The system prompt is
+// fixed at spawn, so this is a best-effort in-band refresh: the original
+// identity stays in context, and only /new truly replaces it.
+func (m *Manager) ReloadIdentity(ctx context.Context, origin Origin) (<-chan executor.Event, error) {
+	m.mu.Lock()
+	_, ok := m.sessions[m.key(origin)]
+	m.mu.Unlock()
+	if !ok {
+		return nil, ErrNoSession
 	}
 
-	sess := &Session{
-		chatID:    chatID,
-		workspace: workDir,
-		exec:      exec,
-		createdAt: time.Now(),
+	identity := m.identityFor(m.key(origin))
+	if identity == "" {
+		return nil, ErrNoIdentity
 	}
+	return m.Send(ctx, origin, identityUpdatePrefix+identity)
+}
 
-	m.sessions[chatID] = sess
-	slog.Info("session created", "chat_id", chatID, "workspace", workDir, "executor", exec.Name())
-	return sess, nil
+// Remember appends a timestamped note to the shared memory, which new
+// sessions load as part of their identity. Running sessions are unaffected.
+func (m *Manager) Remember(note string) error {
+	line := fmt.Sprintf("- %s: %s", time.Now().Format("2006-01-02 15:04"), strings.TrimSpace(note))
+	return m.memory.Append(line)
+}
+
+// compactMemoryPrompt asks the agent to rewrite the shared memory, which
+// follows it.
+const compactMemoryPrompt = "Rewrite the shared memory notes below more concisely. Merge duplicates, drop anything outdated or superseded, and keep every fact that is still useful. Reply with only the rewritten notes as a markdown list, with no preamble.\n\n"
+
+// CompactMemory has a one-off agent turn summarize the shared memory and
+// replaces it with the result, returning its size in bytes before and
+// after. Notes remembered while the agent works are kept; if the memory was
+// otherwise edited meanwhile it fails with ErrMemoryChanged.
+func (m *Manager) CompactMemory(ctx context.Context) (before, after int, err error) {
+	memory, err := m.memory.Read()
+	if err != nil {
+		return 0, 0, err
+	}
+	if strings.TrimSpace(memory) == "" {
+		return 0, 0, ErrNoMemory
+	}
+
+	summary, err := m.oneShot(ctx, executor.SessionContext{ReadOnly: true}, compactMemoryPrompt+memory)
+	if err != nil {
+		return 0, 0, fmt.Errorf("summarize memory: %w", err)
+	}
+	if strings.TrimSpace(summary) == "" {
+		return 0, 0, errors.New("summarize memory: empty reply")
+	}
+	if err := m.memory.Rewrite(memory, summary); err != nil {
+		return 0, 0, err
+	}
+
+	compacted, err := m.memory.Read()
+	if err != nil {
+		return 0, 0, err
+	}
+	slog.Info("shared memory compacted", "before", len(memory), "after", len(compacted))
+	return len(memory), len(compacted), nil
+}
+
+// askPrompt frames a question asked outside any chat's session.
+const askPrompt = "Answer the question below briefly, in a few sentences at most. Your reply is shown as a quick inline answer in Telegram, and there is no chance for follow-up.\n\n"
+
+// Ask answers question with a one-off, tool-less turn outside any session,
+// using model when set. It carries the identity but no chat history, and
+// is meant for quick answers such as Telegram inline queries; callers
+// bound it with ctx.
+func (m *Manager) Ask(ctx context.Context, model, question string) (string, error) {
+	sessCtx := executor.SessionContext{ReadOnly: true, Model: model, IdentityDoc: m.loadIdentity("")}
+	answer, err := m.oneShot(ctx, sessCtx, askPrompt+question)
+	if err != nil {
+		return "", fmt.Errorf("ask: %w", err)
+	}
+	return strings.TrimSpace(m.redact(answer)), nil
+}
+
+// Quick answers message with a one-off, tool-less turn on model outside
+// the origin's session, for the session.lanes commands. It carries the
+// chat's identity and persona but none of its conversation.
+func (m *Manager) Quick(ctx context.Context, origin Origin, model, message string) (string, error) {
+	sessCtx := executor.SessionContext{ReadOnly: true, Model: model, IdentityDoc: m.identityFor(m.key(origin))}
+	answer, err := m.oneShot(ctx, sessCtx, message)
+	if err != nil {
+		return "", fmt.Errorf("quick turn: %w", err)
+	}
+	return strings.TrimSpace(m.redact(answer)), nil
+}
+
+// oneShot runs prompt through a short-lived executor started with sessCtx
+// in a scratch directory, outside any chat's session, and returns the reply.
+// Callers pass a read-only sessCtx, since the directory is thrown away.
+func (m *Manager) oneShot(ctx context.Context, sessCtx executor.SessionContext, prompt string) (string, error) {
+	if m.spawnSlots != nil {
+		select {
+		case m.spawnSlots <- struct{}{}:
+			defer func() { <-m.spawnSlots }()
+		case <-ctx.Done():
+			return "", fmt.Errorf("wait for spawn slot: %w", ctx.Err())
+		}
+	}
+
+	dir, err := os.MkdirTemp("", "natron-oneshot-")
+	if err != nil {
+		return "", fmt.Errorf("create scratch dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	exec := m.factory()
+	if err := exec.Start(ctx, dir, sessCtx); err != nil {
+		return "", fmt.Errorf("start executor: %w", err)
+	}
+	defer exec.Stop()
+
+	events, err := exec.Send(ctx, prompt)
+	if err != nil {
+		return "", fmt.Errorf("send to executor: %w", err)
+	}
+	return collectReply(ctx, events)
+}
+
+// reportReset passes a turn's events through, ending it with
+// ErrSessionReset if the session is reset or expires mid-turn. Stopping the
+// executor closes the stream without a terminal event, which would
+// otherwise look like a crash.
+func (m *Manager) reportReset(sess *Session, in <-chan executor.Event) <-chan executor.Event {
+	out := make(chan executor.Event, cap(in))
+	go func() {
+		defer close(out)
+		ended := false
+		for evt := range in {
+			ended = evt.Type == executor.EventDone || evt.Type == executor.EventError
+			out <- evt
+		}
+		m.mu.Lock()
+		removed := sess.removed
+		m.mu.Unlock()
+		if !ended && removed {
+			out <- executor.Event{Type: executor.EventError, Error: ErrSessionReset}
+		}
+	}()
+	return out
+}
+
+// prependReply shows reply ahead of a turn's own output.
+func prependReply(reply string, in <-chan executor.Event) <-chan executor.Event {
+	out := make(chan executor.Event, cap(in)+1)
+	go func() {
+		defer close(out)
+		out <- executor.Event{Type: executor.EventText, Text: reply + "\n\n"}
+		for evt := range in {
+			if evt.Type == executor.EventDone && evt.Text != "" {
+				evt.Text = reply + "\n\n" + evt.Text
+			}
+			out <- evt
+		}
+	}()
+	return out
+}
+
+// collectReply waits for a turn's final text. It gives up when ctx ends,
+// leaving the caller to stop or replace the executor.
+func collectReply(ctx context.Context, events <-chan executor.Event) (string, error) {
+	var text strings.Builder
+	for {
+		select {
+		case evt, ok := <-events:
+			if !ok {
+				return "", errors.New("response ended abruptly")
+			}
+			switch evt.Type {
+			case executor.EventText:
+				text.WriteString(evt.Text)
+			case executor.EventDone:
+				if evt.Text != "" {
+					return evt.Text, nil
+				}
+				return text.String(), nil
+			case executor.EventError:
+				return "", evt.Error
+			}
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
 }
 
-func (m *Manager) remove(chatID int64) {
+// LastResponse returns the raw text of the origin's most recent completed
+// response, capped at session.max_response_length runes. truncated reports
+// whether the cap cut it short.
+func (m *Manager) LastResponse(origin Origin) (text string, truncated bool) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	last := m.lastResp[m.key(origin)]
+	return last.text, last.truncated
+}
 
-	if sess, ok := m.sessions[chatID]; ok {
-		sess.exec.Stop()
-		delete(m.sessions, chatID)
-		slog.Info("session removed", "chat_id", chatID)
+// Capabilities reports what the origin's backend supports. Without a live
+// session it asks a fresh, unstarted executor from the factory.
+func (m *Manager) Capabilities(origin Origin) executor.ExecutorCapabilities {
+	m.mu.Lock()
+	sess, ok := m.sessions[m.key(origin)]
+	m.mu.Unlock()
+	if ok {
+		return executor.CapabilitiesOf(sess.exec)
 	}
+	return executor.CapabilitiesOf(m.factory())
 }
 
-// loadIdentity reads the soul and memory files and combines them into a
-// single string for use as a system prompt addition. Missing files are
-// silently skipped — neither is required for the bot to function.
-func (m *Manager) loadIdentity() string {
-	var parts []string
+// Activity returns up to n recent activity lines from the origin's
+// session. ok is false when there is no session or its executor keeps no
+// activity log.
+func (m *Manager) Activity(origin Origin, n int) (lines []string, ok bool) {
+	m.mu.Lock()
+	sess, found := m.sessions[m.key(origin)]
+	m.mu.Unlock()
+	if !found {
+		return nil, false
+	}
+	logger, ok := sess.exec.(executor.ActivityLogger)
+	if !ok {
+		return nil, false
+	}
+	return logger.RecentActivity(n), true
+}
 
-	if soul, err := os.ReadFile(m.cfg.Claude.SoulPath); err == nil && len(soul) > 0 {
-		parts = append(parts, strings.TrimSpace(string(soul)))
+// LastTurnRaw returns the raw executor output of the origin's most recent
+// turn. ok is false when there is no session or its executor keeps none.
+func (m *Manager) LastTurnRaw(origin Origin) (lines []string, ok bool) {
+	m.mu.Lock()
+	sess, found := m.sessions[m.key(origin)]
+	m.mu.Unlock()
+	if !found {
+		return nil, false
+	}
+	rec, ok := sess.exec.(executor.RawRecorder)
+	if !ok {
+		return nil, false
 	}
-	if memory, err := os.ReadFile(m.cfg.Claude.MemoryPath); err == nil && len(memory) > 0 {
-		parts = append(parts, "---\n\n## Shared Memory\n\n"+strings.TrimSpace(string(memory)))
+	lines = rec.LastTurnRaw()
+	// The lines end up in the chat, so they're redacted like the response.
+	for i, line := range lines {
+		lines[i] = m.redact(line)
 	}
+	return lines, true
+}
 
-	return strings.Join(parts, "\n\n")
+// WorkDir returns the workspace directory the origin resolves to. It does
+// not create a session.
+func (m *Manager) WorkDir(origin Origin) string {
+	return m.resolveWorkDir(origin)
 }
 
-// resolveWorkDir maps a chat to its workspace directory. Resolution order:
-//  1. @username (config key "@mygroup" or "mygroup")
-//  2. Chat title (e.g. "My Team")
-//  3. Numeric chat ID string (e.g. "-1001234567890")
-//  4. Default workspace
-func (m *Manager) resolveWorkDir(chatID int64, username, title string) string {
-	// Username lookup — accept keys with or without leading @
-	if username != "" {
-		uname := strings.TrimPrefix(username, "@")
-		if name, ok := m.cfg.Workspaces.ChatMap["@"+uname]; ok {
-			return filepath.Join(m.cfg.Workspaces.BasePath, name)
+// PromptDir is where Prompt looks for templates, relative to the root of
+// the origin's workspace.
+const PromptDir = ".natron/prompts"
+
+// Prompt reads the template <workspace>/.natron/prompts/<name>.md for the
+// origin. Per-user subdirectories share their workspace's prompts. Lookups
+// are confined to the prompts directory, so neither the name nor a symlink
+// can reach files outside it.
+func (m *Manager) Prompt(origin Origin, name string) (string, error) {
+	if name == "" || strings.HasPrefix(name, ".") || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("prompt %q: %w", name, ErrInvalidPromptName)
+	}
+
+	dir := filepath.Join(m.cfg.Workspaces.BasePath, m.resolveWorkspace(origin), PromptDir)
+	root, err := os.OpenRoot(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("prompt %q: %w", name, ErrNoPrompt)
+	}
+	if err != nil {
+		return "", fmt.Errorf("open prompt dir: %w", err)
+	}
+	defer root.Close()
+
+	f, err := root.Open(name + ".md")
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("prompt %q: %w", name, ErrNoPrompt)
+	}
+	if err != nil {
+		return "", fmt.Errorf("open prompt: %w", err)
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return "", fmt.Errorf("read prompt: %w", err)
+	}
+	text := strings.TrimSpace(string(data))
+	if text == "" {
+		return "", fmt.Errorf("prompt %q is empty: %w", name, ErrNoPrompt)
+	}
+	return text, nil
+}
+
+// Workspaces lists the workspaces named in the config (chat-map targets and
+// the default), marking the one the origin resolves to. Other directories
+// under the base path are only included when workspaces.list_directories is
+// set, since they may be unrelated to the bot.
+func (m *Manager) Workspaces(origin Origin) []WorkspaceInfo {
+	names := map[string]bool{m.cfg.Workspaces.Default: true}
+	for _, name := range m.cfg.Workspaces.ChatMap {
+		names[name] = true
+	}
+	if m.cfg.Workspaces.ListDirectories {
+		entries, err := os.ReadDir(m.cfg.Workspaces.BasePath)
+		if err != nil {
+			slog.Warn("list workspaces", "error", err)
 		}
-		if name, ok := m.cfg.Workspaces.ChatMap[uname]; ok {
-			return filepath.Join(m.cfg.Workspaces.BasePath, name)
+		for _, e := range entries {
+			if e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+				names[e.Name()] = true
+			}
 		}
 	}
-	// Title lookup
-	if title != "" {
-		if name, ok := m.cfg.Workspaces.ChatMap[title]; ok {
-			return filepath.Join(m.cfg.Workspaces.BasePath, name)
+
+	active := m.resolveWorkspace(origin)
+	list := make([]WorkspaceInfo, 0, len(names))
+	for name := range names {
+		if m.cfg.Workspaces.Permits(name, origin.UserID) {
+			list = append(list, WorkspaceInfo{Name: name, Active: name == active})
 		}
 	}
-	// Numeric chat ID lookup
-	if name, ok := m.cfg.Workspaces.ChatMap[fmt.Sprintf("%d", chatID)]; ok {
-		return filepath.Join(m.cfg.Workspaces.BasePath, name)
+	slices.SortFunc(list, func(a, b WorkspaceInfo) int { return strings.Compare(a.Name, b.Name) })
+	return list
+}
+
+// Snapshot returns a copy of every session's state, ordered by chat.
+func (m *Manager) Snapshot() []SessionSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snaps := make([]SessionSnapshot, 0, len(m.sessions))
+	for key, sess := range m.sessions {
+		snap := SessionSnapshot{
+			ChatID:       key.chatID,
+			UserID:       key.userID,
+			ThreadID:     key.threadID,
+			Workspace:    sess.workspace,
+			CreatedAt:    sess.createdAt,
+			LastActivity: sess.lastActive,
+			Backend:      sess.exec.Name(),
+			Model:        sess.model,
+			Alive:        sess.exec.Alive(),
+			Busy:         sess.active > 0,
+			Parked:       sess.parked,
+			CostUSD:      sess.spentUSD + sess.costUSD,
+		}
+		if r, ok := sess.exec.(executor.Resumer); ok {
+			snap.SessionID = r.SessionID()
+		}
+		snaps = append(snaps, snap)
+	}
+	slices.SortFunc(snaps, func(a, b SessionSnapshot) int {
+		return cmp.Or(cmp.Compare(a.ChatID, b.ChatID), cmp.Compare(a.UserID, b.UserID), cmp.Compare(a.ThreadID, b.ThreadID))
+	})
+	return snaps
+}
+
+// SnapshotHandler serves Snapshot as JSON, for mounting on an admin or
+// health HTTP server.
+func (m *Manager) SnapshotHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(m.Snapshot()); err != nil {
+			slog.Error("encode session snapshot", "error", err)
+		}
+	})
+}
+
+// Flush persists buffered state, waiting at most session.flush_timeout.
+// Only transcripts are buffered; memory notes are written as they're made.
+func (m *Manager) Flush() error {
+	if m.transcripts == nil {
+		return nil
+	}
+	ctx := context.Background()
+	if d := m.cfg.Session.FlushTimeout; d > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+	return m.transcripts.Flush(ctx)
+}
+
+// Shutdown stops all active sessions and flushes pending transcript entries.
+// If the flush times out, entries still queued are dropped.
+func (m *Manager) Shutdown() {
+	m.mu.Lock()
+	for key, sess := range m.sessions {
+		slog.Info("stopping session", key.logAttrs()...)
+		if sess.idle != nil {
+			sess.idle.Stop()
+		}
+		sess.exec.Stop()
+	}
+	m.sessions = make(map[sessionKey]*Session)
+	m.mu.Unlock()
+
+	if m.transcripts == nil {
+		return
+	}
+	if err := m.Flush(); err != nil {
+		slog.Warn("flush on shutdown failed", "error", err)
+		m.transcripts.Abort()
+		return
+	}
+	m.transcripts.Close()
+}
+
+// logLatency is a Tap that logs how long the user waited for the first
+// streamed text and for the whole turn.
+func logLatency(turn Turn) func(executor.Event) {
+	var firstToken time.Duration
+	return func(evt executor.Event) {
+		switch evt.Type {
+		case executor.EventText:
+			if firstToken == 0 {
+				firstToken = time.Since(turn.Started)
+				slog.Info("first token", "chat_id", turn.Origin.ChatID, "request_id", turn.RequestID, "latency", firstToken)
+			}
+		case executor.EventDone:
+			slog.Info("turn complete", "chat_id", turn.Origin.ChatID, "request_id", turn.RequestID,
+				"first_token", firstToken, "total", time.Since(turn.Started))
+		}
+	}
+}
+
+// retainResponse is a Tap that keeps each chat's latest final response for
+// LastResponse.
+func (m *Manager) retainResponse(turn Turn) func(executor.Event) {
+	key := m.key(turn.Origin)
+	var streamed strings.Builder
+	return func(evt executor.Event) {
+		switch evt.Type {
+		case executor.EventText:
+			streamed.WriteString(evt.Text)
+		case executor.EventDone:
+			last := lastResponse{text: evt.Text}
+			if last.text == "" {
+				last.text = streamed.String()
+			}
+			if limit := m.cfg.Session.MaxResponseLength; limit > 0 && utf8.RuneCountInString(last.text) > limit {
+				last.text = string([]rune(last.text)[:limit])
+				last.truncated = true
+			}
+			m.mu.Lock()
+			m.lastResp[key] = last
+			m.mu.Unlock()
+		}
+	}
+}
+
+// recordTranscript is a Tap that logs each turn's message and final response
+// (or error) to the transcript writer.
+func (m *Manager) recordTranscript(turn Turn) func(executor.Event) {
+	var streamed strings.Builder
+	return func(evt executor.Event) {
+		entry := transcript.Entry{
+			Started: turn.Started,
+			ChatID:  turn.Origin.ChatID,
+			UserID:  turn.Origin.UserID,
+			Message: turn.Message,
+		}
+		switch evt.Type {
+		case executor.EventText:
+			streamed.WriteString(evt.Text)
+			return
+		case executor.EventDone:
+			entry.Response = evt.Text
+			if entry.Response == "" {
+				entry.Response = streamed.String()
+			}
+			entry.CostUSD = evt.TurnCostUSD
+			entry.InputTokens = evt.InputTokens
+			entry.OutputTokens = evt.OutputTokens
+		case executor.EventError:
+			entry.Response = streamed.String()
+			if evt.Error != nil {
+				entry.Error = evt.Error.Error()
+			}
+		default:
+			return
+		}
+		entry.Finished = time.Now()
+		m.transcripts.Record(entry)
+	}
+}
+
+// acquire returns a locked, alive session for the chat. If the existing
+// session's executor has died, it is replaced with a fresh one. Start
+// failures and deaths count as crashes; once a chat exceeds the crash limit
+// acquire refuses with ErrSessionFailing until the chat is Reset.
+func (m *Manager) acquire(ctx context.Context, origin Origin) (*Session, error) {
+	key := m.key(origin)
+	if m.failing(key) {
+		return nil, ErrSessionFailing
+	}
+
+	sess, err := m.getOrCreate(ctx, origin)
+	if err != nil {
+		// A misconfigured or forbidden workspace isn't a crash; keep
+		// reporting it as is.
+		var notDir *WorkspaceNotDirError
+		if !errors.As(err, &notDir) && !errors.Is(err, ErrWorkspaceForbidden) {
+			m.recordCrash(key)
+		}
+		return nil, err
+	}
+
+	sess.mu.Lock()
+
+	if sess.exec.Alive() {
+		return m.applyPending(ctx, origin, sess)
+	}
+
+	// Executor gone — unlock, replace, and lock the new session. It only
+	// counts as a crash if nothing else (expiry, reset, downgrade) already
+	// swapped the session out while this caller waited for the lock.
+	sess.mu.Unlock()
+	m.mu.Lock()
+	replaced := m.sessions[key] != sess
+	m.mu.Unlock()
+	if !replaced {
+		m.remove(sess.key)
+		m.recordCrash(key)
+		if m.failing(key) {
+			return nil, ErrSessionFailing
+		}
+	}
+
+	sess, err = m.getOrCreate(ctx, origin)
+	if err != nil {
+		if !errors.Is(err, ErrWorkspaceForbidden) {
+			m.recordCrash(key)
+		}
+		return nil, err
+	}
+
+	sess.mu.Lock()
+	return sess, nil
+}
+
+// spawnContext fills in the identity, workspace brief and chat history a
+// new process for origin starts with.
+func (m *Manager) spawnContext(ctx context.Context, origin Origin, sessCtx executor.SessionContext) executor.SessionContext {
+	sessCtx.IdentityDoc = m.identityFor(m.key(origin))
+	sessCtx.WorkspaceInfo = m.loadBrief(origin)
+	sessCtx.RecentHistory = recentHistory(ctx)
+	return sessCtx
+}
+
+// applyPending replaces a locked session that has a budget downgrade or
+// changed spawn settings pending with one on the new model and settings,
+// resuming the conversation when the executor supports it. The returned
+// session is locked.
+func (m *Manager) applyPending(ctx context.Context, origin Origin, sess *Session) (*Session, error) {
+	m.mu.Lock()
+	downgrade := sess.downgrade
+	pending := downgrade != "" || sess.respawn
+	spent := sess.spentUSD + sess.costUSD
+	st := m.settingsFor(sess.key)
+	sessCtx := executor.SessionContext{ReadOnly: st.readOnly, Model: cmp.Or(downgrade, st.model)}
+	m.mu.Unlock()
+	if !pending {
+		return sess, nil
+	}
+
+	if r, ok := sess.exec.(executor.Restarter); ok {
+		return m.restart(ctx, origin, sess, r, sessCtx, spent)
+	}
+	if r, ok := sess.exec.(executor.Resumer); ok {
+		sessCtx.ResumeID = r.SessionID()
+	}
+	next, err := m.spawn(ctx, origin, sess.key, sessCtx)
+	if err != nil {
+		sess.mu.Unlock()
+		return nil, fmt.Errorf("restart session: %w", err)
+	}
+	next.spentUSD = spent
+
+	m.mu.Lock()
+	if m.sessions[sess.key] != sess {
+		// Reset or expired meanwhile; let the caller start over.
+		m.mu.Unlock()
+		next.exec.Stop()
+		sess.mu.Unlock()
+		return m.acquire(ctx, origin)
+	}
+	m.sessions[sess.key] = next
+	if sess.idle != nil {
+		sess.idle.Stop()
+	}
+	m.armIdle(next)
+	m.mu.Unlock()
+
+	next.mu.Lock()
+	sess.mu.Unlock()
+	sess.exec.Stop()
+	slog.Info("session restarted", append(sess.key.logAttrs(), "model", sessCtx.Model, "downgrade", downgrade != "", "spent_usd", spent)...)
+	return next, nil
+}
+
+// restart restarts a locked session's executor in place with sessCtx,
+// keeping the conversation. On failure the session is unlocked and left
+// dead for the next message to replace.
+func (m *Manager) restart(ctx context.Context, origin Origin, sess *Session, r executor.Restarter, sessCtx executor.SessionContext, spent float64) (*Session, error) {
+	err := r.Restart(ctx, m.spawnContext(ctx, origin, sessCtx))
+	if err == nil {
+		err = m.awaitReady(ctx, sess.key, sess.exec)
+	}
+	if err != nil {
+		sess.mu.Unlock()
+		return nil, fmt.Errorf("restart session: %w", err)
+	}
+
+	m.mu.Lock()
+	downgrade := sess.downgrade != ""
+	sess.model = sessCtx.Model
+	sess.spentUSD = spent
+	sess.costUSD = 0
+	sess.downgrade = ""
+	sess.respawn = false
+	m.mu.Unlock()
+	slog.Info("session restarted", append(sess.key.logAttrs(), "model", sessCtx.Model, "downgrade", downgrade, "spent_usd", spent, "in_place", true)...)
+	return sess, nil
+}
+
+// respawn applies changed spawn settings to key's session. Executors that
+// can restart in place do so before the next turn, keeping the
+// conversation; any other session is reset.
+func (m *Manager) respawn(key sessionKey) {
+	m.mu.Lock()
+	sess, ok := m.sessions[key]
+	if !ok {
+		m.mu.Unlock()
+		return
+	}
+	_, restarts := sess.exec.(executor.Restarter)
+	sess.respawn = restarts
+	m.mu.Unlock()
+
+	if !restarts {
+		m.remove(key)
+	}
+}
+
+// trackCost records each turn's reported spend and schedules a downgrade
+// once the conversation crosses claude.downgrade.threshold of the budget.
+func (m *Manager) trackCost(turn Turn) func(executor.Event) {
+	key := m.key(turn.Origin)
+	return func(evt executor.Event) {
+		if evt.Type != executor.EventDone || evt.CostUSD == 0 {
+			return
+		}
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		sess, ok := m.sessions[key]
+		if !ok {
+			return
+		}
+		sess.costUSD = evt.CostUSD
+
+		budget := m.cfg.Claude.MaxBudgetUSD * m.cfg.Claude.Downgrade.Threshold
+		if sess.spentUSD+sess.costUSD < budget {
+			return
+		}
+		current := sess.model
+		if current == "" {
+			current = m.cfg.Claude.Model
+		}
+		sess.downgrade = nextModel(m.cfg.Claude.Downgrade.Ladder, current)
+	}
+}
+
+// nextModel returns the model after current in ladder, or "" when current
+// is the cheapest or not on the ladder.
+func nextModel(ladder []string, current string) string {
+	i := slices.Index(ladder, current)
+	if i < 0 || i+1 >= len(ladder) {
+		return ""
+	}
+	return ladder[i+1]
+}
+
+// recordCrash notes an executor start failure or death for key.
+func (m *Manager) recordCrash(key sessionKey) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.crashes[key] = append(m.recentCrashes(key), time.Now())
+	slog.Warn("executor crashed", append(key.logAttrs(), "recent_crashes", len(m.crashes[key]))...)
+}
+
+// failing reports whether key has crashed too often within the crash window.
+func (m *Manager) failing(key sessionKey) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	limit := m.cfg.Session.CrashLimit
+	return limit > 0 && len(m.recentCrashes(key)) >= limit
+}
+
+// recentCrashes returns key's crash times that fall within the crash
+// window. Callers must hold m.mu.
+func (m *Manager) recentCrashes(key sessionKey) []time.Time {
+	cutoff := time.Now().Add(-m.cfg.Session.CrashWindow)
+	crashes := m.crashes[key]
+	for len(crashes) > 0 && crashes[0].Before(cutoff) {
+		crashes = crashes[1:]
+	}
+	return crashes
+}
+
+// clearCrashes is a Tap that forgets a chat's crash history once a turn
+// completes successfully.
+func (m *Manager) clearCrashes(turn Turn) func(executor.Event) {
+	key := m.key(turn.Origin)
+	return func(evt executor.Event) {
+		if evt.Type == executor.EventDone {
+			m.mu.Lock()
+			delete(m.crashes, key)
+			m.mu.Unlock()
+		}
+	}
+}
+
+// getOrCreate returns the chat's session, starting one if needed. Only one
+// caller per chat spawns at a time; the rest share its result.
+func (m *Manager) getOrCreate(ctx context.Context, origin Origin) (*Session, error) {
+	key := m.key(origin)
+
+	for {
+		m.mu.Lock()
+		if sess, ok := m.sessions[key]; ok {
+			m.mu.Unlock()
+			return m.permitted(sess, origin)
+		}
+		p, waiting := m.starting[key]
+		if !waiting {
+			p = &pendingSpawn{done: make(chan struct{})}
+			m.starting[key] = p
+		}
+		st := m.settingsFor(key)
+		sessCtx := executor.SessionContext{ReadOnly: st.readOnly, Model: st.model}
+		m.mu.Unlock()
+
+		if waiting {
+			select {
+			case <-p.done:
+			case <-ctx.Done():
+				return nil, fmt.Errorf("wait for session start: %w", ctx.Err())
+			}
+			// The spawn was abandoned by its caller, not failed; try again.
+			if errors.Is(p.err, context.Canceled) && ctx.Err() == nil {
+				continue
+			}
+			if p.err != nil {
+				return nil, p.err
+			}
+			return m.permitted(p.sess, origin)
+		}
+
+		// Spawn without holding m.mu so a slow start doesn't stall other
+		// chats; concurrent first messages for this one wait on p.
+		p.sess, p.err = m.spawn(ctx, origin, key, sessCtx)
+
+		m.mu.Lock()
+		delete(m.starting, key)
+		if p.err == nil {
+			m.sessions[key] = p.sess
+			m.armIdle(p.sess)
+		}
+		m.mu.Unlock()
+		close(p.done)
+
+		if p.err != nil {
+			return nil, p.err
+		}
+		slog.Info("session created", append(key.logAttrs(), "workspace", p.sess.workspace, "executor", p.sess.exec.Name())...)
+		return p.sess, nil
+	}
+}
+
+// permitted returns sess if origin's user may use its workspace. A chat's
+// session can be shared, so one started by a user allowed in a restricted
+// workspace isn't handed to one who isn't.
+func (m *Manager) permitted(sess *Session, origin Origin) (*Session, error) {
+	if !m.cfg.Workspaces.Permits(sess.wsName, origin.UserID) {
+		return nil, fmt.Errorf("workspace %q: %w", sess.wsName, ErrWorkspaceForbidden)
+	}
+	return sess, nil
+}
+
+// spawn starts a new executor for key, completing sessCtx with the identity
+// and workspace brief. When session.max_concurrent_spawns
+// is set it first waits for a free spawn slot, so a burst of first messages
+// after a restart doesn't launch every process at once.
+func (m *Manager) spawn(ctx context.Context, origin Origin, key sessionKey, sessCtx executor.SessionContext) (*Session, error) {
+	if m.spawnSlots != nil {
+		select {
+		case m.spawnSlots <- struct{}{}:
+			defer func() { <-m.spawnSlots }()
+		case <-ctx.Done():
+			return nil, fmt.Errorf("wait for spawn slot: %w", ctx.Err())
+		}
+	}
+
+	workDir := m.resolveWorkDir(origin)
+	// A file at the workspace path makes the CLI fail with an opaque error.
+	wsDir := filepath.Join(m.cfg.Workspaces.BasePath, m.resolveWorkspace(origin))
+	if info, err := os.Stat(wsDir); err == nil && !info.IsDir() {
+		return nil, &WorkspaceNotDirError{Path: wsDir}
+	}
+	if key.userID != 0 {
+		// Per-user directories are created on demand; the parent
+		// workspace is expected to exist already.
+		if err := os.MkdirAll(workDir, 0o755); err != nil {
+			return nil, fmt.Errorf("create user workspace: %w", err)
+		}
+	}
+	sessCtx = m.spawnContext(ctx, origin, sessCtx)
+
+	start := func() (executor.Executor, error) {
+		exec := m.factory()
+		if err := exec.Start(ctx, workDir, sessCtx); err != nil {
+			return nil, fmt.Errorf("start executor for chat %d: %w", origin.ChatID, err)
+		}
+		if err := m.awaitReady(ctx, key, exec); err != nil {
+			exec.Stop()
+			return nil, err
+		}
+		return exec, nil
+	}
+	exec, err := start()
+	if err != nil {
+		return nil, err
+	}
+
+	wsName := m.resolveWorkspace(origin)
+	var initReply string
+	// A resumed conversation was already initialized.
+	if init := m.cfg.Workspaces.InitPrompts[wsName]; init.Prompt != "" && sessCtx.ResumeID == "" {
+		reply, err := m.runInit(ctx, exec, init)
+		switch {
+		case err != nil:
+			// The init turn may still be running; start over without it.
+			slog.Warn("init prompt failed, starting without it", append(key.logAttrs(), "workspace", wsName, "error", err)...)
+			exec.Stop()
+			if exec, err = start(); err != nil {
+				return nil, err
+			}
+		case init.Show:
+			initReply = reply
+		}
+	}
+
+	now := m.now()
+	return &Session{
+		key:        key,
+		workspace:  workDir,
+		wsName:     wsName,
+		model:      sessCtx.Model,
+		exec:       exec,
+		createdAt:  now,
+		lastActive: now,
+		initReply:  initReply,
+	}, nil
+}
+
+// runInit sends a workspace's init prompt as a new session's first turn
+// and waits, up to its timeout, for the reply.
+func (m *Manager) runInit(ctx context.Context, exec executor.Executor, init config.InitPrompt) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, init.Timeout)
+	defer cancel()
+	events, err := exec.Send(ctx, init.Prompt)
+	if err != nil {
+		return "", fmt.Errorf("send init prompt: %w", err)
+	}
+	return collectReply(ctx, events)
+}
+
+// awaitReady waits up to session.ready_timeout for exec's startup
+// handshake so the first message doesn't race it. A timeout is logged and
+// the session used anyway; only cancellation is an error.
+func (m *Manager) awaitReady(ctx context.Context, key sessionKey, exec executor.Executor) error {
+	r, ok := exec.(executor.Readier)
+	timeout := m.cfg.Session.ReadyTimeout
+	if !ok || timeout <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-r.Ready():
+		return nil
+	case <-timer.C:
+		slog.Warn("executor not ready before timeout, continuing", append(key.logAttrs(), "timeout", timeout)...)
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("wait for executor ready: %w", ctx.Err())
+	}
+}
+
+// turnGate serializes a chat's turns: a message sent while a turn is
+// streaming waits until that turn's stream ends. It is kept per chat rather
+// than per session, so it holds across resets and respawns.
+type turnGate struct {
+	slot chan struct{} // Holds a token while a turn runs
+
+	// Guarded by Manager.mu.
+	started time.Time // When the running turn began; zero when idle
+	waiting int       // Sends queued behind it
+}
+
+// waitTurn blocks until key has no turn running, or ctx ends, and claims
+// the chat for a new one. With session.max_concurrent_turns it then also
+// waits for one of the global turn slots. The returned func releases both.
+func (m *Manager) waitTurn(ctx context.Context, key sessionKey) (func(), error) {
+	m.mu.Lock()
+	g := m.gates[key]
+	if g == nil {
+		g = &turnGate{slot: make(chan struct{}, 1)}
+		m.gates[key] = g
+	}
+	g.waiting++
+	m.mu.Unlock()
+
+	select {
+	case g.slot <- struct{}{}:
+	case <-ctx.Done():
+		m.mu.Lock()
+		g.waiting--
+		m.mu.Unlock()
+		return nil, fmt.Errorf("wait for running turn: %w", ctx.Err())
+	}
+
+	if m.turnSlots != nil {
+		select {
+		case m.turnSlots <- struct{}{}:
+		case <-ctx.Done():
+			m.mu.Lock()
+			g.waiting--
+			m.mu.Unlock()
+			<-g.slot
+			return nil, fmt.Errorf("wait for turn slot: %w", ctx.Err())
+		}
+	}
+
+	m.mu.Lock()
+	g.waiting--
+	g.started = m.now()
+	m.mu.Unlock()
+	return func() {
+		m.mu.Lock()
+		g.started = time.Time{}
+		m.mu.Unlock()
+		if m.turnSlots != nil {
+			<-m.turnSlots
+		}
+		<-g.slot
+	}, nil
+}
+
+// TurnsFull reports whether session.max_concurrent_turns turns are in
+// flight, so a new one from origin would wait for another chat's turn. It
+// is false while origin's own chat has a turn running, since the new one
+// queues behind that first.
+func (m *Manager) TurnsFull(origin Origin) bool {
+	if m.turnSlots == nil || len(m.turnSlots) < cap(m.turnSlots) {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	g := m.gates[m.key(origin)]
+	return g == nil || g.started.IsZero()
+}
+
+// beginTurn marks a turn as streaming, pausing the session's idle timer.
+func (m *Manager) beginTurn(sess *Session) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sess.active++
+	sess.parked = false
+	if sess.idle != nil {
+		sess.idle.Stop()
+		sess.idle = nil
+	}
+}
+
+// endTurn records activity when a turn's stream ends and restarts the
+// idle timer once no turns remain.
+func (m *Manager) endTurn(sess *Session) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sess.active--
+	sess.lastActive = m.now()
+	sess.nudged = false
+	m.armIdle(sess)
+}
+
+// armIdle (re)starts sess's idle timer for its next stage, parking,
+// nudging or reaping, measured from its last activity, or for the end of a
+// /keep pause. Callers hold m.mu.
+func (m *Manager) armIdle(sess *Session) {
+	if sess.idle != nil {
+		sess.idle.Stop()
+		sess.idle = nil
+	}
+	if sess.active > 0 {
+		return
+	}
+	if !sess.keepUntil.IsZero() {
+		wait := max(sess.keepUntil.Sub(m.now()), 0)
+		sess.idle = time.AfterFunc(wait, func() { m.expire(sess) })
+		return
+	}
+	timeout := m.idleTimeout(sess.key)
+	next := timeout
+	if m.nudgeDue(sess, timeout) {
+		next = timeout - m.cfg.Session.IdleNudge
+	}
+	if m.parkDue(sess, timeout) {
+		next = min(next, m.cfg.Session.ParkAfter)
+	}
+	if next <= 0 {
+		return
+	}
+	wait := max(next-m.now().Sub(sess.lastActive), 0)
+	sess.idle = time.AfterFunc(wait, func() { m.expire(sess) })
+}
+
+// parkDue reports whether sess still has a park stage ahead of its reap
+// timeout. Callers hold m.mu.
+func (m *Manager) parkDue(sess *Session, timeout time.Duration) bool {
+	park := m.cfg.Session.ParkAfter
+	return park > 0 && !sess.parked && (timeout <= 0 || park < timeout)
+}
+
+// nudgeDue reports whether sess still has an idle nudge ahead of its reap
+// timeout. Callers hold m.mu.
+func (m *Manager) nudgeDue(sess *Session, timeout time.Duration) bool {
+	nudge := m.cfg.Session.IdleNudge
+	return m.nudge != nil && nudge > 0 && !sess.nudged && timeout > nudge && !m.keepWarm(sess)
+}
+
+// OnIdleNudge registers fn to be told, once per idle period, that the
+// origin's session will be reaped in left. It's only called with
+// session.idle_nudge set, and outside the manager's lock.
+func (m *Manager) OnIdleNudge(fn func(origin Origin, left time.Duration)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nudge = fn
+}
+
+// OnReap registers fn to be told when the origin's session is stopped for
+// inactivity. Resets and shutdown don't call it. It's called outside the
+// manager's lock.
+func (m *Manager) OnReap(fn func(origin Origin)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reaped = fn
+}
+
+// expire advances sess through its idle stages: once idle for
+// session.park_after it's marked parked and kept running, session.idle_nudge
+// before its timeout the chat is nudged, and once idle for its full timeout
+// it's stopped. Checks guard against timers that fired
+// while being stopped. Keep-warm sessions are touched and re-armed instead
+// of stopped, and a /keep pause that runs out resumes the idle timeout.
+func (m *Manager) expire(sess *Session) {
+	m.mu.Lock()
+	if m.sessions[sess.key] != sess || sess.active > 0 {
+		m.mu.Unlock()
+		return
+	}
+	if !sess.keepUntil.IsZero() {
+		resumed := !m.now().Before(sess.keepUntil)
+		if resumed {
+			sess.keepUntil = time.Time{}
+			sess.lastActive = m.now()
+			sess.nudged = false
+		}
+		m.armIdle(sess)
+		m.mu.Unlock()
+		if resumed {
+			slog.Info("keep limit reached, idle timeout resumed", append(sess.key.logAttrs(), "limit", m.cfg.Session.KeepLimit)...)
+		}
+		return
+	}
+	timeout := m.idleTimeout(sess.key)
+	idle := m.now().Sub(sess.lastActive)
+	if m.nudgeDue(sess, timeout) && idle >= timeout-m.cfg.Session.IdleNudge && idle < timeout {
+		sess.nudged = true
+		m.armIdle(sess)
+		nudge, left := m.nudge, timeout-idle
+		m.mu.Unlock()
+		slog.Info("session nudged before expiry", append(sess.key.logAttrs(), "left", left)...)
+		nudge(Origin{ChatID: sess.key.chatID, UserID: sess.key.userID, ThreadID: sess.key.threadID}, left)
+		return
+	}
+	if m.parkDue(sess, timeout) {
+		if idle < m.cfg.Session.ParkAfter {
+			m.mu.Unlock()
+			return
+		}
+		sess.parked = true
+		m.armIdle(sess)
+		m.mu.Unlock()
+		slog.Info("session parked", append(sess.key.logAttrs(), "idle", m.cfg.Session.ParkAfter)...)
+		return
+	}
+	if timeout <= 0 || idle < timeout {
+		m.mu.Unlock()
+		return
+	}
+	if m.keepWarm(sess) {
+		sess.lastActive = m.now()
+		m.armIdle(sess)
+		m.mu.Unlock()
+		slog.Debug("keeping session warm", sess.key.logAttrs()...)
+		return
+	}
+	delete(m.sessions, sess.key)
+	sess.idle = nil
+	reaped := m.reaped
+	m.mu.Unlock()
+
+	sess.exec.Stop()
+	slog.Info("session expired", append(sess.key.logAttrs(), "idle", timeout)...)
+	if reaped != nil {
+		reaped(Origin{ChatID: sess.key.chatID, UserID: sess.key.userID, ThreadID: sess.key.threadID})
+	}
+}
+
+// keepWarm reports whether sess's chat ID or workspace is listed in
+// session.keep_warm.
+func (m *Manager) keepWarm(sess *Session) bool {
+	chatID := strconv.FormatInt(sess.key.chatID, 10)
+	for _, entry := range m.cfg.Session.KeepWarm {
+		if entry == chatID || entry == sess.wsName {
+			return true
+		}
+	}
+	return false
+}
+
+// idleTimeout returns the effective inactivity timeout for key. Callers
+// hold m.mu.
+func (m *Manager) idleTimeout(key sessionKey) time.Duration {
+	if t := m.settingsFor(key).timeout; t != nil {
+		return *t
+	}
+	return m.cfg.Session.InactivityTimeout
+}
+
+func (m *Manager) remove(key sessionKey) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if sess, ok := m.sessions[key]; ok {
+		m.drop(sess)
+	}
+}
+
+// removeSession removes sess if it's still its chat's session, leaving a
+// replacement started meanwhile running.
+func (m *Manager) removeSession(sess *Session) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.sessions[sess.key] == sess {
+		m.drop(sess)
+	}
+}
+
+// drop stops sess and forgets it. Callers must hold m.mu.
+func (m *Manager) drop(sess *Session) {
+	if sess.idle != nil {
+		sess.idle.Stop()
+	}
+	sess.removed = true
+	sess.exec.Stop()
+	delete(m.sessions, sess.key)
+	slog.Info("session removed", sess.key.logAttrs()...)
+}
+
+// settingsFor returns the per-chat settings for key, creating them on first
+// use. Callers must hold m.mu.
+func (m *Manager) settingsFor(key sessionKey) *chatSettings {
+	st, ok := m.settings[key]
+	if !ok {
+		st = &chatSettings{}
+		m.settings[key] = st
+	}
+	return st
+}
+
+// key returns the session key for an origin. Group chats are keyed per
+// user when workspaces.per_user_in_groups is enabled and forum topics per
+// topic when session.per_topic is; everything else is keyed on chat ID alone.
+func (m *Manager) key(origin Origin) sessionKey {
+	key := sessionKey{chatID: origin.ChatID}
+	if origin.Group && m.cfg.Workspaces.PerUserInGroups {
+		key.userID = origin.UserID
+	}
+	if m.cfg.Session.PerTopic {
+		key.threadID = origin.ThreadID
+	}
+	return key
+}
+
+// memoryHeader introduces the shared memory in the identity document.
+const memoryHeader = "---\n\n## Shared Memory\n\n"
+
+// memoryTruncated replaces memory lines dropped to fit max_identity_chars.
+const memoryTruncated = "[memory truncated]"
+
+// loadIdentity reads the soul and memory files and combines them, with
+// persona last if set, into a single string for use as a system prompt
+// addition. Missing files are silently skipped — neither is required for
+// the bot to function. Over claude.max_identity_chars, the soul and
+// persona are kept whole and the oldest memory lines are dropped.
+func (m *Manager) loadIdentity(persona string) string {
+	var parts []string
+
+	if soul, err := os.ReadFile(m.cfg.Claude.SoulPath); err == nil && len(soul) > 0 {
+		parts = append(parts, strings.TrimSpace(string(soul)))
+	}
+	if persona != "" {
+		persona = personaHeader + persona
+	}
+	memory, err := m.memory.Read()
+	if err != nil {
+		slog.Warn("load shared memory", "error", err)
+	}
+	if memory != "" {
+		memory = strings.TrimSpace(memory)
+		if limit := m.cfg.Claude.MaxIdentityChars; limit > 0 {
+			overhead := utf8.RuneCountInString(memoryHeader)
+			if len(parts) > 0 {
+				overhead += utf8.RuneCountInString(parts[0]) + len("\n\n")
+			}
+			if persona != "" {
+				overhead += utf8.RuneCountInString(persona) + len("\n\n")
+			}
+			memory = trimMemory(memory, limit-overhead)
+		}
+		parts = append(parts, memoryHeader+memory)
+	}
+	if persona != "" {
+		parts = append(parts, persona)
+	}
+
+	return strings.Join(parts, "\n\n")
+}
+
+// personaHeader introduces a chat's /persona in its identity document.
+const personaHeader = "---\n\n## Persona for This Chat\n\n"
+
+// identityFor is loadIdentity with key's /persona, if any.
+func (m *Manager) identityFor(key sessionKey) string {
+	m.mu.Lock()
+	persona := m.settingsFor(key).persona
+	m.mu.Unlock()
+	return m.loadIdentity(persona)
+}
+
+// trimMemory keeps the newest lines of memory that fit in budget runes,
+// behind a memoryTruncated marker, or returns memory as is if it fits.
+func trimMemory(memory string, budget int) string {
+	if utf8.RuneCountInString(memory) <= budget {
+		return memory
+	}
+	lines := strings.Split(memory, "\n")
+	used := utf8.RuneCountInString(memoryTruncated)
+	first := len(lines)
+	for first > 0 {
+		n := utf8.RuneCountInString(lines[first-1]) + 1
+		if used+n > budget {
+			break
+		}
+		used += n
+		first--
+	}
+	slog.Warn("shared memory truncated to fit max_identity_chars", "dropped_lines", first, "kept_lines", len(lines)-first)
+	return strings.Join(append([]string{memoryTruncated}, lines[first:]...), "\n")
+}
+
+// loadBrief reads the optional brief.md at the root of the origin's
+// workspace. Per-user subdirectories share their workspace's brief.
+func (m *Manager) loadBrief(origin Origin) string {
+	path := filepath.Join(m.cfg.Workspaces.BasePath, m.resolveWorkspace(origin), "brief.md")
+	brief, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(brief))
+}
+
+// resolveWorkDir maps an origin to its workspace directory. When the
+// session is keyed per user, the result is namespaced under
+// <workspace>/users/<user ID>.
+func (m *Manager) resolveWorkDir(origin Origin) string {
+	dir := filepath.Join(m.cfg.Workspaces.BasePath, m.resolveWorkspace(origin))
+	if key := m.key(origin); key.userID != 0 {
+		dir = filepath.Join(dir, "users", fmt.Sprintf("%d", key.userID))
+	}
+	return dir
+}
+
+// resolveWorkspace maps a chat to its workspace name. Resolution order:
+//  1. Override set with /new <workspace>
+//  2. @username (config key "@mygroup" or "mygroup")
+//  3. Chat title (e.g. "My Team")
+//  4. Numeric chat ID string (e.g. "-1001234567890")
+//  5. Default workspace
+//
+// A workspace that workspaces.allowed_user_ids doesn't open to the
+// origin's user is skipped, falling back to the default.
+func (m *Manager) resolveWorkspace(origin Origin) string {
+	name := m.lookupWorkspace(origin)
+	if !m.cfg.Workspaces.Permits(name, origin.UserID) {
+		slog.Debug("workspace not allowed for user, using default", "chat_id", origin.ChatID, "user_id", origin.UserID, "workspace", name)
+		return m.cfg.Workspaces.Default
+	}
+	return name
+}
+
+// lookupWorkspace resolves the origin's workspace, without access checks.
+func (m *Manager) lookupWorkspace(origin Origin) string {
+	m.mu.Lock()
+	override := ""
+	if st, ok := m.settings[m.key(origin)]; ok {
+		override = st.workspace
+	}
+	m.mu.Unlock()
+	if override != "" {
+		return override
+	}
+
+	// Username lookup — accept keys with or without leading @
+	if origin.Username != "" {
+		uname := strings.TrimPrefix(origin.Username, "@")
+		if name, ok := m.cfg.Workspaces.ChatMap["@"+uname]; ok {
+			return name
+		}
+		if name, ok := m.cfg.Workspaces.ChatMap[uname]; ok {
+			return name
+		}
+	}
+	// Title lookup
+	if origin.Title != "" {
+		if name, ok := m.cfg.Workspaces.ChatMap[origin.Title]; ok {
+			return name
+		}
+	}
+	// Numeric chat ID lookup
+	if name, ok := m.cfg.Workspaces.ChatMap[fmt.Sprintf("%d", origin.ChatID)]; ok {
+		return name
+	}
+	return m.cfg.Workspaces.Default
+}
+
+// UsageReport is a chat's recorded usage over recent periods.
+type UsageReport struct {
+	Today transcript.Usage
+	Week  transcript.Usage // Since Monday
+}
+
+// Usage totals the origin's chat's turns today and this week, across
+// sessions and users, from its transcripts.
+func (m *Manager) Usage(ctx context.Context, origin Origin) (UsageReport, error) {
+	if m.transcripts == nil {
+		return UsageReport{}, ErrNoTranscripts
+	}
+	now := m.now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	monday := today.AddDate(0, 0, -((int(today.Weekday()) + 6) % 7))
+	until := today.AddDate(0, 0, 1)
+
+	var r UsageReport
+	var err error
+	if r.Today, err = m.transcripts.Usage(ctx, origin.ChatID, today, until); err != nil {
+		return UsageReport{}, fmt.Errorf("total today's usage: %w", err)
+	}
+	if r.Week, err = m.transcripts.Usage(ctx, origin.ChatID, monday, until); err != nil {
+		return UsageReport{}, fmt.Errorf("total this week's usage: %w", err)
 	}
-	return filepath.Join(m.cfg.Workspaces.BasePath, m.cfg.Workspaces.Default)
+	return r, nil
 }
@@ -2,13 +2,24 @@ package session
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/zette-dev/natron/internal/config"
 	"github.com/zette-dev/natron/internal/executor"
+	"github.com/zette-dev/natron/internal/reqid"
+	"github.com/zette-dev/natron/internal/transcript"
 )
 
 func testConfig(t *testing.T) config.Config {
@@ -31,6 +42,7 @@ type mockExec struct {
 	alive   bool
 	started int
 	stopped int
+	sessCtx executor.SessionContext
 	handler func(string) (<-chan executor.Event, error)
 }
 
@@ -42,11 +54,12 @@ func (m *mockExec) Alive() bool {
 	return m.alive
 }
 
-func (m *mockExec) Start(_ context.Context, _ string, _ executor.SessionContext) error {
+func (m *mockExec) Start(_ context.Context, _ string, sessCtx executor.SessionContext) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.alive = true
 	m.started++
+	m.sessCtx = sessCtx
 	return nil
 }
 
@@ -77,7 +90,7 @@ func TestManager_CreateSession(t *testing.T) {
 	mgr := NewManager(cfg, func() executor.Executor { return &created })
 
 	ctx := context.Background()
-	events, err := mgr.Send(ctx, 100, "", "", "hello")
+	events, err := mgr.Send(ctx, Origin{ChatID: 100}, "hello")
 	if err != nil {
 		t.Fatalf("Send: %v", err)
 	}
@@ -104,12 +117,12 @@ func TestManager_ReuseSession(t *testing.T) {
 
 	ctx := context.Background()
 
-	_, err := mgr.Send(ctx, 200, "", "", "first")
+	_, err := mgr.Send(ctx, Origin{ChatID: 200}, "first")
 	if err != nil {
 		t.Fatalf("first Send: %v", err)
 	}
 
-	_, err = mgr.Send(ctx, 200, "", "", "second")
+	_, err = mgr.Send(ctx, Origin{ChatID: 200}, "second")
 	if err != nil {
 		t.Fatalf("second Send: %v", err)
 	}
@@ -128,8 +141,8 @@ func TestManager_DifferentChatsGetDifferentSessions(t *testing.T) {
 	})
 
 	ctx := context.Background()
-	mgr.Send(ctx, 300, "", "", "a")
-	mgr.Send(ctx, 400, "", "", "b")
+	mgr.Send(ctx, Origin{ChatID: 300}, "a")
+	mgr.Send(ctx, Origin{ChatID: 400}, "b")
 
 	if startCount != 2 {
 		t.Errorf("expected 2 factory calls for 2 chats, got %d", startCount)
@@ -148,7 +161,7 @@ func TestManager_DeadExecutorRecovery(t *testing.T) {
 	ctx := context.Background()
 
 	// First message — creates session
-	_, err := mgr.Send(ctx, 500, "", "", "first")
+	_, err := mgr.Send(ctx, Origin{ChatID: 500}, "first")
 	if err != nil {
 		t.Fatalf("first Send: %v", err)
 	}
@@ -158,13 +171,13 @@ func TestManager_DeadExecutorRecovery(t *testing.T) {
 
 	// Kill the executor behind the manager's back
 	mgr.mu.Lock()
-	sess := mgr.sessions[int64(500)]
+	sess := mgr.sessions[sessionKey{chatID: 500}]
 	mgr.mu.Unlock()
 
 	sess.exec.Stop() // sets alive=false
 
 	// Second message — should detect dead executor and create a new session
-	_, err = mgr.Send(ctx, 500, "", "", "second")
+	_, err = mgr.Send(ctx, Origin{ChatID: 500}, "second")
 	if err != nil {
 		t.Fatalf("second Send after death: %v", err)
 	}
@@ -174,6 +187,235 @@ func TestManager_DeadExecutorRecovery(t *testing.T) {
 	}
 }
 
+// deadExec starts successfully but is never alive, like a backend that
+// crashes immediately after spawning.
+type deadExec struct{ mockExec }
+
+func (d *deadExec) Start(context.Context, string, executor.SessionContext) error { return nil }
+
+func (d *deadExec) Send(context.Context, string) (<-chan executor.Event, error) {
+	return nil, errors.New("executor not running")
+}
+
+func TestManager_CrashCooldown(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Session.CrashLimit = 3
+	cfg.Session.CrashWindow = time.Minute
+	spawns := 0
+	mgr := NewManager(cfg, func() executor.Executor {
+		spawns++
+		return &deadExec{}
+	})
+
+	ctx := context.Background()
+	origin := Origin{ChatID: 1800}
+
+	var err error
+	for i := 0; i < 3; i++ {
+		_, err = mgr.Send(ctx, origin, "hello")
+		if err == nil {
+			t.Fatalf("send %d: expected an error from a dead executor", i)
+		}
+	}
+	if !errors.Is(err, ErrSessionFailing) {
+		t.Fatalf("expected ErrSessionFailing once the crash limit is hit, got %v", err)
+	}
+
+	// Further sends fail fast without spawning
+	before := spawns
+	if _, err := mgr.Send(ctx, origin, "again"); !errors.Is(err, ErrSessionFailing) {
+		t.Errorf("expected ErrSessionFailing, got %v", err)
+	}
+	if spawns != before {
+		t.Errorf("expected no spawns during cooldown, got %d more", spawns-before)
+	}
+
+	// Other chats are unaffected
+	if _, err := mgr.Send(ctx, Origin{ChatID: 1801}, "hi"); errors.Is(err, ErrSessionFailing) {
+		t.Error("crash cooldown should be per chat")
+	}
+
+	// An explicit reset allows another attempt
+	mgr.Reset(origin)
+	before = spawns
+	if _, err := mgr.Send(ctx, origin, "after reset"); errors.Is(err, ErrSessionFailing) {
+		t.Errorf("expected a fresh attempt after Reset, got %v", err)
+	}
+	if spawns == before {
+		t.Error("expected Reset to allow a new spawn")
+	}
+}
+
+func TestManager_CrashCounterResetsAfterSuccess(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Session.CrashLimit = 2
+	cfg.Session.CrashWindow = time.Minute
+	mgr := NewManager(cfg, func() executor.Executor { return &mockExec{} })
+
+	ctx := context.Background()
+	origin := Origin{ChatID: 1900}
+	key := sessionKey{chatID: 1900}
+
+	mgr.recordCrash(key)
+	events, err := mgr.Send(ctx, origin, "works")
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	drain(t, events)
+
+	mgr.recordCrash(key)
+	if mgr.failing(key) {
+		t.Error("expected a successful turn to reset the crash counter")
+	}
+}
+
+// gatedExec blocks in Start until release is closed, recording how many
+// starts are in progress at once.
+type gatedExec struct {
+	mockExec
+	release  <-chan struct{}
+	inFlight *gauge
+}
+
+func (g *gatedExec) Start(ctx context.Context, dir string, sessCtx executor.SessionContext) error {
+	g.inFlight.add(1)
+	defer g.inFlight.add(-1)
+	<-g.release
+	return g.mockExec.Start(ctx, dir, sessCtx)
+}
+
+// gauge tracks a concurrent count and its peak.
+type gauge struct {
+	mu        sync.Mutex
+	cur, peak int
+}
+
+func (g *gauge) add(n int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.cur += n
+	if g.cur > g.peak {
+		g.peak = g.cur
+	}
+}
+
+func (g *gauge) get() (cur, peak int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.cur, g.peak
+}
+
+func TestManager_MaxConcurrentSpawns(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Session.MaxConcurrentSpawns = 2
+	release := make(chan struct{})
+	starting := &gauge{}
+	mgr := NewManager(cfg, func() executor.Executor {
+		return &gatedExec{release: release, inFlight: starting}
+	})
+
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func(chatID int64) {
+			defer wg.Done()
+			events, err := mgr.Send(ctx, Origin{ChatID: chatID}, "hi")
+			if err != nil {
+				t.Errorf("chat %d: %v", chatID, err)
+				return
+			}
+			drain(t, events)
+		}(int64(2100 + i))
+	}
+
+	// Wait until the spawn slots are saturated, then give the rest a chance
+	// to (incorrectly) start before releasing.
+	deadline := time.Now().Add(2 * time.Second)
+	for cur, _ := starting.get(); cur < 2 && time.Now().Before(deadline); cur, _ = starting.get() {
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if _, peak := starting.get(); peak != 2 {
+		t.Errorf("expected at most 2 concurrent starts, peak was %d", peak)
+	}
+	for i := 0; i < 6; i++ {
+		if !mgr.Status(Origin{ChatID: int64(2100 + i)}).Exists {
+			t.Errorf("chat %d: expected a session once spawns were released", 2100+i)
+		}
+	}
+}
+
+func TestManager_MaxConcurrentTurns(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Session.MaxConcurrentTurns = 2
+	release := make(chan struct{})
+	turns := &gauge{}
+	mgr := NewManager(cfg, func() executor.Executor {
+		return &mockExec{handler: func(msg string) (<-chan executor.Event, error) {
+			turns.add(1)
+			ch := make(chan executor.Event)
+			go func() {
+				<-release
+				turns.add(-1)
+				ch <- executor.Event{Type: executor.EventDone, Text: "ok"}
+				close(ch)
+			}()
+			return ch, nil
+		}}
+	})
+	if mgr.TurnsFull(Origin{ChatID: 2299}) {
+		t.Fatal("TurnsFull with nothing running")
+	}
+
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(chatID int64) {
+			defer wg.Done()
+			events, err := mgr.Send(ctx, Origin{ChatID: chatID}, "hi")
+			if err != nil {
+				t.Errorf("chat %d: %v", chatID, err)
+				return
+			}
+			drain(t, events)
+		}(int64(2200 + i))
+	}
+
+	// Wait until the turn slots are saturated, then give the rest a chance
+	// to (incorrectly) start before releasing.
+	deadline := time.Now().Add(2 * time.Second)
+	for cur, _ := turns.get(); cur < 2 && time.Now().Before(deadline); cur, _ = turns.get() {
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if !mgr.TurnsFull(Origin{ChatID: 2299}) {
+		t.Error("TurnsFull = false with every slot taken")
+	}
+	for i := 0; i < 5; i++ {
+		origin := Origin{ChatID: int64(2200 + i)}
+		if mgr.Status(origin).TurnStarted.IsZero() {
+			continue
+		}
+		if mgr.TurnsFull(origin) {
+			t.Errorf("TurnsFull for chat %d, whose own turn holds a slot", origin.ChatID)
+		}
+	}
+	close(release)
+	wg.Wait()
+
+	if _, peak := turns.get(); peak != 2 {
+		t.Errorf("expected at most 2 concurrent turns, peak was %d", peak)
+	}
+	if mgr.TurnsFull(Origin{ChatID: 2299}) {
+		t.Error("TurnsFull after every turn finished")
+	}
+}
+
 func TestManager_Shutdown(t *testing.T) {
 	cfg := testConfig(t)
 	var execs []*mockExec
@@ -185,8 +427,8 @@ func TestManager_Shutdown(t *testing.T) {
 	})
 
 	ctx := context.Background()
-	mgr.Send(ctx, 600, "", "", "a")
-	mgr.Send(ctx, 700, "", "", "b")
+	mgr.Send(ctx, Origin{ChatID: 600}, "a")
+	mgr.Send(ctx, Origin{ChatID: 700}, "b")
 
 	mgr.Shutdown()
 
@@ -212,164 +454,2283 @@ func TestManager_Reset(t *testing.T) {
 	})
 
 	ctx := context.Background()
-	mgr.Send(ctx, 800, "", "", "hello")
+	mgr.Send(ctx, Origin{ChatID: 800}, "hello")
 	if startCount != 1 {
 		t.Fatalf("expected 1 start, got %d", startCount)
 	}
 
-	mgr.Reset(800)
+	mgr.Reset(Origin{ChatID: 800})
 
 	if lastExec.stopped != 1 {
 		t.Errorf("Reset: expected executor to be stopped, got %d", lastExec.stopped)
 	}
 
 	// Next send creates a fresh session
-	mgr.Send(ctx, 800, "", "", "after reset")
+	mgr.Send(ctx, Origin{ChatID: 800}, "after reset")
 	if startCount != 2 {
 		t.Errorf("expected 2 starts after reset, got %d", startCount)
 	}
 }
 
-func TestManager_Status(t *testing.T) {
+// interruptExec supports native interrupts.
+type interruptExec struct {
+	mockExec
+	interrupts int
+}
+
+func (i *interruptExec) Interrupt() error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.interrupts++
+	return nil
+}
+
+func TestManager_InterruptNative(t *testing.T) {
 	cfg := testConfig(t)
-	mgr := NewManager(cfg, func() executor.Executor { return &mockExec{} })
+	starts := 0
+	var exec *interruptExec
+	mgr := NewManager(cfg, func() executor.Executor {
+		starts++
+		exec = &interruptExec{}
+		return exec
+	})
 
 	ctx := context.Background()
+	if ok, err := mgr.Interrupt(ctx, Origin{ChatID: 850}); ok || err != nil {
+		t.Fatalf("Interrupt without session = %v, %v; want false, nil", ok, err)
+	}
 
-	// No session yet
-	info := mgr.Status(800)
-	if info.Exists {
-		t.Error("expected no session before first Send")
+	events, _ := mgr.Send(ctx, Origin{ChatID: 850}, "hello")
+	drain(t, events)
+	ok, err := mgr.Interrupt(ctx, Origin{ChatID: 850})
+	if !ok || err != nil {
+		t.Fatalf("Interrupt = %v, %v; want true, nil", ok, err)
 	}
+	if exec.interrupts != 1 || exec.stopped != 0 || starts != 1 {
+		t.Errorf("expected native interrupt only, got interrupts=%d stopped=%d starts=%d",
+			exec.interrupts, exec.stopped, starts)
+	}
+}
 
-	before := time.Now()
-	mgr.Send(ctx, 800, "", "", "hello")
-	after := time.Now()
+func TestManager_InterruptStopAndReplace(t *testing.T) {
+	cfg := testConfig(t)
+	var execs []*mockExec
+	mgr := NewManager(cfg, func() executor.Executor {
+		e := &mockExec{}
+		execs = append(execs, e)
+		return e
+	})
 
-	info = mgr.Status(800)
-	if !info.Exists {
-		t.Error("expected session to exist after Send")
+	ctx := context.Background()
+	events, _ := mgr.Send(ctx, Origin{ChatID: 851}, "hello")
+	drain(t, events)
+	ok, err := mgr.Interrupt(ctx, Origin{ChatID: 851})
+	if !ok || err != nil {
+		t.Fatalf("Interrupt = %v, %v; want true, nil", ok, err)
 	}
-	if info.Workspace == "" {
-		t.Error("expected non-empty workspace")
+	if len(execs) != 2 {
+		t.Fatalf("expected a replacement executor, got %d executors", len(execs))
 	}
-	if info.CreatedAt.Before(before) || info.CreatedAt.After(after) {
-		t.Errorf("CreatedAt %v outside expected range [%v, %v]", info.CreatedAt, before, after)
+	if execs[0].stopped != 1 || !execs[1].Alive() {
+		t.Errorf("expected old executor stopped and new one running")
 	}
+	if !mgr.Status(Origin{ChatID: 851}).Exists {
+		t.Error("expected a fresh session after stop-and-replace")
+	}
+}
 
-	// After reset, status should show no session
-	mgr.Reset(800)
-	info = mgr.Status(800)
-	if info.Exists {
-		t.Error("expected no session after Reset")
+func TestManager_InterruptAll(t *testing.T) {
+	cfg := testConfig(t)
+	long := &stoppableExec{}
+	execs := []executor.Executor{long, &mockExec{}}
+	mgr := NewManager(cfg, func() executor.Executor {
+		e := execs[0]
+		execs = execs[1:]
+		return e
+	})
+
+	busy, idle := Origin{ChatID: 1}, Origin{ChatID: 2}
+	events, err := mgr.Send(context.Background(), busy, "long task")
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	<-events
+	done, err := mgr.Send(context.Background(), idle, "quick")
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	drain(t, done)
+
+	if n := mgr.InterruptAll(); n != 1 {
+		t.Errorf("InterruptAll = %d, want the one running turn", n)
+	}
+	drain(t, events)
+	if long.stopped != 1 || mgr.Status(busy).Exists {
+		t.Error("expected the busy session, which can't interrupt, to be stopped")
+	}
+	if !mgr.Status(idle).Exists {
+		t.Error("expected the idle session left alone")
 	}
 }
 
-func TestManager_WorkspaceMapping(t *testing.T) {
+func TestManager_InactivityExpiry(t *testing.T) {
 	cfg := testConfig(t)
-	cfg.Workspaces.ChatMap = map[string]string{
-		"1000":        "zette",
-		"@teamchat":   "team",
-		"teamchat":    "team",
-		"Family Chat": "family",
+	cfg.Session.InactivityTimeout = 30 * time.Millisecond
+
+	release := make(chan struct{})
+	exec := &mockExec{handler: func(msg string) (<-chan executor.Event, error) {
+		ch := make(chan executor.Event, 1)
+		go func() {
+			<-release
+			ch <- executor.Event{Type: executor.EventDone, Text: "done"}
+			close(ch)
+		}()
+		return ch, nil
+	}}
+	mgr := NewManager(cfg, func() executor.Executor { return exec })
+	origin := Origin{ChatID: 860}
+
+	events, err := mgr.Send(context.Background(), origin, "long task")
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	// A streaming turn outlives the timeout without being reaped.
+	time.Sleep(80 * time.Millisecond)
+	if !mgr.Status(origin).Exists {
+		t.Fatal("session expired while a turn was streaming")
+	}
+
+	close(release)
+	drain(t, events)
+	waitFor(t, func() bool { return !exec.Alive() })
+	if mgr.Status(origin).Exists {
+		t.Error("expected expired session to be removed")
 	}
+}
 
+func TestManager_KeepWarm(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Session.InactivityTimeout = 20 * time.Millisecond
+	cfg.Session.KeepWarm = []string{"880", "pinned"}
+	cfg.Workspaces.ChatMap = map[string]string{"881": "pinned"}
 	mgr := NewManager(cfg, func() executor.Executor { return &mockExec{} })
 
-	// Numeric ID lookup
-	workDir := mgr.resolveWorkDir(1000, "", "")
-	if workDir != cfg.Workspaces.BasePath+"/zette" {
-		t.Errorf("numeric ID: expected zette workspace, got %q", workDir)
+	ctx := context.Background()
+	byID, byWorkspace, other := Origin{ChatID: 880}, Origin{ChatID: 881}, Origin{ChatID: 882}
+	for _, o := range []Origin{byID, byWorkspace, other} {
+		events, _ := mgr.Send(ctx, o, "hi")
+		drain(t, events)
 	}
 
-	// Default fallback
-	workDir = mgr.resolveWorkDir(9999, "", "")
-	if workDir != cfg.Workspaces.BasePath+"/home" {
-		t.Errorf("default: expected home workspace, got %q", workDir)
+	waitFor(t, func() bool { return !mgr.Status(other).Exists })
+	time.Sleep(60 * time.Millisecond) // several more timeouts
+	if !mgr.Status(byID).Exists || !mgr.Status(byWorkspace).Exists {
+		t.Error("expected keep-warm sessions to survive their timeout")
 	}
+}
 
-	// Username with @ prefix in config, passed without @
-	workDir = mgr.resolveWorkDir(0, "teamchat", "")
-	if workDir != cfg.Workspaces.BasePath+"/team" {
-		t.Errorf("@username: expected team workspace, got %q", workDir)
+func TestManager_IdleTimeoutOverride(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Session.InactivityTimeout = time.Hour
+	mgr := NewManager(cfg, func() executor.Executor { return &mockExec{} })
+
+	ctx := context.Background()
+	keep, short := Origin{ChatID: 870}, Origin{ChatID: 871}
+	for _, o := range []Origin{keep, short} {
+		events, _ := mgr.Send(ctx, o, "hi")
+		drain(t, events)
 	}
 
-	// Title lookup
-	workDir = mgr.resolveWorkDir(0, "", "Family Chat")
-	if workDir != cfg.Workspaces.BasePath+"/family" {
-		t.Errorf("title: expected family workspace, got %q", workDir)
+	mgr.SetIdleTimeout(keep, 0)
+	mgr.SetIdleTimeout(short, 20*time.Millisecond)
+	if got := mgr.IdleTimeout(keep); got != 0 {
+		t.Errorf("expected expiry off, got %v", got)
 	}
 
-	// Username takes priority over title
-	cfg.Workspaces.ChatMap["myfamily"] = "other"
-	workDir = mgr.resolveWorkDir(0, "myfamily", "Family Chat")
-	if workDir != cfg.Workspaces.BasePath+"/other" {
-		t.Errorf("priority: expected username to win over title, got %q", workDir)
+	// Shortening the timeout re-arms the running session's timer.
+	waitFor(t, func() bool { return !mgr.Status(short).Exists })
+	if !mgr.Status(keep).Exists {
+		t.Error("session with expiry off was reaped")
+	}
+	if got := mgr.IdleTimeout(Origin{ChatID: 872}); got != time.Hour {
+		t.Errorf("expected default timeout for other chats, got %v", got)
 	}
 }
 
-func TestManager_ConcurrentSendsSameChat(t *testing.T) {
-	cfg := testConfig(t)
+// fakeClock is a manually advanced clock for idle-stage tests.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
 
-	var mu sync.Mutex
-	inFlight := 0
-	maxInFlight := 0
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
 
-	mgr := NewManager(cfg, func() executor.Executor {
-		e := &mockExec{}
-		e.handler = func(msg string) (<-chan executor.Event, error) {
-			mu.Lock()
-			inFlight++
-			if inFlight > maxInFlight {
-				maxInFlight = inFlight
-			}
-			mu.Unlock()
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
 
-			// Simulate work
-			time.Sleep(50 * time.Millisecond)
+// idleTick runs the origin's idle timer callback as if it had fired.
+func idleTick(mgr *Manager, origin Origin) {
+	mgr.mu.Lock()
+	sess, ok := mgr.sessions[mgr.key(origin)]
+	mgr.mu.Unlock()
+	if ok {
+		mgr.expire(sess)
+	}
+}
 
-			mu.Lock()
-			inFlight--
-			mu.Unlock()
+func parked(mgr *Manager, origin Origin) bool {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+	sess, ok := mgr.sessions[mgr.key(origin)]
+	return ok && sess.parked
+}
 
-			ch := make(chan executor.Event, 2)
-			ch <- executor.Event{Type: executor.EventText, Text: msg}
-			ch <- executor.Event{Type: executor.EventDone}
-			close(ch)
-			return ch, nil
-		}
+func TestManager_ParkAfterIdle(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Session.ParkAfter = time.Hour
+	cfg.Session.InactivityTimeout = 2 * time.Hour
+	var execs []*mockExec
+	mgr := NewManager(cfg, func() executor.Executor {
+		e := &mockExec{}
+		execs = append(execs, e)
 		return e
 	})
+	clock := &fakeClock{now: time.Unix(1_700_000_000, 0)}
+	mgr.now = clock.Now
 
 	ctx := context.Background()
-	var wg sync.WaitGroup
-
-	// Fire 5 concurrent sends to the same chat
-	for i := 0; i < 5; i++ {
-		wg.Add(1)
-		go func(i int) {
-			defer wg.Done()
-			events, err := mgr.Send(ctx, 1100, "", "", fmt.Sprintf("msg-%d", i))
-			if err != nil {
-				t.Errorf("send %d: %v", i, err)
-				return
-			}
-			drain(t, events)
-		}(i)
+	origin := Origin{ChatID: 883}
+	events, _ := mgr.Send(ctx, origin, "hi")
+	drain(t, events)
+
+	clock.Advance(30 * time.Minute)
+	idleTick(mgr, origin)
+	if parked(mgr, origin) {
+		t.Fatal("parked before park_after")
 	}
 
-	wg.Wait()
-
-	mu.Lock()
-	peak := maxInFlight
-	mu.Unlock()
+	clock.Advance(30 * time.Minute)
+	idleTick(mgr, origin)
+	if !parked(mgr, origin) {
+		t.Fatal("expected the session to be parked after park_after")
+	}
+	if !mgr.Status(origin).Exists || execs[0].stopped != 0 {
+		t.Fatal("parking must keep the process")
+	}
+	if snaps := mgr.Snapshot(); len(snaps) != 1 || !snaps[0].Parked {
+		t.Errorf("expected the snapshot to report parked, got %+v", snaps)
+	}
 
-	if peak > 1 {
-		t.Errorf("per-chat lock violated: max concurrent in-flight was %d (expected 1)", peak)
+	// A parked session answers without a cold start and is active again.
+	events, _ = mgr.Send(ctx, origin, "again")
+	drain(t, events)
+	if len(execs) != 1 || execs[0].started != 1 {
+		t.Errorf("expected the parked process to be reused, got %d executors", len(execs))
+	}
+	if parked(mgr, origin) {
+		t.Error("expected a new turn to unpark the session")
 	}
 }
 
-// --- helpers ---
+func TestManager_ReapAfterPark(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Session.ParkAfter = time.Hour
+	cfg.Session.InactivityTimeout = 2 * time.Hour
+	var execs []*mockExec
+	mgr := NewManager(cfg, func() executor.Executor {
+		e := &mockExec{}
+		execs = append(execs, e)
+		return e
+	})
+	clock := &fakeClock{now: time.Unix(1_700_000_000, 0)}
+	mgr.now = clock.Now
+
+	ctx := context.Background()
+	origin := Origin{ChatID: 884}
+	events, _ := mgr.Send(ctx, origin, "hi")
+	drain(t, events)
+
+	clock.Advance(time.Hour)
+	idleTick(mgr, origin)
+	clock.Advance(59 * time.Minute)
+	idleTick(mgr, origin)
+	if !mgr.Status(origin).Exists {
+		t.Fatal("reaped before reap_after")
+	}
+
+	clock.Advance(time.Minute)
+	idleTick(mgr, origin)
+	if mgr.Status(origin).Exists || execs[0].stopped != 1 {
+		t.Fatal("expected the session to be reaped after reap_after")
+	}
+
+	events, _ = mgr.Send(ctx, origin, "hi")
+	drain(t, events)
+	if len(execs) != 2 {
+		t.Errorf("expected a cold start after reaping, got %d executors", len(execs))
+	}
+}
+
+// resumableExec reports a session ID for resume.
+type resumableExec struct {
+	mockExec
+	id string
+}
+
+func (r *resumableExec) SessionID() string { return r.id }
+
+func TestManager_BudgetDowngrade(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Claude.Model = "opus"
+	cfg.Claude.MaxBudgetUSD = 10
+	cfg.Claude.Downgrade = config.DowngradeConfig{Threshold: 0.8, Ladder: []string{"opus", "sonnet", "haiku"}}
+
+	var execs []*resumableExec
+	cost := 0.0
+	mgr := NewManager(cfg, func() executor.Executor {
+		e := &resumableExec{id: fmt.Sprintf("sess-%d", len(execs)+1)}
+		e.handler = func(msg string) (<-chan executor.Event, error) {
+			ch := make(chan executor.Event, 1)
+			ch <- executor.Event{Type: executor.EventDone, Text: "ok", CostUSD: cost}
+			close(ch)
+			return ch, nil
+		}
+		execs = append(execs, e)
+		return e
+	})
+
+	ctx := context.Background()
+	origin := Origin{ChatID: 890}
+	send := func() {
+		events, err := mgr.Send(ctx, origin, "hi")
+		if err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		drain(t, events)
+	}
+
+	cost = 5
+	send()
+	send()
+	if len(execs) != 1 {
+		t.Fatalf("expected no downgrade under the threshold, got %d executors", len(execs))
+	}
+
+	cost = 8.5 // crosses 80% of $10
+	send()
+	send()
+	if len(execs) != 2 {
+		t.Fatalf("expected a downgraded executor, got %d", len(execs))
+	}
+	if got := execs[1].sessCtx; got.Model != "sonnet" || got.ResumeID != "sess-1" {
+		t.Errorf("expected sonnet resuming sess-1, got model=%q resume=%q", got.Model, got.ResumeID)
+	}
+	if execs[0].Alive() {
+		t.Error("expected the expensive executor to be stopped")
+	}
+
+	// The new process reports its own spend; earlier spend still counts,
+	// so the next turn steps down again.
+	cost = 0.5
+	send()
+	send()
+	if len(execs) != 3 || execs[2].sessCtx.Model != "haiku" {
+		t.Fatalf("expected a second downgrade to haiku, got %d executors", len(execs))
+	}
+
+	// haiku is the cheapest rung; no further replacements.
+	send()
+	send()
+	if len(execs) != 3 {
+		t.Errorf("expected to stay on the last rung, got %d executors", len(execs))
+	}
+}
+
+// restartableExec restarts in place, recording each restart's context.
+type restartableExec struct {
+	resumableExec
+	restarts []executor.SessionContext
+}
+
+func (r *restartableExec) Restart(ctx context.Context, sessCtx executor.SessionContext) error {
+	sessCtx.ResumeID = r.id
+	r.restarts = append(r.restarts, sessCtx)
+	r.Stop()
+	return r.Start(ctx, "", sessCtx)
+}
+
+func TestManager_BudgetDowngradeRestartsInPlace(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Claude.Model = "opus"
+	cfg.Claude.MaxBudgetUSD = 10
+	cfg.Claude.Downgrade = config.DowngradeConfig{Threshold: 0.8, Ladder: []string{"opus", "sonnet", "haiku"}}
+
+	var execs []*restartableExec
+	mgr := NewManager(cfg, func() executor.Executor {
+		e := &restartableExec{resumableExec: resumableExec{id: "sess-1"}}
+		e.handler = func(msg string) (<-chan executor.Event, error) {
+			ch := make(chan executor.Event, 1)
+			ch <- executor.Event{Type: executor.EventDone, Text: "ok", CostUSD: 9}
+			close(ch)
+			return ch, nil
+		}
+		execs = append(execs, e)
+		return e
+	})
+
+	origin := Origin{ChatID: 891}
+	for range 2 {
+		events, err := mgr.Send(context.Background(), origin, "hi")
+		if err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		drain(t, events)
+	}
+
+	if len(execs) != 1 {
+		t.Fatalf("expected the executor restarted rather than replaced, got %d", len(execs))
+	}
+	e := execs[0]
+	if len(e.restarts) != 1 || e.restarts[0].Model != "sonnet" || e.restarts[0].ResumeID != "sess-1" {
+		t.Fatalf("expected one restart on sonnet resuming sess-1, got %+v", e.restarts)
+	}
+	if e.started != 2 || !e.Alive() {
+		t.Errorf("expected a fresh live process after the restart, started %d times", e.started)
+	}
+	if snaps := mgr.Snapshot(); len(snaps) != 1 || snaps[0].Model != "sonnet" {
+		t.Errorf("expected the session to report sonnet, got %+v", snaps)
+	}
+}
+
+func TestManager_SettingsRestartInPlace(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Claude.AllowedModels = []string{"opus", "sonnet"}
+
+	var execs []*restartableExec
+	mgr := NewManager(cfg, func() executor.Executor {
+		e := &restartableExec{resumableExec: resumableExec{id: "sess-1"}}
+		e.handler = func(msg string) (<-chan executor.Event, error) {
+			ch := make(chan executor.Event, 1)
+			ch <- executor.Event{Type: executor.EventDone, Text: "ok"}
+			close(ch)
+			return ch, nil
+		}
+		execs = append(execs, e)
+		return e
+	})
+
+	origin := Origin{ChatID: 892}
+	send := func() {
+		t.Helper()
+		events, err := mgr.Send(context.Background(), origin, "hi")
+		if err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		drain(t, events)
+	}
+	send()
+
+	if err := mgr.SetModel(origin, "sonnet"); err != nil {
+		t.Fatalf("SetModel: %v", err)
+	}
+	mgr.SetReadOnly(origin, true)
+	mgr.SetPersona(origin, "Be terse.")
+	if !mgr.Status(origin).Exists {
+		t.Fatal("expected the session kept across setting changes")
+	}
+	send()
+
+	if len(execs) != 1 {
+		t.Fatalf("expected the executor restarted rather than replaced, got %d", len(execs))
+	}
+	e := execs[0]
+	if len(e.restarts) != 1 {
+		t.Fatalf("expected the changes applied in one restart, got %+v", e.restarts)
+	}
+	got := e.restarts[0]
+	if got.Model != "sonnet" || !got.ReadOnly || !strings.Contains(got.IdentityDoc, "Be terse.") || got.ResumeID != "sess-1" {
+		t.Errorf("expected a restart on sonnet, read-only, with the persona, resuming sess-1; got %+v", got)
+	}
+
+	mgr.ClearOverrides(origin)
+	send()
+	if len(execs) != 1 || len(e.restarts) != 2 || e.restarts[1].Model != "" || e.restarts[1].ReadOnly {
+		t.Errorf("expected clearing to restart on the defaults, got %d executors and %+v", len(execs), e.restarts)
+	}
+}
+
+func TestManager_Remember(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Claude.MemoryPath = filepath.Join(t.TempDir(), "memory.md")
+	if err := os.WriteFile(cfg.Claude.MemoryPath, []byte("Existing fact"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mgr := NewManager(cfg, func() executor.Executor { return &mockExec{} })
+
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := mgr.Remember(fmt.Sprintf("note %d", i)); err != nil {
+				t.Errorf("Remember: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	data, err := os.ReadFile(cfg.Claude.MemoryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 11 || lines[0] != "Existing fact" {
+		t.Fatalf("expected existing line plus 10 notes, got %q", lines)
+	}
+	for _, line := range lines[1:] {
+		if !strings.HasPrefix(line, "- ") || !strings.Contains(line, ": note ") {
+			t.Errorf("malformed note line %q", line)
+		}
+	}
+
+	if identity := mgr.loadIdentity(""); !strings.Contains(identity, "note 7") {
+		t.Errorf("expected new identity to include remembered notes, got %q", identity)
+	}
+}
+
+func TestManager_ReloadIdentity(t *testing.T) {
+	cfg := testConfig(t)
+	dir := t.TempDir()
+	cfg.Claude.SoulPath = filepath.Join(dir, "soul.md")
+	cfg.Claude.MemoryPath = filepath.Join(dir, "memory.md")
+
+	var sent []string
+	mgr := NewManager(cfg, func() executor.Executor {
+		return &mockExec{handler: func(msg string) (<-chan executor.Event, error) {
+			sent = append(sent, msg)
+			ch := make(chan executor.Event, 1)
+			ch <- executor.Event{Type: executor.EventDone, Text: "ok"}
+			close(ch)
+			return ch, nil
+		}}
+	})
+
+	ctx := context.Background()
+	origin := Origin{ChatID: 1650}
+	if _, err := mgr.ReloadIdentity(ctx, origin); !errors.Is(err, ErrNoSession) {
+		t.Fatalf("expected ErrNoSession without a session, got %v", err)
+	}
+
+	events, _ := mgr.Send(ctx, origin, "hi")
+	drain(t, events)
+	if _, err := mgr.ReloadIdentity(ctx, origin); !errors.Is(err, ErrNoIdentity) {
+		t.Fatalf("expected ErrNoIdentity with no soul or memory, got %v", err)
+	}
+
+	if err := os.WriteFile(cfg.Claude.SoulPath, []byte("You are terse."), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	events, err := mgr.ReloadIdentity(ctx, origin)
+	if err != nil {
+		t.Fatalf("ReloadIdentity: %v", err)
+	}
+	drain(t, events)
+	if len(sent) != 2 || !strings.HasPrefix(sent[1], "System update:") || !strings.HasSuffix(sent[1], "You are terse.") {
+		t.Errorf("expected the identity sent in-band to the live session, got %q", sent)
+	}
+}
+
+func TestManager_Snapshot(t *testing.T) {
+	cfg := testConfig(t)
+	mgr := NewManager(cfg, func() executor.Executor { return &mockExec{} })
+
+	if snaps := mgr.Snapshot(); len(snaps) != 0 {
+		t.Fatalf("expected empty snapshot, got %+v", snaps)
+	}
+
+	ctx := context.Background()
+	for _, id := range []int64{1600, 1500} {
+		events, _ := mgr.Send(ctx, Origin{ChatID: id}, "hi")
+		drain(t, events)
+	}
+
+	snaps := mgr.Snapshot()
+	if len(snaps) != 2 || snaps[0].ChatID != 1500 || snaps[1].ChatID != 1600 {
+		t.Fatalf("expected sessions 1500 and 1600 in order, got %+v", snaps)
+	}
+	if s := snaps[0]; !s.Alive || s.Backend != "mock" || s.Workspace == "" || s.CreatedAt.IsZero() || s.LastActivity.IsZero() {
+		t.Errorf("incomplete snapshot: %+v", s)
+	}
+
+	mgr.Reset(Origin{ChatID: 1500})
+	snaps = mgr.Snapshot()
+	if len(snaps) != 1 || snaps[0].ChatID != 1600 {
+		t.Errorf("expected only 1600 after reset, got %+v", snaps)
+	}
+
+	rec := httptest.NewRecorder()
+	mgr.SnapshotHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/sessions", nil))
+	var decoded []SessionSnapshot
+	if err := json.Unmarshal(rec.Body.Bytes(), &decoded); err != nil || len(decoded) != 1 {
+		t.Errorf("expected one session as JSON, got %q (%v)", rec.Body.String(), err)
+	}
+}
+
+func TestManager_Status(t *testing.T) {
+	cfg := testConfig(t)
+	mgr := NewManager(cfg, func() executor.Executor { return &mockExec{} })
+
+	ctx := context.Background()
+
+	// No session yet
+	info := mgr.Status(Origin{ChatID: 800})
+	if info.Exists {
+		t.Error("expected no session before first Send")
+	}
+
+	before := time.Now()
+	mgr.Send(ctx, Origin{ChatID: 800}, "hello")
+	after := time.Now()
+
+	info = mgr.Status(Origin{ChatID: 800})
+	if !info.Exists {
+		t.Error("expected session to exist after Send")
+	}
+	if info.Workspace == "" {
+		t.Error("expected non-empty workspace")
+	}
+	if info.CreatedAt.Before(before) || info.CreatedAt.After(after) {
+		t.Errorf("CreatedAt %v outside expected range [%v, %v]", info.CreatedAt, before, after)
+	}
+
+	// After reset, status should show no session
+	mgr.Reset(Origin{ChatID: 800})
+	info = mgr.Status(Origin{ChatID: 800})
+	if info.Exists {
+		t.Error("expected no session after Reset")
+	}
+}
+
+func TestManager_WorkspaceAllowedUsers(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Workspaces.ChatMap = map[string]string{"1000": "teamb"}
+	cfg.Workspaces.AllowedUserIDs = map[string][]int64{"teamb": {2}}
+	if err := os.Mkdir(filepath.Join(cfg.Workspaces.BasePath, "teamb"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	mgr := NewManager(cfg, func() executor.Executor { return &mockExec{} })
+
+	t.Run("static resolution", func(t *testing.T) {
+		if got := mgr.resolveWorkspace(Origin{ChatID: 1000, UserID: 2}); got != "teamb" {
+			t.Errorf("allowed user resolved to %q, want teamb", got)
+		}
+		if got := mgr.resolveWorkspace(Origin{ChatID: 1000, UserID: 1}); got != "home" {
+			t.Errorf("other user resolved to %q, want the default", got)
+		}
+	})
+
+	t.Run("override", func(t *testing.T) {
+		outsider := Origin{ChatID: 5, UserID: 1}
+		if err := mgr.ResetInWorkspace(outsider, "teamb"); !errors.Is(err, ErrWorkspaceForbidden) {
+			t.Errorf("ResetInWorkspace = %v, want ErrWorkspaceForbidden", err)
+		}
+		if got := mgr.resolveWorkspace(outsider); got != "home" {
+			t.Errorf("rejected override changed the workspace to %q", got)
+		}
+		for _, ws := range mgr.Workspaces(outsider) {
+			if ws.Name == "teamb" {
+				t.Error("expected teamb hidden from /workspaces for an outsider")
+			}
+		}
+
+		member := Origin{ChatID: 6, UserID: 2}
+		if err := mgr.ResetInWorkspace(member, "teamb"); err != nil {
+			t.Fatalf("ResetInWorkspace for an allowed user: %v", err)
+		}
+		if got := mgr.resolveWorkspace(member); got != "teamb" {
+			t.Errorf("allowed override resolved to %q, want teamb", got)
+		}
+	})
+
+	t.Run("shared session", func(t *testing.T) {
+		events, err := mgr.Send(context.Background(), Origin{ChatID: 1000, UserID: 2, Group: true}, "hi")
+		if err != nil {
+			t.Fatalf("Send for an allowed user: %v", err)
+		}
+		drain(t, events)
+
+		outsider := Origin{ChatID: 1000, UserID: 1, Group: true}
+		if _, err := mgr.Send(context.Background(), outsider, "hi"); !errors.Is(err, ErrWorkspaceForbidden) {
+			t.Fatalf("Send into the shared teamb session = %v, want ErrWorkspaceForbidden", err)
+		}
+		mgr.mu.Lock()
+		crashes := len(mgr.crashes[mgr.key(outsider)])
+		mgr.mu.Unlock()
+		if crashes != 0 {
+			t.Errorf("refusal counted as %d crashes", crashes)
+		}
+	})
+}
+
+func TestManager_WorkspaceMapping(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Workspaces.ChatMap = map[string]string{
+		"1000":        "zette",
+		"@teamchat":   "team",
+		"teamchat":    "team",
+		"Family Chat": "family",
+	}
+
+	mgr := NewManager(cfg, func() executor.Executor { return &mockExec{} })
+
+	// Numeric ID lookup
+	workDir := mgr.resolveWorkDir(Origin{ChatID: 1000})
+	if workDir != cfg.Workspaces.BasePath+"/zette" {
+		t.Errorf("numeric ID: expected zette workspace, got %q", workDir)
+	}
+
+	// Default fallback
+	workDir = mgr.resolveWorkDir(Origin{ChatID: 9999})
+	if workDir != cfg.Workspaces.BasePath+"/home" {
+		t.Errorf("default: expected home workspace, got %q", workDir)
+	}
+
+	// Username with @ prefix in config, passed without @
+	workDir = mgr.resolveWorkDir(Origin{Username: "teamchat"})
+	if workDir != cfg.Workspaces.BasePath+"/team" {
+		t.Errorf("@username: expected team workspace, got %q", workDir)
+	}
+
+	// Title lookup
+	workDir = mgr.resolveWorkDir(Origin{Title: "Family Chat"})
+	if workDir != cfg.Workspaces.BasePath+"/family" {
+		t.Errorf("title: expected family workspace, got %q", workDir)
+	}
+
+	// Username takes priority over title
+	cfg.Workspaces.ChatMap["myfamily"] = "other"
+	workDir = mgr.resolveWorkDir(Origin{Username: "myfamily", Title: "Family Chat"})
+	if workDir != cfg.Workspaces.BasePath+"/other" {
+		t.Errorf("priority: expected username to win over title, got %q", workDir)
+	}
+}
+
+func TestManager_WorkDirWithoutSession(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Workspaces.ChatMap = map[string]string{"1300": "zette"}
+	startCount := 0
+	mgr := NewManager(cfg, func() executor.Executor {
+		startCount++
+		return &mockExec{}
+	})
+
+	if got := mgr.WorkDir(Origin{ChatID: 1300}); got != cfg.Workspaces.BasePath+"/zette" {
+		t.Errorf("expected zette workspace, got %q", got)
+	}
+	if startCount != 0 || mgr.Status(Origin{ChatID: 1300}).Exists {
+		t.Error("WorkDir should not create a session")
+	}
+}
+
+func TestManager_Workspaces(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Workspaces.ChatMap = map[string]string{"1700": "zette", "@team": "team"}
+	os.Mkdir(filepath.Join(cfg.Workspaces.BasePath, "scratch"), 0o755)
+	os.Mkdir(filepath.Join(cfg.Workspaces.BasePath, ".git"), 0o755)
+	mgr := NewManager(cfg, func() executor.Executor { return &mockExec{} })
+
+	names := func(list []WorkspaceInfo) (all []string, active string) {
+		for _, ws := range list {
+			all = append(all, ws.Name)
+			if ws.Active {
+				active = ws.Name
+			}
+		}
+		return all, active
+	}
+
+	all, active := names(mgr.Workspaces(Origin{ChatID: 1700}))
+	if fmt.Sprint(all) != "[home team zette]" || active != "zette" {
+		t.Errorf("configured only: got %v (active %q)", all, active)
+	}
+
+	mgr.cfg.Workspaces.ListDirectories = true
+	all, active = names(mgr.Workspaces(Origin{ChatID: 1701}))
+	if fmt.Sprint(all) != "[home scratch team zette]" || active != "home" {
+		t.Errorf("with directories: got %v (active %q)", all, active)
+	}
+}
+
+func TestManager_PerUserInGroups(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Workspaces.PerUserInGroups = true
+	startCount := 0
+	mgr := NewManager(cfg, func() executor.Executor {
+		startCount++
+		return &mockExec{}
+	})
+
+	ctx := context.Background()
+	alice := Origin{ChatID: -1200, UserID: 1, Group: true}
+	bob := Origin{ChatID: -1200, UserID: 2, Group: true}
+
+	mgr.Send(ctx, alice, "a")
+	mgr.Send(ctx, bob, "b")
+	mgr.Send(ctx, alice, "again")
+
+	if startCount != 2 {
+		t.Errorf("expected 2 sessions for 2 users in one group, got %d", startCount)
+	}
+
+	want := cfg.Workspaces.BasePath + "/home/users/1"
+	if got := mgr.Status(alice).Workspace; got != want {
+		t.Errorf("alice workspace: expected %q, got %q", want, got)
+	}
+
+	// Resetting one user's session leaves the other intact
+	mgr.Reset(alice)
+	if mgr.Status(alice).Exists {
+		t.Error("expected alice's session to be gone after Reset")
+	}
+	if !mgr.Status(bob).Exists {
+		t.Error("expected bob's session to survive alice's Reset")
+	}
+
+	// DMs are keyed on chat ID alone and use the shared workspace
+	dm := Origin{ChatID: 1, UserID: 1}
+	if got := mgr.resolveWorkDir(dm); got != cfg.Workspaces.BasePath+"/home" {
+		t.Errorf("DM workspace: expected shared home workspace, got %q", got)
+	}
+}
+
+func TestManager_PerTopic(t *testing.T) {
+	ctx := context.Background()
+	general := Origin{ChatID: -2000, Group: true}
+	topicA := Origin{ChatID: -2000, Group: true, ThreadID: 11}
+	topicB := Origin{ChatID: -2000, Group: true, ThreadID: 12}
+
+	// Disabled: every topic shares the chat's session
+	startCount := 0
+	mgr := NewManager(testConfig(t), func() executor.Executor {
+		startCount++
+		return &mockExec{}
+	})
+	mgr.Send(ctx, topicA, "a")
+	mgr.Send(ctx, topicB, "b")
+	if startCount != 1 {
+		t.Errorf("per_topic off: expected 1 shared session, got %d", startCount)
+	}
+
+	// Enabled: each topic gets its own session
+	cfg := testConfig(t)
+	cfg.Session.PerTopic = true
+	startCount = 0
+	mgr = NewManager(cfg, func() executor.Executor {
+		startCount++
+		return &mockExec{}
+	})
+	mgr.Send(ctx, general, "g")
+	mgr.Send(ctx, topicA, "a")
+	mgr.Send(ctx, topicB, "b")
+	mgr.Send(ctx, topicA, "a again")
+	if startCount != 3 {
+		t.Errorf("per_topic on: expected 3 sessions, got %d", startCount)
+	}
+
+	mgr.Reset(topicA)
+	if mgr.Status(topicA).Exists || !mgr.Status(topicB).Exists || !mgr.Status(general).Exists {
+		t.Error("expected Reset to only affect the topic it was sent from")
+	}
+}
+
+func TestManager_WorkspaceNotDirectory(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Session.CrashLimit = 2 // the error must not pause recovery
+	cfg.Session.CrashWindow = time.Minute
+	path := filepath.Join(cfg.Workspaces.BasePath, "home")
+	if err := os.WriteFile(path, []byte("oops"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	started := 0
+	mgr := NewManager(cfg, func() executor.Executor {
+		started++
+		return &mockExec{}
+	})
+
+	for range cfg.Session.CrashLimit + 1 {
+		_, err := mgr.Send(context.Background(), Origin{ChatID: 950}, "hi")
+		var notDir *WorkspaceNotDirError
+		if !errors.As(err, &notDir) || notDir.Path != path {
+			t.Fatalf("expected WorkspaceNotDirError for %s, got %v", path, err)
+		}
+	}
+	if started != 0 {
+		t.Errorf("expected no executor to start, got %d", started)
+	}
+}
+
+func TestManager_WorkspaceBrief(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Workspaces.ChatMap = map[string]string{"900": "project"}
+	dir := filepath.Join(cfg.Workspaces.BasePath, "project")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "brief.md"), []byte("Run make test.\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var execs []*mockExec
+	mgr := NewManager(cfg, func() executor.Executor {
+		e := &mockExec{}
+		execs = append(execs, e)
+		return e
+	})
+
+	ctx := context.Background()
+	mgr.Send(ctx, Origin{ChatID: 900}, "hi")
+	mgr.Send(ctx, Origin{ChatID: 901}, "hi")
+
+	if got := execs[0].sessCtx.WorkspaceInfo; got != "Run make test." {
+		t.Errorf("expected brief for mapped workspace, got %q", got)
+	}
+	if got := execs[1].sessCtx.WorkspaceInfo; got != "" {
+		t.Errorf("expected no brief for workspace without brief.md, got %q", got)
+	}
+}
+
+func TestManager_ResetInWorkspace(t *testing.T) {
+	cfg := testConfig(t)
+	project := filepath.Join(cfg.Workspaces.BasePath, "project")
+	if err := os.MkdirAll(project, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(cfg.Workspaces.BasePath, "notes"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var execs []*mockExec
+	mgr := NewManager(cfg, func() executor.Executor {
+		e := &mockExec{}
+		execs = append(execs, e)
+		return e
+	})
+
+	ctx := context.Background()
+	origin := Origin{ChatID: 950}
+	events, _ := mgr.Send(ctx, origin, "hi")
+	drain(t, events)
+
+	for _, name := range []string{"missing", "..", "../project", "project/sub"} {
+		if err := mgr.ResetInWorkspace(origin, name); !errors.Is(err, ErrNoWorkspace) {
+			t.Errorf("ResetInWorkspace(%q) = %v, want ErrNoWorkspace", name, err)
+		}
+	}
+	var notDir *WorkspaceNotDirError
+	if err := mgr.ResetInWorkspace(origin, "notes"); !errors.As(err, &notDir) {
+		t.Errorf("ResetInWorkspace(notes) = %v, want WorkspaceNotDirError", err)
+	}
+	if execs[0].stopped != 0 {
+		t.Fatal("a rejected workspace should leave the session running")
+	}
+
+	if err := mgr.ResetInWorkspace(origin, "project"); err != nil {
+		t.Fatalf("ResetInWorkspace: %v", err)
+	}
+	if execs[0].stopped != 1 {
+		t.Errorf("expected the old session to be stopped, got %d stops", execs[0].stopped)
+	}
+	if got := mgr.WorkDir(origin); got != project {
+		t.Errorf("WorkDir = %q, want %q", got, project)
+	}
+
+	events, _ = mgr.Send(ctx, origin, "hi")
+	drain(t, events)
+	if len(execs) != 2 {
+		t.Fatalf("expected a fresh session, got %d executors", len(execs))
+	}
+	if got := mgr.Status(origin).Workspace; got != project {
+		t.Errorf("new session workspace = %q, want %q", got, project)
+	}
+	if got := mgr.WorkDir(Origin{ChatID: 951}); got == project {
+		t.Error("override leaked to another chat")
+	}
+}
+
+func TestManager_Prompt(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Workspaces.ChatMap = map[string]string{"910": "project"}
+	prompts := filepath.Join(cfg.Workspaces.BasePath, "project", PromptDir)
+	if err := os.MkdirAll(prompts, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		filepath.Join(prompts, "deploy-check.md"):                      "Check the deploy.\n",
+		filepath.Join(prompts, "blank.md"):                             "  \n",
+		filepath.Join(cfg.Workspaces.BasePath, "project", "secret.md"): "do not read",
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(cfg.Workspaces.BasePath, "project", "secret.md"), filepath.Join(prompts, "escape.md")); err != nil {
+		t.Fatal(err)
+	}
+	mgr := NewManager(cfg, func() executor.Executor { return &mockExec{} })
+	origin := Origin{ChatID: 910}
+
+	got, err := mgr.Prompt(origin, "deploy-check")
+	if err != nil || got != "Check the deploy." {
+		t.Fatalf("Prompt(deploy-check) = %q, %v", got, err)
+	}
+
+	for _, name := range []string{"missing", "blank"} {
+		if _, err := mgr.Prompt(origin, name); !errors.Is(err, ErrNoPrompt) {
+			t.Errorf("Prompt(%q) = %v, want ErrNoPrompt", name, err)
+		}
+	}
+	if _, err := mgr.Prompt(Origin{ChatID: 911}, "deploy-check"); !errors.Is(err, ErrNoPrompt) {
+		t.Errorf("expected prompts to be per workspace, got %v", err)
+	}
+	for _, name := range []string{"", "../secret", "../../project/secret", "sub/x", `..\secret`, ".hidden", ".."} {
+		if _, err := mgr.Prompt(origin, name); !errors.Is(err, ErrInvalidPromptName) {
+			t.Errorf("Prompt(%q) = %v, want ErrInvalidPromptName", name, err)
+		}
+	}
+	if got, err := mgr.Prompt(origin, "escape"); err == nil {
+		t.Errorf("expected a symlink out of the prompts dir to be refused, got %q", got)
+	}
+}
+
+func TestManager_ConcurrentSendsSameChat(t *testing.T) {
+	cfg := testConfig(t)
+
+	var mu sync.Mutex
+	inFlight := 0
+	maxInFlight := 0
+
+	mgr := NewManager(cfg, func() executor.Executor {
+		e := &mockExec{}
+		e.handler = func(msg string) (<-chan executor.Event, error) {
+			mu.Lock()
+			inFlight++
+			if inFlight > maxInFlight {
+				maxInFlight = inFlight
+			}
+			mu.Unlock()
+
+			// Simulate work
+			time.Sleep(50 * time.Millisecond)
+
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+
+			ch := make(chan executor.Event, 2)
+			ch <- executor.Event{Type: executor.EventText, Text: msg}
+			ch <- executor.Event{Type: executor.EventDone}
+			close(ch)
+			return ch, nil
+		}
+		return e
+	})
+
+	ctx := context.Background()
+	var wg sync.WaitGroup
+
+	// Fire 5 concurrent sends to the same chat
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			events, err := mgr.Send(ctx, Origin{ChatID: 1100}, fmt.Sprintf("msg-%d", i))
+			if err != nil {
+				t.Errorf("send %d: %v", i, err)
+				return
+			}
+			drain(t, events)
+		}(i)
+	}
+
+	wg.Wait()
+
+	mu.Lock()
+	peak := maxInFlight
+	mu.Unlock()
+
+	if peak > 1 {
+		t.Errorf("per-chat lock violated: max concurrent in-flight was %d (expected 1)", peak)
+	}
+}
+
+func TestManager_ReadOnly(t *testing.T) {
+	cfg := testConfig(t)
+	var execs []*mockExec
+	mgr := NewManager(cfg, func() executor.Executor {
+		e := &mockExec{}
+		execs = append(execs, e)
+		return e
+	})
+
+	ctx := context.Background()
+	origin := Origin{ChatID: 1600}
+
+	mgr.Send(ctx, origin, "with tools")
+	if execs[0].sessCtx.ReadOnly {
+		t.Error("expected first session to start with tools")
+	}
+
+	mgr.SetReadOnly(origin, true)
+	if !mgr.ReadOnly(origin) {
+		t.Error("expected ReadOnly to report true after SetReadOnly")
+	}
+	if execs[0].stopped != 1 {
+		t.Errorf("expected toggling to reset the session, got %d stops", execs[0].stopped)
+	}
+
+	mgr.Send(ctx, origin, "no tools")
+	if len(execs) != 2 || !execs[1].sessCtx.ReadOnly {
+		t.Fatal("expected a new read-only session after toggling")
+	}
+
+	// Setting the same mode again keeps the session
+	mgr.SetReadOnly(origin, true)
+	if execs[1].stopped != 0 {
+		t.Error("expected no reset when the mode is unchanged")
+	}
+
+	// Other chats are unaffected
+	if mgr.ReadOnly(Origin{ChatID: 1601}) {
+		t.Error("expected read-only mode to be per chat")
+	}
+}
+
+func TestManager_TapObservesEvents(t *testing.T) {
+	cfg := testConfig(t)
+	mgr := NewManager(cfg, func() executor.Executor {
+		e := &mockExec{}
+		e.handler = func(msg string) (<-chan executor.Event, error) {
+			ch := make(chan executor.Event, 3)
+			ch <- executor.Event{Type: executor.EventText, Text: "one "}
+			ch <- executor.Event{Type: executor.EventText, Text: "two"}
+			ch <- executor.Event{Type: executor.EventDone, Text: "one two"}
+			close(ch)
+			return ch, nil
+		}
+		return e
+	})
+
+	var (
+		mu       sync.Mutex
+		turns    []Turn
+		observed []executor.Event
+	)
+	mgr.AddTap(func(turn Turn) func(executor.Event) {
+		mu.Lock()
+		turns = append(turns, turn)
+		mu.Unlock()
+		return func(evt executor.Event) {
+			mu.Lock()
+			observed = append(observed, evt)
+			mu.Unlock()
+		}
+	})
+	// A tap that skips the turn must not affect delivery
+	mgr.AddTap(func(Turn) func(executor.Event) { return nil })
+
+	events, err := mgr.Send(context.Background(), Origin{ChatID: 1400}, "count")
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	got := drain(t, events)
+
+	want := []executor.Event{
+		{Type: executor.EventText, Text: "one "},
+		{Type: executor.EventText, Text: "two"},
+		{Type: executor.EventDone, Text: "one two"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d delivered events, got %d: %+v", len(want), len(got), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("delivered[%d]: expected %+v, got %+v", i, want[i], got[i])
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(turns) != 1 || turns[0].Origin.ChatID != 1400 || turns[0].Message != "count" {
+		t.Errorf("unexpected turns seen by tap: %+v", turns)
+	}
+	if len(observed) != len(want) {
+		t.Fatalf("expected tap to observe %d events, got %d", len(want), len(observed))
+	}
+	for i := range want {
+		if observed[i] != want[i] {
+			t.Errorf("observed[%d]: expected %+v, got %+v", i, want[i], observed[i])
+		}
+	}
+}
+
+func TestManager_LastResponse(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Session.MaxResponseLength = 10
+	mgr := NewManager(cfg, func() executor.Executor { return &mockExec{} })
+
+	ctx := context.Background()
+	origin := Origin{ChatID: 2200}
+
+	if text, _ := mgr.LastResponse(origin); text != "" {
+		t.Errorf("expected no retained response before any turn, got %q", text)
+	}
+
+	events, _ := mgr.Send(ctx, origin, "hi")
+	drain(t, events)
+	if text, truncated := mgr.LastResponse(origin); text != "echo: hi" || truncated {
+		t.Errorf("expected untruncated 'echo: hi', got %q (truncated=%v)", text, truncated)
+	}
+
+	events, _ = mgr.Send(ctx, origin, "**bold** résumé")
+	drain(t, events)
+	text, truncated := mgr.LastResponse(origin)
+	if text != "echo: **bo" || !truncated {
+		t.Errorf("expected response capped at 10 runes, got %q (truncated=%v)", text, truncated)
+	}
+}
+
+func TestManager_RecordsTranscripts(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Transcripts.Dir = t.TempDir()
+	mgr := NewManager(cfg, func() executor.Executor { return &mockExec{} })
+
+	events, err := mgr.Send(context.Background(), Origin{ChatID: 1500, UserID: 7}, "hello")
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	drain(t, events)
+	mgr.Shutdown() // flushes the transcript writer
+
+	files, err := filepath.Glob(filepath.Join(cfg.Transcripts.Dir, "1500-*.jsonl"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected one transcript file for chat 1500, got %v (err %v)", files, err)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("read transcript: %v", err)
+	}
+
+	var entry transcript.Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatalf("unmarshal transcript %q: %v", data, err)
+	}
+	if entry.Message != "hello" || entry.Response != "echo: hello" || entry.UserID != 7 {
+		t.Errorf("unexpected transcript entry: %+v", entry)
+	}
+}
+
+func TestManager_FlushPersistsTranscripts(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Transcripts.Dir = t.TempDir()
+	cfg.Session.FlushTimeout = time.Second
+	mgr := NewManager(cfg, func() executor.Executor { return &mockExec{} })
+	defer mgr.Shutdown()
+
+	for _, msg := range []string{"one", "two"} {
+		events, err := mgr.Send(context.Background(), Origin{ChatID: 1501}, msg)
+		if err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		drain(t, events)
+	}
+	if err := mgr.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(cfg.Transcripts.Dir, "1501-*.jsonl"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected one transcript file after Flush, got %v (err %v)", files, err)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("read transcript: %v", err)
+	}
+	if n := strings.Count(string(data), "\n"); n != 2 {
+		t.Errorf("expected 2 entries persisted by Flush, got %d: %s", n, data)
+	}
+}
+
+func TestManager_UsageFromTranscripts(t *testing.T) {
+	cfg := testConfig(t)
+	mgr := NewManager(cfg, func() executor.Executor { return &mockExec{} })
+	if _, err := mgr.Usage(context.Background(), Origin{ChatID: 1}); !errors.Is(err, ErrNoTranscripts) {
+		t.Fatalf("Usage without transcripts = %v, want ErrNoTranscripts", err)
+	}
+
+	cfg.Transcripts.Dir = t.TempDir()
+	exec := &mockExec{handler: func(msg string) (<-chan executor.Event, error) {
+		ch := make(chan executor.Event, 1)
+		ch <- executor.Event{Type: executor.EventDone, Text: "ok", TurnCostUSD: 0.25, InputTokens: 100, OutputTokens: 10}
+		close(ch)
+		return ch, nil
+	}}
+	mgr = NewManager(cfg, func() executor.Executor { return exec })
+	defer mgr.Shutdown()
+	for range 2 {
+		events, err := mgr.Send(context.Background(), Origin{ChatID: 1}, "hi")
+		if err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		drain(t, events)
+	}
+
+	report, err := mgr.Usage(context.Background(), Origin{ChatID: 1})
+	if err != nil {
+		t.Fatalf("Usage: %v", err)
+	}
+	want := transcript.Usage{Turns: 2, CostUSD: 0.5, InputTokens: 200, OutputTokens: 20}
+	if report.Today != want || report.Week != want {
+		t.Errorf("usage = %+v, want %+v today and this week", report, want)
+	}
+}
+
+type activityExec struct {
+	mockExec
+}
+
+func (a *activityExec) RecentActivity(n int) []string {
+	lines := []string{"one", "two", "three"}
+	if n < len(lines) {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
+
+func TestManager_Activity(t *testing.T) {
+	cfg := testConfig(t)
+	mgr := NewManager(cfg, func() executor.Executor { return &activityExec{} })
+
+	if _, ok := mgr.Activity(Origin{ChatID: 1700}, 5); ok {
+		t.Fatal("expected no activity without a session")
+	}
+
+	events, _ := mgr.Send(context.Background(), Origin{ChatID: 1700}, "hi")
+	drain(t, events)
+	lines, ok := mgr.Activity(Origin{ChatID: 1700}, 2)
+	if !ok || strings.Join(lines, ",") != "two,three" {
+		t.Errorf("Activity = %q, %v; want the two newest lines", lines, ok)
+	}
+
+	plain := NewManager(cfg, func() executor.Executor { return &mockExec{} })
+	events, _ = plain.Send(context.Background(), Origin{ChatID: 1700}, "hi")
+	drain(t, events)
+	if _, ok := plain.Activity(Origin{ChatID: 1700}, 2); ok {
+		t.Error("expected no activity from an executor without a log")
+	}
+}
+
+func TestManager_Capabilities(t *testing.T) {
+	cfg := testConfig(t)
+	mgr := NewManager(cfg, func() executor.Executor { return &interruptExec{} })
+	if caps := mgr.Capabilities(Origin{ChatID: 1800}); !caps.SupportsInterrupt || caps.SupportsResume {
+		t.Errorf("expected interrupt derived from the Interrupter interface, got %+v", caps)
+	}
+
+	plain := NewManager(cfg, func() executor.Executor { return &mockExec{} })
+	events, _ := plain.Send(context.Background(), Origin{ChatID: 1800}, "hi")
+	drain(t, events)
+	if caps := plain.Capabilities(Origin{ChatID: 1800}); caps != (executor.ExecutorCapabilities{}) {
+		t.Errorf("expected no capabilities from a plain executor, got %+v", caps)
+	}
+}
+
+// readyExec completes its startup handshake delay after Start.
+type readyExec struct {
+	mockExec
+	delay   time.Duration
+	ready   chan struct{}
+	readyAt time.Time
+	sentAt  time.Time
+}
+
+func (r *readyExec) Start(ctx context.Context, dir string, sessCtx executor.SessionContext) error {
+	r.ready = make(chan struct{})
+	time.AfterFunc(r.delay, func() {
+		r.mu.Lock()
+		r.readyAt = time.Now()
+		r.mu.Unlock()
+		close(r.ready)
+	})
+	return r.mockExec.Start(ctx, dir, sessCtx)
+}
+
+func (r *readyExec) Ready() <-chan struct{} { return r.ready }
+
+func (r *readyExec) Send(ctx context.Context, msg string) (<-chan executor.Event, error) {
+	r.mu.Lock()
+	r.sentAt = time.Now()
+	r.mu.Unlock()
+	return r.mockExec.Send(ctx, msg)
+}
+
+func TestManager_WaitsForReady(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Session.ReadyTimeout = time.Second
+	exec := &readyExec{delay: 50 * time.Millisecond}
+	mgr := NewManager(cfg, func() executor.Executor { return exec })
+
+	events, err := mgr.Send(context.Background(), Origin{ChatID: 1900}, "hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	drain(t, events)
+
+	exec.mu.Lock()
+	defer exec.mu.Unlock()
+	if exec.readyAt.IsZero() || exec.sentAt.Before(exec.readyAt) {
+		t.Errorf("first message sent at %v, before ready at %v", exec.sentAt, exec.readyAt)
+	}
+}
+
+func TestManager_ReadyTimeout(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Session.ReadyTimeout = 20 * time.Millisecond
+	exec := &readyExec{delay: time.Hour}
+	mgr := NewManager(cfg, func() executor.Executor { return exec })
+
+	events, err := mgr.Send(context.Background(), Origin{ChatID: 1901}, "hi")
+	if err != nil {
+		t.Fatalf("expected the session to be used after the timeout, got %v", err)
+	}
+	if got := drain(t, events); len(got) == 0 {
+		t.Error("expected a response after the ready timeout")
+	}
+}
+
+func TestManager_TurnRequestID(t *testing.T) {
+	cfg := testConfig(t)
+	mgr := NewManager(cfg, func() executor.Executor { return &mockExec{} })
+
+	var mu sync.Mutex
+	var got string
+	mgr.AddTap(func(turn Turn) func(executor.Event) {
+		mu.Lock()
+		got = turn.RequestID
+		mu.Unlock()
+		return nil
+	})
+
+	ctx := reqid.With(context.Background(), "abcd1234")
+	events, _ := mgr.Send(ctx, Origin{ChatID: 2000}, "hi")
+	drain(t, events)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if got != "abcd1234" {
+		t.Errorf("turn request ID = %q, want the one from the context", got)
+	}
+}
+
+func TestManager_RecentHistoryFromContext(t *testing.T) {
+	cfg := testConfig(t)
+	exec := &mockExec{}
+	mgr := NewManager(cfg, func() executor.Executor { return exec })
+
+	ctx := WithRecentHistory(context.Background(), "[15:04] @alice: hi")
+	events, _ := mgr.Send(ctx, Origin{ChatID: 2100}, "hello")
+	drain(t, events)
+
+	exec.mu.Lock()
+	defer exec.mu.Unlock()
+	if exec.sessCtx.RecentHistory != "[15:04] @alice: hi" {
+		t.Errorf("RecentHistory = %q, want the history from the context", exec.sessCtx.RecentHistory)
+	}
+}
+
+func TestManager_Ask(t *testing.T) {
+	cfg := testConfig(t)
+	var asked string
+	exec := &mockExec{handler: func(msg string) (<-chan executor.Event, error) {
+		asked = msg
+		ch := make(chan executor.Event, 1)
+		ch <- executor.Event{Type: executor.EventDone, Text: "  Forty-two.\n"}
+		close(ch)
+		return ch, nil
+	}}
+	mgr := NewManager(cfg, func() executor.Executor { return exec })
+
+	answer, err := mgr.Ask(context.Background(), "haiku", "meaning of life?")
+	if err != nil {
+		t.Fatalf("Ask: %v", err)
+	}
+	if answer != "Forty-two." {
+		t.Errorf("answer = %q, want the trimmed reply", answer)
+	}
+	if !strings.HasSuffix(asked, "meaning of life?") {
+		t.Errorf("prompt should end with the question, got %q", asked)
+	}
+	if !exec.sessCtx.ReadOnly || exec.sessCtx.Model != "haiku" || exec.stopped != 1 {
+		t.Errorf("expected a stopped read-only haiku executor, got ctx %+v stopped %d", exec.sessCtx, exec.stopped)
+	}
+	if len(mgr.sessions) != 0 {
+		t.Error("Ask must not create a chat session")
+	}
+
+	// A turn that outlives ctx is abandoned.
+	exec.handler = func(string) (<-chan executor.Event, error) { return make(chan executor.Event), nil }
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := mgr.Ask(ctx, "", "slow"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline error, got %v", err)
+	}
+}
+
+func TestManager_SerializesTurnsAndReportsQueue(t *testing.T) {
+	cfg := testConfig(t)
+	first := make(chan executor.Event, 1)
+	var sends atomic.Int32
+	exec := &mockExec{handler: func(msg string) (<-chan executor.Event, error) {
+		if sends.Add(1) == 1 {
+			return first, nil
+		}
+		ch := make(chan executor.Event, 1)
+		ch <- executor.Event{Type: executor.EventDone, Text: "second"}
+		close(ch)
+		return ch, nil
+	}}
+	mgr := NewManager(cfg, func() executor.Executor { return exec })
+	origin := Origin{ChatID: 1}
+	ctx := context.Background()
+
+	if info := mgr.Status(origin); !info.TurnStarted.IsZero() || info.Queued != 0 {
+		t.Fatalf("idle chat reports a turn: %+v", info)
+	}
+	events, err := mgr.Send(ctx, origin, "first")
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	second := make(chan (<-chan executor.Event))
+	for range 2 {
+		go func() {
+			ch, err := mgr.Send(ctx, origin, "queued")
+			if err != nil {
+				t.Errorf("queued Send: %v", err)
+			}
+			second <- ch
+		}()
+	}
+	deadline := time.Now().Add(time.Second)
+	for mgr.Status(origin).Queued != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected two queued sends, status %+v", mgr.Status(origin))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if info := mgr.Status(origin); info.TurnStarted.IsZero() || !info.Exists {
+		t.Errorf("expected the running turn's start time, got %+v", info)
+	}
+	if n := sends.Load(); n != 1 {
+		t.Fatalf("queued messages reached the executor during a turn: %d sends", n)
+	}
+
+	first <- executor.Event{Type: executor.EventDone, Text: "first"}
+	close(first)
+	for range events {
+	}
+	for range 2 {
+		for range <-second {
+		}
+	}
+	if n := sends.Load(); n != 3 {
+		t.Errorf("expected the queued sends to run after the turn, got %d sends", n)
+	}
+	if info := mgr.Status(origin); !info.TurnStarted.IsZero() || info.Queued != 0 {
+		t.Errorf("expected an idle chat after the queue drained, got %+v", info)
+	}
+}
+
+func TestManager_QueuedSendCancelled(t *testing.T) {
+	cfg := testConfig(t)
+	hold := make(chan executor.Event)
+	exec := &mockExec{handler: func(string) (<-chan executor.Event, error) { return hold, nil }}
+	mgr := NewManager(cfg, func() executor.Executor { return exec })
+	origin := Origin{ChatID: 1}
+
+	if _, err := mgr.Send(context.Background(), origin, "first"); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := mgr.Send(ctx, origin, "second"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the queued send to give up with its context, got %v", err)
+	}
+	if info := mgr.Status(origin); info.Queued != 0 {
+		t.Errorf("a cancelled send should leave the queue, got %+v", info)
+	}
+	close(hold)
+}
+
+// initExec echoes, recording every message it's sent, and answers the
+// init prompt with initReply, or blocks until the turn ends when hang is set.
+func initExec(sent *[]string, mu *sync.Mutex, hang bool) *mockExec {
+	return &mockExec{handler: func(msg string) (<-chan executor.Event, error) {
+		mu.Lock()
+		*sent = append(*sent, msg)
+		mu.Unlock()
+		ch := make(chan executor.Event, 2)
+		if msg == "read the README" {
+			if hang {
+				return ch, nil // never answers
+			}
+			ch <- executor.Event{Type: executor.EventDone, Text: "Ready."}
+		} else {
+			ch <- executor.Event{Type: executor.EventText, Text: "echo: " + msg}
+			ch <- executor.Event{Type: executor.EventDone, Text: "echo: " + msg}
+		}
+		close(ch)
+		return ch, nil
+	}}
+}
+
+// finalText returns a turn's done text.
+func finalText(t *testing.T, events <-chan executor.Event) string {
+	t.Helper()
+	var text string
+	for _, evt := range drain(t, events) {
+		if evt.Type == executor.EventDone {
+			text = evt.Text
+		}
+	}
+	return text
+}
+
+func TestManager_InitPromptOncePerSession(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Workspaces.InitPrompts = map[string]config.InitPrompt{
+		"home": {Prompt: "read the README", Timeout: time.Second},
+	}
+	var mu sync.Mutex
+	var sent []string
+	mgr := NewManager(cfg, func() executor.Executor { return initExec(&sent, &mu, false) })
+	origin := Origin{ChatID: 1}
+
+	for _, msg := range []string{"one", "two"} {
+		events, err := mgr.Send(context.Background(), origin, msg)
+		if err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		if got := finalText(t, events); got != "echo: "+msg {
+			t.Errorf("reply = %q, want hidden init reply", got)
+		}
+	}
+	mgr.Reset(origin)
+	events, err := mgr.Send(context.Background(), origin, "three")
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	finalText(t, events)
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"read the README", "one", "two", "read the README", "three"}
+	if fmt.Sprint(sent) != fmt.Sprint(want) {
+		t.Errorf("sent = %q, want %q", sent, want)
+	}
+}
+
+func TestManager_InitPromptShown(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Workspaces.InitPrompts = map[string]config.InitPrompt{
+		"home": {Prompt: "read the README", Show: true, Timeout: time.Second},
+	}
+	var mu sync.Mutex
+	var sent []string
+	mgr := NewManager(cfg, func() executor.Executor { return initExec(&sent, &mu, false) })
+
+	events, err := mgr.Send(context.Background(), Origin{ChatID: 1}, "hi")
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if got := finalText(t, events); got != "Ready.\n\necho: hi" {
+		t.Errorf("first reply = %q, want init reply prepended", got)
+	}
+	events, err = mgr.Send(context.Background(), Origin{ChatID: 1}, "again")
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if got := finalText(t, events); got != "echo: again" {
+		t.Errorf("second reply = %q, want no init reply", got)
+	}
+}
+
+func TestManager_InitPromptTimeoutFallsThrough(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Workspaces.InitPrompts = map[string]config.InitPrompt{
+		"home": {Prompt: "read the README", Show: true, Timeout: 20 * time.Millisecond},
+	}
+	var mu sync.Mutex
+	var sent []string
+	var execs []*mockExec
+	mgr := NewManager(cfg, func() executor.Executor {
+		// Only the first executor's init hangs; the restart skips init.
+		exec := initExec(&sent, &mu, len(execs) == 0)
+		execs = append(execs, exec)
+		return exec
+	})
+
+	events, err := mgr.Send(context.Background(), Origin{ChatID: 1}, "hi")
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if got := finalText(t, events); got != "echo: hi" {
+		t.Errorf("reply = %q, want echo", got)
+	}
+	if len(execs) != 2 || execs[0].stopped != 1 {
+		t.Fatalf("executors = %d, want the timed-out one stopped and replaced", len(execs))
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"read the README", "hi"}; fmt.Sprint(sent) != fmt.Sprint(want) {
+		t.Errorf("sent = %q, want %q", sent, want)
+	}
+}
+
+// barrierExec blocks in Start until every chat's spawn has begun, so the
+// test deadlocks (and fails) if spawns across chats are serialized.
+type barrierExec struct {
+	mockExec
+	inflight *atomic.Int32
+	chats    int32
+	release  chan struct{}
+}
+
+func (e *barrierExec) Start(ctx context.Context, workDir string, sessCtx executor.SessionContext) error {
+	if e.inflight.Add(1) == e.chats {
+		close(e.release)
+	}
+	select {
+	case <-e.release:
+	case <-time.After(2 * time.Second):
+		return errors.New("spawns for different chats did not overlap")
+	}
+	return e.mockExec.Start(ctx, workDir, sessCtx)
+}
+
+func TestManager_ConcurrentFirstMessagesShareSpawn(t *testing.T) {
+	const chats, perChat = 3, 5
+	cfg := testConfig(t)
+	var spawns, inflight atomic.Int32
+	release := make(chan struct{})
+	mgr := NewManager(cfg, func() executor.Executor {
+		spawns.Add(1)
+		return &barrierExec{inflight: &inflight, chats: chats, release: release}
+	})
+
+	var wg sync.WaitGroup
+	got := make([][]*Session, chats)
+	for c := range chats {
+		got[c] = make([]*Session, perChat)
+		for i := range perChat {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				sess, err := mgr.getOrCreate(context.Background(), Origin{ChatID: int64(c + 1)})
+				if err != nil {
+					t.Errorf("chat %d: %v", c+1, err)
+				}
+				got[c][i] = sess
+			}()
+		}
+	}
+	wg.Wait()
+
+	if n := spawns.Load(); n != chats {
+		t.Errorf("spawned %d executors, want one per chat (%d)", n, chats)
+	}
+	for c := range chats {
+		for i := range perChat {
+			if got[c][i] != got[c][0] {
+				t.Errorf("chat %d: caller %d got a different session", c+1, i)
+			}
+		}
+	}
+}
+
+// stoppableExec streams one chunk and then holds the turn open until Stop,
+// which closes the stream the way a killed process does.
+type stoppableExec struct {
+	mockExec
+	turn chan executor.Event
+}
+
+func (e *stoppableExec) Send(context.Context, string) (<-chan executor.Event, error) {
+	e.turn = make(chan executor.Event, 1)
+	e.turn <- executor.Event{Type: executor.EventText, Text: "working on it"}
+	return e.turn, nil
+}
+
+func (e *stoppableExec) Stop() error {
+	e.mockExec.Stop()
+	close(e.turn)
+	return nil
+}
+
+func TestManager_ResetDuringTurnEndsWithResetError(t *testing.T) {
+	cfg := testConfig(t)
+	exec := &stoppableExec{}
+	mgr := NewManager(cfg, func() executor.Executor { return exec })
+	origin := Origin{ChatID: 1}
+
+	events, err := mgr.Send(context.Background(), origin, "long task")
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if first := <-events; first.Text != "working on it" {
+		t.Fatalf("first event = %+v", first)
+	}
+	mgr.Reset(origin)
+
+	got := drain(t, events)
+	if len(got) != 1 || got[0].Type != executor.EventError || !errors.Is(got[0].Error, ErrSessionReset) {
+		t.Fatalf("events after reset = %+v, want a single ErrSessionReset", got)
+	}
+	if exec.stopped != 1 {
+		t.Errorf("executor stopped %d times, want 1", exec.stopped)
+	}
+	if mgr.Status(origin).Exists {
+		t.Error("expected the session removed")
+	}
+
+	// The turn gate was released, so the next message isn't stuck behind
+	// the cut-off turn.
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, err := mgr.Send(ctx, origin, "again"); err != nil {
+		t.Fatalf("Send after reset: %v", err)
+	}
+}
+
+func TestManager_TurnContextHeader(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		t.Run(fmt.Sprint("enabled=", enabled), func(t *testing.T) {
+			cfg := testConfig(t)
+			cfg.Session.TurnContext = config.TurnContextConfig{Enabled: enabled, Header: "[in {workspace}]"}
+			var mu sync.Mutex
+			var sent []string
+			mgr := NewManager(cfg, func() executor.Executor { return initExec(&sent, &mu, false) })
+
+			for _, msg := range []string{"one", "two"} {
+				events, err := mgr.Send(context.Background(), Origin{ChatID: 1}, msg)
+				if err != nil {
+					t.Fatalf("Send: %v", err)
+				}
+				finalText(t, events)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			want := []string{"one", "two"}
+			if enabled {
+				want = []string{"[in home]\n\none", "[in home]\n\ntwo"}
+			}
+			if fmt.Sprint(sent) != fmt.Sprint(want) {
+				t.Errorf("sent = %q, want %q", sent, want)
+			}
+		})
+	}
+}
+
+func TestManager_AttributeSenders(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Workspaces.AttributeSenders = []string{"home"}
+	cfg.Workspaces.ChatMap = map[string]string{"-6": "other"}
+	var mu sync.Mutex
+	var sent []string
+	mgr := NewManager(cfg, func() executor.Executor { return initExec(&sent, &mu, false) })
+
+	for _, origin := range []Origin{
+		{ChatID: -5, Group: true, Sender: "alice"}, // listed workspace
+		{ChatID: 5, Sender: "alice"},               // DM
+		{ChatID: -6, Group: true, Sender: "bob"},   // unlisted workspace
+		{ChatID: -5, Group: true},                  // sender unknown
+	} {
+		events, err := mgr.Send(context.Background(), origin, "hi")
+		if err != nil {
+			t.Fatalf("Send(%+v): %v", origin, err)
+		}
+		finalText(t, events)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"alice: hi", "hi", "hi", "hi"}
+	if fmt.Sprint(sent) != fmt.Sprint(want) {
+		t.Errorf("sent = %q, want %q", sent, want)
+	}
+}
+
+func TestManager_SetModelAllowlist(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		model   string
+		wantErr bool
+	}{
+		{name: "listed", allowed: []string{"opus", "sonnet"}, model: "opus"},
+		{name: "unlisted", allowed: []string{"opus", "sonnet"}, model: "gpt-5", wantErr: true},
+		{name: "allow any", model: "whatever-new"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t)
+			cfg.Claude.Model = "sonnet"
+			cfg.Claude.AllowedModels = tt.allowed
+			var last *mockExec
+			mgr := NewManager(cfg, func() executor.Executor {
+				last = &mockExec{}
+				return last
+			})
+			origin := Origin{ChatID: 1}
+
+			err := mgr.SetModel(origin, tt.model)
+			if tt.wantErr {
+				if !errors.Is(err, ErrUnknownModel) {
+					t.Fatalf("SetModel(%q) = %v, want ErrUnknownModel", tt.model, err)
+				}
+				if got := mgr.Model(origin); got != "sonnet" {
+					t.Errorf("Model = %q after a rejected switch, want sonnet", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SetModel(%q): %v", tt.model, err)
+			}
+			if got := mgr.Model(origin); got != tt.model {
+				t.Errorf("Model = %q, want %q", got, tt.model)
+			}
+
+			events, err := mgr.Send(context.Background(), origin, "hi")
+			if err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+			drain(t, events)
+			if last.sessCtx.Model != tt.model {
+				t.Errorf("session started on %q, want %q", last.sessCtx.Model, tt.model)
+			}
+		})
+	}
+}
+
+func TestManager_PersonaIsPerChat(t *testing.T) {
+	cfg := testConfig(t)
+	execs := map[int64]*mockExec{}
+	var next int64
+	mgr := NewManager(cfg, func() executor.Executor {
+		e := &mockExec{}
+		execs[next] = e
+		return e
+	})
+	start := func(chatID int64) *mockExec {
+		t.Helper()
+		next = chatID
+		events, err := mgr.Send(context.Background(), Origin{ChatID: chatID}, "hi")
+		if err != nil {
+			t.Fatalf("Send(%d): %v", chatID, err)
+		}
+		drain(t, events)
+		return execs[chatID]
+	}
+
+	mgr.SetPersona(Origin{ChatID: 1}, "  Answer like a pirate.  ")
+	if got := mgr.Persona(Origin{ChatID: 1}); got != "Answer like a pirate." {
+		t.Errorf("Persona = %q, want it trimmed", got)
+	}
+
+	if doc := start(1).sessCtx.IdentityDoc; !strings.Contains(doc, personaHeader+"Answer like a pirate.") {
+		t.Errorf("chat 1 identity doc missing persona:\n%s", doc)
+	}
+	if doc := start(2).sessCtx.IdentityDoc; strings.Contains(doc, "pirate") {
+		t.Errorf("chat 2 identity doc has chat 1's persona:\n%s", doc)
+	}
+
+	mgr.SetPersona(Origin{ChatID: 1}, "")
+	if mgr.Status(Origin{ChatID: 1}).Exists {
+		t.Fatal("clearing the persona kept the old session")
+	}
+	if doc := start(1).sessCtx.IdentityDoc; strings.Contains(doc, "pirate") {
+		t.Errorf("identity doc still has the cleared persona:\n%s", doc)
+	}
+}
+
+func TestManager_QuickUsesLaneModel(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Claude.Model = "sonnet"
+	var execs []*mockExec
+	mgr := NewManager(cfg, func() executor.Executor {
+		e := &mockExec{}
+		execs = append(execs, e)
+		return e
+	})
+	origin := Origin{ChatID: 886}
+
+	answer, err := mgr.Quick(context.Background(), origin, "haiku", "2+2?")
+	if err != nil {
+		t.Fatalf("Quick: %v", err)
+	}
+	if answer != "echo: 2+2?" {
+		t.Errorf("Quick = %q, want the lane's reply", answer)
+	}
+	if len(execs) != 1 || execs[0].sessCtx.Model != "haiku" || !execs[0].sessCtx.ReadOnly || execs[0].Alive() {
+		t.Fatalf("lane executor = %+v, want a stopped read-only haiku one", execs)
+	}
+	if mgr.Status(origin).Exists {
+		t.Error("Quick created a session for the chat")
+	}
+
+	events, err := mgr.Send(context.Background(), origin, "hi")
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	drain(t, events)
+	if len(execs) != 2 || execs[1].sessCtx.Model != "" {
+		t.Errorf("plain message ran on %q, want the default model", execs[len(execs)-1].sessCtx.Model)
+	}
+}
+
+func TestManager_IdleNudge(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Session.InactivityTimeout = 10 * time.Minute
+	cfg.Session.IdleNudge = 2 * time.Minute
+	mgr := NewManager(cfg, func() executor.Executor { return &mockExec{} })
+	clock := &fakeClock{now: time.Unix(1_700_000_000, 0)}
+	mgr.now = clock.Now
+	var nudges []time.Duration
+	mgr.OnIdleNudge(func(o Origin, left time.Duration) {
+		if o.ChatID != 885 {
+			t.Errorf("nudged chat %d, want 885", o.ChatID)
+		}
+		nudges = append(nudges, left)
+	})
+	origin := Origin{ChatID: 885}
+	alive := func() bool { return mgr.Status(origin).Exists }
+
+	events, _ := mgr.Send(context.Background(), origin, "hi")
+	drain(t, events)
+
+	clock.Advance(7 * time.Minute)
+	idleTick(mgr, origin)
+	if len(nudges) != 0 {
+		t.Fatalf("nudged %v before the nudge window", nudges)
+	}
+	clock.Advance(time.Minute)
+	idleTick(mgr, origin)
+	clock.Advance(time.Minute)
+	idleTick(mgr, origin)
+	if !slices.Equal(nudges, []time.Duration{2 * time.Minute}) || !alive() {
+		t.Fatalf("nudges = %v, alive = %v; want one nudge with 2m left and the session kept", nudges, alive())
+	}
+
+	// A turn starts a new idle period, which gets its own nudge.
+	events, _ = mgr.Send(context.Background(), origin, "still here")
+	drain(t, events)
+	clock.Advance(9 * time.Minute)
+	idleTick(mgr, origin)
+	clock.Advance(time.Minute)
+	idleTick(mgr, origin)
+	if len(nudges) != 2 || nudges[1] != time.Minute {
+		t.Errorf("nudges = %v, want a second one with 1m left", nudges)
+	}
+	if alive() {
+		t.Error("expected expiry at the full timeout after the nudge")
+	}
+}
+
+func TestManager_OnReap(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Session.InactivityTimeout = 10 * time.Minute
+	cfg.Session.PerTopic = true
+	mgr := NewManager(cfg, func() executor.Executor { return &mockExec{} })
+	clock := &fakeClock{now: time.Unix(1_700_000_000, 0)}
+	mgr.now = clock.Now
+	var reaped []Origin
+	mgr.OnReap(func(o Origin) { reaped = append(reaped, o) })
+	origin := Origin{ChatID: 886, ThreadID: 4}
+
+	events, _ := mgr.Send(context.Background(), origin, "hi")
+	drain(t, events)
+
+	clock.Advance(9 * time.Minute)
+	idleTick(mgr, origin)
+	if len(reaped) != 0 {
+		t.Fatalf("reap callback fired at %v before the timeout", reaped)
+	}
+	clock.Advance(time.Minute)
+	idleTick(mgr, origin)
+	if len(reaped) != 1 || reaped[0].ChatID != 886 || reaped[0].ThreadID != 4 {
+		t.Fatalf("reaped = %+v, want chat 886's topic 4 once", reaped)
+	}
+
+	// Resetting isn't a reap.
+	events, _ = mgr.Send(context.Background(), origin, "back")
+	drain(t, events)
+	mgr.Reset(origin)
+	if len(reaped) != 1 {
+		t.Errorf("reset fired the reap callback: %+v", reaped)
+	}
+}
+
+func TestManager_KeepPausesExpiry(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Session.InactivityTimeout = 10 * time.Minute
+	cfg.Session.KeepLimit = time.Hour
+	mgr := NewManager(cfg, func() executor.Executor { return &mockExec{} })
+	clock := &fakeClock{now: time.Unix(1_700_000_000, 0)}
+	mgr.now = clock.Now
+	origin := Origin{ChatID: 884}
+	alive := func() bool { return mgr.Status(origin).Exists }
+
+	if _, err := mgr.Keep(origin, true); !errors.Is(err, ErrNoSession) {
+		t.Fatalf("Keep without a session = %v, want ErrNoSession", err)
+	}
+	events, _ := mgr.Send(context.Background(), origin, "hi")
+	drain(t, events)
+
+	// Paused, then resumed by hand.
+	if limit, err := mgr.Keep(origin, true); err != nil || limit != time.Hour {
+		t.Fatalf("Keep = %v, %v; want 1h", limit, err)
+	}
+	clock.Advance(30 * time.Minute)
+	idleTick(mgr, origin)
+	if !alive() {
+		t.Fatal("kept session expired")
+	}
+	if _, err := mgr.Keep(origin, false); err != nil {
+		t.Fatalf("Keep off: %v", err)
+	}
+	clock.Advance(5 * time.Minute)
+	idleTick(mgr, origin)
+	if !alive() {
+		t.Fatal("idle timeout should count from the resume")
+	}
+	clock.Advance(5 * time.Minute)
+	idleTick(mgr, origin)
+	if alive() {
+		t.Fatal("expected expiry a full timeout after resuming")
+	}
+
+	// Paused and forgotten: the keep limit resumes reaping.
+	events, _ = mgr.Send(context.Background(), origin, "back")
+	drain(t, events)
+	if _, err := mgr.Keep(origin, true); err != nil {
+		t.Fatalf("Keep: %v", err)
+	}
+	clock.Advance(time.Hour)
+	idleTick(mgr, origin)
+	if !alive() {
+		t.Fatal("reaching the keep limit should resume the timer, not expire at once")
+	}
+	clock.Advance(10 * time.Minute)
+	idleTick(mgr, origin)
+	if alive() {
+		t.Error("expected expiry after the keep limit plus the idle timeout")
+	}
+}
+
+func TestManager_RemoveSessionKeepsReplacement(t *testing.T) {
+	cfg := testConfig(t)
+	mgr := NewManager(cfg, func() executor.Executor { return &mockExec{} })
+	origin := Origin{ChatID: 1}
+
+	events, err := mgr.Send(context.Background(), origin, "hi")
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	drain(t, events)
+	key := mgr.key(origin)
+	old := mgr.sessions[key]
+
+	mgr.Reset(origin)
+	events, err = mgr.Send(context.Background(), origin, "again")
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	drain(t, events)
+
+	mgr.removeSession(old)
+	if sess := mgr.sessions[key]; sess == nil || sess == old || !sess.exec.Alive() {
+		t.Error("removing a stale session must leave its replacement running")
+	}
+}
+
+func TestManager_OverridesReportAndClear(t *testing.T) {
+	cfg := testConfig(t)
+	mgr := NewManager(cfg, func() executor.Executor { return &mockExec{} })
+	origin := Origin{ChatID: 1}
+
+	if got := mgr.Overrides(origin); got != (Overrides{}) {
+		t.Fatalf("Overrides = %+v, want none set", got)
+	}
+
+	mgr.SetReadOnly(origin, true)
+	if err := mgr.SetModel(origin, "opus"); err != nil {
+		t.Fatalf("SetModel: %v", err)
+	}
+	mgr.SetPersona(origin, "Be brief.")
+	mgr.SetIdleTimeout(origin, 0)
+
+	got := mgr.Overrides(origin)
+	if !got.ReadOnly || got.Model != "opus" || got.Persona != "Be brief." {
+		t.Errorf("Overrides = %+v, want read-only, opus and the persona", got)
+	}
+	if got.Timeout == nil || *got.Timeout != 0 {
+		t.Errorf("Overrides.Timeout = %v, want an override of 0", got.Timeout)
+	}
+	if other := mgr.Overrides(Origin{ChatID: 2}); other != (Overrides{}) {
+		t.Errorf("chat 2 Overrides = %+v, want none set", other)
+	}
+
+	events, err := mgr.Send(context.Background(), origin, "hi")
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	drain(t, events)
+
+	mgr.ClearOverrides(origin)
+	if got := mgr.Overrides(origin); got != (Overrides{}) {
+		t.Errorf("Overrides after clear = %+v, want none set", got)
+	}
+	if mgr.Status(origin).Exists {
+		t.Error("clearing overrides kept the old session")
+	}
+	if d := mgr.IdleTimeout(origin); d != cfg.Session.InactivityTimeout {
+		t.Errorf("IdleTimeout after clear = %v, want the config default %v", d, cfg.Session.InactivityTimeout)
+	}
+}
+
+// --- helpers ---
+
+// waitFor polls cond until it holds or a deadline passes.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met before deadline")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
 
 func drain(t *testing.T, ch <-chan executor.Event) []executor.Event {
 	t.Helper()
@@ -0,0 +1,121 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// MemoryStore holds the shared memory that every new session loads as part
+// of its identity.
+type MemoryStore interface {
+	// Read returns the full memory text, or "" if none has been written.
+	Read() (string, error)
+
+	// Append adds line to the end of the memory, on a line of its own.
+	Append(line string) error
+
+	// Rewrite replaces base, the memory as read earlier, with text. Lines
+	// appended since are kept after text. It fails with ErrMemoryChanged
+	// if the memory no longer starts with base.
+	Rewrite(base, text string) error
+}
+
+// ErrMemoryChanged is returned by Rewrite when the memory was edited other
+// than by appending since it was read.
+var ErrMemoryChanged = errors.New("memory changed since it was read")
+
+// FileMemory is a MemoryStore backed by a markdown file. Writes are
+// serialized so concurrent writers never interleave.
+type FileMemory struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileMemory returns a store for the file at path, which need not exist.
+func NewFileMemory(path string) *FileMemory {
+	return &FileMemory{path: path}
+}
+
+func (f *FileMemory) Read() (string, error) {
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("read memory file: %w", err)
+	}
+	return string(data), nil
+}
+
+func (f *FileMemory) Append(line string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(f.path), 0o755); err != nil {
+		return fmt.Errorf("create memory dir: %w", err)
+	}
+	file, err := os.OpenFile(f.path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("open memory file: %w", err)
+	}
+	defer file.Close()
+
+	entry := strings.TrimRight(line, "\n") + "\n"
+	// Start on a fresh line if the file was edited by hand without one.
+	if info, err := file.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			entry = "\n" + entry
+		}
+	}
+	if _, err := file.WriteString(entry); err != nil {
+		return fmt.Errorf("append memory: %w", err)
+	}
+	return nil
+}
+
+// Rewrite writes the new memory to a temporary file and renames it into
+// place, so readers see either the old memory or the new one in full.
+func (f *FileMemory) Rewrite(base, text string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	current, err := f.Read()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(current, base) {
+		return ErrMemoryChanged
+	}
+	content := strings.TrimRight(text, "\n") + "\n"
+	if appended := strings.TrimLeft(current[len(base):], "\n"); appended != "" {
+		content += appended
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), ".memory-*")
+	if err != nil {
+		return fmt.Errorf("create temp memory file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write memory: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write memory: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return fmt.Errorf("write memory: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		return fmt.Errorf("replace memory file: %w", err)
+	}
+	return nil
+}
+
+var _ MemoryStore = (*FileMemory)(nil)
@@ -0,0 +1,213 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/zette-dev/natron/internal/executor"
+)
+
+// memMemory is an in-memory MemoryStore for tests.
+type memMemory struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (m *memMemory) Read() (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return strings.Join(m.lines, "\n"), nil
+}
+
+func (m *memMemory) Append(line string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lines = append(m.lines, line)
+	return nil
+}
+
+func (m *memMemory) Rewrite(base, text string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	current := strings.Join(m.lines, "\n")
+	if !strings.HasPrefix(current, base) {
+		return ErrMemoryChanged
+	}
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	if appended := strings.TrimLeft(current[len(base):], "\n"); appended != "" {
+		lines = append(lines, strings.Split(appended, "\n")...)
+	}
+	m.lines = lines
+	return nil
+}
+
+func TestFileMemory_MissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "memory.md")
+	mem := NewFileMemory(path)
+
+	if text, err := mem.Read(); text != "" || err != nil {
+		t.Fatalf("Read of missing file = %q, %v; want empty, nil", text, err)
+	}
+	if err := mem.Append("first"); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if text, _ := mem.Read(); text != "first\n" {
+		t.Errorf("expected appended line, got %q", text)
+	}
+}
+
+func TestManager_UsesMemoryStore(t *testing.T) {
+	mgr := NewManager(testConfig(t), func() executor.Executor { return &mockExec{} })
+	mem := &memMemory{lines: []string{"likes Go"}}
+	mgr.memory = mem
+
+	if err := mgr.Remember("  prefers short answers "); err != nil {
+		t.Fatalf("Remember: %v", err)
+	}
+	if len(mem.lines) != 2 || !strings.HasSuffix(mem.lines[1], ": prefers short answers") {
+		t.Errorf("unexpected memory lines: %q", mem.lines)
+	}
+	identity := mgr.loadIdentity("")
+	if !strings.Contains(identity, "## Shared Memory\n\nlikes Go\n- ") {
+		t.Errorf("expected identity to include the store's memory, got %q", identity)
+	}
+}
+
+func TestFileMemory_Rewrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "memory.md")
+	mem := NewFileMemory(path)
+	mem.Append("one")
+	mem.Append("two")
+	base, _ := mem.Read()
+	mem.Append("three")
+
+	if err := mem.Rewrite(base, "one and two"); err != nil {
+		t.Fatalf("Rewrite: %v", err)
+	}
+	if text, _ := mem.Read(); text != "one and two\nthree\n" {
+		t.Errorf("expected rewrite followed by the later append, got %q", text)
+	}
+
+	if err := mem.Rewrite(base, "stale"); !errors.Is(err, ErrMemoryChanged) {
+		t.Errorf("expected ErrMemoryChanged for a stale base, got %v", err)
+	}
+	if text, _ := mem.Read(); text != "one and two\nthree\n" {
+		t.Errorf("failed rewrite changed the memory: %q", text)
+	}
+}
+
+func TestManager_CompactMemory(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Claude.MemoryPath = filepath.Join(t.TempDir(), "memory.md")
+	var mgr *Manager
+	var prompt string
+	exec := &mockExec{handler: func(msg string) (<-chan executor.Event, error) {
+		prompt = msg
+		// A note remembered mid-compaction must survive it.
+		mgr.Remember("late note")
+		ch := make(chan executor.Event, 1)
+		ch <- executor.Event{Type: executor.EventDone, Text: "- likes Go, short answers"}
+		close(ch)
+		return ch, nil
+	}}
+	mgr = NewManager(cfg, func() executor.Executor { return exec })
+
+	if _, _, err := mgr.CompactMemory(context.Background()); !errors.Is(err, ErrNoMemory) {
+		t.Fatalf("expected ErrNoMemory before anything is remembered, got %v", err)
+	}
+	for _, note := range []string{"likes Go", "really likes Go", "prefers short answers"} {
+		mgr.Remember(note)
+	}
+
+	before, after, err := mgr.CompactMemory(context.Background())
+	if err != nil {
+		t.Fatalf("CompactMemory: %v", err)
+	}
+	text, _ := mgr.memory.Read()
+	if !strings.HasPrefix(text, "- likes Go, short answers\n- ") || !strings.HasSuffix(text, ": late note\n") {
+		t.Errorf("unexpected compacted memory %q", text)
+	}
+	if after != len(text) || before <= after {
+		t.Errorf("sizes before=%d after=%d, memory is %d bytes", before, after, len(text))
+	}
+	if !strings.Contains(prompt, "really likes Go") {
+		t.Errorf("prompt should include the memory, got %q", prompt)
+	}
+	if !exec.sessCtx.ReadOnly || exec.stopped != 1 {
+		t.Errorf("expected a read-only executor that was stopped, got ctx %+v stopped %d", exec.sessCtx, exec.stopped)
+	}
+}
+
+func TestManager_IdentityTruncatesOldestMemory(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Claude.SoulPath = filepath.Join(t.TempDir(), "soul.md")
+	soul := strings.Repeat("I am natron. ", 10)
+	if err := os.WriteFile(cfg.Claude.SoulPath, []byte(soul), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mgr := NewManager(cfg, func() executor.Executor { return &mockExec{} })
+	mem := &memMemory{}
+	for i := range 50 {
+		mem.lines = append(mem.lines, fmt.Sprintf("- note %02d", i))
+	}
+	mgr.memory = mem
+
+	full := mgr.loadIdentity("")
+	mgr.cfg.Claude.MaxIdentityChars = 300
+	identity := mgr.loadIdentity("")
+
+	if n := utf8.RuneCountInString(identity); n > 300 || n >= len(full) {
+		t.Errorf("identity is %d chars, want at most 300 (untruncated %d)", n, len(full))
+	}
+	if !strings.HasPrefix(identity, strings.TrimSpace(soul)) {
+		t.Errorf("soul should be kept whole, got %q", identity)
+	}
+	if !strings.Contains(identity, "## Shared Memory\n\n[memory truncated]\n- note ") {
+		t.Errorf("expected the truncation marker ahead of the kept notes, got %q", identity)
+	}
+	if !strings.HasSuffix(identity, "- note 49") || strings.Contains(identity, "- note 00") {
+		t.Errorf("expected the newest notes kept and the oldest dropped, got %q", identity)
+	}
+
+	mgr.cfg.Claude.MaxIdentityChars = len(full)
+	if got := mgr.loadIdentity(""); got != full {
+		t.Errorf("identity within the limit should be untouched, got %q", got)
+	}
+}
+
+func TestManager_PersonaCountsAgainstIdentityLimit(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Claude.MaxIdentityChars = 200
+	mgr := NewManager(cfg, func() executor.Executor { return &mockExec{} })
+	mem := &memMemory{}
+	for i := range 50 {
+		mem.lines = append(mem.lines, fmt.Sprintf("- note %02d", i))
+	}
+	mgr.memory = mem
+	origin := Origin{ChatID: 1}
+
+	if err := mgr.SetPersona(origin, strings.Repeat("x", 200)); !errors.Is(err, ErrPersonaTooLong) {
+		t.Fatalf("SetPersona over the limit = %v, want ErrPersonaTooLong", err)
+	}
+	if got := mgr.Persona(origin); got != "" {
+		t.Errorf("rejected persona was kept: %q", got)
+	}
+
+	if err := mgr.SetPersona(origin, "Answer like a pirate."); err != nil {
+		t.Fatalf("SetPersona: %v", err)
+	}
+	identity := mgr.identityFor(mgr.key(origin))
+	if n := utf8.RuneCountInString(identity); n > 200 {
+		t.Errorf("identity with persona is %d chars, want at most 200:\n%s", n, identity)
+	}
+	if !strings.HasSuffix(identity, personaHeader+"Answer like a pirate.") || !strings.Contains(identity, "- note 49") {
+		t.Errorf("expected the newest notes and the whole persona, got %q", identity)
+	}
+}
@@ -0,0 +1,83 @@
+package session
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/zette-dev/natron/internal/executor"
+)
+
+// redactHoldMax bounds how much streamed text without whitespace is held
+// back waiting for a chunk boundary.
+const redactHoldMax = 4096
+
+// redacted replaces each session.redactions match.
+const redacted = "[redacted]"
+
+// redactor replaces configured patterns in one turn's text. Streamed text
+// is held back after its last whitespace, so a match split across two
+// chunks is still caught; a match containing whitespace that straddles a
+// chunk boundary can slip through.
+type redactor struct {
+	patterns []*regexp.Regexp
+	pending  string // raw text not yet released
+}
+
+func (r *redactor) apply(text string) string {
+	for _, re := range r.patterns {
+		text = re.ReplaceAllString(text, redacted)
+	}
+	return text
+}
+
+// redact applies session.redact_patterns to text that reaches a chat
+// outside a streamed turn.
+func (m *Manager) redact(text string) string {
+	r := redactor{patterns: m.cfg.Session.RedactPatterns}
+	return r.apply(text)
+}
+
+// process returns the events to deliver in place of evt.
+func (r *redactor) process(evt executor.Event) []executor.Event {
+	switch evt.Type {
+	case executor.EventText:
+		r.pending += evt.Text
+		cut := strings.LastIndexFunc(r.pending, unicode.IsSpace)
+		if cut < 0 && len(r.pending) < redactHoldMax {
+			return nil
+		}
+		var out string
+		if cut < 0 {
+			out, r.pending = r.pending, ""
+		} else {
+			_, size := utf8.DecodeRuneInString(r.pending[cut:])
+			out, r.pending = r.pending[:cut+size], r.pending[cut+size:]
+		}
+		evt.Text = r.apply(out)
+		return []executor.Event{evt}
+
+	case executor.EventToolResult:
+		evt.Text = r.apply(evt.Text)
+		return []executor.Event{evt}
+
+	case executor.EventDone, executor.EventError:
+		out := r.flush()
+		evt.Text = r.apply(evt.Text)
+		return append(out, evt)
+
+	default:
+		return []executor.Event{evt}
+	}
+}
+
+// flush releases any held-back text.
+func (r *redactor) flush() []executor.Event {
+	if r.pending == "" {
+		return nil
+	}
+	evt := executor.Event{Type: executor.EventText, Text: r.apply(r.pending)}
+	r.pending = ""
+	return []executor.Event{evt}
+}
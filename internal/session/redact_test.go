@@ -0,0 +1,109 @@
+package session
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/zette-dev/natron/internal/executor"
+)
+
+func TestManager_RedactsAcrossChunks(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Session.RedactPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`sk-[a-z0-9]+`),
+		regexp.MustCompile(`/home/\w+`),
+	}
+	mgr := NewManager(cfg, func() executor.Executor {
+		return &mockExec{handler: func(string) (<-chan executor.Event, error) {
+			ch := make(chan executor.Event, 4)
+			ch <- executor.Event{Type: executor.EventText, Text: "key sk-ab"}
+			ch <- executor.Event{Type: executor.EventText, Text: "c123 in /home/nate "}
+			ch <- executor.Event{Type: executor.EventText, Text: "ok"}
+			ch <- executor.Event{Type: executor.EventDone, Text: "key sk-abc123 in /home/nate ok"}
+			close(ch)
+			return ch, nil
+		}}
+	})
+
+	events, err := mgr.Send(context.Background(), Origin{ChatID: 2200}, "hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var streamed strings.Builder
+	var final string
+	for _, evt := range drain(t, events) {
+		switch evt.Type {
+		case executor.EventText:
+			streamed.WriteString(evt.Text)
+		case executor.EventDone:
+			final = evt.Text
+		}
+	}
+
+	const want = "key [redacted] in [redacted] ok"
+	if streamed.String() != want {
+		t.Errorf("streamed text = %q, want %q", streamed.String(), want)
+	}
+	if final != want {
+		t.Errorf("final text = %q, want %q", final, want)
+	}
+	if text, _ := mgr.LastResponse(Origin{ChatID: 2200}); strings.Contains(text, "sk-") {
+		t.Errorf("retained response leaked a secret: %q", text)
+	}
+}
+
+func TestManager_QuickRedacted(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Session.RedactPatterns = []*regexp.Regexp{regexp.MustCompile(`sk-[a-z0-9]+`)}
+	mgr := NewManager(cfg, func() executor.Executor { return &mockExec{} })
+
+	answer, err := mgr.Quick(context.Background(), Origin{ChatID: 2201}, "haiku", "key sk-abc123")
+	if err != nil {
+		t.Fatalf("Quick: %v", err)
+	}
+	if answer != "echo: key [redacted]" {
+		t.Errorf("Quick = %q, want the secret redacted", answer)
+	}
+}
+
+func TestRedactor_FlushesWithoutDone(t *testing.T) {
+	r := &redactor{patterns: []*regexp.Regexp{regexp.MustCompile(`secret`)}}
+	if out := r.process(executor.Event{Type: executor.EventText, Text: "a secret"}); len(out) != 1 || out[0].Text != "a " {
+		t.Fatalf("expected text up to the last space, got %+v", out)
+	}
+	if out := r.process(executor.Event{Type: executor.EventText, Text: "ive"}); len(out) != 0 {
+		t.Fatalf("expected the unfinished word to be held, got %+v", out)
+	}
+	if out := r.flush(); len(out) != 1 || out[0].Text != "[redacted]ive" {
+		t.Errorf("flush = %+v, want the held text", out)
+	}
+}
+
+// rawExec is a mockExec that records raw turn output.
+type rawExec struct {
+	mockExec
+	raw []string
+}
+
+func (r *rawExec) LastTurnRaw() []string { return append([]string(nil), r.raw...) }
+
+func TestManager_LastTurnRawRedacted(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Session.RedactPatterns = []*regexp.Regexp{regexp.MustCompile(`sk-[a-z0-9]+`)}
+	mgr := NewManager(cfg, func() executor.Executor {
+		return &rawExec{raw: []string{`{"type":"assistant","text":"key sk-abc123"}`, `{"type":"result"}`}}
+	})
+	origin := Origin{ChatID: 2201}
+	events, err := mgr.Send(context.Background(), origin, "hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	drain(t, events)
+
+	lines, ok := mgr.LastTurnRaw(origin)
+	if !ok || len(lines) != 2 || lines[0] != `{"type":"assistant","text":"key [redacted]"}` {
+		t.Errorf("LastTurnRaw = %q, %v; want the secret redacted", lines, ok)
+	}
+}
@@ -0,0 +1,66 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/zette-dev/natron/internal/executor"
+)
+
+// retry passes a turn's events through, resending prompt when an
+// attempt fails with executor.ErrTransient before producing any output.
+// Attempts that already streamed text or tool calls aren't retried, since
+// resending would repeat them. Retries wait session.auto_retry.backoff,
+// doubling each time, and the last failure is passed on as is.
+func (m *Manager) retry(ctx context.Context, sess *Session, turn Turn, prompt string, in <-chan executor.Event) <-chan executor.Event {
+	cfg := m.cfg.Session.AutoRetry
+	out := make(chan executor.Event, cap(in))
+
+	go func() {
+		defer close(out)
+		backoff := cfg.Backoff
+		for attempt := 1; ; attempt++ {
+			var failed *executor.Event
+			progressed := false
+			for evt := range in {
+				if evt.Type == executor.EventError && errors.Is(evt.Error, executor.ErrTransient) &&
+					!progressed && attempt <= cfg.Attempts {
+					failed = &evt
+					continue
+				}
+				if evt.Type == executor.EventText || evt.Type == executor.EventToolUse {
+					progressed = true
+				}
+				out <- evt
+			}
+			if failed == nil {
+				return
+			}
+
+			slog.Warn("transient agent error, retrying turn", "chat_id", turn.Origin.ChatID, "request_id", turn.RequestID,
+				"attempt", attempt, "backoff", backoff, "error", failed.Error)
+			timer := time.NewTimer(backoff)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				out <- *failed
+				return
+			}
+			backoff *= 2
+
+			sess.mu.Lock()
+			next, err := sess.exec.Send(ctx, prompt)
+			sess.mu.Unlock()
+			if err != nil {
+				out <- executor.Event{Type: executor.EventError, Error: fmt.Errorf("resend to executor: %w", err)}
+				return
+			}
+			in = next
+		}
+	}()
+	return out
+}
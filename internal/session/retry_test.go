@@ -0,0 +1,113 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zette-dev/natron/internal/config"
+	"github.com/zette-dev/natron/internal/executor"
+)
+
+// failingExec returns a mockExec whose first failures sends end with err,
+// after which it echoes. calls counts every Send.
+func failingExec(failures int, err error, calls *atomic.Int32) *mockExec {
+	return &mockExec{handler: func(msg string) (<-chan executor.Event, error) {
+		ch := make(chan executor.Event, 2)
+		if int(calls.Add(1)) <= failures {
+			ch <- executor.Event{Type: executor.EventError, Error: err}
+		} else {
+			ch <- executor.Event{Type: executor.EventText, Text: "echo: " + msg}
+			ch <- executor.Event{Type: executor.EventDone, Text: "echo: " + msg}
+		}
+		close(ch)
+		return ch, nil
+	}}
+}
+
+func TestManager_AutoRetry(t *testing.T) {
+	overloaded := fmt.Errorf("%w: Overloaded", executor.ErrTransient)
+	tests := []struct {
+		name      string
+		attempts  int
+		failures  int
+		err       error
+		wantCalls int32
+		wantError bool
+	}{
+		{"succeeds after transient errors", 3, 2, overloaded, 3, false},
+		{"gives up after attempts", 2, 5, overloaded, 3, true},
+		{"non-transient error surfaces at once", 3, 1, errors.New("boom"), 1, true},
+		{"disabled", 0, 1, overloaded, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t)
+			cfg.Session.AutoRetry = config.RetryConfig{Attempts: tt.attempts, Backoff: time.Millisecond}
+			var calls atomic.Int32
+			exec := failingExec(tt.failures, tt.err, &calls)
+			mgr := NewManager(cfg, func() executor.Executor { return exec })
+
+			events, err := mgr.Send(context.Background(), Origin{ChatID: 1}, "hi")
+			if err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+			var got []executor.Event
+			for evt := range events {
+				got = append(got, evt)
+			}
+
+			if n := calls.Load(); n != tt.wantCalls {
+				t.Errorf("executor sends = %d, want %d", n, tt.wantCalls)
+			}
+			if len(got) == 0 {
+				t.Fatal("no events")
+			}
+			last := got[len(got)-1]
+			if tt.wantError {
+				if last.Type != executor.EventError || !errors.Is(last.Error, tt.err) {
+					t.Errorf("last event = %+v, want error %v", last, tt.err)
+				}
+				if len(got) != 1 {
+					t.Errorf("expected only the final error, got %+v", got)
+				}
+			} else if last.Type != executor.EventDone || last.Text != "echo: hi" {
+				t.Errorf("last event = %+v, want done echo", last)
+			}
+		})
+	}
+}
+
+func TestManager_AutoRetrySkipsAfterOutput(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Session.AutoRetry = config.RetryConfig{Attempts: 3, Backoff: time.Millisecond}
+	var calls atomic.Int32
+	exec := &mockExec{handler: func(string) (<-chan executor.Event, error) {
+		calls.Add(1)
+		ch := make(chan executor.Event, 2)
+		ch <- executor.Event{Type: executor.EventText, Text: "partial"}
+		ch <- executor.Event{Type: executor.EventError, Error: executor.ErrTransient}
+		close(ch)
+		return ch, nil
+	}}
+	mgr := NewManager(cfg, func() executor.Executor { return exec })
+
+	events, err := mgr.Send(context.Background(), Origin{ChatID: 1}, "hi")
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	var types []executor.EventType
+	for evt := range events {
+		types = append(types, evt.Type)
+	}
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("executor sends = %d, want 1 (no retry after output)", n)
+	}
+	if len(types) != 2 || types[1] != executor.EventError {
+		t.Errorf("events = %v, want text then error", types)
+	}
+}
@@ -1,17 +1,104 @@
 package session
 
 import (
+	"context"
 	"sync"
 	"time"
 
 	"github.com/zette-dev/natron/internal/executor"
 )
 
+// Origin describes where an incoming message came from. The bot fills it
+// from the Telegram update; the manager derives the session key and the
+// workspace from it.
+type Origin struct {
+	ChatID   int64
+	UserID   int64
+	Username string // Chat @username without the @ (empty for DMs or private groups)
+	Title    string // Group/channel display name
+	Group    bool   // Group or supergroup chat
+	ThreadID int    // Forum topic ID (0 outside topics)
+	Sender   string // Sender's username, or name without one; empty if unknown
+}
+
+// sessionKey identifies a session in the manager. userID is only set when
+// per-user isolation applies (group chats with workspaces.per_user_in_groups)
+// and threadID only for forum topics with session.per_topic.
+type sessionKey struct {
+	chatID   int64
+	userID   int64
+	threadID int
+}
+
+// chatSettings holds per-chat overrides set through bot commands. They
+// outlive individual sessions and apply to every new session for the key.
+type chatSettings struct {
+	readOnly  bool
+	timeout   *time.Duration // Inactivity timeout override; 0 disables expiry
+	workspace string         // Workspace name override set by /new <name>
+	model     string         // Model override set by /model <name>
+	persona   string         // Appended to the identity doc; set by /persona
+}
+
+// Overrides is a copy of a chat's per-chat settings. Zero values, and a nil
+// Timeout, mean the config default applies.
+type Overrides struct {
+	Workspace string
+	Model     string
+	Persona   string
+	ReadOnly  bool
+	Timeout   *time.Duration // 0 disables expiry
+}
+
 // Session is an active executor process bound to a Telegram chat.
 type Session struct {
-	chatID    int64
-	workspace string
+	key       sessionKey
+	workspace string // Working directory
+	wsName    string // Workspace name it resolved from
 	exec      executor.Executor
 	createdAt time.Time
 	mu        sync.Mutex
+	initReply string // Shown init prompt reply, prepended to the first turn; guarded by mu
+
+	// Budget tracking, guarded by Manager.mu.
+	model     string  // Model override the session was spawned with
+	spentUSD  float64 // Spend of earlier processes in this conversation
+	costUSD   float64 // Spend reported by the current process
+	downgrade string  // Cheaper model to switch to before the next turn
+	respawn   bool    // Spawn settings changed; restart before the next turn
+
+	// Inactivity tracking, guarded by Manager.mu.
+	active     int         // Turns currently streaming
+	lastActive time.Time   // When the last turn ended
+	parked     bool        // Idle past session.park_after; process kept
+	nudged     bool        // Idle nudge sent this idle period
+	keepUntil  time.Time   // Expiry paused by /keep until then; zero when not
+	idle       *time.Timer // nil while a turn is active or expiry is off
+	removed    bool        // Reset or expired; its executor has been stopped
+}
+
+// logAttrs returns slog key/value pairs identifying the session.
+func (k sessionKey) logAttrs() []any {
+	attrs := []any{"chat_id", k.chatID}
+	if k.userID != 0 {
+		attrs = append(attrs, "user_id", k.userID)
+	}
+	if k.threadID != 0 {
+		attrs = append(attrs, "thread_id", k.threadID)
+	}
+	return attrs
+}
+
+type recentHistoryKey struct{}
+
+// WithRecentHistory returns a copy of ctx carrying the chat's recent
+// messages. A session spawned by a Send with this context starts with them
+// as SessionContext.RecentHistory.
+func WithRecentHistory(ctx context.Context, history string) context.Context {
+	return context.WithValue(ctx, recentHistoryKey{}, history)
+}
+
+func recentHistory(ctx context.Context) string {
+	history, _ := ctx.Value(recentHistoryKey{}).(string)
+	return history
 }
@@ -0,0 +1,86 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/zette-dev/natron/internal/executor"
+)
+
+// webhookPayload is the JSON body POSTed to integrations.webhook_url when
+// a turn finishes.
+type webhookPayload struct {
+	ChatID     int64   `json:"chat_id"`
+	UserID     int64   `json:"user_id,omitempty"`
+	ThreadID   int     `json:"thread_id,omitempty"`
+	Workspace  string  `json:"workspace"`
+	RequestID  string  `json:"request_id,omitempty"`
+	Response   string  `json:"response"`
+	CostUSD    float64 `json:"cost_usd,omitempty"` // The session's spend so far, when the executor reports it
+	DurationMS int64   `json:"duration_ms"`
+}
+
+// postWebhook is a Tap that POSTs each turn's final response, after
+// redaction, to integrations.webhook_url. Delivery runs in the background
+// and is best effort: failures are logged and never reach the chat.
+func (m *Manager) postWebhook(turn Turn) func(executor.Event) {
+	workspace := m.resolveWorkspace(turn.Origin)
+	var streamed strings.Builder
+	return func(evt executor.Event) {
+		switch evt.Type {
+		case executor.EventText:
+			streamed.WriteString(evt.Text)
+			return
+		case executor.EventDone:
+		default:
+			return
+		}
+		payload := webhookPayload{
+			ChatID:     turn.Origin.ChatID,
+			UserID:     turn.Origin.UserID,
+			ThreadID:   turn.Origin.ThreadID,
+			Workspace:  workspace,
+			RequestID:  turn.RequestID,
+			Response:   evt.Text,
+			CostUSD:    evt.CostUSD,
+			DurationMS: time.Since(turn.Started).Milliseconds(),
+		}
+		if payload.Response == "" {
+			payload.Response = streamed.String()
+		}
+		go func() {
+			if err := m.sendWebhook(payload); err != nil {
+				slog.Warn("webhook delivery failed", "chat_id", turn.Origin.ChatID, "request_id", turn.RequestID, "error", err)
+			}
+		}()
+	}
+}
+
+// sendWebhook POSTs payload, bounded by integrations.webhook_timeout.
+func (m *Manager) sendWebhook(payload webhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal webhook payload: %w", err)
+	}
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, m.cfg.Integrations.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("build webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := m.webhook.Do(req)
+	if err != nil {
+		return fmt.Errorf("post webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("post webhook: status %s", resp.Status)
+	}
+	return nil
+}
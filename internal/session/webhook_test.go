@@ -0,0 +1,97 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/zette-dev/natron/internal/executor"
+)
+
+func TestManager_PostsFinalResponseToWebhook(t *testing.T) {
+	bodies := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected request %s with content type %q", r.Method, r.Header.Get("Content-Type"))
+		}
+		body, _ := io.ReadAll(r.Body)
+		bodies <- body
+	}))
+	defer srv.Close()
+
+	cfg := testConfig(t)
+	cfg.Integrations.WebhookURL = srv.URL
+	cfg.Integrations.WebhookTimeout = time.Second
+	exec := &mockExec{handler: func(msg string) (<-chan executor.Event, error) {
+		ch := make(chan executor.Event, 2)
+		ch <- executor.Event{Type: executor.EventText, Text: "partial"}
+		ch <- executor.Event{Type: executor.EventDone, Text: "final answer", CostUSD: 0.25}
+		close(ch)
+		return ch, nil
+	}}
+	mgr := NewManager(cfg, func() executor.Executor { return exec })
+
+	events, err := mgr.Send(context.Background(), Origin{ChatID: 42, UserID: 7}, "hi")
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	drain(t, events)
+
+	var body []byte
+	select {
+	case body = <-bodies:
+	case <-time.After(2 * time.Second):
+		t.Fatal("webhook was not called")
+	}
+	var got map[string]any
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatalf("unmarshal payload %s: %v", body, err)
+	}
+	want := map[string]any{
+		"chat_id":   float64(42),
+		"user_id":   float64(7),
+		"workspace": "home",
+		"response":  "final answer",
+		"cost_usd":  0.25,
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("payload[%q] = %v, want %v (payload %s)", k, got[k], v, body)
+		}
+	}
+	if _, ok := got["duration_ms"].(float64); !ok {
+		t.Errorf("payload missing duration_ms: %s", body)
+	}
+}
+
+func TestManager_WebhookFailureDoesNotAffectTurn(t *testing.T) {
+	called := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called <- struct{}{}
+		http.Error(w, "down", http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	cfg := testConfig(t)
+	cfg.Integrations.WebhookURL = srv.URL
+	cfg.Integrations.WebhookTimeout = time.Second
+	mgr := NewManager(cfg, func() executor.Executor { return &mockExec{} })
+
+	events, err := mgr.Send(context.Background(), Origin{ChatID: 1}, "hello")
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	got := drain(t, events)
+	if last := got[len(got)-1]; last.Type != executor.EventDone || last.Text != "echo: hello" {
+		t.Errorf("last event = %+v, want the echo despite the webhook failing", last)
+	}
+	select {
+	case <-called:
+	case <-time.After(2 * time.Second):
+		t.Fatal("webhook was not called")
+	}
+}
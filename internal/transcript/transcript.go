@@ -0,0 +1,245 @@
+// Package transcript keeps a durable, append-only log of every turn per chat.
+// It is separate from any history used as agent context; at runtime it is
+// only read back to total a chat's usage.
+package transcript
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const queueSize = 256
+
+// item is a queued entry, or a flush marker whose flushed channel is
+// closed once everything queued before it has been written.
+type item struct {
+	entry   Entry
+	flushed chan struct{}
+}
+
+// Entry is one recorded turn: the user's message and the final response.
+type Entry struct {
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	ChatID   int64     `json:"chat_id"`
+	UserID   int64     `json:"user_id,omitempty"`
+	Message  string    `json:"message"`
+	Response string    `json:"response,omitempty"`
+	Error    string    `json:"error,omitempty"`
+
+	CostUSD      float64 `json:"cost_usd,omitempty"` // The turn's own spend, when the executor reports it
+	InputTokens  int     `json:"input_tokens,omitempty"`
+	OutputTokens int     `json:"output_tokens,omitempty"`
+}
+
+// Writer appends entries as JSON lines to <dir>/<chat ID>-<date>.jsonl,
+// rotating daily. Writes happen on a background goroutine so recording
+// never blocks a turn; entries are dropped (and logged) if the queue is full
+// or the write fails.
+type Writer struct {
+	dir     string
+	entries chan item
+	done    chan struct{}
+
+	mu      sync.Mutex
+	closed  bool
+	aborted bool // Queued entries are dropped instead of written; guarded by mu
+}
+
+// NewWriter starts a transcript writer rooted at dir.
+func NewWriter(dir string) *Writer {
+	w := &Writer{
+		dir:     dir,
+		entries: make(chan item, queueSize),
+		done:    make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// Record queues an entry for writing. It never blocks.
+func (w *Writer) Record(e Entry) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+
+	select {
+	case w.entries <- item{entry: e}:
+	default:
+		slog.Warn("transcript queue full, dropping entry", "chat_id", e.ChatID)
+	}
+}
+
+// Flush waits until every entry recorded so far has been written, or ctx
+// ends.
+func (w *Writer) Flush(ctx context.Context) error {
+	flushed := make(chan struct{})
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	select {
+	case w.entries <- item{flushed: flushed}:
+		w.mu.Unlock()
+	case <-ctx.Done():
+		w.mu.Unlock()
+		return fmt.Errorf("flush transcripts: %w", ctx.Err())
+	}
+
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("flush transcripts: %w", ctx.Err())
+	}
+}
+
+// Close flushes queued entries and stops the writer.
+func (w *Writer) Close() {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return
+	}
+	w.closed = true
+	close(w.entries)
+	w.mu.Unlock()
+
+	<-w.done
+}
+
+// Abort stops the writer without waiting, dropping entries still queued.
+// Its goroutine exits once any write in progress finishes.
+func (w *Writer) Abort() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.aborted = true
+	if !w.closed {
+		w.closed = true
+		close(w.entries)
+	}
+}
+
+func (w *Writer) run() {
+	defer close(w.done)
+	for it := range w.entries {
+		if it.flushed != nil {
+			close(it.flushed)
+			continue
+		}
+		w.mu.Lock()
+		aborted := w.aborted
+		w.mu.Unlock()
+		if aborted {
+			continue
+		}
+		if err := w.write(it.entry); err != nil {
+			slog.Warn("transcript write failed", "chat_id", it.entry.ChatID, "error", err)
+		}
+	}
+}
+
+func (w *Writer) write(e Entry) error {
+	if err := os.MkdirAll(w.dir, 0o700); err != nil {
+		return fmt.Errorf("create transcript dir: %w", err)
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("marshal entry: %w", err)
+	}
+	data = append(data, '\n')
+
+	f, err := os.OpenFile(w.path(e), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("open transcript: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(data); err != nil {
+		return fmt.Errorf("append transcript: %w", err)
+	}
+	return nil
+}
+
+// path returns the file an entry belongs in, keyed by chat ID and the
+// local date the turn started.
+func (w *Writer) path(e Entry) string {
+	name := fmt.Sprintf("%d-%s.jsonl", e.ChatID, e.Started.Format("2006-01-02"))
+	return filepath.Join(w.dir, name)
+}
+
+// Usage totals the turns recorded for a chat.
+type Usage struct {
+	Turns        int
+	CostUSD      float64
+	InputTokens  int
+	OutputTokens int
+}
+
+// Usage totals the chat's turns started in [since, until), flushing queued
+// entries first so the latest turns count. ctx bounds the flush.
+func (w *Writer) Usage(ctx context.Context, chatID int64, since, until time.Time) (Usage, error) {
+	if err := w.Flush(ctx); err != nil {
+		return Usage{}, err
+	}
+
+	var u Usage
+	start := time.Date(since.Year(), since.Month(), since.Day(), 0, 0, 0, 0, since.Location())
+	for day := start; day.Before(until); day = day.AddDate(0, 0, 1) {
+		if err := w.addUsage(&u, Entry{ChatID: chatID, Started: day}, since, until); err != nil {
+			return Usage{}, err
+		}
+	}
+	return u, nil
+}
+
+// addUsage adds the entries in day's file that started in [since, until).
+// A missing file means the chat had no turns that day; a line that doesn't
+// parse, such as one cut short by a crash, is skipped with a warning.
+func (w *Writer) addUsage(u *Usage, day Entry, since, until time.Time) error {
+	f, err := os.Open(w.path(day))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open transcript: %w", err)
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for n := 1; ; n++ {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) && len(line) == 0 {
+			return nil
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("read transcript %s: %w", f.Name(), err)
+		}
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			slog.Warn("skipping malformed transcript line", "chat_id", day.ChatID, "file", f.Name(), "line", n, "error", err)
+			continue
+		}
+		if e.Started.Before(since) || !e.Started.Before(until) {
+			continue
+		}
+		u.Turns++
+		u.CostUSD += e.CostUSD
+		u.InputTokens += e.InputTokens
+		u.OutputTokens += e.OutputTokens
+	}
+}
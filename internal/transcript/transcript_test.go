@@ -0,0 +1,190 @@
+package transcript
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriter_AppendsPerChatPerDay(t *testing.T) {
+	dir := t.TempDir()
+	w := NewWriter(dir)
+
+	day1 := time.Date(2026, 2, 18, 9, 0, 0, 0, time.Local)
+	day2 := day1.Add(24 * time.Hour)
+
+	w.Record(Entry{Started: day1, Finished: day1, ChatID: 100, Message: "hi", Response: "hello"})
+	w.Record(Entry{Started: day1, Finished: day1, ChatID: 100, Message: "again", Response: "yes"})
+	w.Record(Entry{Started: day2, Finished: day2, ChatID: 100, Message: "next day", Error: "boom"})
+	w.Record(Entry{Started: day1, Finished: day1, ChatID: -200, Message: "group"})
+	w.Close()
+
+	got := readEntries(t, filepath.Join(dir, "100-2026-02-18.jsonl"))
+	if len(got) != 2 {
+		t.Fatalf("expected 2 entries for chat 100 on day 1, got %d", len(got))
+	}
+	if got[0].Message != "hi" || got[0].Response != "hello" || got[1].Message != "again" {
+		t.Errorf("unexpected entries: %+v", got)
+	}
+
+	got = readEntries(t, filepath.Join(dir, "100-2026-02-19.jsonl"))
+	if len(got) != 1 || got[0].Error != "boom" {
+		t.Errorf("expected rotated file with error entry, got %+v", got)
+	}
+
+	got = readEntries(t, filepath.Join(dir, "-200-2026-02-18.jsonl"))
+	if len(got) != 1 {
+		t.Errorf("expected 1 entry for chat -200, got %d", len(got))
+	}
+}
+
+func TestWriter_RecordAfterCloseIsNoop(t *testing.T) {
+	dir := t.TempDir()
+	w := NewWriter(dir)
+	w.Close()
+
+	w.Record(Entry{Started: time.Now(), ChatID: 1, Message: "late"})
+	w.Close() // second Close must not panic
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("read dir: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected no files after Record on closed writer, got %d", len(entries))
+	}
+}
+
+func TestWriter_FlushWritesQueuedEntries(t *testing.T) {
+	dir := t.TempDir()
+	w := NewWriter(dir)
+	defer w.Close()
+
+	day := time.Date(2026, 2, 18, 9, 0, 0, 0, time.Local)
+	for range 10 {
+		w.Record(Entry{Started: day, ChatID: 7, Message: "hi"})
+	}
+	if err := w.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	if got := readEntries(t, filepath.Join(dir, "7-2026-02-18.jsonl")); len(got) != 10 {
+		t.Errorf("expected 10 entries written by Flush, got %d", len(got))
+	}
+	w.Record(Entry{Started: day, ChatID: 7, Message: "after flush"})
+}
+
+func TestWriter_AbortStopsWithoutWaiting(t *testing.T) {
+	w := NewWriter(t.TempDir())
+	for range 10 {
+		w.Record(Entry{Started: time.Now(), ChatID: 7, Message: "hi"})
+	}
+	w.Abort()
+
+	select {
+	case <-w.done:
+	case <-time.After(time.Second):
+		t.Fatal("writer goroutine still running after Abort")
+	}
+	w.Record(Entry{Started: time.Now(), ChatID: 7, Message: "after abort"})
+	w.Close()
+}
+
+func TestWriter_Usage(t *testing.T) {
+	w := NewWriter(t.TempDir())
+	defer w.Close()
+
+	at := func(day, hour int) time.Time { return time.Date(2026, 2, day, hour, 0, 0, 0, time.Local) }
+	seed := []Entry{
+		{Started: at(15, 23), ChatID: 7, CostUSD: 1, InputTokens: 1000},                     // before the week
+		{Started: at(16, 9), ChatID: 7, CostUSD: 0.10, InputTokens: 100, OutputTokens: 10},  // Monday
+		{Started: at(18, 8), ChatID: 7, CostUSD: 0.20, InputTokens: 200, OutputTokens: 20},  // today
+		{Started: at(18, 10), ChatID: 7, CostUSD: 0.30, InputTokens: 300, OutputTokens: 30}, // today
+		{Started: at(18, 11), ChatID: 8, CostUSD: 5, InputTokens: 5000},                     // another chat
+		{Started: at(19, 1), ChatID: 7, CostUSD: 2, InputTokens: 2000},                      // after until
+		{Started: at(18, 12), ChatID: 7, Error: "boom"},                                     // a failed turn
+	}
+	for _, e := range seed {
+		w.Record(e)
+	}
+
+	ctx := context.Background()
+	until := at(18, 23)
+	week, err := w.Usage(ctx, 7, at(16, 0), until)
+	if err != nil {
+		t.Fatalf("Usage: %v", err)
+	}
+	if want := (Usage{Turns: 4, CostUSD: 0.6, InputTokens: 600, OutputTokens: 60}); week.Turns != want.Turns ||
+		week.InputTokens != want.InputTokens || week.OutputTokens != want.OutputTokens || math.Abs(week.CostUSD-want.CostUSD) > 1e-9 {
+		t.Errorf("week = %+v, want %+v", week, want)
+	}
+
+	today, err := w.Usage(ctx, 7, at(18, 0), until)
+	if err != nil {
+		t.Fatalf("Usage: %v", err)
+	}
+	if today.Turns != 3 || today.InputTokens != 500 || math.Abs(today.CostUSD-0.5) > 1e-9 {
+		t.Errorf("today = %+v, want 3 turns, $0.50, 500 in", today)
+	}
+
+	none, err := w.Usage(ctx, 9, at(16, 0), until)
+	if err != nil || none != (Usage{}) {
+		t.Errorf("usage for a chat without transcripts = %+v, %v", none, err)
+	}
+}
+
+func TestWriter_UsageSkipsMalformedLines(t *testing.T) {
+	dir := t.TempDir()
+	w := NewWriter(dir)
+	defer w.Close()
+
+	at := time.Date(2026, 2, 18, 9, 0, 0, 0, time.Local)
+	w.Record(Entry{Started: at, ChatID: 7, CostUSD: 0.10, InputTokens: 100})
+	ctx := context.Background()
+	if err := w.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	path := w.path(Entry{ChatID: 7, Started: at})
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatalf("open %s: %v", path, err)
+	}
+	if _, err := f.WriteString("{\"started\": not json\n"); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	f.Close()
+	w.Record(Entry{Started: at.Add(time.Hour), ChatID: 7, CostUSD: 0.20, InputTokens: 200})
+
+	u, err := w.Usage(ctx, 7, at.Add(-time.Hour), at.Add(2*time.Hour))
+	if err != nil {
+		t.Fatalf("Usage: %v", err)
+	}
+	if u.Turns != 2 || u.InputTokens != 300 || math.Abs(u.CostUSD-0.3) > 1e-9 {
+		t.Errorf("usage = %+v, want 2 turns, $0.30, 300 in", u)
+	}
+}
+
+func readEntries(t *testing.T, path string) []Entry {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open %s: %v", path, err)
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("unmarshal %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}